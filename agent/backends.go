@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Executors besides Claude Code: "shell" runs the prompt as a shell command (quick
+// "git pull && make" tasks without LLM cost), and other CLI coding tools can be
+// configured under "executors" in ~/.minerva-agent.json:
+//
+//	"executors": {
+//	  "codex": {"command": ["codex", "exec", "--full-auto", "{prompt}"]}
+//	}
+//
+// {prompt} and {output_dir} are replaced in each argument; without {prompt} the
+// prompt is passed as the last argument.
+
+// ShellExecutor runs the prompt with sh -c
+const ShellExecutor = "shell"
+
+const (
+	// maxCollectedOutput caps the plain output kept for a result (the tail is kept)
+	maxCollectedOutput = 1024 * 1024
+	// lineProgressInterval throttles progress from tools printing many lines
+	lineProgressInterval = time.Second
+)
+
+var executorNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// commandConfig is a CLI tool configured as an executor
+type commandConfig struct {
+	Command []string `json:"command"`
+}
+
+// loadBackends returns the built-in executors plus the ones configured in the agent
+// config file, limited to allowed (empty = all)
+func loadBackends(path string, allowed []string) (map[string]Backend, error) {
+	backends := map[string]Backend{
+		DefaultExecutor: claudeBackend{},
+		ShellExecutor:   shellBackend{},
+	}
+
+	var cfg struct {
+		Executors map[string]commandConfig `json:"executors"`
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+	for name, c := range cfg.Executors {
+		if !executorNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid executor name %q", name)
+		}
+		if _, builtin := backends[name]; builtin {
+			return nil, fmt.Errorf("executor %q is built in and can't be redefined", name)
+		}
+		if len(c.Command) == 0 || c.Command[0] == "" {
+			return nil, fmt.Errorf("executor %q has no command", name)
+		}
+		backends[name] = commandBackend{args: c.Command}
+	}
+
+	if len(allowed) == 0 {
+		return backends, nil
+	}
+	kept := make(map[string]Backend, len(allowed))
+	for _, name := range allowed {
+		b, ok := backends[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown executor %q in allowed_executors", name)
+		}
+		kept[name] = b
+	}
+	return kept, nil
+}
+
+// shellBackend runs the prompt as a shell command
+type shellBackend struct{}
+
+func (shellBackend) Command(ctx context.Context, req ExecRequest) (*exec.Cmd, error) {
+	if req.SessionID != "" {
+		return nil, fmt.Errorf("the shell executor has no session to resume")
+	}
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", req.Prompt), nil
+	}
+	return exec.CommandContext(ctx, "sh", "-c", req.Prompt), nil
+}
+
+func (shellBackend) NewCollector(onProgress func(string)) outputCollector {
+	return &lineWriter{onProgress: onProgress}
+}
+
+// commandBackend runs a configured CLI tool with the prompt as an argument
+type commandBackend struct {
+	args []string
+}
+
+func (b commandBackend) Command(ctx context.Context, req ExecRequest) (*exec.Cmd, error) {
+	if req.SessionID != "" {
+		return nil, fmt.Errorf("%s has no session to resume", b.args[0])
+	}
+	args := make([]string, 0, len(b.args)+1)
+	hasPrompt := false
+	for _, arg := range b.args[1:] {
+		if strings.Contains(arg, "{prompt}") {
+			hasPrompt = true
+		}
+		arg = strings.ReplaceAll(arg, "{prompt}", req.Prompt)
+		args = append(args, strings.ReplaceAll(arg, "{output_dir}", req.OutputDir))
+	}
+	if !hasPrompt {
+		args = append(args, req.Prompt)
+	}
+	return exec.CommandContext(ctx, b.args[0], args...), nil
+}
+
+func (commandBackend) NewCollector(onProgress func(string)) outputCollector {
+	return &lineWriter{onProgress: onProgress}
+}
+
+// lineWriter collects plain output and reports the latest line as progress, at most
+// once per lineProgressInterval. Writes come from a single goroutine (exec's copier).
+type lineWriter struct {
+	raw        []byte
+	partial    []byte
+	onProgress func(string)
+	lastReport time.Time
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.raw = append(w.raw, p...)
+	if len(w.raw) > maxCollectedOutput {
+		w.raw = append(w.raw[:0], w.raw[len(w.raw)-maxCollectedOutput:]...)
+	}
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(w.partial[:i]))
+		w.partial = append(w.partial[:0], w.partial[i+1:]...)
+		if line != "" && w.onProgress != nil && time.Since(w.lastReport) >= lineProgressInterval {
+			w.lastReport = time.Now()
+			w.onProgress("▸ " + truncate(line, 150))
+		}
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) Output() string        { return string(w.raw) }
+func (w *lineWriter) SessionID() string     { return "" }
+func (w *lineWriter) Failed() bool          { return false }
+func (w *lineWriter) Usage() (int, float64) { return 0, 0 }
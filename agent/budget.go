@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"minerva/protocol"
+)
+
+// Budgets: the server caps each task's wall time, and its turns and cost for executors that
+// report them. A task that runs over is stopped like a kill, but its partial output still
+// goes back as the result, with the limit it hit in BudgetExceeded.
+
+const (
+	// defaultTaskTimeout caps tasks from servers that send no wall-time budget
+	defaultTaskTimeout = 55 * time.Minute
+	// budgetCheckInterval is how often a running task's turns and cost are checked
+	budgetCheckInterval = 500 * time.Millisecond
+)
+
+// budgetExceededError is the cause a task's context is cancelled with when it runs over budget
+type budgetExceededError struct {
+	limit string
+}
+
+func (e *budgetExceededError) Error() string {
+	return "budget exceeded: " + e.limit
+}
+
+// taskTimeout is how long a task may run
+func taskTimeout(b *protocol.Budget) time.Duration {
+	if b == nil || b.MaxDurationSec <= 0 {
+		return defaultTaskTimeout
+	}
+	return time.Duration(b.MaxDurationSec) * time.Second
+}
+
+// budgetContext returns the context a task runs under: cancelled by cancel (a kill), at its
+// wall-time limit, or by stopOverBudget once watchBudget finds it over another limit
+func budgetContext(b *protocol.Budget) (ctx context.Context, cancel context.CancelFunc, stopOverBudget context.CancelCauseFunc) {
+	timeout := taskTimeout(b)
+	parent, stopOverBudget := context.WithCancelCause(context.Background())
+	ctx, cancel = context.WithTimeoutCause(parent, timeout, &budgetExceededError{limit: fmt.Sprintf("max wall time %v", timeout)})
+	return ctx, cancel, stopOverBudget
+}
+
+// overBudget describes the limit turns and cost exceed, or returns ""
+func overBudget(b *protocol.Budget, turns int, costUSD float64) string {
+	if b == nil {
+		return ""
+	}
+	if b.MaxTurns > 0 && turns > b.MaxTurns {
+		return fmt.Sprintf("max %d turns", b.MaxTurns)
+	}
+	if b.MaxCostUSD > 0 && costUSD > b.MaxCostUSD {
+		return fmt.Sprintf("max cost $%.2f (spent $%.2f)", b.MaxCostUSD, costUSD)
+	}
+	return ""
+}
+
+// watchBudget stops a task once its executor reports more turns or cost than the budget
+// allows. It returns when ctx ends.
+func watchBudget(ctx context.Context, stop context.CancelCauseFunc, b *protocol.Budget, stdout outputCollector) {
+	if b == nil || b.MaxTurns <= 0 && b.MaxCostUSD <= 0 {
+		return
+	}
+	ticker := time.NewTicker(budgetCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			turns, cost := stdout.Usage()
+			if limit := overBudget(b, turns, cost); limit != "" {
+				stop(&budgetExceededError{limit: limit})
+				return
+			}
+		}
+	}
+}
+
+// budgetExceeded returns the limit that stopped a task, or "" if none did
+func budgetExceeded(ctx context.Context) string {
+	var exceeded *budgetExceededError
+	if errors.As(context.Cause(ctx), &exceeded) {
+		return exceeded.limit
+	}
+	return ""
+}
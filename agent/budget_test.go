@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"minerva/protocol"
+)
+
+// usageWriter reports fixed usage, like a stream whose last event said so
+type usageWriter struct {
+	lineWriter
+	turns   int
+	costUSD float64
+}
+
+func (w *usageWriter) Usage() (int, float64) { return w.turns, w.costUSD }
+
+func TestTaskTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget *protocol.Budget
+		want   time.Duration
+	}{
+		{"no budget", nil, defaultTaskTimeout},
+		{"no wall time", &protocol.Budget{MaxTurns: 5}, defaultTaskTimeout},
+		{"wall time", &protocol.Budget{MaxDurationSec: 90}, 90 * time.Second},
+		{"longer than the default", &protocol.Budget{MaxDurationSec: 7200}, 2 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := taskTimeout(tt.budget); got != tt.want {
+			t.Errorf("taskTimeout(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOverBudget(t *testing.T) {
+	tests := []struct {
+		name    string
+		budget  *protocol.Budget
+		turns   int
+		costUSD float64
+		want    string
+	}{
+		{"no budget", nil, 100, 100, ""},
+		{"unlimited", &protocol.Budget{}, 100, 100, ""},
+		{"within", &protocol.Budget{MaxTurns: 10, MaxCostUSD: 1}, 10, 1, ""},
+		{"turns", &protocol.Budget{MaxTurns: 10}, 11, 0, "max 10 turns"},
+		{"cost", &protocol.Budget{MaxCostUSD: 0.5}, 3, 0.75, "max cost $0.50 (spent $0.75)"},
+		{"turns first", &protocol.Budget{MaxTurns: 1, MaxCostUSD: 0.5}, 2, 1, "max 1 turns"},
+	}
+	for _, tt := range tests {
+		if got := overBudget(tt.budget, tt.turns, tt.costUSD); got != tt.want {
+			t.Errorf("overBudget(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBudgetContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		budget *protocol.Budget
+		usage  *usageWriter
+		kill   bool
+		want   string
+	}{
+		{"wall time", &protocol.Budget{MaxDurationSec: 1}, &usageWriter{}, false, "max wall time 1s"},
+		{"turns", &protocol.Budget{MaxDurationSec: 60, MaxTurns: 2}, &usageWriter{turns: 3}, false, "max 2 turns"},
+		{"cost", &protocol.Budget{MaxDurationSec: 60, MaxCostUSD: 1}, &usageWriter{costUSD: 2}, false, "max cost $1.00 (spent $2.00)"},
+		{"killed", &protocol.Budget{MaxDurationSec: 60, MaxTurns: 2}, &usageWriter{turns: 1}, true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel, stop := budgetContext(tt.budget)
+			defer cancel()
+			go watchBudget(ctx, stop, tt.budget, tt.usage)
+			if tt.kill {
+				cancel()
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+				t.Fatal("task context still running")
+			}
+			if got := budgetExceeded(ctx); got != tt.want {
+				t.Errorf("budgetExceeded = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := budgetExceeded(context.Background()); got != "" {
+		t.Errorf("budgetExceeded of a plain context = %q, want \"\"", got)
+	}
+}
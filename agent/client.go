@@ -2,66 +2,26 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
-)
-
-// Message types
-const (
-	MsgTypeRegister     = "register"
-	MsgTypeTask         = "task"
-	MsgTypeAck          = "ack"
-	MsgTypeResult       = "result"
-	MsgTypeStatus       = "status"
-	MsgTypePing         = "ping"
-	MsgTypePong         = "pong"
-	MsgTypeHeartbeat    = "heartbeat"
-	MsgTypeError        = "error"
-	MsgTypeListProjects = "list_projects"
-	MsgTypeProjects     = "projects"
-	MsgTypeKill         = "kill"
-	MsgTypeKilled       = "killed"
-	MsgTypeFileUpload   = "file_upload"
+	"minerva/policy"
+	"minerva/protocol"
 )
 
 var errConnNil = fmt.Errorf("connection is nil")
 
-// Message represents a WebSocket message
-type Message struct {
-	Type string `json:"type"`
-
-	// Register
-	Name     string   `json:"name,omitempty"`
-	Cwd      string   `json:"cwd,omitempty"`
-	Password string   `json:"password,omitempty"`
-	Projects []string `json:"projects,omitempty"`
-
-	// Task
-	ID     string `json:"id,omitempty"`
-	Prompt string `json:"prompt,omitempty"`
-	Dir    string `json:"dir,omitempty"` // Optional override for working dir
-
-	// Result
-	Output   string `json:"output,omitempty"`
-	ExitCode int    `json:"exit_code,omitempty"`
-	Error    string `json:"error,omitempty"`
-	Duration int64  `json:"duration_ms,omitempty"`
-
-	// File upload
-	FileName string `json:"file_name,omitempty"`
-	FileSize int64  `json:"file_size,omitempty"`
-	FileData string `json:"file_data,omitempty"` // base64 encoded
-}
-
 // RunningTask represents a task that is currently executing
 type RunningTask struct {
 	cancel context.CancelFunc
@@ -74,24 +34,47 @@ type Client struct {
 	agentName  string
 	workingDir string
 	password   string
+	maxTasks   int // advertised concurrency limit (0 = server default)
+	policy     *policy.Policy
+	labels     []string // configured labels; detected ones are added at registration
+
+	tokenLock sync.Mutex
+	token     string // per-agent token; empty until enrolled
+	tokenFile string // where an approved token is saved
 
 	conn     *websocket.Conn
 	connLock sync.Mutex
 
 	executor     *Executor
 	runningTasks sync.Map // taskID -> *RunningTask
+	// worktree name -> ID of the task running in it (one at a time, and not while under review)
+	worktreeTasks sync.Map
+
+	protocol           atomic.Int32             // version the server picked for this connection
+	incoming           map[string]*incomingFile // files being pushed to us (read loop only)
+	transferAcks       sync.Map                 // transferID -> chan protocol.Message, for sendFile
+	transfersSupported atomic.Value             // bool: the server acked a chunked transfer
+	restartPending     atomic.Bool              // an update is installed, restart once no task runs
+	outbox             *Outbox                  // finished tasks' results until the server has them
 
 	done chan struct{}
 }
 
 // NewClient creates a new agent client
-func NewClient(serverURL, agentName, workingDir, password string) *Client {
+func NewClient(serverURL, agentName, workingDir, password, token, tokenFile string, maxTasks int, taskPolicy *policy.Policy, executor *Executor, labels []string, outbox *Outbox) *Client {
 	return &Client{
 		serverURL:  serverURL,
 		agentName:  agentName,
 		workingDir: workingDir,
 		password:   password,
-		executor:   NewExecutor(),
+		token:      token,
+		tokenFile:  tokenFile,
+		maxTasks:   maxTasks,
+		policy:     taskPolicy,
+		executor:   executor,
+		labels:     labels,
+		outbox:     outbox,
+		incoming:   make(map[string]*incomingFile),
 		done:       make(chan struct{}),
 	}
 }
@@ -136,8 +119,14 @@ func (c *Client) connect() error {
 		}).Dial,
 	}
 
+	c.tokenLock.Lock()
+	token := c.token
+	c.tokenLock.Unlock()
+
 	header := make(http.Header)
-	if c.password != "" {
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	} else if c.password != "" {
 		header.Set("Authorization", "Bearer "+c.password)
 	}
 	conn, _, err := dialer.Dial(c.serverURL, header)
@@ -149,17 +138,48 @@ func (c *Client) connect() error {
 	c.conn = conn
 	c.connLock.Unlock()
 
-	// Register with the server
-	return c.send(Message{
-		Type:     MsgTypeRegister,
-		Name:     c.agentName,
-		Cwd:      c.workingDir,
-		Password: c.password,
-		Projects: listHomeProjects(),
+	// Register with the server, reporting tasks still running from a previous connection.
+	// Tasks whose result is in the outbox count as running until it is delivered.
+	running := c.outbox.Pending()
+	c.runningTasks.Range(func(key, _ any) bool {
+		if !slices.Contains(running, key.(string)) {
+			running = append(running, key.(string))
+		}
+		return true
+	})
+	projects := listHomeProjects()
+	return c.send(protocol.Message{
+		Type:           protocol.MsgRegister,
+		Name:           c.agentName,
+		Cwd:            c.workingDir,
+		Password:       c.password,
+		Token:          token,
+		Projects:       projects,
+		RunningTasks:   &running,
+		MaxConcurrency: c.maxTasks,
+		Capabilities:   c.capabilities(),
+		Executors:      c.executor.Names(),
+		Labels:         detectLabels(c.labels, projects),
+		Resources:      detectResources(),
+		Version:        agentVersion(),
+		OS:             runtime.GOOS,
+		Arch:           runtime.GOARCH,
+		// Versions this agent speaks; the server answers with the one to use
+		ProtocolVersion:    protocol.Version,
+		MinProtocolVersion: protocol.MinVersion,
 	})
 }
 
-func (c *Client) send(msg Message) error {
+// capabilities lists the optional features this agent offers the server
+func (c *Client) capabilities() []string {
+	caps := []string{protocol.CapWorktree, protocol.CapFollowUp, protocol.CapReadFile, protocol.CapFileTransfer, protocol.CapBudget}
+	if c.canSelfUpdate() {
+		caps = append(caps, protocol.CapSelfUpdate)
+	}
+	return caps
+}
+
+func (c *Client) send(msg protocol.Message) error {
 	c.connLock.Lock()
 	defer c.connLock.Unlock()
 
@@ -172,7 +192,7 @@ func (c *Client) send(msg Message) error {
 
 // sendWithRetry attempts to send a message with exponential backoff.
 // Used for result messages that must not be lost.
-func (c *Client) sendWithRetry(msg Message, maxRetries int) error {
+func (c *Client) sendWithRetry(msg protocol.Message, maxRetries int) error {
 	var lastErr error
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		if attempt > 0 {
@@ -190,6 +210,22 @@ func (c *Client) sendWithRetry(msg Message, maxRetries int) error {
 	return fmt.Errorf("all %d send attempts failed, last error: %w", maxRetries+1, lastErr)
 }
 
+// saveToken keeps the token the server issued when the admin approved this agent
+func (c *Client) saveToken(token string) {
+	if token == "" {
+		return
+	}
+	c.tokenLock.Lock()
+	c.token = token
+	c.tokenLock.Unlock()
+
+	if err := os.WriteFile(c.tokenFile, []byte(token+"\n"), 0600); err != nil {
+		log.Printf("Enrolled, but failed to save the token to %s: %v (issue a new one with: minerva agent enroll %s)", c.tokenFile, err, c.agentName)
+		return
+	}
+	log.Printf("Enrolled by the server, token saved to %s", c.tokenFile)
+}
+
 // closeConn closes the current connection (safe to call multiple times)
 func (c *Client) closeConn() {
 	c.connLock.Lock()
@@ -224,8 +260,13 @@ func (c *Client) handleMessages() {
 		return nil
 	})
 
+	// Servers that predate versioning never send welcome
+	c.protocol.Store(1)
+
 	// Start ping ticker - when it fails, it closes the connection to unblock ReadJSON
 	go c.pingLoop(stopPing)
+	go c.healthLoop(stopPing)
+	go c.outboxLoop(stopPing)
 
 	for {
 		select {
@@ -234,7 +275,7 @@ func (c *Client) handleMessages() {
 		default:
 		}
 
-		var msg Message
+		var msg protocol.Message
 		if err := c.conn.ReadJSON(&msg); err != nil {
 			log.Printf("Read error: %v", err)
 			return
@@ -244,18 +285,53 @@ func (c *Client) handleMessages() {
 		c.conn.SetReadDeadline(time.Now().Add(90 * time.Second))
 
 		switch msg.Type {
-		case MsgTypeTask:
+		case protocol.MsgTask, protocol.MsgFollowUp:
 			go c.handleTask(msg)
-		case MsgTypeKill:
+		case protocol.MsgKill:
 			c.handleKill(msg)
-		case MsgTypeListProjects:
-			c.send(Message{
-				Type:     MsgTypeProjects,
-				ID:       msg.ID,
-				Projects: listHomeProjects(),
-			})
-		case MsgTypePing:
-			c.send(Message{Type: MsgTypePong})
+		case protocol.MsgListProjects:
+			// Describing projects runs git in each, so keep it off the read loop
+			go func(id string) {
+				projects := listHomeProjects()
+				c.send(protocol.Message{
+					Type:        protocol.MsgProjects,
+					ID:          id,
+					Projects:    projects,
+					ProjectInfo: describeProjects(projects),
+				})
+			}(msg.ID)
+		case protocol.MsgReadFile:
+			go c.handleReadFile(msg)
+		case protocol.MsgWorktreeAction:
+			go c.handleWorktreeAction(msg)
+		case protocol.MsgUpdate:
+			go c.handleUpdate(msg)
+		case protocol.MsgFileBegin:
+			c.handleFileBegin(msg)
+		case protocol.MsgFileChunk:
+			c.handleFileChunk(msg)
+		case protocol.MsgFileAck:
+			c.handleFileAck(msg)
+		case protocol.MsgResultAck:
+			c.handleResultAck(msg)
+		case protocol.MsgPing:
+			c.send(protocol.Message{Type: protocol.MsgPong})
+		case protocol.MsgEnrolled:
+			c.saveToken(msg.Token)
+		case protocol.MsgWelcome:
+			c.protocol.Store(int32(msg.ProtocolVersion))
+			log.Printf("Server speaks protocol v%d", msg.ProtocolVersion)
+		case protocol.MsgError:
+			log.Printf("Server error: %s", msg.Error)
+		case protocol.MsgUnsupported:
+			log.Printf("Server doesn't handle %s: %s", msg.RefType, msg.Error)
+			// A transfer waiting for its ack fails now instead of timing out
+			c.handleFileAck(msg)
+		default:
+			log.Printf("Unsupported message type %q", msg.Type)
+			if version := int(c.protocol.Load()); protocol.Supports(version, protocol.MsgUnsupported) {
+				c.send(protocol.Unsupported(msg, version))
+			}
 		}
 	}
 }
@@ -289,7 +365,7 @@ func (c *Client) pingLoop(stop chan struct{}) {
 			}
 
 			// Also send application-level ping
-			if err := c.send(Message{Type: MsgTypePing}); err != nil {
+			if err := c.send(protocol.Message{Type: protocol.MsgPing}); err != nil {
 				log.Printf("Application ping failed: %v, closing connection", err)
 				c.closeConn()
 				return
@@ -298,27 +374,68 @@ func (c *Client) pingLoop(stop chan struct{}) {
 	}
 }
 
-func (c *Client) handleTask(task Message) {
+func (c *Client) handleTask(task protocol.Message) {
 	log.Printf("[Task %s] Received: %s", task.ID, truncate(task.Prompt, 100))
 
-	// Determine working directory
+	// Check the task against our policy before anything runs
+	taskType := policy.TaskTypeTask
+	if task.Type == protocol.MsgFollowUp {
+		taskType = policy.TaskTypeFollowUp
+	}
 	dir := c.workingDir
-	if task.Dir != "" {
-		dir = task.Dir
+	err := c.policy.AllowType(taskType)
+	if err == nil && task.Dir != "" {
+		dir, err = c.policy.CheckPath(task.Dir, c.workingDir)
+	} else if err == nil {
+		_, err = c.policy.CheckPath(dir, c.workingDir)
+	}
+
+	// In worktree mode Claude works on its own branch; the checkout is only touched on review
+	var wt *worktree
+	if err == nil && task.Worktree != "" {
+		if other, busy := c.worktreeTasks.LoadOrStore(task.Worktree, task.ID); busy {
+			err = fmt.Errorf("task %s is still running in worktree %s", other, task.Worktree)
+		} else if wt, dir, err = openWorktree(task.Worktree, dir); err != nil {
+			c.worktreeTasks.Delete(task.Worktree)
+		}
+	}
+	if err != nil {
+		log.Printf("[Task %s] %v", task.ID, err)
+		c.send(protocol.Message{
+			Type:  protocol.MsgAck,
+			ID:    task.ID,
+			Error: err.Error(),
+		})
+		return
 	}
 	log.Printf("[Task %s] Working dir: %s", task.ID, dir)
 
-	// Start claude (non-blocking) with 55 min timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 55*time.Minute)
+	// Start the executor (non-blocking); the task's budget limits how long it may run
+	ctx, cancel, stopOverBudget := budgetContext(task.Budget)
 	start := time.Now()
 
-	cmd, stdout, stderr, err := c.executor.Start(ctx, task.ID, task.Prompt, dir)
+	// Forward each step Claude takes so the server can show live progress
+	onProgress := func(line string) {
+		if err := c.send(protocol.Message{Type: protocol.MsgProgress, ID: task.ID, Output: line}); err != nil {
+			log.Printf("[Task %s] Progress send failed: %v", task.ID, err)
+		}
+	}
+
+	cmd, stdout, stderr, err := c.executor.Start(ctx, task.Executor, ExecRequest{
+		TaskID:    task.ID,
+		Prompt:    task.Prompt,
+		WorkDir:   dir,
+		SessionID: task.SessionID,
+	}, onProgress)
 	if err != nil {
 		cancel()
-		// Send ACK with error - claude failed to start
+		if wt != nil {
+			c.worktreeTasks.Delete(wt.Name)
+		}
+		// Send ACK with error - the executor failed to start
 		log.Printf("[Task %s] Failed to start: %v", task.ID, err)
-		c.send(Message{
-			Type:  MsgTypeAck,
+		c.send(protocol.Message{
+			Type:  protocol.MsgAck,
 			ID:    task.ID,
 			Error: err.Error(),
 		})
@@ -331,10 +448,12 @@ func (c *Client) handleTask(task Message) {
 		cmd:    cmd.Process,
 	})
 
-	// Send ACK - claude started successfully
-	log.Printf("[Task %s] Claude started, sending ACK", task.ID)
-	if err := c.send(Message{
-		Type: MsgTypeAck,
+	go watchBudget(ctx, stopOverBudget, task.Budget, stdout)
+
+	// Send ACK - the task started successfully
+	log.Printf("[Task %s] Started, sending ACK", task.ID)
+	if err := c.send(protocol.Message{
+		Type: protocol.MsgAck,
 		ID:   task.ID,
 	}); err != nil {
 		log.Printf("[Task %s] WARNING: Failed to send ACK: %v", task.ID, err)
@@ -344,7 +463,12 @@ func (c *Client) handleTask(task Message) {
 	go func() {
 		defer func() {
 			cancel()
+			stopOverBudget(nil)
 			c.runningTasks.Delete(task.ID)
+			if wt != nil {
+				c.worktreeTasks.Delete(wt.Name)
+			}
+			c.restartIfIdle()
 		}()
 
 		// Start heartbeat goroutine - sends periodic heartbeats while task is running
@@ -357,9 +481,10 @@ func (c *Client) handleTask(task Message) {
 				case <-heartbeatDone:
 					return
 				case <-ticker.C:
-					if err := c.send(Message{
-						Type: MsgTypeHeartbeat,
-						ID:   task.ID,
+					if err := c.send(protocol.Message{
+						Type:   protocol.MsgHeartbeat,
+						ID:     task.ID,
+						Health: collectHealth(dir),
 					}); err != nil {
 						log.Printf("[Task %s] Heartbeat send failed: %v", task.ID, err)
 					} else {
@@ -372,30 +497,56 @@ func (c *Client) handleTask(task Message) {
 		result := c.executor.Wait(cmd, stdout, stderr, start)
 		close(heartbeatDone)
 
-		// Check if the task was killed (context cancelled)
-		if ctx.Err() == context.Canceled {
-			// Task was killed, don't send result (killed message already sent)
+		// A task over budget was stopped, but its partial output is still its result;
+		// one cancelled otherwise was killed (the killed message was already sent)
+		exceeded := budgetExceeded(ctx)
+		if exceeded == "" && ctx.Err() == context.Canceled {
 			log.Printf("[Task %s] Task was killed, not sending result", task.ID)
 			return
 		}
+		turns, cost := stdout.Usage()
+		if exceeded == "" {
+			// Claude reports its cost only with its result, once there is nothing left to stop
+			exceeded = overBudget(task.Budget, turns, cost)
+		}
+		if exceeded != "" {
+			log.Printf("[Task %s] Budget exceeded: %s", task.ID, exceeded)
+		}
 
-		// Collect and send output files before sending the result
-		if outputDir := c.executor.GetOutputDir(task.ID); outputDir != "" {
-			c.collectAndSendFiles(task.ID, outputDir)
+		msg := protocol.Message{
+			Type:           protocol.MsgResult,
+			ID:             task.ID,
+			Output:         result.Output,
+			ExitCode:       result.ExitCode,
+			Duration:       result.DurationMs,
+			SessionID:      result.SessionID,
+			Turns:          turns,
+			CostUSD:        cost,
+			BudgetExceeded: exceeded,
 		}
 
-		msg := Message{
-			Type:     MsgTypeResult,
-			ID:       task.ID,
-			Output:   result.Output,
-			ExitCode: result.ExitCode,
-			Duration: result.DurationMs,
+		// Commit a worktree task's changes; its patch goes out with the output files
+		outputDir := c.executor.GetOutputDir(task.ID)
+		if wt != nil {
+			var kept bool
+			if msg.DiffStat, kept = c.finishWorktree(task.ID, wt, outputDir); kept {
+				msg.Worktree = wt.Name
+			}
 		}
 
 		log.Printf("[Task %s] Completed: exit=%d, output=%d bytes, duration=%dms",
 			task.ID, result.ExitCode, len(result.Output), result.DurationMs)
 
-		// Use retry logic for result delivery - this is critical data
+		// Output files go first, then the result; both wait in the outbox if the connection is down
+		if err := c.outbox.Save(msg, outputDir); err != nil {
+			log.Printf("[Task %s] Failed to save the result in the outbox, sending it directly: %v", task.ID, err)
+		} else {
+			c.deliver(task.ID, false)
+			return
+		}
+		if outputDir != "" {
+			c.collectAndSendFiles(task.ID, outputDir)
+		}
 		if err := c.sendWithRetry(msg, 3); err != nil {
 			log.Printf("[Task %s] CRITICAL: Failed to deliver result after retries: %v", task.ID, err)
 			log.Printf("[Task %s] Lost output (%d bytes): %s", task.ID, len(result.Output), truncate(result.Output, 500))
@@ -405,7 +556,7 @@ func (c *Client) handleTask(task Message) {
 	}()
 }
 
-func (c *Client) handleKill(msg Message) {
+func (c *Client) handleKill(msg protocol.Message) {
 	log.Printf("[Task %s] Kill signal received", msg.ID)
 
 	val, ok := c.runningTasks.Load(msg.ID)
@@ -429,24 +580,64 @@ func (c *Client) handleKill(msg Message) {
 	c.runningTasks.Delete(msg.ID)
 
 	// Send killed confirmation
-	c.send(Message{
-		Type: MsgTypeKilled,
+	c.send(protocol.Message{
+		Type: protocol.MsgKilled,
 		ID:   msg.ID,
 	})
 
 	log.Printf("[Task %s] Task killed successfully", msg.ID)
 }
 
-// collectAndSendFiles scans the output directory for files and sends them to the server
-func (c *Client) collectAndSendFiles(taskID, outputDir string) {
+// maxReadFileSize caps files returned to read_file requests
+const maxReadFileSize = 1024 * 1024
+
+// handleReadFile returns the contents of a small text file (used by server-side watchers)
+func (c *Client) handleReadFile(msg protocol.Message) {
+	reply := protocol.Message{Type: protocol.MsgFileContent, ID: msg.ID}
+
+	err := c.policy.AllowType(policy.TaskTypeReadFile)
+	path := msg.FileName
+	if err == nil {
+		path, err = c.policy.CheckPath(msg.FileName, c.workingDir)
+	}
+
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(path)
+	}
+	switch {
+	case err != nil:
+		reply.Error = err.Error()
+	case info.IsDir():
+		reply.Error = fmt.Sprintf("%s is a directory", path)
+	case info.Size() > maxReadFileSize:
+		reply.Error = fmt.Sprintf("%s is too large (%d bytes, max %d)", path, info.Size(), maxReadFileSize)
+	default:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			reply.Error = err.Error()
+		} else {
+			reply.Output = string(data)
+		}
+	}
+
+	if err := c.send(reply); err != nil {
+		log.Printf("Failed to send file content for %s: %v", path, err)
+	}
+}
+
+// collectAndSendFiles scans the output directory for files and sends them to the server.
+// Returns whether all of them were delivered (the directory is removed then).
+func (c *Client) collectAndSendFiles(taskID, outputDir string) bool {
 	entries, err := os.ReadDir(outputDir)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			log.Printf("[Task %s] Failed to read output dir %s: %v", taskID, outputDir, err)
 		}
-		return
+		return os.IsNotExist(err)
 	}
 
+	failed := 0
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
@@ -460,40 +651,38 @@ func (c *Client) collectAndSendFiles(taskID, outputDir string) {
 		}
 
 		// Skip files larger than 50MB (Telegram limit)
-		if info.Size() > 50*1024*1024 {
+		if info.Size() > maxUploadSize {
 			log.Printf("[Task %s] Skipping file %s: too large (%d bytes)", taskID, entry.Name(), info.Size())
 			continue
 		}
 
-		data, err := os.ReadFile(filePath)
-		if err != nil {
-			log.Printf("[Task %s] Failed to read file %s: %v", taskID, filePath, err)
-			continue
-		}
-
-		encoded := base64.StdEncoding.EncodeToString(data)
-		msg := Message{
-			Type:     MsgTypeFileUpload,
-			ID:       taskID,
-			FileName: entry.Name(),
-			FileSize: info.Size(),
-			FileData: encoded,
-		}
-
 		log.Printf("[Task %s] Sending file: %s (%d bytes)", taskID, entry.Name(), info.Size())
-		if err := c.sendWithRetry(msg, 3); err != nil {
+		err = c.sendFile(taskID, filePath, 3)
+		if errors.Is(err, errTransferUnsupported) {
+			log.Printf("[Task %s] Server has no chunked transfers, sending %s in one message", taskID, entry.Name())
+			err = c.sendFileLegacy(taskID, filePath)
+		}
+		if err != nil {
 			log.Printf("[Task %s] Failed to send file %s: %v", taskID, entry.Name(), err)
+			failed++
 		} else {
 			log.Printf("[Task %s] File %s sent successfully", taskID, entry.Name())
 		}
 	}
 
+	// Keep what could not be delivered, so it isn't lost
+	if failed > 0 {
+		log.Printf("[Task %s] %d file(s) not delivered, keeping output dir %s", taskID, failed, outputDir)
+		return false
+	}
+
 	// Clean up the output directory
 	if err := os.RemoveAll(outputDir); err != nil {
 		log.Printf("[Task %s] Failed to clean output dir %s: %v", taskID, outputDir, err)
 	} else {
 		log.Printf("[Task %s] Cleaned output dir %s", taskID, outputDir)
 	}
+	return true
 }
 
 func truncate(s string, n int) string {
@@ -4,29 +4,79 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 )
 
-// ExecutionResult holds the result of a claude execution
+const (
+	// DefaultExecutor runs tasks that don't name an executor
+	DefaultExecutor = "claude"
+	// outputWaitDelay is how long a stopped task's output is still read: children of a
+	// killed shell can keep its stdout open long after it is gone
+	outputWaitDelay = 5 * time.Second
+)
+
+// ExecutionResult holds the result of an execution
 type ExecutionResult struct {
 	Output     string
 	ExitCode   int
 	DurationMs int64
+	SessionID  string // session the run used (resumable with a follow-up)
+}
+
+// ExecRequest is what a backend needs to run a task
+type ExecRequest struct {
+	TaskID    string
+	Prompt    string
+	WorkDir   string
+	SessionID string // resume this session (only backends that have sessions)
+	OutputDir string // files placed here are sent to the user ("" if unavailable)
+}
+
+// Backend runs tasks with one tool (Claude Code, a shell, another CLI coding tool)
+type Backend interface {
+	// Command builds the process for a task; the Executor sets its dir, env and output
+	Command(ctx context.Context, req ExecRequest) (*exec.Cmd, error)
+	// NewCollector returns the writer for the process's stdout
+	NewCollector(onProgress func(string)) outputCollector
 }
 
-// Executor runs claude commands
+// outputCollector receives a process's stdout, reporting progress as it goes
+type outputCollector interface {
+	io.Writer
+	Output() string    // the task's result text
+	SessionID() string // session to resume with a follow-up ("" if none)
+	Failed() bool      // the tool reported failure despite exiting cleanly
+	// Usage returns the turns taken and cost so far, 0 if the tool doesn't report them.
+	// Unlike the other methods, it may be called while the process runs.
+	Usage() (turns int, costUSD float64)
+}
+
+// Executor runs tasks with the backend they ask for
 type Executor struct {
+	backends   map[string]Backend
 	outputDirs sync.Map // taskID -> output directory path
 }
 
-// NewExecutor creates a new executor
-func NewExecutor() *Executor {
-	return &Executor{}
+// NewExecutor creates an executor with the given backends by name
+func NewExecutor(backends map[string]Backend) *Executor {
+	return &Executor{backends: backends}
+}
+
+// Names lists the executors this agent offers, sorted
+func (e *Executor) Names() []string {
+	names := make([]string, 0, len(e.backends))
+	for name := range e.backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // GetOutputDir returns the output directory for a task, if one was created
@@ -37,87 +87,132 @@ func (e *Executor) GetOutputDir(taskID string) string {
 	return ""
 }
 
-// Start launches a claude process and returns immediately after verifying it started.
-// The caller receives stdout/stderr buffers and the cmd to wait on.
-func (e *Executor) Start(ctx context.Context, taskID, prompt, workDir string) (*exec.Cmd, *bytes.Buffer, *bytes.Buffer, error) {
+// Start launches a task with the named executor ("" = DefaultExecutor) and returns
+// immediately after verifying it started. onProgress (optional) gets a one-line summary
+// of each step. The caller receives the stdout collector, the stderr buffer and the cmd to wait on.
+func (e *Executor) Start(ctx context.Context, name string, req ExecRequest, onProgress func(string)) (*exec.Cmd, outputCollector, *bytes.Buffer, error) {
+	if name == "" {
+		name = DefaultExecutor
+	}
+	backend, ok := e.backends[name]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("executor %q is not available on this agent (available: %v)", name, e.Names())
+	}
+
 	// Create output directory for file transfers
-	outputDir := filepath.Join(os.TempDir(), fmt.Sprintf("minerva-output-%s", taskID))
+	outputDir := filepath.Join(os.TempDir(), fmt.Sprintf("minerva-output-%s", req.TaskID))
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		log.Printf("[Executor] Failed to create output dir %s: %v", outputDir, err)
 		// Non-fatal: continue without output dir
 		outputDir = ""
 	} else {
-		e.outputDirs.Store(taskID, outputDir)
+		e.outputDirs.Store(req.TaskID, outputDir)
 	}
+	req.OutputDir = outputDir
 
-	appendPrompt := "IMPORTANT: You are running in non-interactive mode. If you need clarification or have questions, DO NOT use AskUserQuestion (it will block). Instead, list all your questions in your response text and end your execution. The user will provide answers and run you again."
-	if outputDir != "" {
-		appendPrompt += fmt.Sprintf("\n\nFILE OUTPUT: If you need to send files to the user, save them in the directory $MINERVA_OUTPUT_DIR (%s). Any files placed there will be automatically sent to the user via Telegram when the task completes.", outputDir)
+	cmd, err := backend.Command(ctx, req)
+	if err != nil {
+		e.dropOutputDir(req.TaskID, outputDir)
+		return nil, nil, nil, err
 	}
-
-	cmd := exec.CommandContext(ctx,
-		"claude",
-		"-p",
-		"--dangerously-skip-permissions",
-		"--model", "opus",
-		"--append-system-prompt", appendPrompt,
-		prompt,
-	)
-	cmd.Dir = workDir
+	cmd.Dir = req.WorkDir
+	cmd.WaitDelay = outputWaitDelay
 
 	// Set MINERVA_OUTPUT_DIR environment variable
 	cmd.Env = append(os.Environ(), fmt.Sprintf("MINERVA_OUTPUT_DIR=%s", outputDir))
 
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
+	stdout := backend.NewCollector(onProgress)
+	var stderr bytes.Buffer
+	cmd.Stdout = stdout
 	cmd.Stderr = &stderr
 
-	log.Printf("[Executor] Starting claude in %s", workDir)
-	log.Printf("[Executor] Prompt: %s", truncate(prompt, 200))
+	log.Printf("[Executor] Starting %s in %s", name, req.WorkDir)
+	log.Printf("[Executor] Prompt: %s", truncate(req.Prompt, 200))
+	if req.SessionID != "" {
+		log.Printf("[Executor] Resuming session %s", req.SessionID)
+	}
 	if outputDir != "" {
 		log.Printf("[Executor] Output dir: %s", outputDir)
 	}
 
 	if err := cmd.Start(); err != nil {
-		log.Printf("[Executor] Failed to start claude: %v", err)
+		log.Printf("[Executor] Failed to start %s: %v", name, err)
 		// Clean up output dir on failure
-		if outputDir != "" {
-			os.RemoveAll(outputDir)
-			e.outputDirs.Delete(taskID)
-		}
-		return nil, nil, nil, fmt.Errorf("failed to start claude: %w", err)
+		e.dropOutputDir(req.TaskID, outputDir)
+		return nil, nil, nil, fmt.Errorf("failed to start %s: %w", name, err)
 	}
 
-	log.Printf("[Executor] Claude started (PID: %d)", cmd.Process.Pid)
-	return cmd, &stdout, &stderr, nil
+	log.Printf("[Executor] %s started (PID: %d)", name, cmd.Process.Pid)
+	return cmd, stdout, &stderr, nil
+}
+
+func (e *Executor) dropOutputDir(taskID, outputDir string) {
+	if outputDir != "" {
+		os.RemoveAll(outputDir)
+		e.outputDirs.Delete(taskID)
+	}
 }
 
 // Wait waits for a started command and returns the result
-func (e *Executor) Wait(cmd *exec.Cmd, stdout, stderr *bytes.Buffer, start time.Time) *ExecutionResult {
+func (e *Executor) Wait(cmd *exec.Cmd, stdout outputCollector, stderr *bytes.Buffer, start time.Time) *ExecutionResult {
 	err := cmd.Wait()
 	elapsed := time.Since(start)
 
 	result := &ExecutionResult{
-		Output:     stdout.String(),
+		Output:     stdout.Output(),
 		DurationMs: elapsed.Milliseconds(),
+		SessionID:  stdout.SessionID(),
+	}
+	if err == nil && stdout.Failed() {
+		// Exited cleanly but reported the run as failed
+		result.ExitCode = 1
 	}
 
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			result.ExitCode = exitErr.ExitCode()
-			log.Printf("[Executor] Claude exited with code %d after %v", result.ExitCode, elapsed)
+			log.Printf("[Executor] Process exited with code %d after %v", result.ExitCode, elapsed)
 			if stderr.Len() > 0 {
 				log.Printf("[Executor] Stderr: %s", truncate(stderr.String(), 500))
 				result.Output = fmt.Sprintf("%s\n\nStderr:\n%s", result.Output, stderr.String())
 			}
 		} else {
-			log.Printf("[Executor] Claude wait error: %v", err)
+			log.Printf("[Executor] Wait error: %v", err)
 			result.ExitCode = 1
-			result.Output = fmt.Sprintf("claude error: %v", err)
+			result.Output = fmt.Sprintf("executor error: %v", err)
 		}
 	} else {
-		log.Printf("[Executor] Claude completed successfully in %v (output: %d bytes)", elapsed, len(result.Output))
+		log.Printf("[Executor] Completed successfully in %v (output: %d bytes)", elapsed, len(result.Output))
 	}
 
 	return result
 }
+
+// claudeBackend runs Claude Code, streaming its steps as progress
+type claudeBackend struct{}
+
+func (claudeBackend) Command(ctx context.Context, req ExecRequest) (*exec.Cmd, error) {
+	appendPrompt := "IMPORTANT: You are running in non-interactive mode. If you need clarification or have questions, DO NOT use AskUserQuestion (it will block). Instead, list all your questions in your response text and end your execution. The user's answers will arrive as a follow-up message in this same session."
+	if req.OutputDir != "" {
+		appendPrompt += fmt.Sprintf("\n\nFILE OUTPUT: If you need to send files to the user, save them in the directory $MINERVA_OUTPUT_DIR (%s). Any files placed there will be automatically sent to the user via Telegram when the task completes.", req.OutputDir)
+	}
+
+	args := []string{
+		"-p",
+		"--dangerously-skip-permissions",
+		"--model", "opus",
+		"--output-format", "stream-json",
+		"--verbose", // required by stream-json in print mode
+		"--append-system-prompt", appendPrompt,
+	}
+	if req.SessionID != "" {
+		args = append(args, "--resume", req.SessionID)
+	}
+	args = append(args, req.Prompt)
+
+	return exec.CommandContext(ctx, "claude", args...), nil
+}
+
+func (claudeBackend) NewCollector(onProgress func(string)) outputCollector {
+	return newStreamWriter(onProgress)
+}
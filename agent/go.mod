@@ -2,4 +2,12 @@ module minerva-agent
 
 go 1.24.0
 
-require github.com/gorilla/websocket v1.5.3
+require (
+	github.com/gorilla/websocket v1.5.3
+	minerva/policy v0.0.0
+	minerva/protocol v0.0.0
+)
+
+replace minerva/policy => ../policy
+
+replace minerva/protocol => ../protocol
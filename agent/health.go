@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"minerva/protocol"
+)
+
+const (
+	// HealthInterval is how often the agent reports its host's health
+	HealthInterval = time.Minute
+	// claudeVersionTTL is how long the claude version is reused before asking the binary again
+	claudeVersionTTL = time.Hour
+)
+
+// agentVersion returns Version, or the commit this binary was built from
+func agentVersion() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		var rev, dirty string
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				rev = s.Value
+				if len(rev) > 7 {
+					rev = rev[:7]
+				}
+			case "vcs.modified":
+				if s.Value == "true" {
+					dirty = "-dirty"
+				}
+			}
+		}
+		if rev != "" {
+			return "dev-" + rev + dirty
+		}
+	}
+	return "dev"
+}
+
+var claudeVersion struct {
+	sync.Mutex
+	value   string
+	checked time.Time
+}
+
+// claudeBinaryVersion runs `claude --version`, at most once per claudeVersionTTL
+func claudeBinaryVersion() string {
+	claudeVersion.Lock()
+	defer claudeVersion.Unlock()
+	if time.Since(claudeVersion.checked) < claudeVersionTTL {
+		return claudeVersion.value
+	}
+	claudeVersion.checked = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "claude", "--version").Output()
+	if err != nil {
+		claudeVersion.value = ""
+		return ""
+	}
+
+	// "2.0.14 (Claude Code)"
+	claudeVersion.value = ""
+	if fields := strings.Fields(string(out)); len(fields) > 0 {
+		claudeVersion.value = fields[0]
+	}
+	return claudeVersion.value
+}
+
+// collectHealth samples the host. dir picks the filesystem whose free space is reported.
+func collectHealth(dir string) *protocol.Health {
+	h := &protocol.Health{
+		CPUs:          runtime.NumCPU(),
+		AgentVersion:  agentVersion(),
+		ClaudeVersion: claudeBinaryVersion(),
+	}
+	h.Load1 = loadAverage()
+	h.MemTotalMB, h.MemAvailableMB = memoryMB()
+	h.DiskTotalMB, h.DiskFreeMB = diskSpaceMB(dir)
+	h.Battery, h.Charging = battery()
+	h.UptimeSec = uptimeSeconds()
+	return h
+}
+
+// healthLoop reports the host's health now and every HealthInterval until stop closes
+func (c *Client) healthLoop(stop chan struct{}) {
+	ticker := time.NewTicker(HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.send(protocol.Message{Type: protocol.MsgHeartbeat, Health: collectHealth(c.workingDir)}); err != nil {
+			log.Printf("Health report failed: %v", err)
+		}
+		select {
+		case <-c.done:
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func loadAverage() float64 {
+	switch runtime.GOOS {
+	case "linux", "android":
+		data, err := os.ReadFile("/proc/loadavg")
+		if err != nil {
+			return 0
+		}
+		// "0.52 0.58 0.59 1/467 12345"
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			load, _ := strconv.ParseFloat(fields[0], 64)
+			return load
+		}
+	case "darwin":
+		out, err := exec.Command("sysctl", "-n", "vm.loadavg").Output()
+		if err != nil {
+			return 0
+		}
+		// "{ 1.23 1.45 1.67 }"
+		if fields := strings.Fields(strings.Trim(strings.TrimSpace(string(out)), "{}")); len(fields) > 0 {
+			load, _ := strconv.ParseFloat(fields[0], 64)
+			return load
+		}
+	}
+	return 0
+}
+
+// memoryMB returns the total and available memory in MiB
+func memoryMB() (total, available int64) {
+	switch runtime.GOOS {
+	case "linux", "android":
+		f, err := os.Open("/proc/meminfo")
+		if err != nil {
+			return 0, 0
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// MemAvailable:    8123456 kB
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 {
+				continue
+			}
+			kb, _ := strconv.ParseInt(fields[1], 10, 64)
+			switch fields[0] {
+			case "MemTotal:":
+				total = kb / 1024
+			case "MemAvailable:":
+				available = kb / 1024
+			}
+		}
+		return total, available
+	case "darwin":
+		total = totalMemoryMB()
+		out, err := exec.Command("vm_stat").Output()
+		if err != nil {
+			return total, 0
+		}
+		return total, darwinAvailableMB(string(out))
+	}
+	return totalMemoryMB(), 0
+}
+
+var vmStatPageSize = regexp.MustCompile(`page size of (\d+) bytes`)
+
+// darwinAvailableMB adds up the free, inactive and speculative pages reported by vm_stat
+func darwinAvailableMB(vmStat string) int64 {
+	pageSize := int64(4096)
+	if m := vmStatPageSize.FindStringSubmatch(vmStat); m != nil {
+		pageSize, _ = strconv.ParseInt(m[1], 10, 64)
+	}
+	var pages int64
+	for _, line := range strings.Split(vmStat, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch name {
+		case "Pages free", "Pages inactive", "Pages speculative":
+			n, _ := strconv.ParseInt(strings.TrimSuffix(strings.TrimSpace(value), "."), 10, 64)
+			pages += n
+		}
+	}
+	return pages * pageSize / (1024 * 1024)
+}
+
+var pmsetBattery = regexp.MustCompile(`(\d+)%;\s*([a-zA-Z ]+)`)
+
+// battery returns the charge percentage (nil without a battery) and whether it is charging
+func battery() (*int, bool) {
+	switch runtime.GOOS {
+	case "linux", "android":
+		supplies, _ := filepath.Glob("/sys/class/power_supply/*")
+		for _, dir := range supplies {
+			kind, _ := os.ReadFile(filepath.Join(dir, "type"))
+			if strings.TrimSpace(string(kind)) != "Battery" {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "capacity"))
+			if err != nil {
+				continue
+			}
+			pct, err := strconv.Atoi(strings.TrimSpace(string(data)))
+			if err != nil {
+				continue
+			}
+			status, _ := os.ReadFile(filepath.Join(dir, "status"))
+			s := strings.TrimSpace(string(status))
+			return &pct, s == "Charging" || s == "Full"
+		}
+		// Termux on Android can't read /sys, but ships a helper
+		if out, err := exec.Command("termux-battery-status").Output(); err == nil {
+			var st struct {
+				Percentage int    `json:"percentage"`
+				Status     string `json:"status"`
+			}
+			if json.Unmarshal(out, &st) == nil {
+				return &st.Percentage, st.Status == "CHARGING" || st.Status == "FULL"
+			}
+		}
+	case "darwin":
+		out, err := exec.Command("pmset", "-g", "batt").Output()
+		if err != nil {
+			return nil, false
+		}
+		// " -InternalBattery-0 (id=1234)	85%; discharging; 4:12 remaining"
+		if m := pmsetBattery.FindStringSubmatch(string(out)); m != nil {
+			pct, _ := strconv.Atoi(m[1])
+			state := strings.TrimSpace(m[2])
+			return &pct, state == "charging" || state == "charged" || state == "finishing charge"
+		}
+	}
+	return nil, false
+}
+
+var sysctlBootTime = regexp.MustCompile(`sec = (\d+)`)
+
+func uptimeSeconds() int64 {
+	switch runtime.GOOS {
+	case "linux", "android":
+		data, err := os.ReadFile("/proc/uptime")
+		if err != nil {
+			return 0
+		}
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			up, _ := strconv.ParseFloat(fields[0], 64)
+			return int64(up)
+		}
+	case "darwin":
+		out, err := exec.Command("sysctl", "-n", "kern.boottime").Output()
+		if err != nil {
+			return 0
+		}
+		// "{ sec = 1700000000, usec = 0 } Tue Nov 14 ..."
+		if m := sysctlBootTime.FindStringSubmatch(string(out)); m != nil {
+			boot, _ := strconv.ParseInt(m[1], 10, 64)
+			return time.Now().Unix() - boot
+		}
+	}
+	return 0
+}
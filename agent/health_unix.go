@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// diskSpaceMB returns the size and free space (for unprivileged users) of dir's filesystem in MiB
+func diskSpaceMB(dir string) (total, free int64) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, 0
+	}
+	bsize := int64(st.Bsize)
+	return int64(st.Blocks) * bsize / (1024 * 1024), int64(st.Bavail) * bsize / (1024 * 1024)
+}
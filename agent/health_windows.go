@@ -0,0 +1,24 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// diskSpaceMB returns the size and free space (for this user) of dir's volume in MiB
+func diskSpaceMB(dir string) (total, free int64) {
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, 0
+	}
+	var freeAvail, totalBytes, totalFree uint64
+	proc := syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+	r, _, _ := proc.Call(uintptr(unsafe.Pointer(path)),
+		uintptr(unsafe.Pointer(&freeAvail)), uintptr(unsafe.Pointer(&totalBytes)), uintptr(unsafe.Pointer(&totalFree)))
+	if r == 0 {
+		return 0, 0
+	}
+	return int64(totalBytes / (1024 * 1024)), int64(freeAvail / (1024 * 1024))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"minerva/protocol"
+)
+
+// Labels describe this agent so the server can route tasks by what it needs instead of
+// by name. Detected ones are os:<goos>, arch:<goarch>, has-docker, gpu or gpu-less and
+// repo:<name> for each git repository in the home folder; more can be listed under
+// "labels" in ~/.minerva-agent.json:
+//
+//	"labels": ["location:office", "fast-disk"]
+
+var labelPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,31}(:[A-Za-z0-9_.-]{1,64})?$`)
+
+// loadLabels reads the configured labels from the agent config file
+func loadLabels(path string) ([]string, error) {
+	var cfg struct {
+		Labels []string `json:"labels"`
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+	for _, l := range cfg.Labels {
+		if !labelPattern.MatchString(l) {
+			return nil, fmt.Errorf("invalid label %q (use name or key:value)", l)
+		}
+	}
+	return cfg.Labels, nil
+}
+
+// detectLabels returns the detected labels plus the configured ones, sorted
+func detectLabels(configured, projects []string) []string {
+	seen := map[string]bool{}
+	add := func(l string) {
+		if labelPattern.MatchString(l) {
+			seen[l] = true
+		}
+	}
+
+	add("os:" + runtime.GOOS)
+	add("arch:" + runtime.GOARCH)
+	if _, err := exec.LookPath("docker"); err == nil {
+		add("has-docker")
+	}
+	if hasGPU() {
+		add("gpu")
+	} else {
+		add("gpu-less")
+	}
+	for _, p := range projects {
+		if _, err := os.Stat(filepath.Join(p, ".git")); err == nil {
+			add("repo:" + filepath.Base(p))
+		}
+	}
+	for _, l := range configured {
+		add(l)
+	}
+
+	labels := make([]string, 0, len(seen))
+	for l := range seen {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// hasGPU reports whether a GPU usable for compute is present (NVIDIA or AMD tooling,
+// or Apple silicon)
+func hasGPU() bool {
+	if runtime.GOOS == "darwin" && runtime.GOARCH == "arm64" {
+		return true
+	}
+	for _, tool := range []string{"nvidia-smi", "rocm-smi"} {
+		if _, err := exec.LookPath(tool); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// detectResources reports the CPU count and total memory
+func detectResources() *protocol.Resources {
+	return &protocol.Resources{CPUs: runtime.NumCPU(), MemoryMB: totalMemoryMB()}
+}
+
+// totalMemoryMB returns the machine's memory in MiB, or 0 if it can't be read
+func totalMemoryMB() int64 {
+	switch runtime.GOOS {
+	case "linux":
+		f, err := os.Open("/proc/meminfo")
+		if err != nil {
+			return 0
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// MemTotal:       16314208 kB
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "MemTotal:" {
+				kb, _ := strconv.ParseInt(fields[1], 10, 64)
+				return kb / 1024
+			}
+		}
+	case "darwin":
+		out, err := exec.Command("sysctl", "-n", "hw.memsize").Output()
+		if err != nil {
+			return 0
+		}
+		b, _ := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+		return b / (1024 * 1024)
+	}
+	return 0
+}
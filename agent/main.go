@@ -2,20 +2,38 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
+
+	"minerva/policy"
 )
 
 // Password is set at build time with -ldflags "-X main.Password=secret"
 var Password string
 
+// Version is set at build time with -ldflags "-X main.Version=v1.2.3"; empty means a dev build
+var Version string
+
+// UpdateKey is the server's key for signed agent releases, set at build time with
+// -ldflags "-X main.UpdateKey=..." (see `minerva agent releases`); empty means no self-update
+var UpdateKey string
+
 var (
 	serverURL  string
 	agentName  string
 	workingDir string
+	maxTasks   int
+	token      string
+	tokenFile  string
+	configFile string
+	outboxDir  string
+	// allowDowngrade lets the server install releases that aren't newer than this build
+	allowDowngrade bool
 )
 
 func main() {
@@ -24,6 +42,12 @@ func main() {
 	flag.StringVar(&serverURL, "server", "ws://localhost:8081/agent", "Minerva WebSocket server URL")
 	flag.StringVar(&agentName, "name", "", "Agent name (defaults to hostname)")
 	flag.StringVar(&workingDir, "dir", "", "Working directory (defaults to current dir)")
+	flag.IntVar(&maxTasks, "max-tasks", 0, "Max tasks to run at once; extra tasks wait in the server queue (0 = server default)")
+	flag.StringVar(&token, "token", os.Getenv("MINERVA_AGENT_TOKEN"), "Agent token from `minerva agent enroll` (defaults to the saved token file)")
+	flag.StringVar(&configFile, "config", "", "Agent config: policy, executors and labels (defaults to ~/.minerva-agent.json)")
+	flag.StringVar(&tokenFile, "token-file", "", "Where the agent's token is kept (defaults to ~/.minerva-agent-<name>.token)")
+	flag.StringVar(&outboxDir, "outbox", "", "Where finished tasks' results wait until the server has them (defaults to ~/.minerva-agent-<name>.outbox)")
+	flag.BoolVar(&allowDowngrade, "allow-downgrade", false, "Accept signed releases older than (or the same as) this build from the server")
 	flag.Parse()
 
 	// Default agent name to hostname
@@ -51,8 +75,53 @@ func main() {
 	}
 	workingDir = absDir
 
+	home, err := os.UserHomeDir()
+	if err != nil {
+		log.Fatalf("Failed to get home directory: %v", err)
+	}
+
+	// What the server may ask of us, enforced before anything starts
+	if configFile == "" {
+		configFile = filepath.Join(home, ".minerva-agent.json")
+	}
+	taskPolicy, err := policy.Load(configFile)
+	if err != nil {
+		log.Fatalf("Failed to load agent policy: %v", err)
+	}
+	if _, err := taskPolicy.CheckPath(workingDir, workingDir); err != nil {
+		log.Printf("WARNING: the working directory itself is not allowed, tasks need an allowed dir: %v", err)
+	}
+	backends, err := loadBackends(configFile, taskPolicy.AllowedExecutors)
+	if err != nil {
+		log.Fatalf("Failed to load executors: %v", err)
+	}
+	executor := NewExecutor(backends)
+	labels, err := loadLabels(configFile)
+	if err != nil {
+		log.Fatalf("Failed to load labels: %v", err)
+	}
+
+	// Per-agent token: issued by `minerva agent enroll`, or saved here once the admin approves us
+	if tokenFile == "" {
+		tokenFile = filepath.Join(home, fmt.Sprintf(".minerva-agent-%s.token", filepath.Base(agentName)))
+	}
+	if token == "" {
+		if data, err := os.ReadFile(tokenFile); err == nil {
+			token = strings.TrimSpace(string(data))
+		}
+	}
+
+	// Results of tasks that finished while disconnected (or before a restart) are resent from here
+	if outboxDir == "" {
+		outboxDir = filepath.Join(home, fmt.Sprintf(".minerva-agent-%s.outbox", filepath.Base(agentName)))
+	}
+	outbox, err := openOutbox(outboxDir)
+	if err != nil {
+		log.Fatalf("Failed to open the result outbox: %v", err)
+	}
+
 	// Password is optional - server may not require it
-	if Password == "" {
+	if token == "" && Password == "" {
 		log.Printf("WARNING: No password set. Build with: go build -ldflags \"-X main.Password=SECRET\"")
 	}
 
@@ -60,9 +129,25 @@ func main() {
 	log.Printf("  Name: %s", agentName)
 	log.Printf("  Server: %s", serverURL)
 	log.Printf("  Working dir: %s", workingDir)
+	if maxTasks > 0 {
+		log.Printf("  Max tasks: %d", maxTasks)
+	}
+	log.Printf("  Policy: %s", taskPolicy)
+	log.Printf("  Executors: %s", strings.Join(executor.Names(), ", "))
+	if len(labels) > 0 {
+		log.Printf("  Labels: %s", strings.Join(labels, ", "))
+	}
+	if token != "" {
+		log.Printf("  Token: enrolled")
+	} else {
+		log.Printf("  Token: none, the admin will be asked to approve this agent (saved to %s)", tokenFile)
+	}
+	if pending := outbox.Pending(); len(pending) > 0 {
+		log.Printf("  Outbox: %d undelivered result(s), resent once connected", len(pending))
+	}
 
 	// Create and start client
-	client := NewClient(serverURL, agentName, workingDir, Password)
+	client := NewClient(serverURL, agentName, workingDir, Password, token, tokenFile, maxTasks, taskPolicy, executor, labels, outbox)
 
 	// Graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"minerva/protocol"
+)
+
+// The outbox keeps each finished task's result, and the output files still to upload,
+// on disk until the server has them. A result that couldn't be sent (or, with servers
+// that acknowledge results, wasn't acknowledged) is resent after reconnecting, so the
+// output of a long task survives a dropped connection or an agent restart.
+
+const (
+	// outboxRetryInterval is how often undelivered results are sent again while connected
+	outboxRetryInterval = 30 * time.Second
+	// outboxFirstDelay lets registration finish before resending after a reconnect
+	outboxFirstDelay = 3 * time.Second
+)
+
+// outboxEntry is a finished task waiting to be delivered
+type outboxEntry struct {
+	Result   protocol.Message `json:"result"`
+	FilesDir string           `json:"files_dir,omitempty"` // output files not uploaded yet
+	Saved    time.Time        `json:"saved"`
+}
+
+// Outbox stores undelivered results in a directory, one <taskID>.json per task
+type Outbox struct {
+	dir string
+
+	mu       sync.Mutex
+	inFlight map[string]bool      // being delivered right now
+	lastSent map[string]time.Time // results sent on this connection, awaiting result_ack
+}
+
+func openOutbox(dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Outbox{
+		dir:      dir,
+		inFlight: make(map[string]bool),
+		lastSent: make(map[string]time.Time),
+	}, nil
+}
+
+func (o *Outbox) entryPath(taskID string) string {
+	return filepath.Join(o.dir, taskID+".json")
+}
+
+// Save stores a task's result. Its output files are moved into the outbox when possible,
+// since outputDir is under the temp dir, which may not survive a reboot.
+func (o *Outbox) Save(result protocol.Message, outputDir string) error {
+	if strings.ContainsAny(result.ID, `/\`) || result.ID == "" {
+		return fmt.Errorf("invalid task id %q", result.ID)
+	}
+	entry := outboxEntry{Result: result, FilesDir: outputDir, Saved: time.Now()}
+	if outputDir != "" {
+		files := filepath.Join(o.dir, result.ID+".files")
+		if err := os.Rename(outputDir, files); err == nil {
+			entry.FilesDir = files
+		} else if os.IsNotExist(err) {
+			entry.FilesDir = ""
+		}
+	}
+	return o.write(&entry)
+}
+
+func (o *Outbox) write(entry *outboxEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	path := o.entryPath(entry.Result.ID)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (o *Outbox) load(taskID string) (*outboxEntry, error) {
+	data, err := os.ReadFile(o.entryPath(taskID))
+	if err != nil {
+		return nil, err
+	}
+	var entry outboxEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Pending lists the tasks whose results haven't been delivered
+func (o *Outbox) Pending() []string {
+	matches, _ := filepath.Glob(filepath.Join(o.dir, "*.json"))
+	ids := make([]string, 0, len(matches))
+	for _, m := range matches {
+		ids = append(ids, strings.TrimSuffix(filepath.Base(m), ".json"))
+	}
+	return ids
+}
+
+// Remove forgets a delivered result
+func (o *Outbox) Remove(taskID string) {
+	os.Remove(o.entryPath(taskID))
+	os.RemoveAll(filepath.Join(o.dir, taskID+".files"))
+	o.mu.Lock()
+	delete(o.lastSent, taskID)
+	o.mu.Unlock()
+}
+
+// begin claims a delivery attempt, unless one is running or the result was sent
+// recently (the server may still acknowledge it)
+func (o *Outbox) begin(taskID string, retry bool) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.inFlight[taskID] || retry && time.Since(o.lastSent[taskID]) < outboxRetryInterval {
+		return false
+	}
+	o.inFlight[taskID] = true
+	return true
+}
+
+func (o *Outbox) markSent(taskID string) {
+	o.mu.Lock()
+	o.lastSent[taskID] = time.Now()
+	o.mu.Unlock()
+}
+
+// forgetSent is called on a new connection: acks for results sent on the old one are lost
+func (o *Outbox) forgetSent() {
+	o.mu.Lock()
+	clear(o.lastSent)
+	o.mu.Unlock()
+}
+
+func (o *Outbox) end(taskID string) {
+	o.mu.Lock()
+	delete(o.inFlight, taskID)
+	o.mu.Unlock()
+}
+
+// deliver sends a stored task's output files and then its result. The entry stays in the
+// outbox until the server acknowledges the result (servers that predate result_ack: until
+// it was sent).
+func (c *Client) deliver(taskID string, retry bool) {
+	if !c.outbox.begin(taskID, retry) {
+		return
+	}
+	defer c.outbox.end(taskID)
+
+	entry, err := c.outbox.load(taskID)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[Task %s] Unreadable outbox entry, dropping it: %v", taskID, err)
+			c.outbox.Remove(taskID)
+		}
+		return
+	}
+	if retry {
+		log.Printf("[Task %s] Resending result saved %v ago", taskID, time.Since(entry.Saved).Round(time.Second))
+	}
+
+	if entry.FilesDir != "" {
+		if !c.collectAndSendFiles(taskID, entry.FilesDir) {
+			log.Printf("[Task %s] Output files not delivered, result kept in the outbox", taskID)
+			return
+		}
+		entry.FilesDir = ""
+		if err := c.outbox.write(entry); err != nil {
+			log.Printf("[Task %s] Failed to update outbox entry: %v", taskID, err)
+		}
+	}
+
+	if err := c.send(entry.Result); err != nil {
+		log.Printf("[Task %s] Result not delivered (%v), kept in the outbox until reconnected", taskID, err)
+		return
+	}
+	if protocol.Supports(int(c.protocol.Load()), protocol.MsgResultAck) {
+		c.outbox.markSent(taskID)
+		log.Printf("[Task %s] Result sent, waiting for the server to confirm it", taskID)
+		return
+	}
+	log.Printf("[Task %s] Result sent successfully", taskID)
+	c.outbox.Remove(taskID)
+}
+
+// handleResultAck drops a result the server confirmed
+func (c *Client) handleResultAck(msg protocol.Message) {
+	log.Printf("[Task %s] Result confirmed by the server", msg.ID)
+	c.outbox.Remove(msg.ID)
+}
+
+// outboxLoop resends undelivered results shortly after connecting, then periodically
+func (c *Client) outboxLoop(stop chan struct{}) {
+	c.outbox.forgetSent()
+	timer := time.NewTimer(outboxFirstDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-stop:
+			return
+		case <-timer.C:
+			for _, id := range c.outbox.Pending() {
+				go c.deliver(id, true)
+			}
+			timer.Reset(outboxRetryInterval)
+		}
+	}
+}
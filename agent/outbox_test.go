@@ -0,0 +1,116 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"minerva/protocol"
+)
+
+func TestOutbox(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	o, err := openOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputDir := filepath.Join(t.TempDir(), "task1")
+	if err := os.MkdirAll(outputDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, "report.txt"), []byte("report"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Save(protocol.Message{Type: protocol.MsgResult, ID: "task1", Output: "done"}, outputDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Save(protocol.Message{Type: protocol.MsgResult, ID: "task2", Output: "also done"}, filepath.Join(t.TempDir(), "gone")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted agent finds both results, with task1's files moved into the outbox
+	reopened, err := openOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := slices.Sorted(slices.Values(reopened.Pending())); !slices.Equal(got, []string{"task1", "task2"}) {
+		t.Errorf("pending = %v, want [task1 task2]", got)
+	}
+	entry, err := reopened.load("task1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Result.Output != "done" || entry.FilesDir != filepath.Join(dir, "task1.files") {
+		t.Errorf("task1 entry = %q in %q, want \"done\" in the outbox", entry.Result.Output, entry.FilesDir)
+	}
+	if data, err := os.ReadFile(filepath.Join(entry.FilesDir, "report.txt")); err != nil || string(data) != "report" {
+		t.Errorf("moved output file = %q, %v", data, err)
+	}
+	if entry, _ := reopened.load("task2"); entry.FilesDir != "" {
+		t.Errorf("task2 without output files has files dir %q", entry.FilesDir)
+	}
+
+	reopened.Remove("task1")
+	if got := reopened.Pending(); !slices.Equal(got, []string{"task2"}) {
+		t.Errorf("pending after remove = %v, want [task2]", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "task1.files")); !os.IsNotExist(err) {
+		t.Errorf("task1 files left behind: %v", err)
+	}
+}
+
+func TestOutboxSaveInvalidID(t *testing.T) {
+	o, err := openOutbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"", "../task", `dir\task`} {
+		if err := o.Save(protocol.Message{Type: protocol.MsgResult, ID: id}, ""); err == nil {
+			t.Errorf("Save(%q) succeeded, want error", id)
+		}
+	}
+}
+
+func TestOutboxBegin(t *testing.T) {
+	tests := []struct {
+		name     string
+		inFlight bool
+		sent     bool
+		retry    bool
+		want     bool
+	}{
+		{"first delivery", false, false, false, true},
+		{"retry", false, false, true, true},
+		{"in flight", true, false, false, false},
+		{"retry in flight", true, false, true, false},
+		{"retry awaiting ack", false, true, true, false},
+		{"new result awaiting ack", false, true, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := openOutbox(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.inFlight {
+				o.begin("task1", false)
+			}
+			if tt.sent {
+				o.markSent("task1")
+			}
+			if got := o.begin("task1", tt.retry); got != tt.want {
+				t.Errorf("begin(retry %v) = %v, want %v", tt.retry, got, tt.want)
+			}
+		})
+	}
+
+	// A new connection resends right away: acks for the old one are lost
+	o, _ := openOutbox(t.TempDir())
+	o.markSent("task1")
+	o.forgetSent()
+	if !o.begin("task1", true) {
+		t.Error("begin after forgetSent = false, want true")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"minerva/protocol"
+)
+
+// projectGitTimeout bounds the git commands run to describe one project
+const projectGitTimeout = 5 * time.Second
+
+// projectMarkers maps files at a project's root to the language and build system they imply
+var projectMarkers = []struct {
+	file, language, build string
+}{
+	{"go.mod", "Go", "go"},
+	{"Cargo.toml", "Rust", "cargo"},
+	{"tsconfig.json", "TypeScript", ""},
+	{"package.json", "JavaScript", "npm"},
+	{"pnpm-lock.yaml", "", "pnpm"},
+	{"yarn.lock", "", "yarn"},
+	{"bun.lockb", "", "bun"},
+	{"pyproject.toml", "Python", "pyproject"},
+	{"requirements.txt", "Python", "pip"},
+	{"setup.py", "Python", "setuptools"},
+	{"pom.xml", "Java", "maven"},
+	{"build.gradle", "Java", "gradle"},
+	{"build.gradle.kts", "Kotlin", "gradle"},
+	{"Gemfile", "Ruby", "bundler"},
+	{"composer.json", "PHP", "composer"},
+	{"mix.exs", "Elixir", "mix"},
+	{"Package.swift", "Swift", "swiftpm"},
+	{"pubspec.yaml", "Dart", "pub"},
+	{"CMakeLists.txt", "C/C++", "cmake"},
+	{"Makefile", "", "make"},
+	{"Dockerfile", "", "docker"},
+}
+
+// describeProjects describes each project folder, a few at a time
+func describeProjects(paths []string) []protocol.ProjectInfo {
+	infos := make([]protocol.ProjectInfo, len(paths))
+	sem := make(chan struct{}, 4)
+	var wg sync.WaitGroup
+	for i, path := range paths {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, path string) {
+			defer func() { <-sem; wg.Done() }()
+			infos[i] = describeProject(path)
+		}(i, path)
+	}
+	wg.Wait()
+	return infos
+}
+
+// describeProject reports a project's git state, languages, build systems and CLAUDE.md
+func describeProject(path string) protocol.ProjectInfo {
+	info := protocol.ProjectInfo{Path: path}
+
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(path, name))
+		return err == nil
+	}
+	for _, m := range projectMarkers {
+		if !exists(m.file) {
+			continue
+		}
+		info.Languages = appendUnique(info.Languages, m.language)
+		info.BuildSystems = appendUnique(info.BuildSystems, m.build)
+	}
+	// Another package manager's lockfile means package.json isn't built with npm
+	for _, pm := range []string{"pnpm", "yarn", "bun"} {
+		if containsString(info.BuildSystems, pm) {
+			info.BuildSystems = removeString(info.BuildSystems, "npm")
+			break
+		}
+	}
+	info.ClaudeMD = exists("CLAUDE.md")
+
+	if exists(".git") {
+		info.Git = true
+		describeGit(&info)
+	}
+	return info
+}
+
+// describeGit fills in the branch, working tree state and last commit of a repository
+func describeGit(info *protocol.ProjectInfo) {
+	ctx, cancel := context.WithTimeout(context.Background(), projectGitTimeout)
+	defer cancel()
+	run := func(args ...string) (string, bool) {
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", info.Path}, args...)...)
+		out, err := cmd.Output()
+		return string(out), err == nil
+	}
+
+	if out, ok := run("status", "--porcelain=v2", "--branch"); ok {
+		for _, line := range strings.Split(out, "\n") {
+			switch {
+			case strings.HasPrefix(line, "# branch.head "):
+				info.Branch = strings.TrimPrefix(line, "# branch.head ")
+			case strings.HasPrefix(line, "# branch.ab "):
+				// # branch.ab +2 -1
+				fields := strings.Fields(strings.TrimPrefix(line, "# branch.ab "))
+				if len(fields) == 2 {
+					info.Ahead, _ = strconv.Atoi(strings.TrimPrefix(fields[0], "+"))
+					info.Behind, _ = strconv.Atoi(strings.TrimPrefix(fields[1], "-"))
+				}
+			case line != "" && !strings.HasPrefix(line, "#"):
+				info.Dirty = true
+			}
+		}
+	}
+	if out, ok := run("log", "-1", "--format=%h %s%x00%cI"); ok {
+		if subject, at, found := strings.Cut(strings.TrimSpace(out), "\x00"); found {
+			info.LastCommit = truncate(subject, 100)
+			info.LastCommitAt = at
+		}
+	}
+}
+
+func appendUnique(list []string, s string) []string {
+	if s == "" || containsString(list, s) {
+		return list
+	}
+	return append(list, s)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(list []string, s string) []string {
+	out := list[:0]
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
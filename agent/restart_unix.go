@@ -0,0 +1,13 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// restart replaces this process with exe, keeping its arguments and environment
+func restart(exe string) error {
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
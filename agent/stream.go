@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// streamEvent is one line of `claude --output-format stream-json`
+type streamEvent struct {
+	Type    string `json:"type"`
+	Subtype string `json:"subtype"`
+	Message struct {
+		ID      string `json:"id"`
+		Content []struct {
+			Type  string          `json:"type"`
+			Text  string          `json:"text"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
+		} `json:"content"`
+	} `json:"message"`
+	Result       string  `json:"result"`
+	IsError      bool    `json:"is_error"`
+	SessionID    string  `json:"session_id"`
+	NumTurns     int     `json:"num_turns"`
+	TotalCostUSD float64 `json:"total_cost_usd"`
+}
+
+// streamResult is the final "result" event of a stream
+type streamResult struct {
+	Text    string
+	IsError bool
+}
+
+// streamWriter receives claude's stream-json stdout, reports progress line by line
+// and keeps the final result. Writes come from a single goroutine (exec's copier).
+type streamWriter struct {
+	raw        bytes.Buffer
+	partial    []byte
+	onProgress func(string)
+	result     *streamResult
+	sessionID  string   // Claude session, for resuming it with a follow-up
+	texts      []string // assistant text so far: the output of a run stopped before its result
+
+	mu          sync.Mutex // guards the usage, read while the run goes on
+	turns       int
+	costUSD     float64
+	lastMessage string // ID of the assistant message the last turn was counted for
+}
+
+func newStreamWriter(onProgress func(string)) *streamWriter {
+	return &streamWriter{onProgress: onProgress}
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.raw.Write(p)
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.handleLine(w.partial[:i])
+		w.partial = append(w.partial[:0], w.partial[i+1:]...)
+	}
+	return len(p), nil
+}
+
+func (w *streamWriter) handleLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+
+	var evt streamEvent
+	if err := json.Unmarshal(line, &evt); err != nil {
+		return // not stream-json (e.g. an older claude printing plain text)
+	}
+	if evt.SessionID != "" {
+		w.sessionID = evt.SessionID
+	}
+
+	switch evt.Type {
+	case "assistant":
+		// Content blocks of one message may arrive as separate events
+		w.mu.Lock()
+		if evt.Message.ID == "" || evt.Message.ID != w.lastMessage {
+			w.turns++
+			w.lastMessage = evt.Message.ID
+		}
+		w.mu.Unlock()
+
+		for _, block := range evt.Message.Content {
+			var progress string
+			switch block.Type {
+			case "text":
+				if text := strings.TrimSpace(block.Text); text != "" {
+					w.texts = append(w.texts, text)
+				}
+				progress = summarizeText(block.Text)
+			case "tool_use":
+				progress = summarizeToolUse(block.Name, block.Input)
+			}
+			if progress != "" && w.onProgress != nil {
+				w.onProgress(progress)
+			}
+		}
+	case "result":
+		w.result = &streamResult{Text: evt.Result, IsError: evt.IsError}
+		w.mu.Lock()
+		w.turns = max(w.turns, evt.NumTurns)
+		w.costUSD = evt.TotalCostUSD
+		w.mu.Unlock()
+	}
+}
+
+// Output returns the final result text. Without a result event (the run was stopped, or
+// an older claude printed plain text) it is what Claude said so far, or the raw output.
+func (w *streamWriter) Output() string {
+	if w.result != nil {
+		return w.result.Text
+	}
+	if len(w.texts) > 0 {
+		return strings.Join(w.texts, "\n\n")
+	}
+	return w.raw.String()
+}
+
+// SessionID returns the Claude session the run used
+func (w *streamWriter) SessionID() string {
+	return w.sessionID
+}
+
+// Failed reports whether Claude's result event marked the run as an error
+func (w *streamWriter) Failed() bool {
+	return w.result != nil && w.result.IsError
+}
+
+// Usage returns the assistant turns so far and the cost Claude reported with its result
+func (w *streamWriter) Usage() (int, float64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.turns, w.costUSD
+}
+
+// summarizeText shortens assistant text to its first line
+func summarizeText(text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return ""
+	}
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+	return "💬 " + truncate(text, 150)
+}
+
+// summarizeToolUse describes a tool call in one short line
+func summarizeToolUse(name string, raw json.RawMessage) string {
+	var input struct {
+		Command     string `json:"command"`
+		Description string `json:"description"`
+		FilePath    string `json:"file_path"`
+		Path        string `json:"path"`
+		Pattern     string `json:"pattern"`
+		URL         string `json:"url"`
+		Query       string `json:"query"`
+	}
+	json.Unmarshal(raw, &input)
+
+	switch name {
+	case "Bash":
+		if input.Description != "" {
+			return "⚙️ " + truncate(input.Description, 120)
+		}
+		return "⚙️ $ " + truncate(input.Command, 120)
+	case "Edit", "MultiEdit", "Write", "NotebookEdit":
+		return fmt.Sprintf("✏️ %s %s", name, filepath.Base(input.FilePath))
+	case "Read":
+		return "📖 Read " + filepath.Base(input.FilePath)
+	case "Grep", "Glob":
+		return fmt.Sprintf("🔍 %s %s", name, truncate(input.Pattern, 80))
+	case "WebFetch":
+		return "🌐 Fetch " + truncate(input.URL, 100)
+	case "WebSearch":
+		return "🌐 Search " + truncate(input.Query, 100)
+	case "Task":
+		return "🤖 Subagent: " + truncate(input.Description, 100)
+	case "TodoWrite":
+		return "📝 Updated todo list"
+	}
+	return "🔧 " + name
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"minerva/policy"
+	"minerva/protocol"
+)
+
+// Chunked file transfers with the server: file_begin announces a file (name, size,
+// SHA-256), file_chunk carries its data and the receiver answers each of them with a
+// file_ack holding how many bytes it has. Announcing the same transfer ID again
+// resumes an interrupted transfer from there.
+
+const (
+	fileChunkSize  = 256 * 1024
+	fileAckTimeout = 30 * time.Second
+	// maxUploadSize is the largest file Telegram accepts from a bot
+	maxUploadSize = 50 * 1024 * 1024
+)
+
+// errTransferUnsupported means the server doesn't handle file_begin (it predates chunked transfers)
+var errTransferUnsupported = errors.New("server does not support chunked transfers")
+
+// errAckTimeout means the server didn't answer a file_begin or file_chunk in time
+var errAckTimeout = errors.New("timeout waiting for file_ack")
+
+// incomingFile is a file the server is pushing to us. Data goes to a hidden .part
+// file next to the destination, renamed into place once the checksum matches.
+type incomingFile struct {
+	partPath  string
+	finalPath string
+	checksum  string
+	size      int64
+	received  int64
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// handleFileBegin prepares to receive a pushed file, picking up any partial data
+func (c *Client) handleFileBegin(msg protocol.Message) {
+	in, err := c.openIncoming(msg)
+	if err != nil {
+		log.Printf("[Transfer %s] Rejected %s: %v", msg.ID, msg.FileName, err)
+		c.send(protocol.Message{Type: protocol.MsgFileAck, ID: msg.ID, Error: err.Error()})
+		return
+	}
+	c.incoming[msg.ID] = in
+	log.Printf("[Transfer %s] Receiving %s (%d/%d bytes present)", msg.ID, in.finalPath, in.received, in.size)
+	c.ackIncoming(msg.ID, in)
+}
+
+func (c *Client) openIncoming(msg protocol.Message) (*incomingFile, error) {
+	name := filepath.Base(msg.FileName)
+	if msg.ID == "" || name == "." || name == string(filepath.Separator) {
+		return nil, fmt.Errorf("invalid transfer")
+	}
+	if msg.FileSize < 0 || len(msg.Checksum) != sha256.Size*2 {
+		return nil, fmt.Errorf("file size and SHA-256 checksum are required")
+	}
+
+	// A release goes next to our own binary, where only handleUpdate will look for it
+	var finalPath string
+	var err error
+	if msg.Action == updateAction {
+		if !c.canSelfUpdate() {
+			return nil, errNoSelfUpdate
+		}
+		finalPath, err = stagedUpdatePath()
+	} else if err = c.policy.AllowType(policy.TaskTypeFilePush); err == nil {
+		var checked string
+		if checked, err = c.policy.CheckPath(filepath.Join(msg.Dir, name), c.workingDir); err == nil {
+			finalPath = filepath.Join(filepath.Dir(checked), name)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(finalPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	in := &incomingFile{
+		partPath:  filepath.Join(dir, fmt.Sprintf(".%s.%s.part", filepath.Base(finalPath), msg.ID)),
+		finalPath: finalPath,
+		checksum:  msg.Checksum,
+		size:      msg.FileSize,
+	}
+	if info, err := os.Stat(in.partPath); err == nil && info.Size() <= in.size {
+		in.received = info.Size()
+	} else if err := os.WriteFile(in.partPath, nil, 0644); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// handleFileChunk appends a chunk to a pushed file
+func (c *Client) handleFileChunk(msg protocol.Message) {
+	in, ok := c.incoming[msg.ID]
+	if !ok {
+		c.send(protocol.Message{Type: protocol.MsgFileAck, ID: msg.ID, Error: "unknown transfer, send file_begin again"})
+		return
+	}
+
+	// A chunk we already have (or one too far ahead): just tell the server where we are
+	if msg.Offset == in.received {
+		data, err := base64.StdEncoding.DecodeString(msg.FileData)
+		if err == nil && in.received+int64(len(data)) > in.size {
+			err = fmt.Errorf("chunk exceeds announced size")
+		}
+		if err == nil {
+			err = appendFile(in.partPath, data)
+		}
+		if err != nil {
+			delete(c.incoming, msg.ID)
+			os.Remove(in.partPath)
+			c.send(protocol.Message{Type: protocol.MsgFileAck, ID: msg.ID, Error: err.Error()})
+			return
+		}
+		in.received += int64(len(data))
+	}
+	c.ackIncoming(msg.ID, in)
+}
+
+func appendFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ackIncoming reports progress, or verifies and moves the file into place once complete
+func (c *Client) ackIncoming(id string, in *incomingFile) {
+	if in.received < in.size {
+		c.send(protocol.Message{Type: protocol.MsgFileAck, ID: id, Offset: in.received})
+		return
+	}
+	delete(c.incoming, id)
+
+	sum, err := fileChecksum(in.partPath)
+	if err == nil && sum != in.checksum {
+		err = fmt.Errorf("checksum mismatch")
+	}
+	if err == nil {
+		err = os.Rename(in.partPath, in.finalPath)
+	}
+	if err != nil {
+		log.Printf("[Transfer %s] Failed to receive %s: %v", id, in.finalPath, err)
+		os.Remove(in.partPath)
+		c.send(protocol.Message{Type: protocol.MsgFileAck, ID: id, Error: err.Error()})
+		return
+	}
+
+	log.Printf("[Transfer %s] Received %s (%d bytes)", id, in.finalPath, in.size)
+	c.send(protocol.Message{Type: protocol.MsgFileAck, ID: id, Offset: in.received, Done: true, Output: in.finalPath})
+}
+
+// handleFileAck hands a server ack to the sendFile waiting for it
+func (c *Client) handleFileAck(msg protocol.Message) {
+	if ch, ok := c.transferAcks.Load(msg.ID); ok {
+		select {
+		case ch.(chan protocol.Message) <- msg:
+		default:
+		}
+	}
+}
+
+// sendFile uploads a task output file in chunks. Retries after a dropped connection
+// resume from what the server already has.
+func (c *Client) sendFile(taskID, path string, maxRetries int) error {
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return err
+	}
+	id := fmt.Sprintf("%s-%s", taskID, checksum[:12])
+
+	acks := make(chan protocol.Message, 1)
+	c.transferAcks.Store(id, acks)
+	defer c.transferAcks.Delete(id)
+
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			delay := time.Duration(1<<uint(attempt-1)) * 5 * time.Second // 5s, 10s, 20s
+			log.Printf("[Task %s] Retrying %s (%d/%d) in %v...", taskID, filepath.Base(path), attempt, maxRetries, delay)
+			time.Sleep(delay)
+		}
+
+		lastErr = c.transferFile(id, taskID, path, checksum, acks)
+		if lastErr == nil || errors.Is(lastErr, errTransferUnsupported) {
+			return lastErr
+		}
+		log.Printf("[Task %s] Transfer of %s interrupted: %v", taskID, filepath.Base(path), lastErr)
+	}
+	return fmt.Errorf("all %d transfer attempts failed, last error: %w", maxRetries+1, lastErr)
+}
+
+func (c *Client) transferFile(id, taskID, path, checksum string, acks chan protocol.Message) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Drop acks left over from a previous attempt
+	select {
+	case <-acks:
+	default:
+	}
+
+	send := func(msg protocol.Message) (protocol.Message, error) {
+		if err := c.send(msg); err != nil {
+			return protocol.Message{}, err
+		}
+		select {
+		case ack := <-acks:
+			if ack.Error != "" {
+				return ack, fmt.Errorf("server rejected %s: %s", filepath.Base(path), ack.Error)
+			}
+			return ack, nil
+		case <-time.After(fileAckTimeout):
+			return protocol.Message{}, fmt.Errorf("%s: %w", filepath.Base(path), errAckTimeout)
+		}
+	}
+
+	ack, err := send(protocol.Message{
+		Type:     protocol.MsgFileBegin,
+		ID:       id,
+		TaskID:   taskID,
+		FileName: filepath.Base(path),
+		FileSize: info.Size(),
+		Checksum: checksum,
+	})
+	if err != nil {
+		if ack.Type == protocol.MsgUnsupported || errors.Is(err, errAckTimeout) && !c.serverHasTransfers() {
+			return errTransferUnsupported
+		}
+		return err
+	}
+	c.transfersSupported.Store(true)
+	if ack.Offset > 0 && !ack.Done {
+		log.Printf("[Task %s] Resuming %s at %d/%d bytes", taskID, filepath.Base(path), ack.Offset, info.Size())
+	}
+
+	buf := make([]byte, fileChunkSize)
+	for !ack.Done {
+		if ack.Offset < 0 || ack.Offset >= info.Size() {
+			return fmt.Errorf("server reported an invalid offset for %s", filepath.Base(path))
+		}
+		n, err := f.ReadAt(buf, ack.Offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		ack, err = send(protocol.Message{
+			Type:     protocol.MsgFileChunk,
+			ID:       id,
+			Offset:   ack.Offset,
+			FileData: base64.StdEncoding.EncodeToString(buf[:n]),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serverHasTransfers reports whether the server has ever acked a chunked transfer
+func (c *Client) serverHasTransfers() bool {
+	ok, _ := c.transfersSupported.Load().(bool)
+	return ok
+}
+
+// sendFileLegacy uploads a whole file in one file_upload message, for older servers
+func (c *Client) sendFileLegacy(taskID, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return c.sendWithRetry(protocol.Message{
+		Type:     protocol.MsgFileUpload,
+		ID:       taskID,
+		FileName: filepath.Base(path),
+		FileSize: int64(len(data)),
+		FileData: base64.StdEncoding.EncodeToString(data),
+	}, 3)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"minerva/policy"
+	"minerva/protocol"
+)
+
+// Self-update: the server pushes a release with file_begin (Action "update"), which is
+// staged next to our binary, then sends update. The release is only installed if it is
+// signed with UpdateKey; the previous binary is kept as <binary>.old.
+
+// updateAction marks a file_begin carrying a release of the agent
+const updateAction = "update"
+
+// errNoSelfUpdate means the agent was built without an update key, or its policy forbids updates
+var errNoSelfUpdate = errors.New("this agent doesn't update itself (built without an update key, or not allowed by its policy)")
+
+// canSelfUpdate reports whether this agent installs releases from the server
+func (c *Client) canSelfUpdate() bool {
+	return UpdateKey != "" && c.policy.AllowType(policy.TaskTypeUpdate) == nil
+}
+
+// executablePath is the binary we are running, with symlinks resolved. It is looked up
+// once: after an update renames the binary, the OS reports it under its .old name.
+var executablePath = sync.OnceValues(func() (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(exe)
+})
+
+// stagedUpdatePath is where a pushed release waits to replace the running binary
+func stagedUpdatePath() (string, error) {
+	exe, err := executablePath()
+	if err != nil {
+		return "", err
+	}
+	return exe + ".new", nil
+}
+
+// handleUpdate installs a staged release and restarts into it, right away if no task
+// is running or else once the last one finishes
+func (c *Client) handleUpdate(msg protocol.Message) {
+	if err := c.installUpdate(msg); err != nil {
+		log.Printf("[Update] Not installing %s: %v", msg.Version, err)
+		c.send(protocol.Message{Type: protocol.MsgUpdateResult, ID: msg.ID, Error: err.Error()})
+		return
+	}
+
+	restarting := c.idle()
+	log.Printf("[Update] Installed %s (was %s)", msg.Version, agentVersion())
+	c.restartPending.Store(true)
+	c.send(protocol.Message{
+		Type:    protocol.MsgUpdateResult,
+		ID:      msg.ID,
+		Version: msg.Version,
+		Output:  fmt.Sprintf("installed %s", msg.Version),
+		Done:    restarting,
+	})
+	if restarting {
+		c.restartIfIdle()
+	} else {
+		log.Printf("[Update] Restarting once running tasks finish")
+	}
+}
+
+// installUpdate verifies the staged release against the update key and swaps it in
+func (c *Client) installUpdate(msg protocol.Message) error {
+	if !c.canSelfUpdate() {
+		return errNoSelfUpdate
+	}
+	if msg.OS != runtime.GOOS || msg.Arch != runtime.GOARCH {
+		return fmt.Errorf("release is for %s/%s, this agent runs on %s/%s", msg.OS, msg.Arch, runtime.GOOS, runtime.GOARCH)
+	}
+	// An old signed release must not be replayable to bring back a vulnerable build. A dev
+	// build has no release version to compare with, so any release is an upgrade.
+	if _, ok := protocol.ParseVersion(msg.Version); !ok {
+		return fmt.Errorf("%q is not a release version", msg.Version)
+	}
+	if cmp, ok := protocol.CompareVersions(msg.Version, agentVersion()); ok && cmp <= 0 && !allowDowngrade {
+		return fmt.Errorf("release %s is not newer than this agent's %s (start the agent with -allow-downgrade to accept it)", msg.Version, agentVersion())
+	}
+	staged, err := stagedUpdatePath()
+	if err != nil {
+		return err
+	}
+	if msg.FileName != staged {
+		return fmt.Errorf("release wasn't staged at %s", staged)
+	}
+
+	sum, err := fileChecksum(staged)
+	if err != nil {
+		return err
+	}
+	if sum != msg.Checksum {
+		return fmt.Errorf("checksum mismatch")
+	}
+	switch err := protocol.VerifyUpdate(UpdateKey, msg.Signature, msg.Version, msg.OS, msg.Arch, msg.Checksum); {
+	case errors.Is(err, protocol.ErrBadUpdateKey):
+		return fmt.Errorf("invalid update key built into this agent")
+	case err != nil:
+		os.Remove(staged)
+		return fmt.Errorf("release %s is not signed with this agent's update key", msg.Version)
+	}
+
+	if err := os.Chmod(staged, 0755); err != nil {
+		return err
+	}
+	exe := strings.TrimSuffix(staged, ".new")
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, exe); err != nil {
+		os.Rename(old, exe)
+		return err
+	}
+	return nil
+}
+
+// idle reports whether no task is running
+func (c *Client) idle() bool {
+	idle := true
+	c.runningTasks.Range(func(_, _ any) bool {
+		idle = false
+		return false
+	})
+	return idle
+}
+
+// restartIfIdle restarts into an installed update once no task is running
+func (c *Client) restartIfIdle() {
+	if !c.restartPending.Load() || !c.idle() {
+		return
+	}
+	exe, err := executablePath()
+	if err != nil {
+		log.Printf("[Update] Can't restart: %v", err)
+		return
+	}
+	log.Printf("[Update] Restarting into %s", exe)
+	c.closeConn()
+	if err := restart(exe); err != nil {
+		log.Printf("[Update] Restart failed, the update applies on the next start: %v", err)
+		c.restartPending.Store(false)
+	}
+}
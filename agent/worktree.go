@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"minerva/policy"
+	"minerva/protocol"
+)
+
+// Worktree mode: a task that names a worktree runs in its own git worktree, on branch
+// minerva/<name>, instead of the live checkout. When Claude is done its changes are
+// committed there and the server gets the diff to review; nothing reaches the working
+// copy until the admin applies it with a worktree_action.
+
+// Actions the server can take on a task's worktree
+const (
+	WorktreeApply   = "apply"   // apply the diff to the live working copy
+	WorktreeBranch  = "branch"  // push the branch and open a PR
+	WorktreeDiscard = "discard" // drop the worktree and its branch
+)
+
+const worktreeBranchPrefix = "minerva/"
+
+var worktreeNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// worktree is a task's isolated checkout, described by a sidecar file in worktreesDir
+type worktree struct {
+	Name   string `json:"name"`
+	Repo   string `json:"repo"` // top level of the live checkout
+	Path   string `json:"path"`
+	Branch string `json:"branch"`
+	Base   string `json:"base"` // commit the worktree started from
+}
+
+func worktreesDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".minerva-worktrees"), nil
+}
+
+func sidecarPath(name string) (string, error) {
+	root, err := worktreesDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, name+".json"), nil
+}
+
+// git runs a git command in dir and returns its trimmed output
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", strings.Join(args, " "), msg)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// loadWorktree reads the sidecar of the worktree called name
+func loadWorktree(name string) (*worktree, error) {
+	if !worktreeNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid worktree name %q", name)
+	}
+	path, err := sidecarPath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("worktree %s not found (already applied or discarded?)", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	wt := &worktree{}
+	if err := json.Unmarshal(data, wt); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return wt, nil
+}
+
+func (wt *worktree) save() error {
+	path, err := sidecarPath(wt.Name)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(wt, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// openWorktree returns the worktree called name for the repository containing dir,
+// creating it from the checkout's HEAD if needed, and the directory in it matching dir
+func openWorktree(name, dir string) (*worktree, string, error) {
+	if !worktreeNamePattern.MatchString(name) {
+		return nil, "", fmt.Errorf("invalid worktree name %q", name)
+	}
+	repo, err := git(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, "", fmt.Errorf("worktree mode needs a git repository, and %s is not in one", dir)
+	}
+	repo = policy.ResolvePath(repo)
+	rel, err := filepath.Rel(repo, policy.ResolvePath(dir))
+	if err != nil || !policy.IsWithin(filepath.Join(repo, rel), repo) {
+		return nil, "", fmt.Errorf("%s is not inside %s", dir, repo)
+	}
+
+	// A follow-up continues in the worktree of the task it follows
+	if wt, err := loadWorktree(name); err == nil {
+		if wt.Repo != repo {
+			return nil, "", fmt.Errorf("worktree %s belongs to %s, not %s", name, wt.Repo, repo)
+		}
+		if _, err := os.Stat(wt.Path); err != nil {
+			// Checkout deleted by hand: check the branch out again
+			git(repo, "worktree", "prune")
+			if _, err := git(repo, "worktree", "add", wt.Path, wt.Branch); err != nil {
+				return nil, "", err
+			}
+		}
+		return wt, filepath.Join(wt.Path, rel), nil
+	}
+
+	root, err := worktreesDir()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, "", err
+	}
+	base, err := git(repo, "rev-parse", "HEAD")
+	if err != nil {
+		return nil, "", fmt.Errorf("worktree mode needs a commit to start from: %w", err)
+	}
+	wt := &worktree{
+		Name:   name,
+		Repo:   repo,
+		Path:   filepath.Join(root, filepath.Base(repo)+"-"+name),
+		Branch: worktreeBranchPrefix + name,
+		Base:   base,
+	}
+	if _, err := git(repo, "worktree", "add", "-b", wt.Branch, wt.Path, base); err != nil {
+		return nil, "", err
+	}
+	if err := wt.save(); err != nil {
+		wt.remove(true)
+		return nil, "", err
+	}
+	log.Printf("[Worktree %s] Created %s on branch %s (base %s)", name, wt.Path, wt.Branch, shortSHA(base))
+	return wt, filepath.Join(wt.Path, rel), nil
+}
+
+// commit records whatever Claude left in the worktree and returns the summary of
+// all changes since the base ("" if there are none)
+func (wt *worktree) commit(message string) (string, error) {
+	if _, err := git(wt.Path, "add", "-A"); err != nil {
+		return "", err
+	}
+	status, err := git(wt.Path, "status", "--porcelain")
+	if err != nil {
+		return "", err
+	}
+	if status != "" {
+		if _, err := git(wt.Path, "-c", "user.name=Minerva", "-c", "user.email=minerva@localhost",
+			"commit", "-q", "--no-verify", "-m", message); err != nil {
+			return "", err
+		}
+	}
+	return git(wt.Path, "diff", "--stat", wt.Base, "HEAD")
+}
+
+// writePatch saves the changes since the base as a patch git apply accepts
+func (wt *worktree) writePatch(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("git", "diff", "--binary", wt.Base, "HEAD")
+	cmd.Dir = wt.Path
+	cmd.Stdout = f
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("git diff: %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// apply brings the worktree's changes into the live working copy, uncommitted.
+// Nothing is touched unless the whole patch applies cleanly.
+func (wt *worktree) apply() (string, error) {
+	stat, err := git(wt.Path, "diff", "--stat", wt.Base, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if stat == "" {
+		wt.remove(true)
+		return "No changes to apply; worktree removed", nil
+	}
+
+	patch, err := os.CreateTemp("", "minerva-worktree-*.patch")
+	if err != nil {
+		return "", err
+	}
+	patch.Close()
+	defer os.Remove(patch.Name())
+	if err := wt.writePatch(patch.Name()); err != nil {
+		return "", err
+	}
+
+	if _, err := git(wt.Repo, "apply", "--check", patch.Name()); err != nil {
+		return "", fmt.Errorf("the changes don't apply cleanly to %s (the worktree is kept; open a PR branch instead): %v", wt.Repo, err)
+	}
+	if _, err := git(wt.Repo, "apply", patch.Name()); err != nil {
+		return "", err
+	}
+	log.Printf("[Worktree %s] Applied to %s", wt.Name, wt.Repo)
+	wt.remove(true)
+	return fmt.Sprintf("Applied to %s (uncommitted):\n%s", wt.Repo, stat), nil
+}
+
+// publish pushes the branch and opens a PR when a remote and the gh CLI are available.
+// The branch is kept; only the worktree checkout is removed.
+func (wt *worktree) publish() (string, error) {
+	lines := []string{fmt.Sprintf("Branch %s is in %s", wt.Branch, wt.Repo)}
+	if _, err := git(wt.Repo, "remote", "get-url", "origin"); err == nil {
+		if _, err := git(wt.Path, "push", "-u", "origin", wt.Branch); err != nil {
+			return "", err
+		}
+		lines = append(lines, "Pushed to origin")
+
+		if _, err := exec.LookPath("gh"); err == nil {
+			cmd := exec.Command("gh", "pr", "create", "--fill", "--head", wt.Branch)
+			cmd.Dir = wt.Path
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				lines = append(lines, fmt.Sprintf("gh pr create failed: %s", strings.TrimSpace(string(out))))
+			} else {
+				lines = append(lines, "PR: "+strings.TrimSpace(string(out)))
+			}
+		}
+	}
+	log.Printf("[Worktree %s] Published branch %s", wt.Name, wt.Branch)
+	wt.remove(false)
+	return strings.Join(lines, "\n"), nil
+}
+
+// remove deletes the worktree checkout and its sidecar, and the branch if asked to
+func (wt *worktree) remove(deleteBranch bool) error {
+	_, err := git(wt.Repo, "worktree", "remove", "--force", wt.Path)
+	if err != nil {
+		// Not registered any more (e.g. deleted by hand): just clear what's left
+		os.RemoveAll(wt.Path)
+		git(wt.Repo, "worktree", "prune")
+	}
+	if deleteBranch {
+		if _, berr := git(wt.Repo, "branch", "-D", wt.Branch); berr != nil && err == nil {
+			err = berr
+		}
+	}
+	if path, perr := sidecarPath(wt.Name); perr == nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// finishWorktree commits a task's changes and writes them as a patch into outputDir,
+// so they reach the user with the task's files. Returns the diff summary and whether
+// the worktree was kept for review; one without changes is removed right away.
+func (c *Client) finishWorktree(taskID string, wt *worktree, outputDir string) (string, bool) {
+	stat, err := wt.commit(fmt.Sprintf("minerva: task %s", taskID))
+	if err != nil {
+		log.Printf("[Task %s] Failed to commit worktree %s: %v", taskID, wt.Path, err)
+		return fmt.Sprintf("(failed to collect the changes: %v)", err), true
+	}
+	if stat == "" {
+		log.Printf("[Task %s] No changes in worktree %s, removing it", taskID, wt.Path)
+		if err := wt.remove(true); err != nil {
+			log.Printf("[Task %s] Failed to remove worktree %s: %v", taskID, wt.Path, err)
+		}
+		return "", false
+	}
+
+	if outputDir != "" {
+		patch := filepath.Join(outputDir, fmt.Sprintf("%s.patch", strings.ReplaceAll(wt.Branch, "/", "-")))
+		if err := wt.writePatch(patch); err != nil {
+			log.Printf("[Task %s] Failed to write patch: %v", taskID, err)
+		}
+	}
+	log.Printf("[Task %s] Changes kept for review on %s", taskID, wt.Branch)
+	return stat, true
+}
+
+// handleWorktreeAction applies, publishes or discards a task's worktree on the admin's request
+func (c *Client) handleWorktreeAction(msg protocol.Message) {
+	reply := protocol.Message{Type: protocol.MsgWorktreeResult, ID: msg.ID, Worktree: msg.Worktree}
+
+	wt, err := loadWorktree(msg.Worktree)
+	if err == nil {
+		if taskID, busy := c.worktreeTasks.Load(msg.Worktree); busy {
+			err = fmt.Errorf("task %s is still running in worktree %s", taskID, msg.Worktree)
+		}
+	}
+	if err == nil {
+		switch msg.Action {
+		case WorktreeApply:
+			reply.Output, err = wt.apply()
+		case WorktreeBranch:
+			reply.Output, err = wt.publish()
+		case WorktreeDiscard:
+			if err = wt.remove(true); err == nil {
+				reply.Output = fmt.Sprintf("Discarded worktree and branch %s", wt.Branch)
+				log.Printf("[Worktree %s] Discarded", wt.Name)
+			}
+		default:
+			err = fmt.Errorf("unknown worktree action %q", msg.Action)
+		}
+	}
+	if err != nil {
+		log.Printf("[Worktree %s] %s failed: %v", msg.Worktree, msg.Action, err)
+		reply.Error = err.Error()
+	}
+
+	if err := c.send(reply); err != nil {
+		log.Printf("[Worktree %s] Failed to send result: %v", msg.Worktree, err)
+	}
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBroadcastTimeout is how long a broadcast waits for its sub-tasks before reporting
+	DefaultBroadcastTimeout = 30 * time.Minute
+	// MaxBroadcastTimeout caps a broadcast's wait
+	MaxBroadcastTimeout = 24 * time.Hour
+	// broadcastReportBudget caps the outputs quoted in a broadcast report, shared by all agents
+	broadcastReportBudget = 3600
+)
+
+// Broadcast is one task fanned out to several agents. Its sub-tasks run like any other
+// task, but their results are held back and reported together, once all of them end or
+// the broadcast times out.
+type Broadcast struct {
+	ID       string
+	Prompt   string
+	Selector string // "" = every connected agent
+	Started  time.Time
+	Tasks    []*BroadcastTask // sorted by agent name
+	timer    *time.Timer
+	done     bool
+}
+
+// BroadcastTask is a broadcast's sub-task on one agent
+type BroadcastTask struct {
+	Agent    string `json:"agent"`
+	TaskID   string `json:"task_id,omitempty"`
+	Position int    `json:"position,omitempty"` // queue position if the agent was busy
+	Status   string `json:"status,omitempty"`   // the outcome, once the sub-task ended
+	Output   string `json:"-"`
+	Error    string `json:"error,omitempty"` // why it couldn't be submitted
+}
+
+// Broadcast sends a task to every connected agent matching selector ("" = all of them)
+// and tracks each sub-task. One aggregated report goes to the brain once all sub-tasks
+// end or timeout passes (0 = DefaultBroadcastTimeout); a sub-task that ends later is
+// reported on its own. Returns the broadcast's ID and its sub-tasks as submitted.
+func (h *AgentHub) Broadcast(selector, prompt, dir string, opts TaskOptions, timeout time.Duration) (string, []BroadcastTask, error) {
+	if timeout <= 0 {
+		timeout = DefaultBroadcastTimeout
+	}
+	if timeout > MaxBroadcastTimeout {
+		return "", nil, fmt.Errorf("timeout too long (max %v)", MaxBroadcastTimeout)
+	}
+	var sel AgentSelector
+	if selector != "" {
+		var err error
+		if sel, err = ParseSelector(selector); err != nil {
+			return "", nil, err
+		}
+	}
+
+	b := &Broadcast{
+		ID:       fmt.Sprintf("bc_%d", time.Now().UnixNano()),
+		Prompt:   prompt,
+		Selector: selector,
+		Started:  time.Now(),
+	}
+	dirs := map[string]string{}
+
+	h.mu.Lock()
+	for name, agent := range h.agents {
+		if sel != nil && !sel.Matches(agent) {
+			continue
+		}
+		dirs[name] = dir
+		if sel != nil {
+			dirs[name] = taskDirFor(agent, sel, dir)
+		}
+		b.Tasks = append(b.Tasks, &BroadcastTask{Agent: name})
+	}
+	if len(b.Tasks) == 0 {
+		h.mu.Unlock()
+		if sel != nil {
+			return "", nil, fmt.Errorf("no connected agent matches %s", sel)
+		}
+		return "", nil, fmt.Errorf("no agents connected")
+	}
+	sort.Slice(b.Tasks, func(i, j int) bool { return b.Tasks[i].Agent < b.Tasks[j].Agent })
+	// Track the sub-tasks by ID before they start, so none can end unnoticed
+	for i, t := range b.Tasks {
+		t.TaskID = fmt.Sprintf("%d", time.Now().UnixNano()+int64(i))
+		h.broadcastTasks[t.TaskID] = b
+	}
+	h.mu.Unlock()
+
+	// Submit in parallel: each start waits for its agent's ACK
+	var wg sync.WaitGroup
+	for _, t := range b.Tasks {
+		wg.Add(1)
+		go func(t *BroadcastTask) {
+			defer wg.Done()
+			taskOpts := opts
+			taskOpts.TaskID = t.TaskID
+			_, position, err := h.SubmitTask(t.Agent, prompt, dirs[t.Agent], taskOpts)
+
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if err != nil {
+				delete(h.broadcastTasks, t.TaskID)
+				t.Error = err.Error()
+				t.Status = AgentTaskFailed
+				return
+			}
+			t.Position = position
+		}(t)
+	}
+	wg.Wait()
+	log.Printf("[AgentHub] Broadcast %s sent to %d agent(s): %s", b.ID, len(b.Tasks), truncateText(prompt, 100))
+
+	h.mu.Lock()
+	b.timer = time.AfterFunc(timeout, func() { h.finishBroadcast(b, true) })
+	pending := b.pending()
+	tasks := make([]BroadcastTask, len(b.Tasks))
+	for i, t := range b.Tasks {
+		tasks[i] = *t
+	}
+	h.mu.Unlock()
+
+	// Nothing left to wait for (every sub-task failed to start, or all ended already)
+	if pending == 0 {
+		h.finishBroadcast(b, false)
+	}
+	return b.ID, tasks, nil
+}
+
+// pending counts sub-tasks that haven't ended. Must be called with h.mu held.
+func (b *Broadcast) pending() int {
+	n := 0
+	for _, t := range b.Tasks {
+		if t.Status == "" {
+			n++
+		}
+	}
+	return n
+}
+
+// inBroadcast reports whether a task's result is held back for a broadcast report
+func (h *AgentHub) inBroadcast(taskID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.broadcastTasks[taskID]
+	return ok
+}
+
+// broadcastTaskDone records a sub-task's outcome, reporting the broadcast once it was the last
+func (h *AgentHub) broadcastTaskDone(taskID, status, output string) {
+	h.mu.Lock()
+	b, ok := h.broadcastTasks[taskID]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.broadcastTasks, taskID)
+	for _, t := range b.Tasks {
+		if t.TaskID == taskID {
+			t.Status = status
+			t.Output = output
+		}
+	}
+	// Wait for the submitting goroutines to arm the timer before finishing
+	finished := b.timer != nil && b.pending() == 0
+	h.mu.Unlock()
+
+	if finished {
+		h.finishBroadcast(b, false)
+	}
+}
+
+// finishBroadcast sends the aggregated report, once
+func (h *AgentHub) finishBroadcast(b *Broadcast, timedOut bool) {
+	h.mu.Lock()
+	if b.done {
+		h.mu.Unlock()
+		return
+	}
+	b.done = true
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	// Sub-tasks still running report on their own from now on
+	for _, t := range b.Tasks {
+		delete(h.broadcastTasks, t.TaskID)
+	}
+	report := b.report(timedOut)
+	onResult := h.onResult
+	h.mu.Unlock()
+
+	log.Printf("[AgentHub] Broadcast %s finished (timed out: %v)", b.ID, timedOut)
+	if onResult != nil {
+		onResult(report)
+	}
+}
+
+// report summarizes every sub-task's outcome. Must be called with h.mu held.
+func (b *Broadcast) report(timedOut bool) string {
+	target := "all agents"
+	if b.Selector != "" {
+		target = "agents matching " + b.Selector
+	}
+	counts := map[string]int{}
+	for _, t := range b.Tasks {
+		status := t.Status
+		if status == "" {
+			status = "unfinished"
+		}
+		counts[status]++
+	}
+	var tally []string
+	for _, status := range []string{AgentTaskCompleted, AgentTaskFailed, AgentTaskKilled, AgentTaskBudgetExceeded, AgentTaskStale, AgentTaskLost, AgentTaskCancelled, AgentTaskExpired, "unfinished"} {
+		if counts[status] > 0 {
+			tally = append(tally, fmt.Sprintf("%d %s", counts[status], status))
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("[BROADCAST %s to %s, %d agent(s)] (task: %s)\n", b.ID, target, len(b.Tasks), truncateText(b.Prompt, 200)))
+	sb.WriteString(strings.Join(tally, ", "))
+	if timedOut {
+		sb.WriteString(fmt.Sprintf(" — timed out after %v; unfinished tasks will report on their own", time.Since(b.Started).Round(time.Second)))
+	}
+	sb.WriteString("\n")
+
+	perAgent := broadcastReportBudget / len(b.Tasks)
+	for _, t := range b.Tasks {
+		icon := map[string]string{AgentTaskCompleted: "✅", "": "⏳"}[t.Status]
+		if icon == "" {
+			icon = "❌"
+		}
+		sb.WriteString(fmt.Sprintf("\n%s %s", icon, t.Agent))
+		switch {
+		case t.Error != "":
+			sb.WriteString(fmt.Sprintf(": not started: %s\n", t.Error))
+			continue
+		case t.Status == "":
+			sb.WriteString(fmt.Sprintf(" (task %s): still running or queued\n", t.TaskID))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf(" (task %s): %s\n", t.TaskID, t.Status))
+		if out := strings.TrimSpace(t.Output); out != "" {
+			sb.WriteString(truncateText(out, perAgent) + "\n")
+		}
+	}
+	return sb.String()
+}
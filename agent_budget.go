@@ -0,0 +1,266 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"minerva/protocol"
+)
+
+// Task budgets: every task goes out with limits on its wall time, and on its turns and cost
+// for executors that report them. Limits come from the task itself, else the agent's own
+// defaults (`minerva agent budget`), else the server's (AGENT_TASK_MAX_*). The agent stops a
+// task that runs over and returns its partial output with the status budget_exceeded.
+
+// AgentTaskBudgetExceeded is the outcome of a task its agent stopped for running over budget
+const AgentTaskBudgetExceeded = "budget_exceeded"
+
+// AgentBudget is an agent's default task limits; zero fields fall back to the server's
+type AgentBudget struct {
+	Name      string          `json:"name"`
+	Budget    protocol.Budget `json:"budget"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// InitAgentBudgetTable creates the agent_budgets table
+func (db *DB) InitAgentBudgetTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS agent_budgets (
+			name TEXT PRIMARY KEY,
+			max_duration_sec INTEGER NOT NULL DEFAULT 0,
+			max_turns INTEGER NOT NULL DEFAULT 0,
+			max_cost_usd REAL NOT NULL DEFAULT 0,
+			updated_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create agent_budgets table: %w", err)
+	}
+	return nil
+}
+
+// SetAgentBudget stores an agent's default limits; an all-zero budget removes them
+func (db *DB) SetAgentBudget(name string, b protocol.Budget) error {
+	if b == (protocol.Budget{}) {
+		_, err := db.Exec(`DELETE FROM agent_budgets WHERE name = ?`, name)
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT INTO agent_budgets (name, max_duration_sec, max_turns, max_cost_usd, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET max_duration_sec = excluded.max_duration_sec,
+			max_turns = excluded.max_turns, max_cost_usd = excluded.max_cost_usd, updated_at = excluded.updated_at
+	`, name, b.MaxDurationSec, b.MaxTurns, b.MaxCostUSD, time.Now().Format(time.RFC3339))
+	return err
+}
+
+// GetAgentBudget returns an agent's default limits, or nil if it has none
+func (db *DB) GetAgentBudget(name string) (*AgentBudget, error) {
+	rows, err := db.Query(`
+		SELECT name, max_duration_sec, max_turns, max_cost_usd, updated_at
+		FROM agent_budgets WHERE name = ?
+	`, name)
+	if err != nil {
+		return nil, err
+	}
+	budgets, err := scanAgentBudgets(rows)
+	if err != nil || len(budgets) == 0 {
+		return nil, err
+	}
+	return &budgets[0], nil
+}
+
+// ListAgentBudgets returns the agents that have their own default limits
+func (db *DB) ListAgentBudgets() ([]AgentBudget, error) {
+	rows, err := db.Query(`
+		SELECT name, max_duration_sec, max_turns, max_cost_usd, updated_at
+		FROM agent_budgets ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return scanAgentBudgets(rows)
+}
+
+func scanAgentBudgets(rows *sql.Rows) ([]AgentBudget, error) {
+	defer rows.Close()
+
+	var budgets []AgentBudget
+	for rows.Next() {
+		var b AgentBudget
+		var updatedAt string
+		if err := rows.Scan(&b.Name, &b.Budget.MaxDurationSec, &b.Budget.MaxTurns, &b.Budget.MaxCostUSD, &updatedAt); err != nil {
+			return nil, err
+		}
+		b.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+		budgets = append(budgets, b)
+	}
+	return budgets, rows.Err()
+}
+
+// parseBudget builds a budget from a wall time (e.g. "30m"), max turns and max cost in USD.
+// Empty or zero values leave that limit to the defaults.
+func parseBudget(maxTime string, maxTurns int, maxCostUSD float64) (protocol.Budget, error) {
+	var b protocol.Budget
+	if maxTime != "" {
+		d, err := time.ParseDuration(maxTime)
+		if err != nil {
+			return b, fmt.Errorf("invalid max time %q: %w", maxTime, err)
+		}
+		if d < 0 || d > 0 && d < time.Second {
+			return b, fmt.Errorf("max time must be at least 1s")
+		}
+		b.MaxDurationSec = int64(d / time.Second)
+	}
+	if maxTurns < 0 {
+		return b, fmt.Errorf("max turns can't be negative")
+	}
+	if maxCostUSD < 0 {
+		return b, fmt.Errorf("max cost can't be negative")
+	}
+	b.MaxTurns = maxTurns
+	b.MaxCostUSD = maxCostUSD
+	return b, nil
+}
+
+// budgetOption is parseBudget for a task's own limits: nil if it sets none
+func budgetOption(maxTime string, maxTurns int, maxCostUSD float64) (*protocol.Budget, error) {
+	b, err := parseBudget(maxTime, maxTurns, maxCostUSD)
+	if err != nil || b == (protocol.Budget{}) {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// overlayBudget returns base with the limits set in over replacing its own
+func overlayBudget(base, over protocol.Budget) protocol.Budget {
+	if over.MaxDurationSec > 0 {
+		base.MaxDurationSec = over.MaxDurationSec
+	}
+	if over.MaxTurns > 0 {
+		base.MaxTurns = over.MaxTurns
+	}
+	if over.MaxCostUSD > 0 {
+		base.MaxCostUSD = over.MaxCostUSD
+	}
+	return base
+}
+
+// formatBudget describes a budget's limits, e.g. "30m0s, 40 turns, $2.50"
+func formatBudget(b *protocol.Budget) string {
+	if b == nil {
+		return "unlimited"
+	}
+	var limits []string
+	if b.MaxDurationSec > 0 {
+		limits = append(limits, (time.Duration(b.MaxDurationSec) * time.Second).String())
+	}
+	if b.MaxTurns > 0 {
+		limits = append(limits, fmt.Sprintf("%d turns", b.MaxTurns))
+	}
+	if b.MaxCostUSD > 0 {
+		limits = append(limits, fmt.Sprintf("$%.2f", b.MaxCostUSD))
+	}
+	if len(limits) == 0 {
+		return "unlimited"
+	}
+	return strings.Join(limits, ", ")
+}
+
+// formatUsage describes the turns and cost a result reported, or returns ""
+func formatUsage(msg protocol.Message) string {
+	var usage []string
+	if msg.Turns > 0 {
+		usage = append(usage, fmt.Sprintf("%d turns", msg.Turns))
+	}
+	if msg.CostUSD > 0 {
+		usage = append(usage, fmt.Sprintf("$%.2f", msg.CostUSD))
+	}
+	return strings.Join(usage, ", ")
+}
+
+// SetDefaultBudget sets the limits of tasks on agents without their own (0 = unlimited)
+func (h *AgentHub) SetDefaultBudget(maxDuration time.Duration, maxTurns int, maxCostUSD float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.defaultBudget = protocol.Budget{
+		MaxDurationSec: int64(maxDuration / time.Second),
+		MaxTurns:       maxTurns,
+		MaxCostUSD:     maxCostUSD,
+	}
+}
+
+// taskBudget resolves a task's limits: its own, then its agent's, then the server's.
+// Returns nil if the task is unlimited.
+func (h *AgentHub) taskBudget(agentName string, own *protocol.Budget) *protocol.Budget {
+	h.mu.RLock()
+	b := h.defaultBudget
+	store := h.store
+	h.mu.RUnlock()
+
+	if store != nil {
+		if agentBudget, err := store.GetAgentBudget(agentName); err != nil {
+			log.Printf("[AgentHub] Failed to load the budget of '%s': %v", agentName, err)
+		} else if agentBudget != nil {
+			b = overlayBudget(b, agentBudget.Budget)
+		}
+	}
+	if own != nil {
+		b = overlayBudget(b, *own)
+	}
+	if b == (protocol.Budget{}) {
+		return nil
+	}
+	return &b
+}
+
+// SetAgentBudget sets an agent's default task limits (zero fields use the server's) and
+// returns the limits its tasks get. Tasks already queued keep the limits they were submitted with.
+func (h *AgentHub) SetAgentBudget(name string, b protocol.Budget) (string, error) {
+	h.mu.RLock()
+	store := h.store
+	h.mu.RUnlock()
+	if store == nil {
+		return "", fmt.Errorf("no database to store agent budgets")
+	}
+	if err := store.SetAgentBudget(name, b); err != nil {
+		return "", err
+	}
+	log.Printf("[AgentHub] Budget of '%s' set to %s", name, formatBudget(&b))
+	return formatBudget(h.taskBudget(name, nil)), nil
+}
+
+// AgentBudgets lists the server's default limits, and each agent with its own and the
+// limits its tasks get
+func (h *AgentHub) AgentBudgets() (map[string]any, error) {
+	h.mu.RLock()
+	store := h.store
+	defaults := h.defaultBudget
+	h.mu.RUnlock()
+	if store == nil {
+		return nil, fmt.Errorf("no database to store agent budgets")
+	}
+
+	budgets, err := store.ListAgentBudgets()
+	if err != nil {
+		return nil, err
+	}
+	agents := make([]map[string]any, 0, len(budgets))
+	for _, b := range budgets {
+		effective := overlayBudget(defaults, b.Budget)
+		agents = append(agents, map[string]any{
+			"name":       b.Name,
+			"budget":     b.Budget,
+			"effective":  formatBudget(&effective),
+			"updated_at": b.UpdatedAt,
+			"connected":  h.IsConnected(b.Name),
+		})
+	}
+	return map[string]any{
+		"default": map[string]any{"budget": defaults, "effective": formatBudget(&defaults)},
+		"agents":  agents,
+	}, nil
+}
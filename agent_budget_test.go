@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"minerva/protocol"
+)
+
+func TestParseBudget(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxTime    string
+		maxTurns   int
+		maxCostUSD float64
+		want       protocol.Budget
+		wantErr    bool
+	}{
+		{"none", "", 0, 0, protocol.Budget{}, false},
+		{"all", "30m", 40, 2.5, protocol.Budget{MaxDurationSec: 1800, MaxTurns: 40, MaxCostUSD: 2.5}, false},
+		{"zero time", "0s", 5, 0, protocol.Budget{MaxTurns: 5}, false},
+		{"sub-second time", "500ms", 0, 0, protocol.Budget{}, true},
+		{"negative time", "-1m", 0, 0, protocol.Budget{}, true},
+		{"invalid time", "soon", 0, 0, protocol.Budget{}, true},
+		{"negative turns", "", -1, 0, protocol.Budget{}, true},
+		{"negative cost", "", 0, -0.5, protocol.Budget{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseBudget(tt.maxTime, tt.maxTurns, tt.maxCostUSD)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseBudget(%s) error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parseBudget(%s) = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTaskBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults protocol.Budget
+		agent    *protocol.Budget // the agent's own limits, none if nil
+		own      *protocol.Budget // the task's own limits
+		want     *protocol.Budget
+	}{
+		{"unlimited", protocol.Budget{}, nil, nil, nil},
+		{"server default", protocol.Budget{MaxDurationSec: 600}, nil, nil, &protocol.Budget{MaxDurationSec: 600}},
+		{"agent over default", protocol.Budget{MaxDurationSec: 600, MaxTurns: 10},
+			&protocol.Budget{MaxTurns: 20}, nil, &protocol.Budget{MaxDurationSec: 600, MaxTurns: 20}},
+		{"task over agent", protocol.Budget{MaxDurationSec: 600},
+			&protocol.Budget{MaxTurns: 20, MaxCostUSD: 1}, &protocol.Budget{MaxCostUSD: 5},
+			&protocol.Budget{MaxDurationSec: 600, MaxTurns: 20, MaxCostUSD: 5}},
+		{"task without defaults", protocol.Budget{}, nil, &protocol.Budget{MaxTurns: 3}, &protocol.Budget{MaxTurns: 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, db := newAgentTestHub(t)
+			if err := db.InitAgentBudgetTable(); err != nil {
+				t.Fatal(err)
+			}
+			h.SetDefaultBudget(time.Duration(tt.defaults.MaxDurationSec)*time.Second, tt.defaults.MaxTurns, tt.defaults.MaxCostUSD)
+			if tt.agent != nil {
+				if _, err := h.SetAgentBudget("laptop", *tt.agent); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got := h.taskBudget("laptop", tt.own)
+			if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+				t.Errorf("taskBudget = %s, want %s", formatBudget(got), formatBudget(tt.want))
+			}
+		})
+	}
+}
+
+func TestFormatBudget(t *testing.T) {
+	tests := []struct {
+		budget *protocol.Budget
+		want   string
+	}{
+		{nil, "unlimited"},
+		{&protocol.Budget{}, "unlimited"},
+		{&protocol.Budget{MaxDurationSec: 1800, MaxTurns: 40, MaxCostUSD: 2.5}, "30m0s, 40 turns, $2.50"},
+		{&protocol.Budget{MaxCostUSD: 1}, "$1.00"},
+	}
+	for _, tt := range tests {
+		if got := formatBudget(tt.budget); got != tt.want {
+			t.Errorf("formatBudget(%+v) = %q, want %q", tt.budget, got, tt.want)
+		}
+	}
+}
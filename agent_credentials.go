@@ -0,0 +1,488 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"minerva/protocol"
+)
+
+// Per-agent credentials: each agent authenticates with its own token, bound to its name.
+// Tokens are issued by `minerva agent enroll` or when the admin approves an unknown agent
+// in Telegram; only their SHA-256 is stored.
+
+const (
+	AgentCredActive  = "active"
+	AgentCredRevoked = "revoked"
+
+	// agentTokenPrefix tells per-agent tokens apart from the shared AGENT_PASSWORD
+	agentTokenPrefix = "mat_"
+	// AgentApprovalPromptInterval is how often an agent waiting for approval is announced again
+	AgentApprovalPromptInterval = time.Hour
+	// AgentRejectCooldown is how long a rejected agent is refused without asking the admin again
+	AgentRejectCooldown = time.Hour
+)
+
+// agentNamePattern is what an agent name may be: it ends up in Telegram callback data,
+// which is limited to 64 bytes ("agent_approve:" + name), and in messages and logs
+var agentNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,48}$`)
+
+// validateAgentName checks an agent name against agentNamePattern
+func validateAgentName(name string) error {
+	if !agentNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid agent name %q: use 1-48 letters, digits, '.', '_' or '-'", name)
+	}
+	return nil
+}
+
+// AgentCredential is an enrolled agent name and the state of its token
+type AgentCredential struct {
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	tokenHash  string
+}
+
+// AgentApprovalFunc asks the admin whether an unknown agent may register
+type AgentApprovalFunc func(agentName, cwd, remoteAddr string)
+
+// agentAuth is the outcome of checking an agent's registration
+type agentAuth int
+
+const (
+	agentAuthRejected agentAuth = iota
+	agentAuthAccepted
+	agentAuthPending // unknown agent, waiting for the admin
+)
+
+// InitAgentCredentialTable creates the agent_credentials table
+func (db *DB) InitAgentCredentialTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS agent_credentials (
+			name TEXT PRIMARY KEY,
+			token_hash TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'active',
+			created_at DATETIME NOT NULL,
+			last_seen_at DATETIME,
+			revoked_at DATETIME
+		);
+		CREATE INDEX IF NOT EXISTS idx_agent_credentials_token ON agent_credentials(token_hash);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create agent_credentials table: %w", err)
+	}
+	return nil
+}
+
+// generateAgentToken returns a new random agent token
+func generateAgentToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return agentTokenPrefix + hex.EncodeToString(b), nil
+}
+
+func hashAgentToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// EnrollAgent issues a new token for an agent name, replacing (and invalidating) any previous one
+func (db *DB) EnrollAgent(name string) (string, error) {
+	if err := validateAgentName(name); err != nil {
+		return "", err
+	}
+	token, err := generateAgentToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Exec(`
+		INSERT INTO agent_credentials (name, token_hash, status, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET token_hash = excluded.token_hash, status = excluded.status,
+			created_at = excluded.created_at, last_seen_at = NULL, revoked_at = NULL
+	`, name, hashAgentToken(token), AgentCredActive, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// GetAgentCredential returns an agent's credential, or nil if the name was never enrolled
+func (db *DB) GetAgentCredential(name string) (*AgentCredential, error) {
+	rows, err := db.Query(`
+		SELECT name, token_hash, status, created_at, last_seen_at, revoked_at
+		FROM agent_credentials WHERE name = ?
+	`, name)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := scanAgentCredentials(rows)
+	if err != nil || len(creds) == 0 {
+		return nil, err
+	}
+	return &creds[0], nil
+}
+
+// ListAgentCredentials returns all enrolled agents
+func (db *DB) ListAgentCredentials() ([]AgentCredential, error) {
+	rows, err := db.Query(`
+		SELECT name, token_hash, status, created_at, last_seen_at, revoked_at
+		FROM agent_credentials ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return scanAgentCredentials(rows)
+}
+
+func scanAgentCredentials(rows *sql.Rows) ([]AgentCredential, error) {
+	defer rows.Close()
+
+	var creds []AgentCredential
+	for rows.Next() {
+		var c AgentCredential
+		var createdAt string
+		var lastSeen, revoked sql.NullString
+		if err := rows.Scan(&c.Name, &c.tokenHash, &c.Status, &createdAt, &lastSeen, &revoked); err != nil {
+			return nil, err
+		}
+		c.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		if lastSeen.Valid {
+			t, _ := time.Parse(time.RFC3339, lastSeen.String)
+			c.LastSeenAt = &t
+		}
+		if revoked.Valid {
+			t, _ := time.Parse(time.RFC3339, revoked.String)
+			c.RevokedAt = &t
+		}
+		creds = append(creds, c)
+	}
+	return creds, rows.Err()
+}
+
+// HasActiveAgentToken reports whether a token belongs to any active agent
+func (db *DB) HasActiveAgentToken(token string) (bool, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM agent_credentials WHERE token_hash = ? AND status = ?`,
+		hashAgentToken(token), AgentCredActive).Scan(&n)
+	return n > 0, err
+}
+
+// RevokeAgentCredential marks an agent's token revoked; returns false if the name isn't enrolled
+func (db *DB) RevokeAgentCredential(name string) (bool, error) {
+	res, err := db.Exec(`UPDATE agent_credentials SET status = ?, revoked_at = ? WHERE name = ?`,
+		AgentCredRevoked, time.Now().Format(time.RFC3339), name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// TouchAgentCredential records that an agent just authenticated
+func (db *DB) TouchAgentCredential(name string) error {
+	_, err := db.Exec(`UPDATE agent_credentials SET last_seen_at = ? WHERE name = ?`,
+		time.Now().Format(time.RFC3339), name)
+	return err
+}
+
+// SetAgentApprovalCallback sets the callback asking the admin to approve unknown agents.
+// Without it (and a task store), unknown agents fall back to the shared password alone.
+func (h *AgentHub) SetAgentApprovalCallback(fn AgentApprovalFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onApproval = fn
+}
+
+// ValidToken reports whether a bearer token may open the agent WebSocket: the shared
+// password or any active agent token. Registration then checks the token against the name.
+func (h *AgentHub) ValidToken(token string) bool {
+	if h.password != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.password)) == 1 {
+		return true
+	}
+	h.mu.RLock()
+	store := h.store
+	h.mu.RUnlock()
+	if store == nil {
+		return false
+	}
+	ok, err := store.HasActiveAgentToken(token)
+	if err != nil {
+		log.Printf("[AgentHub] Failed to check agent token: %v", err)
+	}
+	return ok
+}
+
+// authenticate decides whether an agent may register under its name
+func (h *AgentHub) authenticate(agent *Agent, password, token string) agentAuth {
+	if err := validateAgentName(agent.Name); err != nil {
+		log.Printf("Agent rejected: %v", err)
+		return agentAuthRejected
+	}
+	passwordOK := h.password == "" || subtle.ConstantTimeCompare([]byte(password), []byte(h.password)) == 1
+
+	h.mu.RLock()
+	store := h.store
+	onApproval := h.onApproval
+	h.mu.RUnlock()
+
+	// No database: only the shared password
+	if store == nil {
+		if !passwordOK {
+			log.Printf("Agent '%s' rejected: invalid password", agent.Name)
+			return agentAuthRejected
+		}
+		return agentAuthAccepted
+	}
+
+	cred, err := store.GetAgentCredential(agent.Name)
+	if err != nil {
+		log.Printf("Agent '%s' rejected: failed to load credential: %v", agent.Name, err)
+		return agentAuthRejected
+	}
+
+	if cred != nil {
+		switch {
+		case cred.Status == AgentCredRevoked:
+			log.Printf("Agent '%s' rejected: credential revoked", agent.Name)
+			return agentAuthRejected
+		case token == "" || subtle.ConstantTimeCompare([]byte(hashAgentToken(token)), []byte(cred.tokenHash)) != 1:
+			log.Printf("Agent '%s' rejected: invalid token for this name", agent.Name)
+			return agentAuthRejected
+		}
+		store.TouchAgentCredential(agent.Name)
+		return agentAuthAccepted
+	}
+
+	// Unknown name: a token issued to another agent doesn't count
+	if token != "" {
+		log.Printf("Agent '%s' rejected: token was issued to another agent", agent.Name)
+		return agentAuthRejected
+	}
+	if !passwordOK {
+		log.Printf("Agent '%s' rejected: invalid password", agent.Name)
+		return agentAuthRejected
+	}
+	if onApproval == nil {
+		log.Printf("Agent '%s' rejected: not enrolled (use: minerva agent enroll %s)", agent.Name, agent.Name)
+		return agentAuthRejected
+	}
+
+	h.mu.Lock()
+	if at, ok := h.rejectedAgents[agent.Name]; ok && time.Since(at) < AgentRejectCooldown {
+		h.mu.Unlock()
+		log.Printf("Agent '%s' rejected: registration was declined recently", agent.Name)
+		return agentAuthRejected
+	}
+	// A newer connection takes over the pending request
+	if old, ok := h.pendingAgents[agent.Name]; ok && old != agent {
+		old.conn.Close()
+	}
+	h.pendingAgents[agent.Name] = agent
+	prompt := time.Since(h.approvalAsked[agent.Name]) > AgentApprovalPromptInterval
+	if prompt {
+		h.approvalAsked[agent.Name] = time.Now()
+	}
+	h.mu.Unlock()
+
+	log.Printf("Agent '%s' is not enrolled, waiting for admin approval", agent.Name)
+	if prompt {
+		go onApproval(agent.Name, agent.Cwd, agent.conn.RemoteAddr().String())
+	}
+	return agentAuthPending
+}
+
+// ApproveAgent enrolls an agent waiting for approval, hands it its token and registers it
+func (h *AgentHub) ApproveAgent(name string) error {
+	h.mu.Lock()
+	agent, ok := h.pendingAgents[name]
+	delete(h.pendingAgents, name)
+	delete(h.approvalAsked, name)
+	store := h.store
+	h.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("agent '%s' is no longer waiting; it will ask again when it reconnects", name)
+	}
+	token, err := store.EnrollAgent(name)
+	if err != nil {
+		agent.conn.Close()
+		return err
+	}
+
+	safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgEnrolled, Token: token})
+	log.Printf("Agent '%s' approved and enrolled", name)
+	h.completeRegistration(agent)
+	return nil
+}
+
+// RejectAgent turns down an agent waiting for approval
+func (h *AgentHub) RejectAgent(name string) {
+	h.mu.Lock()
+	agent, ok := h.pendingAgents[name]
+	delete(h.pendingAgents, name)
+	delete(h.approvalAsked, name)
+	h.rejectedAgents[name] = time.Now()
+	h.mu.Unlock()
+
+	log.Printf("Agent '%s' registration rejected by admin", name)
+	if ok {
+		safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgError, Error: "registration rejected"})
+		close(agent.send)
+	}
+}
+
+// EnrollAgent issues a new token for an agent. An agent connected with the previous
+// token is disconnected, and a pending request for the name is dropped.
+func (h *AgentHub) EnrollAgent(name string) (string, error) {
+	h.mu.RLock()
+	store := h.store
+	h.mu.RUnlock()
+	if store == nil {
+		return "", fmt.Errorf("no database to store agent credentials")
+	}
+
+	token, err := store.EnrollAgent(name)
+	if err != nil {
+		return "", err
+	}
+	h.mu.Lock()
+	delete(h.rejectedAgents, name)
+	h.mu.Unlock()
+	h.disconnectAgent(name)
+	return token, nil
+}
+
+// RevokeAgent invalidates an agent's token and disconnects it right away
+func (h *AgentHub) RevokeAgent(name string) error {
+	h.mu.RLock()
+	store := h.store
+	h.mu.RUnlock()
+	if store == nil {
+		return fmt.Errorf("no database to store agent credentials")
+	}
+
+	ok, err := store.RevokeAgentCredential(name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("agent '%s' is not enrolled", name)
+	}
+	if h.disconnectAgent(name) {
+		log.Printf("Agent '%s' revoked and disconnected", name)
+	} else {
+		log.Printf("Agent '%s' revoked", name)
+	}
+	return nil
+}
+
+// AgentCredentials lists enrolled agents and whether each is connected
+func (h *AgentHub) AgentCredentials() ([]map[string]any, error) {
+	h.mu.RLock()
+	store := h.store
+	h.mu.RUnlock()
+	if store == nil {
+		return nil, fmt.Errorf("no database to store agent credentials")
+	}
+
+	creds, err := store.ListAgentCredentials()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]map[string]any, 0, len(creds))
+	for _, c := range creds {
+		list = append(list, map[string]any{
+			"name":         c.Name,
+			"status":       c.Status,
+			"created_at":   c.CreatedAt,
+			"last_seen_at": c.LastSeenAt,
+			"revoked_at":   c.RevokedAt,
+			"connected":    h.IsConnected(c.Name),
+		})
+	}
+	return list, nil
+}
+
+// disconnectAgent closes an agent's connection (registered or pending); reports whether it was connected
+func (h *AgentHub) disconnectAgent(name string) bool {
+	h.mu.Lock()
+	agent, connected := h.agents[name]
+	pending, waiting := h.pendingAgents[name]
+	delete(h.pendingAgents, name)
+	delete(h.approvalAsked, name)
+	h.mu.Unlock()
+
+	if connected {
+		agent.conn.Close()
+	}
+	if waiting {
+		pending.conn.Close()
+	}
+	return connected
+}
+
+// sendAgentApprovalRequest asks the admin whether an unknown agent may connect
+func (b *Bot) sendAgentApprovalRequest(agentName, cwd, remoteAddr string) {
+	if b.config.AdminID == 0 {
+		return
+	}
+
+	// Plain text: the directory comes from the agent and may contain Markdown
+	text := fmt.Sprintf("🆕 New agent request\n\nName: %s\nDirectory: %s\nFrom: %s\n\nApproving issues it its own token.",
+		agentName, cwd, remoteAddr)
+	msg := tgbotapi.NewMessage(b.config.AdminID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Approve", "agent_approve:"+agentName),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Reject", "agent_reject:"+agentName),
+		),
+	)
+
+	if _, err := b.api.Send(msg); err != nil {
+		log.Printf("Failed to send agent approval request to admin: %v", err)
+	}
+}
+
+// handleAgentApprovalCallback handles the Approve / Reject buttons of a new agent request
+func (b *Bot) handleAgentApprovalCallback(callback *tgbotapi.CallbackQuery, action, agentName string) error {
+	if !b.isAdmin(callback.From.ID) {
+		b.api.Send(tgbotapi.NewCallback(callback.ID, "Only the admin can approve agents"))
+		return nil
+	}
+	if b.agentHub == nil {
+		b.api.Send(tgbotapi.NewCallback(callback.ID, "Agent hub not available"))
+		return nil
+	}
+
+	var text, answer string
+	switch action {
+	case "agent_approve":
+		if err := b.agentHub.ApproveAgent(agentName); err != nil {
+			b.api.Send(tgbotapi.NewCallback(callback.ID, fmt.Sprintf("Error: %v", err)))
+			return nil
+		}
+		text = fmt.Sprintf("✅ Agent '%s' approved and enrolled\n\nRevoke with: minerva agent revoke %s", agentName, agentName)
+		answer = "Agent approved"
+	case "agent_reject":
+		b.agentHub.RejectAgent(agentName)
+		text = fmt.Sprintf("❌ Agent '%s' rejected", agentName)
+		answer = "Agent rejected"
+	}
+
+	b.api.Send(tgbotapi.NewEditMessageText(callback.Message.Chat.ID, callback.Message.MessageID, text))
+	b.api.Send(tgbotapi.NewCallback(callback.ID, answer))
+	return nil
+}
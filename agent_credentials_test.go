@@ -0,0 +1,147 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// newCredentialTestHub returns a hub whose store has "laptop" enrolled and "old" revoked,
+// with their tokens
+func newCredentialTestHub(t *testing.T) (h *AgentHub, laptopToken, oldToken string) {
+	t.Helper()
+	h, db := newAgentTestHub(t)
+	if err := db.InitAgentCredentialTable(); err != nil {
+		t.Fatal(err)
+	}
+	laptopToken, err := db.EnrollAgent("laptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oldToken, err = db.EnrollAgent("old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.RevokeAgentCredential("old"); err != nil {
+		t.Fatal(err)
+	}
+	return h, laptopToken, oldToken
+}
+
+func TestAgentTokens(t *testing.T) {
+	a, err := generateAgentToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := generateAgentToken()
+	if !strings.HasPrefix(a, agentTokenPrefix) || len(a) != len(agentTokenPrefix)+64 {
+		t.Errorf("token %q should be %s followed by 64 hex digits", a, agentTokenPrefix)
+	}
+	if a == b {
+		t.Error("two generated tokens are equal")
+	}
+	if hashAgentToken(a) != hashAgentToken(a) || hashAgentToken(a) == hashAgentToken(b) {
+		t.Error("token hashes should be stable and differ between tokens")
+	}
+	if strings.Contains(hashAgentToken(a), strings.TrimPrefix(a, agentTokenPrefix)) {
+		t.Error("the hash contains the token")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	h, laptopToken, oldToken := newCredentialTestHub(t)
+	h.SetAgentApprovalCallback(func(agentName, cwd, remoteAddr string) {})
+	h.rejectedAgents["declined"] = time.Now()
+
+	tests := []struct {
+		name, agent, password, token string
+		want                         agentAuth
+	}{
+		{"good token", "laptop", "", laptopToken, agentAuthAccepted},
+		{"good token and password", "laptop", "secret", laptopToken, agentAuthAccepted},
+		{"bad token", "laptop", "secret", agentTokenPrefix + strings.Repeat("0", 64), agentAuthRejected},
+		{"password without token", "laptop", "secret", "", agentAuthRejected},
+		{"another agent's token", "laptop", "secret", oldToken, agentAuthRejected},
+		{"revoked token", "old", "secret", oldToken, agentAuthRejected},
+		{"token for an unknown name", "stranger", "secret", laptopToken, agentAuthRejected},
+		{"unknown name, bad password", "stranger", "wrong", "", agentAuthRejected},
+		{"unknown name, declined recently", "declined", "secret", "", agentAuthRejected},
+		{"invalid name", "my laptop", "secret", "", agentAuthRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			agent := &Agent{Name: tt.agent, hub: h}
+			if got := h.authenticate(agent, tt.password, tt.token); got != tt.want {
+				t.Errorf("authenticate(%s) = %v, want %v", tt.agent, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateWithoutApproval(t *testing.T) {
+	h, _, _ := newCredentialTestHub(t)
+
+	// Nobody can approve an unknown agent, so it has to be enrolled first
+	if got := h.authenticate(&Agent{Name: "stranger", hub: h}, "secret", ""); got != agentAuthRejected {
+		t.Errorf("unknown agent without approval = %v, want rejected", got)
+	}
+
+	// Without a database only the shared password counts
+	noStore := NewAgentHub("secret", nil, nil)
+	defer close(noStore.stopWatchdog)
+	if got := noStore.authenticate(&Agent{Name: "laptop"}, "secret", ""); got != agentAuthAccepted {
+		t.Errorf("right password without a store = %v, want accepted", got)
+	}
+	if got := noStore.authenticate(&Agent{Name: "laptop"}, "wrong", ""); got != agentAuthRejected {
+		t.Errorf("wrong password without a store = %v, want rejected", got)
+	}
+}
+
+func TestValidToken(t *testing.T) {
+	h, laptopToken, oldToken := newCredentialTestHub(t)
+	tests := []struct {
+		name, token string
+		want        bool
+	}{
+		{"shared password", "secret", true},
+		{"agent token", laptopToken, true},
+		{"revoked token", oldToken, false},
+		{"unknown token", agentTokenPrefix + strings.Repeat("0", 64), false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		if got := h.ValidToken(tt.token); got != tt.want {
+			t.Errorf("ValidToken(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateAgentName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"laptop", true},
+		{"my-laptop.home_1", true},
+		{strings.Repeat("a", 48), true},
+		{strings.Repeat("a", 49), false},
+		{"", false},
+		{"my laptop", false},
+		{"*bold*", false},
+		{"`code`", false},
+		{"a:b", false},
+		{"ordinateur-é", false},
+	}
+	for _, tt := range tests {
+		if err := validateAgentName(tt.name); (err == nil) != tt.ok {
+			t.Errorf("validateAgentName(%q) = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if tt.ok && len("agent_approve:"+tt.name) > 64 {
+			t.Errorf("approval callback data for %q is over 64 bytes", tt.name)
+		}
+	}
+
+	h, _, _ := newCredentialTestHub(t)
+	if _, err := h.store.EnrollAgent("bad name"); err == nil {
+		t.Error("EnrollAgent accepted an invalid name")
+	}
+}
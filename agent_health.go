@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"minerva/protocol"
+)
+
+const (
+	// HealthSamples is how many health reports are kept per agent (an hour at one a minute)
+	HealthSamples = 60
+	// healthStaleAfter is when a report is too old to judge a new task by
+	healthStaleAfter = 10 * time.Minute
+	// healthAlertInterval rate-limits repeated alerts about the same problem on an agent
+	healthAlertInterval = time.Hour
+
+	lowDiskFreeMB  = 2048 // alert below 2 GB free...
+	lowDiskFreePct = 5    // ...or below 5% of the disk
+	lowBatteryPct  = 20   // alert below 20% when not charging
+)
+
+// AgentHealth is a health report from an agent's host, stamped when it arrived
+type AgentHealth struct {
+	protocol.Health
+	Received time.Time `json:"received"`
+}
+
+// recordHealth stores a health report, keeping the last HealthSamples per agent
+func (h *AgentHub) recordHealth(agentName string, health *protocol.Health) {
+	sample := AgentHealth{Health: *health, Received: time.Now()}
+
+	h.mu.Lock()
+	samples := append(h.health[agentName], sample)
+	if len(samples) > HealthSamples {
+		samples = samples[len(samples)-HealthSamples:]
+	}
+	h.health[agentName] = samples
+	h.mu.Unlock()
+}
+
+// Health returns an agent's recent health reports, oldest first
+func (h *AgentHub) Health(agentName string) []AgentHealth {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]AgentHealth(nil), h.health[agentName]...)
+}
+
+// latestHealth returns an agent's last health report, or nil. Must be called with h.mu held.
+func (h *AgentHub) latestHealth(agentName string) *AgentHealth {
+	samples := h.health[agentName]
+	if len(samples) == 0 {
+		return nil
+	}
+	latest := samples[len(samples)-1]
+	return &latest
+}
+
+// averageLoad is the mean 1-minute load over an agent's stored reports. Must be called with h.mu held.
+func (h *AgentHub) averageLoad(agentName string) float64 {
+	samples := h.health[agentName]
+	if len(samples) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, s := range samples {
+		sum += s.Load1
+	}
+	return sum / float64(len(samples))
+}
+
+// healthProblem is something that could make a long task fail on an agent's host
+type healthProblem struct {
+	kind string // "disk" or "battery", for rate-limiting alerts
+	text string
+}
+
+// problems lists the report's low disk or battery
+func (s AgentHealth) problems() []healthProblem {
+	var problems []healthProblem
+	if s.DiskTotalMB > 0 && (s.DiskFreeMB < lowDiskFreeMB || s.DiskFreeMB*100 < s.DiskTotalMB*lowDiskFreePct) {
+		problems = append(problems, healthProblem{"disk", fmt.Sprintf("disk nearly full: %s free of %s", formatMB(s.DiskFreeMB), formatMB(s.DiskTotalMB))})
+	}
+	if s.Battery != nil && *s.Battery < lowBatteryPct && !s.Charging {
+		problems = append(problems, healthProblem{"battery", fmt.Sprintf("battery low: %d%%, not charging", *s.Battery)})
+	}
+	return problems
+}
+
+// checkHealth warns the admin when an agent about to run a long task is low on disk or
+// battery. It never blocks the task, and each problem is reported at most once per
+// healthAlertInterval.
+func (h *AgentHub) checkHealth(agentName, taskID string) {
+	h.mu.Lock()
+	latest := h.latestHealth(agentName)
+	if latest == nil || time.Since(latest.Received) > healthStaleAfter {
+		h.mu.Unlock()
+		return
+	}
+	var alerts []string
+	for _, p := range latest.problems() {
+		key := agentName + "/" + p.kind
+		if time.Since(h.healthAlerted[key]) < healthAlertInterval {
+			continue
+		}
+		h.healthAlerted[key] = time.Now()
+		alerts = append(alerts, p.text)
+	}
+	h.mu.Unlock()
+
+	if len(alerts) == 0 {
+		return
+	}
+	log.Printf("[AgentHub] Health warning for '%s' before task %s: %s", agentName, taskID, strings.Join(alerts, "; "))
+	if h.notify != nil {
+		h.notify(SourceAgentHealth, fmt.Sprintf("🩺 Agent '%s' is starting task `%s` with %s", agentName, taskID, strings.Join(alerts, " and ")))
+	}
+}
+
+// Summary describes the report in one line, e.g.
+// "load 0.8/8 · mem 5.3 GB free of 16 GB · disk 78 GB free · 🔋 85% · up 3d · agent v1.2.0 · claude 2.0.14"
+func (s AgentHealth) Summary() string {
+	var parts []string
+	if s.CPUs > 0 {
+		parts = append(parts, fmt.Sprintf("load %.1f/%d", s.Load1, s.CPUs))
+	}
+	if s.MemTotalMB > 0 {
+		parts = append(parts, fmt.Sprintf("mem %s free of %s", formatMB(s.MemAvailableMB), formatMB(s.MemTotalMB)))
+	}
+	low := map[string]bool{}
+	for _, p := range s.problems() {
+		low[p.kind] = true
+	}
+	if s.DiskTotalMB > 0 {
+		d := fmt.Sprintf("disk %s free", formatMB(s.DiskFreeMB))
+		if low["disk"] {
+			d += " ⚠️"
+		}
+		parts = append(parts, d)
+	}
+	if s.Battery != nil {
+		b := fmt.Sprintf("🔋 %d%%", *s.Battery)
+		if s.Charging {
+			b += " ⚡"
+		}
+		if low["battery"] {
+			b += " ⚠️"
+		}
+		parts = append(parts, b)
+	}
+	if s.UptimeSec > 0 {
+		parts = append(parts, "up "+formatAge(time.Duration(s.UptimeSec)*time.Second))
+	}
+	if s.AgentVersion != "" {
+		parts = append(parts, "agent "+s.AgentVersion)
+	}
+	if s.ClaudeVersion != "" {
+		parts = append(parts, "claude "+s.ClaudeVersion)
+	}
+	return strings.Join(parts, " · ")
+}
+
+// formatMB formats a size in MiB as MB or GB
+func formatMB(mb int64) string {
+	if mb < 1024 {
+		return fmt.Sprintf("%d MB", mb)
+	}
+	return fmt.Sprintf("%.1f GB", float64(mb)/1024)
+}
+
+// handleAgents lists the connected agents with their load and latest health report
+func (b *Bot) handleAgents(msg *tgbotapi.Message) error {
+	if !b.isAdmin(msg.From.ID) {
+		return b.sendMessage(msg.Chat.ID, "Only the admin can view the agents.")
+	}
+	if b.agentHub == nil {
+		return b.sendMessage(msg.Chat.ID, "Agent hub not available.")
+	}
+
+	agents := b.agentHub.ListAgents()
+	if len(agents) == 0 {
+		return b.sendMessage(msg.Chat.ID, "No agents connected.")
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i]["name"].(string) < agents[j]["name"].(string) })
+
+	var sb strings.Builder
+	sb.WriteString("🖥 *Agents*\n")
+	for _, a := range agents {
+		status := fmt.Sprintf("%d running", a["active_tasks"].(int))
+		if limit := a["max_concurrency"].(int); limit > 0 {
+			status = fmt.Sprintf("%d/%d running", a["active_tasks"].(int), limit)
+		}
+		if queued := a["queued_tasks"].(int); queued > 0 {
+			status += fmt.Sprintf(", %d queued", queued)
+		}
+		sb.WriteString(fmt.Sprintf("\n*%s* (%s)\n", a["name"], status))
+
+		health, _ := a["health"].(*AgentHealth)
+		if health == nil {
+			sb.WriteString("no health report yet\n")
+			continue
+		}
+		sb.WriteString(health.Summary() + "\n")
+		if load, _ := a["load_avg"].(float64); load > 0 {
+			sb.WriteString(fmt.Sprintf("avg load %.1f, reported %s ago\n", load, formatAge(time.Since(health.Received))))
+		}
+	}
+	return b.sendMessage(msg.Chat.ID, sb.String())
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"minerva/protocol"
+)
+
+const (
+	// ProjectCacheTTL is how long an agent's project details are reused before asking again
+	ProjectCacheTTL = 10 * time.Minute
+	// projectRefreshTimeout bounds a background refresh (agents run git in every project)
+	projectRefreshTimeout = 30 * time.Second
+)
+
+// projectCacheEntry is an agent's last project report
+type projectCacheEntry struct {
+	projects   []protocol.ProjectInfo
+	fetched    time.Time // zero once invalidated
+	refreshing bool
+}
+
+// Projects returns an agent's project details, asking the agent only when the cached
+// ones are older than ProjectCacheTTL
+func (h *AgentHub) Projects(agentName string, timeout time.Duration) ([]protocol.ProjectInfo, error) {
+	h.mu.RLock()
+	entry, ok := h.projectCache[agentName]
+	if ok && time.Since(entry.fetched) < ProjectCacheTTL {
+		projects := entry.projects
+		h.mu.RUnlock()
+		return projects, nil
+	}
+	h.mu.RUnlock()
+
+	return h.fetchProjects(agentName, timeout)
+}
+
+// CachedProjects returns an agent's project details without waiting: the cached ones,
+// even if stale, or the paths it registered with. A stale or missing cache is refreshed
+// in the background for next time.
+func (h *AgentHub) CachedProjects(agentName string) []protocol.ProjectInfo {
+	h.mu.Lock()
+	agent, connected := h.agents[agentName]
+	entry, ok := h.projectCache[agentName]
+	stale := !ok || time.Since(entry.fetched) >= ProjectCacheTTL
+	refresh := connected && stale && (!ok || !entry.refreshing)
+	if refresh {
+		if !ok {
+			entry = &projectCacheEntry{}
+			h.projectCache[agentName] = entry
+		}
+		entry.refreshing = true
+	}
+	var projects []protocol.ProjectInfo
+	if entry != nil && entry.projects != nil {
+		projects = entry.projects
+	} else if connected {
+		projects = projectsFromPaths(agent.Projects)
+	}
+	h.mu.Unlock()
+
+	if refresh {
+		go func() {
+			if _, err := h.fetchProjects(agentName, projectRefreshTimeout); err != nil {
+				log.Printf("[AgentHub] Failed to refresh projects of '%s': %v", agentName, err)
+			}
+		}()
+	}
+	return projects
+}
+
+// invalidateProjects marks an agent's cached projects stale (e.g. after a task changed them)
+func (h *AgentHub) invalidateProjects(agentName string) {
+	h.mu.Lock()
+	if entry, ok := h.projectCache[agentName]; ok {
+		entry.fetched = time.Time{}
+	}
+	h.mu.Unlock()
+}
+
+// fetchProjects asks the agent for its projects and caches the answer
+func (h *AgentHub) fetchProjects(agentName string, timeout time.Duration) ([]protocol.ProjectInfo, error) {
+	defer func() {
+		h.mu.Lock()
+		if entry, ok := h.projectCache[agentName]; ok {
+			entry.refreshing = false
+		}
+		h.mu.Unlock()
+	}()
+
+	msg, err := h.requestProjects(agentName, timeout)
+	if err != nil {
+		return nil, err
+	}
+	projects := msg.ProjectInfo
+	if len(projects) == 0 {
+		projects = projectsFromPaths(msg.Projects)
+	}
+	if projects == nil {
+		projects = []protocol.ProjectInfo{}
+	}
+
+	h.mu.Lock()
+	h.projectCache[agentName] = &projectCacheEntry{projects: projects, fetched: time.Now()}
+	if agent, ok := h.agents[agentName]; ok {
+		// Keep routing by project current
+		agent.Projects = msg.Projects
+	}
+	h.mu.Unlock()
+	return projects, nil
+}
+
+func projectsFromPaths(paths []string) []protocol.ProjectInfo {
+	projects := make([]protocol.ProjectInfo, 0, len(paths))
+	for _, p := range paths {
+		projects = append(projects, protocol.ProjectInfo{Path: p})
+	}
+	return projects
+}
+
+// projectSummary describes a project in one line, e.g.
+// "minerva: Go (go, make) · main ↑2 dirty · CLAUDE.md · last commit abc1234 Fix login (3h ago)"
+func projectSummary(p protocol.ProjectInfo) string {
+	var parts []string
+	if len(p.Languages) > 0 || len(p.BuildSystems) > 0 {
+		s := strings.Join(p.Languages, "/")
+		if len(p.BuildSystems) > 0 {
+			s = strings.TrimSpace(s + " (" + strings.Join(p.BuildSystems, ", ") + ")")
+		}
+		parts = append(parts, s)
+	}
+	if p.Git {
+		s := p.Branch
+		if s == "" {
+			s = "git"
+		}
+		if p.Ahead > 0 {
+			s += fmt.Sprintf(" ↑%d", p.Ahead)
+		}
+		if p.Behind > 0 {
+			s += fmt.Sprintf(" ↓%d", p.Behind)
+		}
+		if p.Dirty {
+			s += " dirty"
+		}
+		parts = append(parts, s)
+	}
+	if p.ClaudeMD {
+		parts = append(parts, "CLAUDE.md")
+	}
+	if p.LastCommit != "" {
+		s := "last commit " + p.LastCommit
+		if at, err := time.Parse(time.RFC3339, p.LastCommitAt); err == nil {
+			s += fmt.Sprintf(" (%s ago)", formatAge(time.Since(at)))
+		}
+		parts = append(parts, s)
+	}
+
+	name := filepath.Base(p.Path)
+	if len(parts) == 0 {
+		return name
+	}
+	return name + ": " + strings.Join(parts, " · ")
+}
+
+// formatAge rounds a duration to its largest unit (e.g. 3h, 2d)
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"minerva/protocol"
+)
+
+// negotiateProtocol picks the protocol version to speak with a registering agent and
+// welcomes it. An agent Minerva can't talk to gets an error saying which side to update.
+func (h *AgentHub) negotiateProtocol(agent *Agent, msg protocol.Message) error {
+	version, err := protocol.Negotiate(msg.MinProtocolVersion, msg.ProtocolVersion)
+	if err != nil {
+		switch {
+		case errors.Is(err, protocol.ErrPeerTooOld):
+			err = fmt.Errorf("agent '%s' is too old for this Minerva (%v): update the agent", msg.Name, err)
+		case errors.Is(err, protocol.ErrPeerTooNew):
+			err = fmt.Errorf("agent '%s' is newer than this Minerva (%v): update Minerva", msg.Name, err)
+		}
+		log.Printf("[AgentHub] Rejected: %v", err)
+		safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgError, Error: err.Error()})
+		close(agent.send)
+		return err
+	}
+
+	agent.Protocol = version
+	if version < protocol.Version {
+		log.Printf("[AgentHub] Agent '%s' speaks protocol v%d (Minerva v%d): newer features are off for it", msg.Name, version, protocol.Version)
+	}
+	if protocol.Supports(version, protocol.MsgWelcome) {
+		safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgWelcome, ProtocolVersion: version})
+	}
+	return nil
+}
+
+// accepts reports why the agent can't handle a message type, if it can't
+func (a *Agent) accepts(msgType string) error {
+	if err := protocol.Check(a.Protocol, a.Capabilities, msgType); err != nil {
+		return fmt.Errorf("agent '%s': %w (update the agent)", a.Name, err)
+	}
+	return nil
+}
+
+// handleUnknown answers a message type Minerva doesn't handle, so the agent doesn't wait for a reply
+func (h *AgentHub) handleUnknown(agent *Agent, msg protocol.Message) {
+	log.Printf("[AgentHub] Agent '%s' sent unsupported message type %q", agent.Name, msg.Type)
+	if protocol.Supports(agent.Protocol, protocol.MsgUnsupported) {
+		safeSendAgent(agent.send, protocol.Unsupported(msg, protocol.Version))
+	}
+}
+
+// handleUnsupported fails the request an agent couldn't handle right away, instead of
+// letting it time out
+func (h *AgentHub) handleUnsupported(agentName string, msg protocol.Message) {
+	log.Printf("[AgentHub] Agent '%s' doesn't handle %s: %s", agentName, msg.RefType, msg.Error)
+
+	reply := msg
+	reply.Error = fmt.Sprintf("agent '%s' doesn't handle %s (update the agent)", agentName, msg.RefType)
+
+	h.mu.RLock()
+	var reqAgent string
+	var result chan protocol.Message
+	if pending, ok := h.pendingAcks[msg.ID]; ok {
+		reqAgent, result = pending.Agent, pending.Result
+	} else if req, ok := h.projectReqs[msg.ID]; ok {
+		reqAgent, result = req.Agent, req.Result
+	} else if req, ok := h.fileReqs[msg.ID]; ok {
+		reqAgent, result = req.Agent, req.Result
+	} else if req, ok := h.worktreeReqs[msg.ID]; ok {
+		reqAgent, result = req.Agent, req.Result
+	} else if req, ok := h.updateReqs[msg.ID]; ok {
+		reqAgent, result = req.Agent, req.Result
+	} else if req, ok := h.transferAcks[msg.ID]; ok {
+		reqAgent, result = req.Agent, req.Result
+	}
+	h.mu.RUnlock()
+
+	if result != nil {
+		deliverReply(agentName, reply, reqAgent, result)
+	}
+}
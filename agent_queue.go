@@ -0,0 +1,531 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"minerva/protocol"
+)
+
+// QueuedTask is a task waiting for a free slot on its agent
+type QueuedTask struct {
+	ID        string    `json:"id"`
+	AgentName string    `json:"agent"`
+	Prompt    string    `json:"prompt"`
+	Dir       string    `json:"dir,omitempty"`
+	Priority  int       `json:"priority"`
+	QueuedAt  time.Time `json:"queued_at"`
+	Position  int       `json:"position"`
+	// Set for tasks submitted while the agent was offline: dropped if it hasn't reconnected by then
+	ExpiresAt *time.Time       `json:"expires_at,omitempty"`
+	SessionID string           `json:"session_id,omitempty"` // Claude session a follow-up resumes
+	ParentID  string           `json:"parent_id,omitempty"`
+	Worktree  string           `json:"worktree,omitempty"` // isolated git worktree the task runs in
+	Executor  string           `json:"executor,omitempty"` // backend running the task ("" = claude)
+	Budget    *protocol.Budget `json:"budget,omitempty"`   // limits the agent enforces (nil = unlimited)
+	MessageID int              `json:"-"`                  // Telegram message showing the queued task
+	ChatID    int64            `json:"-"`
+}
+
+// TaskOptions tunes how SubmitTask handles a busy or offline agent
+type TaskOptions struct {
+	Priority int // higher runs first when the agent is busy
+	// OfflineWait queues the task for a disconnected agent for at most this long (0 = fail right away)
+	OfflineWait time.Duration
+	// ResumeSession continues an earlier task's Claude session (a follow-up of ParentID)
+	ResumeSession string
+	ParentID      string
+	// Worktree runs the task in an isolated git worktree whose changes are applied only on
+	// review; WorktreeName reuses an existing one (a follow-up's) instead of the task's own
+	Worktree     bool
+	WorktreeName string
+	// Executor picks the agent backend: claude (default), shell or a configured CLI tool
+	Executor string
+	// TaskID is used instead of a generated ID, so the caller can track the task before it starts
+	TaskID string
+	// Budget overrides the agent's default limits on wall time, turns and cost (zero fields keep them)
+	Budget *protocol.Budget
+}
+
+// MaxOfflineWait caps how long a task can wait for an offline agent
+const MaxOfflineWait = 7 * 24 * time.Hour
+
+// parseOfflineWait parses how long a task may wait for an offline agent (e.g. "12h")
+func parseOfflineWait(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid wait %q: %w", s, err)
+	}
+	if d <= 0 || d > MaxOfflineWait {
+		return 0, fmt.Errorf("wait must be between 0 and %v", MaxOfflineWait)
+	}
+	return d, nil
+}
+
+// TaskQueuedFunc is a callback when a task is queued because its agent is busy
+type TaskQueuedFunc func(taskID, agentName, prompt string, position int)
+
+// SetTaskQueuedCallback sets the callback for when a task is queued
+func (h *AgentHub) SetTaskQueuedCallback(fn TaskQueuedFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onTaskQueued = fn
+}
+
+// SetDefaultConcurrency sets the max concurrent tasks for agents that don't advertise one
+func (h *AgentHub) SetDefaultConcurrency(n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.defaultConcurrency = n
+}
+
+// concurrencyLimit returns how many tasks an agent may run at once (0 = unlimited).
+// Must be called with h.mu held.
+func (h *AgentHub) concurrencyLimit(agent *Agent) int {
+	if agent.MaxConcurrency > 0 {
+		return agent.MaxConcurrency
+	}
+	return h.defaultConcurrency
+}
+
+// runningCount returns how many tasks hold a slot on the agent (starting or running)
+func (a *Agent) runningCount() int {
+	n := 0
+	a.activeTasks.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// hasFreeSlot reports whether the agent can start another task. Must be called with h.mu held.
+func (h *AgentHub) hasFreeSlot(agent *Agent) bool {
+	limit := h.concurrencyLimit(agent)
+	return limit <= 0 || agent.runningCount() < limit
+}
+
+// reserveSlot tracks a task as active on the agent before it is sent. Must be called with h.mu held.
+func (h *AgentHub) reserveSlot(agent *Agent, taskID, prompt string, messageID int, chatID int64) {
+	now := time.Now()
+	agent.activeTasks.Store(taskID, &ActiveTask{
+		StartTime:     now,
+		LastHeartbeat: now,
+		Prompt:        truncateText(prompt, 100),
+		MessageID:     messageID,
+		ChatID:        chatID,
+	})
+	h.taskAgentMap[taskID] = agent.Name
+}
+
+// releaseSlot stops tracking a task that failed to start
+func (h *AgentHub) releaseSlot(agent *Agent, taskID string) {
+	agent.activeTasks.Delete(taskID)
+	h.mu.Lock()
+	delete(h.taskAgentMap, taskID)
+	h.mu.Unlock()
+}
+
+// enqueue inserts a task after every queued task of equal or higher priority and
+// returns its 1-based position. Must be called with h.mu held.
+func (h *AgentHub) enqueue(qt *QueuedTask) int {
+	queue := h.queues[qt.AgentName]
+	i := len(queue)
+	for i > 0 && queue[i-1].Priority < qt.Priority {
+		i--
+	}
+	queue = append(queue, nil)
+	copy(queue[i+1:], queue[i:])
+	queue[i] = qt
+	h.queues[qt.AgentName] = queue
+	return i + 1
+}
+
+// findQueued locates a queued task. Must be called with h.mu held.
+func (h *AgentHub) findQueued(taskID string) (string, int) {
+	for agentName, queue := range h.queues {
+		for i, qt := range queue {
+			if qt.ID == taskID {
+				return agentName, i
+			}
+		}
+	}
+	return "", -1
+}
+
+// persistQueueOrder stores the order of an agent's queue so it survives restarts
+func (h *AgentHub) persistQueueOrder(agentName string) {
+	h.mu.RLock()
+	var ids []string
+	for _, qt := range h.queues[agentName] {
+		ids = append(ids, qt.ID)
+	}
+	h.mu.RUnlock()
+
+	for i, id := range ids {
+		seq := i + 1
+		h.recordTask(id, func(db *DB) error { return db.SetAgentTaskQueueSeq(id, seq) })
+	}
+}
+
+// restoreQueues reloads queued tasks from the task store after a restart
+func (h *AgentHub) restoreQueues() {
+	h.mu.RLock()
+	store := h.store
+	h.mu.RUnlock()
+	if store == nil {
+		return
+	}
+
+	tasks, err := store.GetQueuedAgentTasks()
+	if err != nil {
+		log.Printf("[AgentHub] Failed to restore queued tasks: %v", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, t := range tasks {
+		h.queues[t.AgentName] = append(h.queues[t.AgentName], &QueuedTask{
+			ID:        t.ID,
+			AgentName: t.AgentName,
+			Prompt:    t.Prompt,
+			Dir:       t.Dir,
+			Priority:  t.Priority,
+			QueuedAt:  t.CreatedAt,
+			ExpiresAt: t.ExpiresAt,
+			SessionID: t.SessionID,
+			ParentID:  t.ParentID,
+			Worktree:  t.Worktree,
+			Executor:  t.Executor,
+			Budget:    t.Budget,
+			MessageID: t.MessageID,
+			ChatID:    t.ChatID,
+		})
+	}
+	if len(tasks) > 0 {
+		log.Printf("[AgentHub] Restored %d queued task(s)", len(tasks))
+	}
+}
+
+// dispatchQueued starts queued tasks while the agent has free slots
+func (h *AgentHub) dispatchQueued(agentName string) {
+	h.expireQueued()
+
+	for {
+		h.mu.Lock()
+		agent, ok := h.agents[agentName]
+		queue := h.queues[agentName]
+		if !ok || len(queue) == 0 || !h.hasFreeSlot(agent) {
+			h.mu.Unlock()
+			return
+		}
+		qt := queue[0]
+		h.queues[agentName] = queue[1:]
+		h.reserveSlot(agent, qt.ID, qt.Prompt, qt.MessageID, qt.ChatID)
+		h.mu.Unlock()
+
+		log.Printf("[AgentHub] Dispatching queued task %s to '%s' (waited %v)", qt.ID, agentName, time.Since(qt.QueuedAt).Round(time.Second))
+		h.recordTask(qt.ID, func(db *DB) error { return db.SetAgentTaskStatus(qt.ID, AgentTaskStarting) })
+		go func(qt *QueuedTask) {
+			if err := h.startTask(agent, qt); err != nil {
+				log.Printf("[AgentHub] Queued task %s failed to start: %v", qt.ID, err)
+				if h.notify != nil {
+					h.notify(SourceAgent, fmt.Sprintf("❌ Queued task `%s` failed to start on '%s': %v", qt.ID, agentName, err))
+				}
+				h.reportTaskDone(qt.ID, agentName, AgentTaskFailed, err.Error())
+				return
+			}
+			if qt.ExpiresAt != nil && h.notify != nil {
+				// Queued while the agent was offline: the start is news, not just an edited message
+				h.notify(SourceAgent, fmt.Sprintf("▶️ Agent '%s' is back: task `%s` queued %v ago has started\nTask: %s",
+					agentName, qt.ID, time.Since(qt.QueuedAt).Round(time.Minute), truncateText(qt.Prompt, 200)))
+			}
+		}(qt)
+	}
+}
+
+// expireQueued drops queued tasks whose agent stayed offline past their expiry
+func (h *AgentHub) expireQueued() {
+	now := time.Now()
+	var expired []*QueuedTask
+
+	h.mu.Lock()
+	for agentName, queue := range h.queues {
+		if _, online := h.agents[agentName]; online {
+			continue
+		}
+		kept := queue[:0]
+		for _, qt := range queue {
+			if qt.ExpiresAt != nil && now.After(*qt.ExpiresAt) {
+				expired = append(expired, qt)
+				continue
+			}
+			kept = append(kept, qt)
+		}
+		h.queues[agentName] = kept
+	}
+	h.mu.Unlock()
+
+	for _, qt := range expired {
+		log.Printf("[AgentHub] Queued task %s expired: agent '%s' did not reconnect", qt.ID, qt.AgentName)
+		h.recordTask(qt.ID, func(db *DB) error {
+			return db.FinishAgentTask(qt.ID, AgentTaskExpired, "", 0, "agent did not reconnect before the task expired", 0)
+		})
+		if h.notify != nil {
+			h.notify(SourceAgent, fmt.Sprintf("⌛ Task `%s` expired: agent '%s' did not reconnect in %v\nTask: %s",
+				qt.ID, qt.AgentName, qt.ExpiresAt.Sub(qt.QueuedAt).Round(time.Minute), truncateText(qt.Prompt, 200)))
+		}
+		h.reportTaskDone(qt.ID, qt.AgentName, AgentTaskExpired, "")
+	}
+}
+
+// QueuedTask returns a queued task by ID
+func (h *AgentHub) QueuedTask(taskID string) (QueuedTask, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	agentName, i := h.findQueued(taskID)
+	if i < 0 {
+		return QueuedTask{}, false
+	}
+	qt := *h.queues[agentName][i]
+	qt.Position = i + 1
+	return qt, true
+}
+
+// QueuedTasks returns the queued tasks of an agent, or of every agent if agentName is empty,
+// sorted by agent name and queue position
+func (h *AgentHub) QueuedTasks(agentName string) []QueuedTask {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var list []QueuedTask
+	for name, queue := range h.queues {
+		if agentName != "" && name != agentName {
+			continue
+		}
+		for i, qt := range queue {
+			item := *qt
+			item.Position = i + 1
+			list = append(list, item)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].AgentName != list[j].AgentName {
+			return list[i].AgentName < list[j].AgentName
+		}
+		return list[i].Position < list[j].Position
+	})
+	return list
+}
+
+// QueueStatus returns how many tasks run on an agent, its limit (0 = unlimited) and how many wait
+func (h *AgentHub) QueueStatus(agentName string) (running, limit, queued int) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if agent, ok := h.agents[agentName]; ok {
+		running = agent.runningCount()
+		limit = h.concurrencyLimit(agent)
+	}
+	return running, limit, len(h.queues[agentName])
+}
+
+// MoveQueuedTask moves a queued task to a 1-based position in its agent's queue.
+// Returns the new position.
+func (h *AgentHub) MoveQueuedTask(taskID string, position int) (int, error) {
+	h.mu.Lock()
+	agentName, i := h.findQueued(taskID)
+	if i < 0 {
+		h.mu.Unlock()
+		return 0, fmt.Errorf("task %s is not queued", taskID)
+	}
+	queue := h.queues[agentName]
+	if position < 1 {
+		position = 1
+	}
+	if position > len(queue) {
+		position = len(queue)
+	}
+	qt := queue[i]
+	queue = append(queue[:i], queue[i+1:]...)
+	queue = append(queue, nil)
+	copy(queue[position:], queue[position-1:])
+	queue[position-1] = qt
+	h.queues[agentName] = queue
+	h.mu.Unlock()
+
+	log.Printf("[AgentHub] Queued task %s moved to position %d on '%s'", taskID, position, agentName)
+	h.persistQueueOrder(agentName)
+	return position, nil
+}
+
+// CancelQueuedTask removes a task from its agent's queue before it starts
+func (h *AgentHub) CancelQueuedTask(taskID string) error {
+	h.mu.Lock()
+	agentName, i := h.findQueued(taskID)
+	if i < 0 {
+		h.mu.Unlock()
+		return fmt.Errorf("task %s is not queued", taskID)
+	}
+	queue := h.queues[agentName]
+	h.queues[agentName] = append(queue[:i:i], queue[i+1:]...)
+	h.mu.Unlock()
+
+	log.Printf("[AgentHub] Queued task %s on '%s' cancelled", taskID, agentName)
+	h.recordTask(taskID, func(db *DB) error {
+		return db.FinishAgentTask(taskID, AgentTaskCancelled, "", 0, "cancelled before it started", 0)
+	})
+	h.persistQueueOrder(agentName)
+	h.reportTaskDone(taskID, agentName, AgentTaskCancelled, "")
+	return nil
+}
+
+// queuedTaskKeyboard builds the reorder / cancel buttons of a queued task
+func queuedTaskKeyboard(taskID string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⏫ Run next", "aq_top:"+taskID),
+			tgbotapi.NewInlineKeyboardButtonData("⬆️ Move up", "aq_up:"+taskID),
+			tgbotapi.NewInlineKeyboardButtonData("✖️ Cancel", "aq_cancel:"+taskID),
+		),
+	)
+}
+
+// queuedTaskText renders the message of a queued task
+func queuedTaskText(task QueuedTask, queued int, online bool) string {
+	text := fmt.Sprintf("🕒 *Agent task queued*\n\nAgent: `%s`\nTask ID: `%s`\nPosition: %d of %d\nPrompt: %s",
+		task.AgentName, task.ID, task.Position, queued, truncate(task.Prompt, 100))
+	if !online && task.ExpiresAt != nil {
+		text += fmt.Sprintf("\n\n💤 Agent offline: runs when it reconnects (expires %s)", task.ExpiresAt.Format("Jan 2 15:04"))
+	}
+	return text
+}
+
+// sendAgentTaskQueuedMessage tells the admin a task is waiting for a free slot, with reorder / cancel buttons
+func (b *Bot) sendAgentTaskQueuedMessage(taskID, agentName, prompt string, position int) {
+	if b.config.AdminID == 0 {
+		return
+	}
+
+	task, ok := b.agentHub.QueuedTask(taskID)
+	if !ok {
+		return // already started or cancelled
+	}
+	_, _, queued := b.agentHub.QueueStatus(agentName)
+	msg := tgbotapi.NewMessage(b.config.AdminID, queuedTaskText(task, queued, b.agentHub.IsConnected(agentName)))
+	msg.ParseMode = "Markdown"
+	msg.ReplyMarkup = queuedTaskKeyboard(taskID)
+
+	sentMsg, err := b.api.Send(msg)
+	if err != nil {
+		log.Printf("Failed to send agent task queued message: %v", err)
+		return
+	}
+	if err := b.agentHub.UpdateTaskMessage(taskID, sentMsg.MessageID, b.config.AdminID); err != nil {
+		log.Printf("Failed to update queued task message ID: %v", err)
+	}
+}
+
+// handleQueueCallback handles the Run next / Move up / Cancel buttons of a queued task
+func (b *Bot) handleQueueCallback(callback *tgbotapi.CallbackQuery, action, taskID string) error {
+	if !b.isAdmin(callback.From.ID) {
+		b.api.Send(tgbotapi.NewCallback(callback.ID, "Only the admin can manage the queue"))
+		return nil
+	}
+	if b.agentHub == nil {
+		b.api.Send(tgbotapi.NewCallback(callback.ID, "Agent hub not available"))
+		return nil
+	}
+
+	task, ok := b.agentHub.QueuedTask(taskID)
+	if !ok {
+		b.api.Send(tgbotapi.NewCallback(callback.ID, "Task is no longer queued"))
+		return nil
+	}
+
+	switch action {
+	case "aq_top", "aq_up":
+		target := 1
+		if action == "aq_up" {
+			target = task.Position - 1
+		}
+		position, err := b.agentHub.MoveQueuedTask(taskID, target)
+		if err != nil {
+			b.api.Send(tgbotapi.NewCallback(callback.ID, fmt.Sprintf("Error: %v", err)))
+			return nil
+		}
+		_, _, queued := b.agentHub.QueueStatus(task.AgentName)
+		task.Position = position
+		edit := tgbotapi.NewEditMessageTextAndMarkup(callback.Message.Chat.ID, callback.Message.MessageID,
+			queuedTaskText(task, queued, b.agentHub.IsConnected(task.AgentName)), queuedTaskKeyboard(taskID))
+		edit.ParseMode = "Markdown"
+		b.api.Send(edit)
+		b.api.Send(tgbotapi.NewCallback(callback.ID, fmt.Sprintf("Now at position %d", position)))
+
+	case "aq_cancel":
+		if err := b.agentHub.CancelQueuedTask(taskID); err != nil {
+			b.api.Send(tgbotapi.NewCallback(callback.ID, fmt.Sprintf("Error: %v", err)))
+			return nil
+		}
+		edit := tgbotapi.NewEditMessageText(callback.Message.Chat.ID, callback.Message.MessageID,
+			callback.Message.Text+"\n\n✖️ Cancelled before it started")
+		b.api.Send(edit)
+		b.api.Send(tgbotapi.NewCallback(callback.ID, "Task cancelled"))
+	}
+	return nil
+}
+
+// handleAgentQueue shows the queued agent tasks: /agentqueue [agent]
+func (b *Bot) handleAgentQueue(msg *tgbotapi.Message) error {
+	if !b.isAdmin(msg.From.ID) {
+		return b.sendMessage(msg.Chat.ID, "Only the admin can view the agent queue.")
+	}
+	if b.agentHub == nil {
+		return b.sendMessage(msg.Chat.ID, "Agent hub not available.")
+	}
+
+	agentName := strings.TrimSpace(msg.CommandArguments())
+	tasks := b.agentHub.QueuedTasks(agentName)
+	if len(tasks) == 0 {
+		return b.sendMessage(msg.Chat.ID, "No queued agent tasks.")
+	}
+
+	byAgent := make(map[string][]QueuedTask)
+	var agents []string
+	for _, t := range tasks {
+		if _, ok := byAgent[t.AgentName]; !ok {
+			agents = append(agents, t.AgentName)
+		}
+		byAgent[t.AgentName] = append(byAgent[t.AgentName], t)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("🕒 *Agent queue*\n")
+	for _, name := range agents {
+		running, limit, _ := b.agentHub.QueueStatus(name)
+		status := "running unlimited"
+		if !b.agentHub.IsConnected(name) {
+			status = "offline"
+		} else if limit > 0 {
+			status = fmt.Sprintf("running %d/%d", running, limit)
+		}
+		sb.WriteString(fmt.Sprintf("\n*%s* (%s)\n", name, status))
+		for _, t := range byAgent[name] {
+			sb.WriteString(fmt.Sprintf("%d. `%s` %s", t.Position, t.ID, strings.ReplaceAll(truncate(t.Prompt, 80), "\n", " ")))
+			if t.Priority != 0 {
+				sb.WriteString(fmt.Sprintf(" (priority %d)", t.Priority))
+			}
+			if t.ExpiresAt != nil {
+				sb.WriteString(fmt.Sprintf(" (expires %s)", t.ExpiresAt.Format("Jan 2 15:04")))
+			}
+			sb.WriteString("\n")
+		}
+	}
+	sb.WriteString("\nUse the buttons on each queued task to reorder or cancel it.")
+	return b.sendMessage(msg.Chat.ID, sb.String())
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// queueIDs returns the IDs of an agent's queued tasks in queue order
+func queueIDs(h *AgentHub, agentName string) []string {
+	var ids []string
+	for _, qt := range h.queues[agentName] {
+		ids = append(ids, qt.ID)
+	}
+	return ids
+}
+
+// fillQueue queues one task per priority, named q1, q2, ... in that order
+func fillQueue(h *AgentHub, agentName string, priorities ...int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, p := range priorities {
+		h.enqueue(&QueuedTask{ID: fmt.Sprintf("q%d", i+1), AgentName: agentName, Priority: p})
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	tests := []struct {
+		name         string
+		queued       []int // priorities of q1, q2, ...
+		priority     int
+		wantPosition int
+		wantOrder    []string
+	}{
+		{"empty queue", nil, 0, 1, []string{"new"}},
+		{"equal priority goes last", []int{0, 0}, 0, 3, []string{"q1", "q2", "new"}},
+		{"higher priority goes first", []int{0, 0}, 5, 1, []string{"new", "q1", "q2"}},
+		{"lower priority goes last", []int{5, 5}, 0, 3, []string{"q1", "q2", "new"}},
+		{"after equal, before lower", []int{9, 5, 5, 1}, 5, 4, []string{"q1", "q2", "q3", "new", "q4"}},
+		{"negative priority", []int{0, -1}, -1, 3, []string{"q1", "q2", "new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newAgentTestHub(t)
+			fillQueue(h, "laptop", tt.queued...)
+			fillQueue(h, "desktop", 0)
+
+			h.mu.Lock()
+			position := h.enqueue(&QueuedTask{ID: "new", AgentName: "laptop", Priority: tt.priority})
+			h.mu.Unlock()
+
+			if position != tt.wantPosition {
+				t.Errorf("position = %d, want %d", position, tt.wantPosition)
+			}
+			if got := queueIDs(h, "laptop"); !slices.Equal(got, tt.wantOrder) {
+				t.Errorf("queue = %v, want %v", got, tt.wantOrder)
+			}
+			if got := queueIDs(h, "desktop"); !slices.Equal(got, []string{"q1"}) {
+				t.Errorf("other agent's queue = %v, want [q1]", got)
+			}
+		})
+	}
+}
+
+func TestMoveQueuedTask(t *testing.T) {
+	tests := []struct {
+		name         string
+		taskID       string
+		position     int
+		wantPosition int
+		wantOrder    []string
+		wantErr      bool
+	}{
+		{"to the front", "q3", 1, 1, []string{"q3", "q1", "q2", "q4"}, false},
+		{"up one", "q3", 2, 2, []string{"q1", "q3", "q2", "q4"}, false},
+		{"down", "q1", 3, 3, []string{"q2", "q3", "q1", "q4"}, false},
+		{"same place", "q2", 2, 2, []string{"q1", "q2", "q3", "q4"}, false},
+		{"before the front", "q4", 0, 1, []string{"q4", "q1", "q2", "q3"}, false},
+		{"past the end", "q1", 10, 4, []string{"q2", "q3", "q4", "q1"}, false},
+		{"not queued", "nope", 1, 0, []string{"q1", "q2", "q3", "q4"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newAgentTestHub(t)
+			fillQueue(h, "laptop", 0, 0, 0, 0)
+
+			position, err := h.MoveQueuedTask(tt.taskID, tt.position)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MoveQueuedTask(%s, %d) error = %v, want error %v", tt.taskID, tt.position, err, tt.wantErr)
+			}
+			if position != tt.wantPosition {
+				t.Errorf("position = %d, want %d", position, tt.wantPosition)
+			}
+			if got := queueIDs(h, "laptop"); !slices.Equal(got, tt.wantOrder) {
+				t.Errorf("queue = %v, want %v", got, tt.wantOrder)
+			}
+		})
+	}
+}
+
+func TestQueueOrderSurvivesRestart(t *testing.T) {
+	h, db := newAgentTestHub(t)
+	for i, p := range []int{0, 0, 3} {
+		id := fmt.Sprintf("q%d", i+1)
+		if err := db.CreateAgentTask(AgentTaskRecord{ID: id, AgentName: "laptop", Prompt: "prompt", Status: AgentTaskQueued, Priority: p}); err != nil {
+			t.Fatal(err)
+		}
+		h.mu.Lock()
+		h.enqueue(&QueuedTask{ID: id, AgentName: "laptop", Priority: p})
+		h.mu.Unlock()
+	}
+	h.persistQueueOrder("laptop")
+	if _, err := h.MoveQueuedTask("q2", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := NewAgentHub("secret", nil, nil)
+	defer close(restarted.stopWatchdog)
+	restarted.SetTaskStore(db) // restores the queues
+
+	want := queueIDs(h, "laptop")
+	if got := queueIDs(restarted, "laptop"); !slices.Equal(got, want) {
+		t.Errorf("restored queue = %v, want %v", got, want)
+	}
+}
+
+func TestParseOfflineWait(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"12h", 12 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"168h", MaxOfflineWait, false},
+		{"169h", 0, true},
+		{"0s", 0, true},
+		{"-1h", 0, true},
+		{"tomorrow", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseOfflineWait(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseOfflineWait(%q) = %v, %v, want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestExpireQueued(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+	tests := []struct {
+		name      string
+		online    bool
+		expiresAt *time.Time
+		expired   bool
+	}{
+		{"offline, past expiry", false, &past, true},
+		{"offline, before expiry", false, &future, false},
+		{"offline, no expiry", false, nil, false},
+		{"online, past expiry", true, &past, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, db := newAgentTestHub(t)
+			var mu sync.Mutex
+			var done []string
+			h.SetTaskDoneCallback(func(taskID, agentName, status, output string) {
+				mu.Lock()
+				defer mu.Unlock()
+				done = append(done, taskID+" "+status)
+			})
+			if tt.online {
+				h.agents["laptop"] = &Agent{Name: "laptop", hub: h}
+			}
+			if err := db.CreateAgentTask(AgentTaskRecord{ID: "q1", AgentName: "laptop", Prompt: "prompt", Status: AgentTaskQueued, ExpiresAt: tt.expiresAt}); err != nil {
+				t.Fatal(err)
+			}
+			h.mu.Lock()
+			h.enqueue(&QueuedTask{ID: "q1", AgentName: "laptop", QueuedAt: time.Now().Add(-time.Hour), ExpiresAt: tt.expiresAt})
+			h.enqueue(&QueuedTask{ID: "q2", AgentName: "laptop", QueuedAt: time.Now()})
+			h.mu.Unlock()
+
+			h.expireQueued()
+
+			wantQueue := []string{"q1", "q2"}
+			wantStatus := AgentTaskQueued
+			var wantDone []string
+			if tt.expired {
+				wantQueue = []string{"q2"}
+				wantStatus = AgentTaskExpired
+				wantDone = []string{"q1 " + AgentTaskExpired}
+			}
+			if got := queueIDs(h, "laptop"); !slices.Equal(got, wantQueue) {
+				t.Errorf("queue = %v, want %v", got, wantQueue)
+			}
+			if task, _ := db.GetAgentTask("q1"); task.Status != wantStatus {
+				t.Errorf("task is %s, want %s", task.Status, wantStatus)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !slices.Equal(done, wantDone) {
+				t.Errorf("reported done = %v, want %v", done, wantDone)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"minerva/protocol"
+)
+
+// errNotDelivered marks a task that never reached the agent (it disconnected before
+// acknowledging it), so it is safe to run elsewhere
+var errNotDelivered = errors.New("task not delivered")
+
+// AgentSelector picks agents by label or project instead of by name. It is a
+// comma-separated list of terms that must all match: a label the agent registered
+// (e.g. os:linux, has-docker, repo:minerva, location:office), project:<name> for an
+// agent with that project folder, or either prefixed with "!" to exclude agents.
+type AgentSelector []string
+
+// ParseSelector parses a selector like "os:linux,has-docker" or "project:minerva"
+func ParseSelector(s string) (AgentSelector, error) {
+	var sel AgentSelector
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		if strings.TrimPrefix(term, "!") == "" || strings.ContainsAny(term, " \t") {
+			return nil, fmt.Errorf("invalid selector term %q", term)
+		}
+		sel = append(sel, term)
+	}
+	if len(sel) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+	return sel, nil
+}
+
+func (sel AgentSelector) String() string {
+	return strings.Join(sel, ",")
+}
+
+// Matches reports whether the agent satisfies every term of the selector
+func (sel AgentSelector) Matches(a *Agent) bool {
+	for _, term := range sel {
+		negate := strings.HasPrefix(term, "!")
+		if a.matchesTerm(strings.TrimPrefix(term, "!")) == negate {
+			return false
+		}
+	}
+	return true
+}
+
+// project returns the project the selector asks for ("" if none)
+func (sel AgentSelector) project() string {
+	for _, term := range sel {
+		if name, ok := strings.CutPrefix(term, "project:"); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+func (a *Agent) matchesTerm(term string) bool {
+	if name, ok := strings.CutPrefix(term, "project:"); ok {
+		return a.projectDir(name) != "" || a.hasLabel("repo:"+name)
+	}
+	return a.hasLabel(term)
+}
+
+func (a *Agent) hasLabel(label string) bool {
+	for _, l := range a.Labels {
+		if strings.EqualFold(l, label) {
+			return true
+		}
+	}
+	return false
+}
+
+// projectDir returns the path of the agent's project folder with the given name ("" if none)
+func (a *Agent) projectDir(name string) string {
+	for _, p := range a.Projects {
+		if strings.EqualFold(filepath.Base(p), name) {
+			return p
+		}
+	}
+	return ""
+}
+
+// RouteTask picks the connected agent best suited to a task for the selector, without
+// starting anything. Returns the agent's name and the directory the task should run in:
+// dir, or for a project selector without one, that project's folder on the agent.
+func (h *AgentHub) RouteTask(selector, dir string, opts TaskOptions) (string, string, error) {
+	sel, err := ParseSelector(selector)
+	if err != nil {
+		return "", "", err
+	}
+	agent, err := h.route(sel, opts, nil)
+	if err != nil {
+		return "", "", err
+	}
+	return agent.Name, taskDirFor(agent, sel, dir), nil
+}
+
+// SubmitRoutedTask submits a task to the agent RouteTask would pick. If that agent
+// disconnects before acknowledging the task, it fails over to the next best match.
+// Returns the task ID, the agent it went to and its queue position (0 if it started).
+func (h *AgentHub) SubmitRoutedTask(selector, prompt, dir string, opts TaskOptions) (string, string, int, error) {
+	sel, err := ParseSelector(selector)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	tried := map[string]bool{}
+	for {
+		agent, err := h.route(sel, opts, tried)
+		if err != nil {
+			if len(tried) > 0 {
+				return "", "", 0, fmt.Errorf("%w (already tried: %s)", err, strings.Join(sortedKeys(tried), ", "))
+			}
+			return "", "", 0, err
+		}
+
+		taskID, position, err := h.SubmitTask(agent.Name, prompt, taskDirFor(agent, sel, dir), opts)
+		if err == nil {
+			log.Printf("[AgentHub] Task %s routed to '%s' for selector %s", taskID, agent.Name, sel)
+			return taskID, agent.Name, position, nil
+		}
+		if !errors.Is(err, errNotDelivered) {
+			return "", "", 0, err
+		}
+		log.Printf("[AgentHub] '%s' went away before starting a task for %s, failing over: %v", agent.Name, sel, err)
+		tried[agent.Name] = true
+	}
+}
+
+// route returns the best connected agent matching sel that can run the task: one that
+// offers its executor and supports worktree mode if asked. Agents with a free slot come
+// first, then the shortest queue, then the most CPUs; the name breaks ties.
+func (h *AgentHub) route(sel AgentSelector, opts TaskOptions, exclude map[string]bool) (*Agent, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	type candidate struct {
+		agent  *Agent
+		free   bool
+		queued int
+		cpus   int
+	}
+	var candidates []candidate
+	matched := 0
+	for name, agent := range h.agents {
+		if exclude[name] || !sel.Matches(agent) {
+			continue
+		}
+		matched++
+		if !agent.supportsExecutor(opts.Executor) {
+			continue
+		}
+		if opts.Worktree && !agent.hasCapability(protocol.CapWorktree) {
+			continue
+		}
+		c := candidate{agent: agent, free: h.hasFreeSlot(agent), queued: len(h.queues[name])}
+		if agent.Resources != nil {
+			c.cpus = agent.Resources.CPUs
+		}
+		candidates = append(candidates, c)
+	}
+
+	if len(candidates) == 0 {
+		if matched > 0 {
+			return nil, fmt.Errorf("no connected agent matching %s can run this task (executor %s, worktree %v)", sel, executorName(opts.Executor), opts.Worktree)
+		}
+		return nil, fmt.Errorf("no connected agent matches %s", sel)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.free != b.free {
+			return a.free
+		}
+		if a.queued != b.queued {
+			return a.queued < b.queued
+		}
+		if a.cpus != b.cpus {
+			return a.cpus > b.cpus
+		}
+		return a.agent.Name < b.agent.Name
+	})
+	return candidates[0].agent, nil
+}
+
+// taskDirFor returns dir, or the folder of the project the selector asks for on that agent
+func taskDirFor(agent *Agent, sel AgentSelector, dir string) string {
+	if dir != "" {
+		return dir
+	}
+	if name := sel.project(); name != "" {
+		return agent.projectDir(name)
+	}
+	return ""
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"minerva/protocol"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    AgentSelector
+		wantErr bool
+	}{
+		{"os:linux", AgentSelector{"os:linux"}, false},
+		{"os:linux,has-docker", AgentSelector{"os:linux", "has-docker"}, false},
+		{" project:minerva , !location:home ", AgentSelector{"project:minerva", "!location:home"}, false},
+		{"os:linux,,gpu", AgentSelector{"os:linux", "gpu"}, false},
+		{"", nil, true},
+		{" , ", nil, true},
+		{"!", nil, true},
+		{"os:linux,!", nil, true},
+		{"has docker", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSelector(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSelector(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ParseSelector(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	agent := &Agent{
+		Name:     "laptop",
+		Labels:   []string{"os:linux", "has-docker", "repo:website"},
+		Projects: []string{"/home/me/src/minerva", "/home/me/src/Blog"},
+	}
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"os:linux", true},
+		{"OS:Linux", true},
+		{"os:darwin", false},
+		{"os:linux,has-docker", true},
+		{"os:linux,gpu", false},
+		{"!gpu", true},
+		{"!has-docker", false},
+		{"project:minerva", true},
+		{"project:blog", true},
+		{"project:website", true}, // by repo label
+		{"project:other", false},
+		{"!project:minerva", false},
+		{"os:linux,!location:home", true},
+	}
+	for _, tt := range tests {
+		sel, err := ParseSelector(tt.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sel.Matches(agent); got != tt.want {
+			t.Errorf("%q matches = %v, want %v", tt.selector, got, tt.want)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	// busy has no free slot, queued has a free slot but a waiting task, the rest differ in CPUs
+	newHub := func(t *testing.T) *AgentHub {
+		h, _ := newAgentTestHub(t)
+		add := func(name string, cpus int, labels ...string) *Agent {
+			a := &Agent{Name: name, hub: h, Labels: labels, Resources: &protocol.Resources{CPUs: cpus}}
+			h.agents[name] = a
+			return a
+		}
+		busy := add("busy", 32, "os:linux")
+		busy.MaxConcurrency = 1
+		busy.activeTasks.Store("running", &ActiveTask{})
+		add("queued", 16, "os:linux")
+		h.queues["queued"] = []*QueuedTask{{ID: "waiting", AgentName: "queued"}}
+		add("small", 2, "os:linux")
+		add("big", 8, "os:linux")
+		add("big2", 8, "os:linux")
+		shell := add("shell", 1, "os:linux")
+		shell.Executors = []string{"claude", "shell"}
+		mac := add("mac", 4, "os:darwin")
+		mac.Capabilities = []string{protocol.CapWorktree}
+		return h
+	}
+
+	tests := []struct {
+		name     string
+		selector string
+		opts     TaskOptions
+		exclude  []string
+		want     string
+		wantErr  string
+	}{
+		{"most CPUs among free agents, then name", "os:linux", TaskOptions{}, nil, "big", ""},
+		{"failover skips tried agents", "os:linux", TaskOptions{}, []string{"big"}, "big2", ""},
+		{"shorter queue before busy", "os:linux", TaskOptions{}, []string{"big", "big2", "small", "shell"}, "queued", ""},
+		{"busy agent last", "os:linux", TaskOptions{}, []string{"big", "big2", "small", "shell", "queued"}, "busy", ""},
+		{"executor", "os:linux", TaskOptions{Executor: "shell"}, nil, "shell", ""},
+		{"worktree capability", "!gpu", TaskOptions{Worktree: true}, nil, "mac", ""},
+		{"matching but unable", "os:linux", TaskOptions{Worktree: true}, nil, "", "can run this task"},
+		{"nothing matches", "gpu", TaskOptions{}, nil, "", "no connected agent matches"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHub(t)
+			h.SetDefaultConcurrency(2)
+			sel, err := ParseSelector(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			exclude := map[string]bool{}
+			for _, name := range tt.exclude {
+				exclude[name] = true
+			}
+
+			agent, err := h.route(sel, tt.opts, exclude)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("route(%s) error = %v, want %q", tt.selector, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("route(%s) = %v", tt.selector, err)
+			}
+			if agent.Name != tt.want {
+				t.Errorf("route(%s) = %s, want %s", tt.selector, agent.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskDirFor(t *testing.T) {
+	agent := &Agent{Name: "laptop", Projects: []string{"/src/minerva"}}
+	tests := []struct {
+		selector, dir, want string
+	}{
+		{"project:minerva", "", "/src/minerva"},
+		{"project:minerva", "/tmp/other", "/tmp/other"},
+		{"os:linux", "", ""},
+		{"project:website", "", ""},
+	}
+	for _, tt := range tests {
+		sel, _ := ParseSelector(tt.selector)
+		if got := taskDirFor(agent, sel, tt.dir); got != tt.want {
+			t.Errorf("taskDirFor(%s, %q) = %q, want %q", tt.selector, tt.dir, got, tt.want)
+		}
+	}
+}
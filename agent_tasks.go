@@ -0,0 +1,619 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"minerva/protocol"
+)
+
+// Agent task lifecycle states stored in agent_tasks (final states are the AgentTask* outcomes)
+const (
+	AgentTaskQueued   = "queued"   // waiting for a free slot on the agent
+	AgentTaskStarting = "starting" // sent, waiting for the agent's ACK
+	AgentTaskRunning  = "running"  // ACKed, the agent is executing it
+	AgentTaskLost     = "lost"     // the agent reconnected without it and no result arrived
+)
+
+// AgentTaskRecord is the persisted state of a task sent to an agent
+type AgentTaskRecord struct {
+	ID         string           `json:"id"`
+	AgentName  string           `json:"agent"`
+	Prompt     string           `json:"prompt"`
+	Dir        string           `json:"dir,omitempty"`
+	Status     string           `json:"status"`
+	CreatedAt  time.Time        `json:"created_at"`
+	StartedAt  *time.Time       `json:"started_at,omitempty"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+	Output     string           `json:"output,omitempty"`
+	ExitCode   int              `json:"exit_code"`
+	Error      string           `json:"error,omitempty"`
+	DurationMs int64            `json:"duration_ms,omitempty"`
+	MessageID  int              `json:"message_id,omitempty"`
+	ChatID     int64            `json:"chat_id,omitempty"`
+	Priority   int              `json:"priority,omitempty"`
+	ExpiresAt  *time.Time       `json:"expires_at,omitempty"` // queued for an offline agent until then
+	SessionID  string           `json:"session_id,omitempty"` // Claude session: resumed if queued, reported once done
+	ParentID   string           `json:"parent_id,omitempty"`  // task this one follows up on
+	Worktree   string           `json:"worktree,omitempty"`   // isolated git worktree the task ran in
+	Executor   string           `json:"executor,omitempty"`   // agent backend ("" = claude)
+	Budget     *protocol.Budget `json:"budget,omitempty"`     // limits the task ran with (nil = unlimited)
+	Turns      int              `json:"turns,omitempty"`      // turns and cost its executor reported
+	CostUSD    float64          `json:"cost_usd,omitempty"`
+}
+
+const agentTaskColumns = `id, agent_name, prompt, dir, status, created_at, started_at, finished_at,
+	output, exit_code, error, duration_ms, message_id, chat_id, priority, expires_at, session_id, parent_id, worktree, executor,
+	max_duration_sec, max_turns, max_cost_usd, turns, cost_usd`
+
+// InitAgentTaskTable creates the agent_tasks table
+func (db *DB) InitAgentTaskTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS agent_tasks (
+			id TEXT PRIMARY KEY,
+			agent_name TEXT NOT NULL,
+			prompt TEXT NOT NULL,
+			dir TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'starting',
+			created_at DATETIME NOT NULL,
+			started_at DATETIME,
+			finished_at DATETIME,
+			output TEXT NOT NULL DEFAULT '',
+			exit_code INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			duration_ms INTEGER NOT NULL DEFAULT 0,
+			message_id INTEGER NOT NULL DEFAULT 0,
+			chat_id INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE INDEX IF NOT EXISTS idx_agent_tasks_agent_status ON agent_tasks(agent_name, status);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create agent_tasks table: %w", err)
+	}
+
+	// Migrations: queue ordering
+	if err := db.addColumnIfMissing("agent_tasks", "priority", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := db.addColumnIfMissing("agent_tasks", "queue_seq", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := db.addColumnIfMissing("agent_tasks", "expires_at", "DATETIME"); err != nil {
+		return err
+	}
+	// Migrations: follow-ups
+	if err := db.addColumnIfMissing("agent_tasks", "session_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := db.addColumnIfMissing("agent_tasks", "parent_id", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	// Migrations: worktree mode
+	if err := db.addColumnIfMissing("agent_tasks", "worktree", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	// Migrations: executors
+	if err := db.addColumnIfMissing("agent_tasks", "executor", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	// Migrations: budgets
+	if err := db.addColumnIfMissing("agent_tasks", "max_duration_sec", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := db.addColumnIfMissing("agent_tasks", "max_turns", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := db.addColumnIfMissing("agent_tasks", "max_cost_usd", "REAL NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := db.addColumnIfMissing("agent_tasks", "turns", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := db.addColumnIfMissing("agent_tasks", "cost_usd", "REAL NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_agent_tasks_message ON agent_tasks(chat_id, message_id)`)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// CreateAgentTask records a task that is about to be sent to an agent (starting) or queued.
+// Uses ID, AgentName, Prompt, Dir, Status, Priority, ExpiresAt, SessionID, ParentID, Worktree, Executor and Budget.
+func (db *DB) CreateAgentTask(t AgentTaskRecord) error {
+	var expires sql.NullString
+	if t.ExpiresAt != nil {
+		expires = sql.NullString{String: t.ExpiresAt.Format(time.RFC3339), Valid: true}
+	}
+	var budget protocol.Budget
+	if t.Budget != nil {
+		budget = *t.Budget
+	}
+	_, err := db.Exec(`
+		INSERT INTO agent_tasks (id, agent_name, prompt, dir, status, created_at, priority, expires_at, session_id, parent_id, worktree, executor,
+			max_duration_sec, max_turns, max_cost_usd)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, t.ID, t.AgentName, t.Prompt, t.Dir, t.Status, time.Now().Format(time.RFC3339), t.Priority, expires, t.SessionID, t.ParentID, t.Worktree, t.Executor,
+		budget.MaxDurationSec, budget.MaxTurns, budget.MaxCostUSD)
+	return err
+}
+
+// SetAgentTaskUsage stores the turns and cost a task's executor reported
+func (db *DB) SetAgentTaskUsage(id string, turns int, costUSD float64) error {
+	_, err := db.Exec(`UPDATE agent_tasks SET turns = ?, cost_usd = ? WHERE id = ?`, turns, costUSD, id)
+	return err
+}
+
+// SetAgentTaskSession stores the Claude session a task ran in, so it can be resumed
+func (db *DB) SetAgentTaskSession(id, sessionID string) error {
+	_, err := db.Exec(`UPDATE agent_tasks SET session_id = ? WHERE id = ?`, sessionID, id)
+	return err
+}
+
+// GetAgentTaskByMessage finds the task shown in a Telegram message
+func (db *DB) GetAgentTaskByMessage(chatID int64, messageID int) (*AgentTaskRecord, error) {
+	rows, err := db.Query(`
+		SELECT `+agentTaskColumns+` FROM agent_tasks
+		WHERE chat_id = ? AND message_id = ?
+		ORDER BY created_at DESC, id DESC LIMIT 1
+	`, chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks, err := scanAgentTasks(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(tasks) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &tasks[0], nil
+}
+
+// SetAgentTaskQueueSeq stores the position of a queued task in its agent's queue
+func (db *DB) SetAgentTaskQueueSeq(id string, seq int) error {
+	_, err := db.Exec(`UPDATE agent_tasks SET queue_seq = ? WHERE id = ? AND status = ?`, seq, id, AgentTaskQueued)
+	return err
+}
+
+// GetQueuedAgentTasks returns the queued tasks of every agent in queue order
+func (db *DB) GetQueuedAgentTasks() ([]AgentTaskRecord, error) {
+	rows, err := db.Query(`
+		SELECT `+agentTaskColumns+` FROM agent_tasks
+		WHERE status = ?
+		ORDER BY agent_name, queue_seq, created_at
+	`, AgentTaskQueued)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAgentTasks(rows)
+}
+
+// MarkAgentTaskRunning records that the agent started the task
+func (db *DB) MarkAgentTaskRunning(id string) error {
+	_, err := db.Exec(`
+		UPDATE agent_tasks SET status = ?, started_at = COALESCE(started_at, ?)
+		WHERE id = ? AND status IN (?, ?)
+	`, AgentTaskRunning, time.Now().Format(time.RFC3339), id, AgentTaskStarting, AgentTaskRunning)
+	return err
+}
+
+// SetAgentTaskStatus updates the status of a task without finishing it (e.g. stale)
+func (db *DB) SetAgentTaskStatus(id, status string) error {
+	_, err := db.Exec(`UPDATE agent_tasks SET status = ? WHERE id = ?`, status, id)
+	return err
+}
+
+// SetAgentTaskMessage stores the Telegram message showing the task (with its Kill button)
+func (db *DB) SetAgentTaskMessage(id string, messageID int, chatID int64) error {
+	_, err := db.Exec(`UPDATE agent_tasks SET message_id = ?, chat_id = ? WHERE id = ?`, messageID, chatID, id)
+	return err
+}
+
+// FinishAgentTask records the final outcome of a task
+func (db *DB) FinishAgentTask(id, status, output string, exitCode int, errMsg string, durationMs int64) error {
+	_, err := db.Exec(`
+		UPDATE agent_tasks
+		SET status = ?, output = ?, exit_code = ?, error = ?, duration_ms = ?, finished_at = ?
+		WHERE id = ?
+	`, status, output, exitCode, errMsg, durationMs, time.Now().Format(time.RFC3339), id)
+	return err
+}
+
+// GetAgentTask retrieves a task by ID
+func (db *DB) GetAgentTask(id string) (*AgentTaskRecord, error) {
+	rows, err := db.Query(`SELECT `+agentTaskColumns+` FROM agent_tasks WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks, err := scanAgentTasks(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(tasks) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &tasks[0], nil
+}
+
+// GetInFlightAgentTasks returns the tasks of an agent that have not reached a final outcome
+func (db *DB) GetInFlightAgentTasks(agentName string) ([]AgentTaskRecord, error) {
+	rows, err := db.Query(`
+		SELECT `+agentTaskColumns+` FROM agent_tasks
+		WHERE agent_name = ? AND status IN (?, ?, ?)
+		ORDER BY created_at
+	`, agentName, AgentTaskStarting, AgentTaskRunning, AgentTaskStale)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAgentTasks(rows)
+}
+
+// ListAgentTasks returns the most recent tasks, optionally filtered by agent and status
+func (db *DB) ListAgentTasks(agentName, status string, limit int) ([]AgentTaskRecord, error) {
+	query := `SELECT ` + agentTaskColumns + ` FROM agent_tasks WHERE 1 = 1`
+	var args []any
+	if agentName != "" {
+		query += ` AND agent_name = ?`
+		args = append(args, agentName)
+	}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanAgentTasks(rows)
+}
+
+func scanAgentTasks(rows *sql.Rows) ([]AgentTaskRecord, error) {
+	var tasks []AgentTaskRecord
+	for rows.Next() {
+		var t AgentTaskRecord
+		var createdAt string
+		var startedAt, finishedAt, expiresAt sql.NullString
+		var budget protocol.Budget
+		if err := rows.Scan(&t.ID, &t.AgentName, &t.Prompt, &t.Dir, &t.Status, &createdAt, &startedAt, &finishedAt,
+			&t.Output, &t.ExitCode, &t.Error, &t.DurationMs, &t.MessageID, &t.ChatID, &t.Priority, &expiresAt, &t.SessionID, &t.ParentID, &t.Worktree, &t.Executor,
+			&budget.MaxDurationSec, &budget.MaxTurns, &budget.MaxCostUSD, &t.Turns, &t.CostUSD); err != nil {
+			return nil, err
+		}
+		if budget != (protocol.Budget{}) {
+			t.Budget = &budget
+		}
+		t.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		if startedAt.Valid {
+			if ts, err := time.Parse(time.RFC3339, startedAt.String); err == nil {
+				t.StartedAt = &ts
+			}
+		}
+		if finishedAt.Valid {
+			if ts, err := time.Parse(time.RFC3339, finishedAt.String); err == nil {
+				t.FinishedAt = &ts
+			}
+		}
+		if expiresAt.Valid {
+			if ts, err := time.Parse(time.RFC3339, expiresAt.String); err == nil {
+				t.ExpiresAt = &ts
+			}
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// agentTaskStatusIcon returns the emoji shown for a task status
+func agentTaskStatusIcon(status string) string {
+	switch status {
+	case AgentTaskQueued:
+		return "🕒"
+	case AgentTaskStarting:
+		return "⏳"
+	case AgentTaskRunning:
+		return "🔄"
+	case AgentTaskCompleted:
+		return "✅"
+	case AgentTaskFailed:
+		return "❌"
+	case AgentTaskKilled:
+		return "🛑"
+	case AgentTaskStale:
+		return "⚠️"
+	case AgentTaskLost:
+		return "❓"
+	case AgentTaskCancelled:
+		return "✖️"
+	case AgentTaskExpired:
+		return "⌛"
+	case AgentTaskBudgetExceeded:
+		return "💸"
+	}
+	return "•"
+}
+
+// handleAgentTasks shows the recent agent task history: /agenttasks [agent]
+func (b *Bot) handleAgentTasks(msg *tgbotapi.Message) error {
+	if !b.isAdmin(msg.From.ID) {
+		return b.sendMessage(msg.Chat.ID, "Only the admin can view agent tasks.")
+	}
+
+	agentName := strings.TrimSpace(msg.CommandArguments())
+	tasks, err := b.db.ListAgentTasks(agentName, "", 15)
+	if err != nil {
+		return b.sendMessage(msg.Chat.ID, fmt.Sprintf("Error: %v", err))
+	}
+	if len(tasks) == 0 {
+		return b.sendMessage(msg.Chat.ID, "No agent tasks yet.")
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🤖 *Agent tasks* (last %d)\n", len(tasks)))
+	for _, t := range tasks {
+		sb.WriteString(fmt.Sprintf("\n%s `%s` *%s* · %s · %s", agentTaskStatusIcon(t.Status), t.ID, t.AgentName, t.Status, t.CreatedAt.Local().Format("02 Jan 15:04")))
+		if t.DurationMs > 0 {
+			sb.WriteString(fmt.Sprintf(" · %v", (time.Duration(t.DurationMs) * time.Millisecond).Round(time.Second)))
+		}
+		sb.WriteString("\n   " + strings.ReplaceAll(truncate(t.Prompt, 80), "\n", " "))
+		if t.Error != "" {
+			sb.WriteString("\n   _" + truncate(t.Error, 100) + "_")
+		}
+	}
+	return b.sendMessage(msg.Chat.ID, sb.String())
+}
+
+// SetTaskStore sets the database agent tasks are persisted to and restores queued tasks from it
+func (h *AgentHub) SetTaskStore(db *DB) {
+	h.mu.Lock()
+	h.store = db
+	h.mu.Unlock()
+	h.restoreQueues()
+}
+
+// recordTask applies a write to the task store, if one is set
+func (h *AgentHub) recordTask(taskID string, write func(db *DB) error) {
+	h.mu.RLock()
+	store := h.store
+	h.mu.RUnlock()
+
+	if store == nil {
+		return
+	}
+	if err := write(store); err != nil {
+		log.Printf("[AgentHub] Failed to persist task %s: %v", taskID, err)
+	}
+}
+
+// storedTask returns the persisted record of a task, or nil
+func (h *AgentHub) storedTask(taskID string) *AgentTaskRecord {
+	h.mu.RLock()
+	store := h.store
+	h.mu.RUnlock()
+
+	if store == nil {
+		return nil
+	}
+	task, err := store.GetAgentTask(taskID)
+	if err != nil {
+		return nil
+	}
+	return task
+}
+
+// FollowUp sends a message into the Claude session of a finished task, on the same agent
+// and directory. Returns the new task ID and its queue position (0 if it started).
+func (h *AgentHub) FollowUp(parentID, prompt string) (string, int, error) {
+	parent := h.storedTask(parentID)
+	if parent == nil {
+		return "", 0, fmt.Errorf("task %s not found", parentID)
+	}
+	switch parent.Status {
+	case AgentTaskQueued, AgentTaskStarting, AgentTaskRunning, AgentTaskStale:
+		return "", 0, fmt.Errorf("task %s is still %s; follow up once it finishes", parentID, parent.Status)
+	}
+	if parent.SessionID == "" && executorName(parent.Executor) != DefaultExecutor {
+		return "", 0, fmt.Errorf("task %s ran with the %s executor, which has no session to continue", parentID, parent.Executor)
+	}
+	if parent.SessionID == "" {
+		return "", 0, fmt.Errorf("task %s has no Claude session to resume (the agent may be too old to report it)", parentID)
+	}
+
+	log.Printf("[AgentHub] Follow-up on task %s (session %s) for '%s'", parentID, parent.SessionID, parent.AgentName)
+	// Claude's session belongs to the directory it ran in, so a worktree task continues in its worktree
+	return h.SubmitTask(parent.AgentName, prompt, parent.Dir, TaskOptions{
+		ResumeSession: parent.SessionID,
+		ParentID:      parentID,
+		Worktree:      parent.Worktree != "",
+		WorktreeName:  parent.Worktree,
+		Executor:      parent.Executor,
+	})
+}
+
+// handleAgentFollowUpReply resumes an agent task's session when the admin replies to its
+// Telegram message. Returns false if the message is not such a reply.
+func (b *Bot) handleAgentFollowUpReply(msg *tgbotapi.Message) (bool, error) {
+	reply := msg.ReplyToMessage
+	if reply == nil || reply.From == nil || reply.From.ID != b.api.Self.ID || msg.Text == "" {
+		return false, nil
+	}
+	if b.agentHub == nil || !b.isAdmin(msg.From.ID) {
+		return false, nil
+	}
+
+	task, err := b.db.GetAgentTaskByMessage(msg.Chat.ID, reply.MessageID)
+	if err != nil {
+		return false, nil
+	}
+
+	taskID, position, err := b.agentHub.FollowUp(task.ID, msg.Text)
+	if err != nil {
+		return true, b.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ Could not follow up: %v", err))
+	}
+	log.Printf("[Agent] Follow-up %s on task %s sent to '%s' (position %d)", taskID, task.ID, task.AgentName, position)
+	// The started / queued message of the follow-up confirms it
+	return true, nil
+}
+
+// reconcileTasks restores tracking of an agent's in-flight tasks when it (re)connects.
+// running lists the tasks the agent reports as still executing; nil means the agent
+// doesn't report them, in which case every in-flight task is assumed to be running.
+func (h *AgentHub) reconcileTasks(agent *Agent, running *[]string) {
+	h.mu.RLock()
+	store := h.store
+	h.mu.RUnlock()
+	if store == nil {
+		return
+	}
+
+	inFlight, err := store.GetInFlightAgentTasks(agent.Name)
+	if err != nil {
+		log.Printf("[AgentHub] Failed to load in-flight tasks of '%s': %v", agent.Name, err)
+		return
+	}
+
+	reported := make(map[string]bool)
+	if running != nil {
+		for _, id := range *running {
+			reported[id] = true
+		}
+	}
+
+	now := time.Now()
+	restored := 0
+	var lost []AgentTaskRecord
+	for _, t := range inFlight {
+		if running != nil && !reported[t.ID] {
+			lost = append(lost, t)
+			continue
+		}
+		delete(reported, t.ID)
+
+		startTime := t.CreatedAt
+		if t.StartedAt != nil {
+			startTime = *t.StartedAt
+		}
+		agent.activeTasks.Store(t.ID, &ActiveTask{
+			StartTime:     startTime,
+			LastHeartbeat: now,
+			Prompt:        truncateText(t.Prompt, 100),
+			MessageID:     t.MessageID,
+			ChatID:        t.ChatID,
+		})
+		h.mu.Lock()
+		h.taskAgentMap[t.ID] = agent.Name
+		h.mu.Unlock()
+		if t.Status == AgentTaskStarting {
+			// The ACK was lost, but the agent is running it
+			h.recordTask(t.ID, func(db *DB) error { return db.MarkAgentTaskRunning(t.ID) })
+		}
+		restored++
+	}
+
+	// Tasks the agent runs that were never recorded (e.g. sent before persistence existed)
+	for id := range reported {
+		agent.activeTasks.Store(id, &ActiveTask{StartTime: now, LastHeartbeat: now, Prompt: "(unknown task)"})
+		h.mu.Lock()
+		h.taskAgentMap[id] = agent.Name
+		h.mu.Unlock()
+		restored++
+	}
+
+	if restored > 0 {
+		log.Printf("[AgentHub] Reconciled %d in-flight task(s) on agent '%s'", restored, agent.Name)
+	}
+
+	for _, t := range lost {
+		log.Printf("[AgentHub] Task %s on agent '%s' lost: agent no longer runs it", t.ID, agent.Name)
+		h.recordTask(t.ID, func(db *DB) error {
+			return db.FinishAgentTask(t.ID, AgentTaskLost, "", 0, "agent reconnected without this task and no result arrived", 0)
+		})
+		if h.notify != nil {
+			h.notify(SourceAgent, fmt.Sprintf("❓ Task `%s` on agent '%s' was lost: the agent is no longer running it and no result arrived.\nTask: %s",
+				t.ID, agent.Name, truncateText(t.Prompt, 200)))
+		}
+		// Report asynchronously: callbacks may dispatch new tasks to this agent, whose ACK is read by the caller
+		go h.reportTaskDone(t.ID, agent.Name, AgentTaskLost, "")
+	}
+}
+
+// ownsTask reports whether taskID was sent to agentName, going by the tasks in flight and
+// then the stored ones (e.g. tasks restored after a restart). Tasks Minerva has no record
+// of aren't anyone else's, so they count as the sender's.
+func (h *AgentHub) ownsTask(agentName, taskID string) bool {
+	h.mu.RLock()
+	owner, ok := h.taskAgentMap[taskID]
+	h.mu.RUnlock()
+	if ok {
+		return owner == agentName
+	}
+	if stored := h.storedTask(taskID); stored != nil {
+		return stored.AgentName == agentName
+	}
+	return true
+}
+
+// resultProcessed reports whether a task's result was already processed, and marks it
+// processed otherwise. Results stored as completed or failed count too, so a result resent
+// after a server restart isn't reported twice.
+func (h *AgentHub) resultProcessed(taskID string) bool {
+	h.mu.Lock()
+	if _, ok := h.resultsDone[taskID]; ok {
+		h.mu.Unlock()
+		return true
+	}
+	h.resultsDone[taskID] = time.Now()
+	h.mu.Unlock()
+
+	if stored := h.storedTask(taskID); stored != nil {
+		switch stored.Status {
+		case AgentTaskCompleted, AgentTaskFailed, AgentTaskKilled, AgentTaskBudgetExceeded:
+			return true
+		}
+	}
+	return false
+}
+
+// ackResult tells the agent its result is stored, so it can drop it from its outbox
+func (h *AgentHub) ackResult(agentName, taskID string) {
+	h.mu.RLock()
+	agent, ok := h.agents[agentName]
+	h.mu.RUnlock()
+	if ok && protocol.Supports(agent.Protocol, protocol.MsgResultAck) {
+		safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgResultAck, ID: taskID})
+	}
+}
+
+// forgetDelivered drops delivered results and files older than DeliveredRetention
+func (h *AgentHub) forgetDelivered() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, at := range h.resultsDone {
+		if time.Since(at) > DeliveredRetention {
+			delete(h.resultsDone, id)
+		}
+	}
+	for id, at := range h.transfersDone {
+		if time.Since(at) > DeliveredRetention {
+			delete(h.transfersDone, id)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"minerva/protocol"
+)
+
+// newAgentTestHub returns a hub that persists tasks to a fresh database
+func newAgentTestHub(t *testing.T) (*AgentHub, *DB) {
+	t.Helper()
+	db, err := InitDB(filepath.Join(t.TempDir(), "minerva.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InitAgentTaskTable(); err != nil {
+		t.Fatal(err)
+	}
+	h := NewAgentHub("secret", nil, nil)
+	h.SetTaskStore(db)
+	t.Cleanup(func() {
+		close(h.stopWatchdog)
+		db.Close()
+	})
+	return h, db
+}
+
+func TestReconcileTasks(t *testing.T) {
+	tests := []struct {
+		name        string
+		running     []string // tasks the agent reports, nil if it doesn't report them
+		wantActive  []string
+		wantLost    []string
+		wantRunning []string // stored as running afterwards
+	}{
+		{"agent doesn't report", nil, []string{"t-starting", "t-running"}, nil, []string{"t-starting", "t-running"}},
+		{"agent runs both", []string{"t-starting", "t-running"}, []string{"t-starting", "t-running"}, nil, []string{"t-starting", "t-running"}},
+		{"agent lost one", []string{"t-running"}, []string{"t-running"}, []string{"t-starting"}, []string{"t-running"}},
+		{"agent runs none", []string{}, nil, []string{"t-starting", "t-running"}, nil},
+		{"unrecorded task", []string{"t-running", "t-unknown"}, []string{"t-running", "t-unknown"}, []string{"t-starting"}, []string{"t-running"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, db := newAgentTestHub(t)
+			for _, id := range []string{"t-starting", "t-running", "t-done", "t-other"} {
+				agentName := "laptop"
+				if id == "t-other" {
+					agentName = "desktop"
+				}
+				if err := db.CreateAgentTask(AgentTaskRecord{ID: id, AgentName: agentName, Prompt: "prompt " + id, Status: AgentTaskStarting}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			db.MarkAgentTaskRunning("t-running")
+			db.FinishAgentTask("t-done", AgentTaskCompleted, "done", 0, "", 10)
+
+			agent := &Agent{Name: "laptop", hub: h}
+			running := &tt.running
+			if tt.running == nil {
+				running = nil
+			}
+			h.reconcileTasks(agent, running)
+
+			var active []string
+			agent.activeTasks.Range(func(key, _ any) bool {
+				active = append(active, key.(string))
+				return true
+			})
+			slices.Sort(active)
+			wantActive := slices.Sorted(slices.Values(tt.wantActive))
+			if !slices.Equal(active, wantActive) {
+				t.Errorf("active tasks = %v, want %v", active, wantActive)
+			}
+			for _, id := range tt.wantActive {
+				if got := h.taskAgentMap[id]; got != "laptop" {
+					t.Errorf("task %s maps to agent %q, want laptop", id, got)
+				}
+			}
+
+			for _, id := range tt.wantLost {
+				if task, _ := db.GetAgentTask(id); task.Status != AgentTaskLost {
+					t.Errorf("task %s is %s, want %s", id, task.Status, AgentTaskLost)
+				}
+			}
+			for _, id := range tt.wantRunning {
+				if task, _ := db.GetAgentTask(id); task.Status != AgentTaskRunning {
+					t.Errorf("task %s is %s, want %s", id, task.Status, AgentTaskRunning)
+				}
+			}
+			// Finished tasks and other agents' tasks are left alone
+			if task, _ := db.GetAgentTask("t-done"); task.Status != AgentTaskCompleted {
+				t.Errorf("finished task is %s, want %s", task.Status, AgentTaskCompleted)
+			}
+			if task, _ := db.GetAgentTask("t-other"); task.Status != AgentTaskStarting {
+				t.Errorf("other agent's task is %s, want %s", task.Status, AgentTaskStarting)
+			}
+		})
+	}
+}
+
+func TestResultProcessed(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string // stored status, none if empty
+		seen   bool   // processed earlier by this server
+		want   bool
+	}{
+		{"new result", AgentTaskRunning, false, false},
+		{"unrecorded task", "", false, false},
+		{"already processed", AgentTaskRunning, true, true},
+		{"stored as completed", AgentTaskCompleted, false, true},
+		{"stored as failed", AgentTaskFailed, false, true},
+		{"stored as killed", AgentTaskKilled, false, true},
+		{"stored as lost", AgentTaskLost, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, db := newAgentTestHub(t)
+			if tt.status != "" {
+				if err := db.CreateAgentTask(AgentTaskRecord{ID: "t1", AgentName: "laptop", Prompt: "prompt", Status: AgentTaskStarting}); err != nil {
+					t.Fatal(err)
+				}
+				if tt.status != AgentTaskRunning {
+					db.FinishAgentTask("t1", tt.status, "", 0, "", 10)
+				}
+			}
+			if tt.seen {
+				h.resultProcessed("t1")
+			}
+			if got := h.resultProcessed("t1"); got != tt.want {
+				t.Errorf("resultProcessed = %v, want %v", got, tt.want)
+			}
+			// Whatever the answer, the next copy is a duplicate
+			if !h.resultProcessed("t1") {
+				t.Error("second resultProcessed = false, want true")
+			}
+		})
+	}
+}
+
+func TestDuplicateResult(t *testing.T) {
+	h, db := newAgentTestHub(t)
+	var mu sync.Mutex
+	var done []string
+	h.SetTaskDoneCallback(func(taskID, agentName, status, output string) {
+		mu.Lock()
+		defer mu.Unlock()
+		done = append(done, taskID+" "+status)
+	})
+	agent := &Agent{Name: "laptop", hub: h, Protocol: protocol.Version, send: make(chan protocol.Message, 4)}
+	h.agents["laptop"] = agent
+	if err := db.CreateAgentTask(AgentTaskRecord{ID: "t1", AgentName: "laptop", Prompt: "prompt", Status: AgentTaskStarting}); err != nil {
+		t.Fatal(err)
+	}
+
+	result := protocol.Message{Type: protocol.MsgResult, ID: "t1", Output: "first"}
+	h.handleResult("laptop", result)
+	result.Output = "resent"
+	h.handleResult("laptop", result)
+
+	// Both copies are acknowledged, so the agent stops resending
+	for i := 0; i < 2; i++ {
+		select {
+		case ack := <-agent.send:
+			if ack.Type != protocol.MsgResultAck || ack.ID != "t1" {
+				t.Errorf("reply %d = %s for %s, want result_ack for t1", i, ack.Type, ack.ID)
+			}
+		default:
+			t.Fatalf("reply %d: no result_ack", i)
+		}
+	}
+	// Only the first is recorded and reported
+	if task, _ := db.GetAgentTask("t1"); task.Output != "first" {
+		t.Errorf("stored output = %q, want first", task.Output)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(done, []string{"t1 " + AgentTaskCompleted}) {
+		t.Errorf("reported done = %v, want [t1 completed]", done)
+	}
+}
+
+func TestOwnsTask(t *testing.T) {
+	h, db := newAgentTestHub(t)
+	h.taskAgentMap["t-running"] = "laptop"
+	if err := db.CreateAgentTask(AgentTaskRecord{ID: "t-stored", AgentName: "laptop", Prompt: "prompt", Status: AgentTaskStarting}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		agent, taskID string
+		want          bool
+	}{
+		{"laptop", "t-running", true},
+		{"desktop", "t-running", false},
+		{"laptop", "t-stored", true},
+		{"desktop", "t-stored", false},
+		{"desktop", "t-unknown", true},
+	}
+	for _, tt := range tests {
+		if got := h.ownsTask(tt.agent, tt.taskID); got != tt.want {
+			t.Errorf("ownsTask(%s, %s) = %v, want %v", tt.agent, tt.taskID, got, tt.want)
+		}
+	}
+}
+
+func TestRepliesFromAnotherAgent(t *testing.T) {
+	h, db := newAgentTestHub(t)
+	if err := db.CreateAgentTask(AgentTaskRecord{ID: "t1", AgentName: "laptop", Prompt: "prompt", Status: AgentTaskStarting}); err != nil {
+		t.Fatal(err)
+	}
+	acks := make(chan protocol.Message, 1)
+	h.pendingAcks["t1"] = &PendingAck{Agent: "laptop", Result: acks}
+	files := make(chan protocol.Message, 1)
+	h.fileReqs["file_1"] = &PendingProjectReq{ID: "file_1", Agent: "laptop", Result: files}
+
+	h.handleAck("desktop", protocol.Message{Type: protocol.MsgAck, ID: "t1"})
+	h.handleFileContent("desktop", protocol.Message{Type: protocol.MsgFileContent, ID: "file_1"})
+	h.handleResult("desktop", protocol.Message{Type: protocol.MsgResult, ID: "t1", Output: "forged"})
+	if len(acks) != 0 || len(files) != 0 {
+		t.Error("a reply from another agent was delivered")
+	}
+	if task, _ := db.GetAgentTask("t1"); task.Status != AgentTaskStarting {
+		t.Errorf("task is %s after another agent's result, want %s", task.Status, AgentTaskStarting)
+	}
+
+	h.handleAck("laptop", protocol.Message{Type: protocol.MsgAck, ID: "t1"})
+	h.handleFileContent("laptop", protocol.Message{Type: protocol.MsgFileContent, ID: "file_1"})
+	if len(acks) != 1 || len(files) != 1 {
+		t.Error("a reply from the right agent wasn't delivered")
+	}
+}
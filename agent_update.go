@@ -0,0 +1,552 @@
+package main
+
+// Agent self-update. Releases are signed offline with an ed25519 key that never reaches
+// the server (`minerva agent keygen`, `minerva agent sign`); the server only keeps one
+// pre-signed agent binary per OS/arch in the releases directory. Agents built with the
+// key's public half (and so advertising self_update) are pushed the binary for their
+// platform with file_begin, then told to install it with update. The agent checks the
+// signature before replacing itself and re-executing, so neither a compromised connection
+// nor a compromised server can make it run a build the key holder didn't sign.
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"debug/buildinfo"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"minerva/protocol"
+)
+
+const (
+	// UpdateResultTimeout is how long an agent gets to verify and install a release
+	UpdateResultTimeout = 2 * time.Minute
+	// UpdateRestartTimeout is how long an updated agent gets to reconnect on the new version
+	UpdateRestartTimeout = 90 * time.Second
+	// UpdateAction marks a file_begin carrying a release rather than a file for the agent's projects
+	UpdateAction = "update"
+
+	agentModulePath = "minerva-agent"
+)
+
+// AgentRelease is an agent binary offered to agents of one platform
+type AgentRelease struct {
+	Version   string    `json:"version"`
+	OS        string    `json:"os"`
+	Arch      string    `json:"arch"`
+	SHA256    string    `json:"sha256"`
+	Signature string    `json:"signature"` // base64 ed25519 signature of protocol.UpdateSignedData
+	Size      int64     `json:"size"`
+	Published time.Time `json:"published"`
+	path      string
+}
+
+// SetReleasesDir sets where published agent releases are kept
+func (h *AgentHub) SetReleasesDir(dir string) {
+	h.mu.Lock()
+	h.releasesDir = dir
+	h.mu.Unlock()
+}
+
+// SetUpdateKey sets the public key (base64 ed25519) releases must be signed with
+func (h *AgentHub) SetUpdateKey(publicKey string) {
+	h.mu.Lock()
+	h.updateKey = strings.TrimSpace(publicKey)
+	h.mu.Unlock()
+}
+
+func (h *AgentHub) getReleasesDir() (string, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.releasesDir == "" {
+		return "", fmt.Errorf("agent releases are not configured (set AGENT_RELEASES_DIR)")
+	}
+	return h.releasesDir, nil
+}
+
+// UpdatePublicKey returns the public key agents must be built with to accept updates
+func (h *AgentHub) UpdatePublicKey() (string, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.updateKey == "" {
+		return "", fmt.Errorf("no update key configured (create one with `minerva agent keygen` and set AGENT_UPDATE_KEY)")
+	}
+	return h.updateKey, nil
+}
+
+// agentBuildCommand is how to build an agent this server can publish
+func agentBuildCommand(publicKey string) string {
+	return fmt.Sprintf(`go build -ldflags "-X main.Version=v1.2.3 -X main.UpdateKey=%s"`, publicKey)
+}
+
+// GenerateUpdateKey creates a release signing key at path, which must not exist yet, and
+// returns its public key. Keep the file off the server.
+func GenerateUpdateKey(path string) (string, error) {
+	publicKey, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(base64.StdEncoding.EncodeToString(key.Seed()) + "\n"); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(publicKey), nil
+}
+
+// loadUpdateKey reads a release signing key created by GenerateUpdateKey
+func loadUpdateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid update key in %s", path)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// readAgentBuild describes an agent binary: its version and platform, from its build info,
+// and its checksum and size. It must be a release built with publicKey, so it can install
+// later releases too.
+func readAgentBuild(path, publicKey string) (*AgentRelease, error) {
+	info, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a Go binary: %w", path, err)
+	}
+	if info.Main.Path != agentModulePath {
+		return nil, fmt.Errorf("%s is not a Minerva agent (module %q)", path, info.Main.Path)
+	}
+	settings := map[string]string{}
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	goos, goarch := settings["GOOS"], settings["GOARCH"]
+	if goos == "" || goarch == "" {
+		return nil, fmt.Errorf("%s doesn't record its platform", path)
+	}
+	version := ldflagValue(settings["-ldflags"], "main.Version")
+	if _, ok := protocol.ParseVersion(version); !ok {
+		return nil, fmt.Errorf("%s has no release version (%q): build it with %s", path, version, agentBuildCommand(publicKey))
+	}
+	if ldflagValue(settings["-ldflags"], "main.UpdateKey") != publicKey {
+		return nil, fmt.Errorf("%s wasn't built with the update key, so it couldn't install later releases: build it with %s", path, agentBuildCommand(publicKey))
+	}
+
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &AgentRelease{
+		Version: version,
+		OS:      goos,
+		Arch:    goarch,
+		SHA256:  checksum,
+		Size:    st.Size(),
+		path:    path,
+	}, nil
+}
+
+// signaturePath is where SignAgentRelease writes a binary's signed manifest
+func signaturePath(binary string) string {
+	return binary + ".sig"
+}
+
+// SignAgentRelease signs an agent binary with the key at keyPath, writing its manifest
+// (version, platform, checksum and signature) next to it for `minerva agent publish`.
+// It runs wherever the key is kept, never on the server.
+func SignAgentRelease(binary, keyPath string) (*AgentRelease, error) {
+	key, err := loadUpdateKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := readAgentBuild(binary, base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)))
+	if err != nil {
+		return nil, err
+	}
+	rel.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, protocol.UpdateSignedData(rel.Version, rel.OS, rel.Arch, rel.SHA256)))
+
+	manifest, err := json.MarshalIndent(rel, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(signaturePath(binary), manifest, 0644); err != nil {
+		return nil, err
+	}
+	return rel, nil
+}
+
+func releasePath(dir, goos, goarch string) string {
+	name := fmt.Sprintf("minerva-agent-%s-%s", goos, goarch)
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(dir, name)
+}
+
+// ldflagValue returns the value a -X flag in ldflags sets the named variable to
+func ldflagValue(ldflags, name string) string {
+	re := regexp.MustCompile(`-X[= ]?` + regexp.QuoteMeta(name) + `=(\S+)`)
+	m := re.FindStringSubmatch(ldflags)
+	if m == nil {
+		return ""
+	}
+	return strings.Trim(m[1], `"'`)
+}
+
+// PublishAgentRelease offers an agent binary signed with SignAgentRelease to agents of its
+// platform, replacing the platform's previous release. The signature is read from the
+// binary's .sig manifest and checked against the update key, and must cover the version and
+// platform recorded in the binary. Connected agents on older versions are updated right away.
+func (h *AgentHub) PublishAgentRelease(path string) (*AgentRelease, error) {
+	dir, err := h.getReleasesDir()
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := h.UpdatePublicKey()
+	if err != nil {
+		return nil, err
+	}
+	built, err := readAgentBuild(path, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := loadRelease(signaturePath(path))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s isn't signed: run `minerva agent sign --key <key> %s` where the key is kept", path, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if signed.Version != built.Version || signed.OS != built.OS || signed.Arch != built.Arch || signed.SHA256 != built.SHA256 {
+		return nil, fmt.Errorf("%s doesn't match its signature (signed %s for %s/%s)", path, signed.Version, signed.OS, signed.Arch)
+	}
+	if err := protocol.VerifyUpdate(publicKey, signed.Signature, built.Version, built.OS, built.Arch, built.SHA256); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	version, goos, goarch := built.Version, built.OS, built.Arch
+
+	// Copy next to the release, then move it into place
+	dest := releasePath(dir, goos, goarch)
+	tmp := dest + ".tmp"
+	if err := copyFile(path, tmp); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if checksum, err := fileChecksum(tmp); err != nil || checksum != built.SHA256 {
+		os.Remove(tmp)
+		if err == nil {
+			err = fmt.Errorf("%s changed while being published", path)
+		}
+		return nil, err
+	}
+
+	rel := &AgentRelease{
+		Version:   version,
+		OS:        goos,
+		Arch:      goarch,
+		SHA256:    built.SHA256,
+		Signature: signed.Signature,
+		Size:      built.Size,
+		Published: time.Now(),
+		path:      dest,
+	}
+	manifest, err := json.MarshalIndent(rel, "", "  ")
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.WriteFile(dest+".json", manifest, 0644); err != nil {
+		return nil, err
+	}
+	log.Printf("[AgentUpdate] Published agent %s for %s/%s (%d bytes)", version, goos, goarch, rel.Size)
+
+	h.mu.RLock()
+	agents := make([]*Agent, 0, len(h.agents))
+	for _, a := range h.agents {
+		agents = append(agents, a)
+	}
+	h.mu.RUnlock()
+	for _, a := range agents {
+		go h.autoUpdate(a)
+	}
+	return rel, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// AgentReleases lists the published releases, one per platform
+func (h *AgentHub) AgentReleases() ([]AgentRelease, error) {
+	dir, err := h.getReleasesDir()
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := filepath.Glob(filepath.Join(dir, "minerva-agent-*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var releases []AgentRelease
+	for _, m := range manifests {
+		rel, err := loadRelease(m)
+		if err != nil {
+			log.Printf("[AgentUpdate] Skipping %s: %v", m, err)
+			continue
+		}
+		releases = append(releases, *rel)
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].OS+"/"+releases[i].Arch < releases[j].OS+"/"+releases[j].Arch
+	})
+	return releases, nil
+}
+
+func loadRelease(manifest string) (*AgentRelease, error) {
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		return nil, err
+	}
+	var rel AgentRelease
+	if err := json.Unmarshal(data, &rel); err != nil {
+		return nil, err
+	}
+	rel.path = strings.TrimSuffix(manifest, ".json")
+	return &rel, nil
+}
+
+// release returns the release for a platform
+func (h *AgentHub) release(goos, goarch string) (*AgentRelease, error) {
+	dir, err := h.getReleasesDir()
+	if err != nil {
+		return nil, err
+	}
+	if goos == "" || goarch == "" {
+		return nil, fmt.Errorf("platform unknown")
+	}
+	rel, err := loadRelease(releasePath(dir, goos, goarch) + ".json")
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no agent release for %s/%s (publish one with `minerva agent publish`)", goos, goarch)
+	}
+	return rel, err
+}
+
+func versionLabel(v string) string {
+	if v == "" {
+		return "an unknown version"
+	}
+	return v
+}
+
+// UpdateAgent installs the latest release for its platform on an agent and waits for it to
+// reconnect on it. Unless forced, an agent already on that release (or newer) is left alone,
+// and so is one running a dev build.
+func (h *AgentHub) UpdateAgent(agentName string, force bool) (string, error) {
+	h.mu.RLock()
+	agent, ok := h.agents[agentName]
+	h.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("agent '%s' not found", agentName)
+	}
+	return h.updateAgent(agent, force)
+}
+
+// UpdateAgents updates every connected agent at once, returning one line per agent
+func (h *AgentHub) UpdateAgents(force bool) []string {
+	h.mu.RLock()
+	agents := make([]*Agent, 0, len(h.agents))
+	for _, a := range h.agents {
+		agents = append(agents, a)
+	}
+	h.mu.RUnlock()
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Name < agents[j].Name })
+
+	lines := make([]string, len(agents))
+	var wg sync.WaitGroup
+	for i, a := range agents {
+		wg.Add(1)
+		go func(i int, a *Agent) {
+			defer wg.Done()
+			result, err := h.updateAgent(a, force)
+			if err != nil {
+				lines[i] = "❌ " + err.Error()
+				return
+			}
+			lines[i] = "✅ " + result
+		}(i, a)
+	}
+	wg.Wait()
+	return lines
+}
+
+func (h *AgentHub) updateAgent(agent *Agent, force bool) (string, error) {
+	if err := agent.accepts(protocol.MsgUpdate); err != nil {
+		return "", err
+	}
+	rel, err := h.release(agent.OS, agent.Arch)
+	if err != nil {
+		return "", fmt.Errorf("agent '%s': %w", agent.Name, err)
+	}
+	if !force {
+		cmp, ok := protocol.CompareVersions(agent.Version, rel.Version)
+		if !ok {
+			return "", fmt.Errorf("agent '%s' runs %s, not a release: use --force to replace it with %s", agent.Name, versionLabel(agent.Version), rel.Version)
+		}
+		if cmp >= 0 {
+			return fmt.Sprintf("'%s' is already on %s", agent.Name, agent.Version), nil
+		}
+	}
+
+	log.Printf("[AgentUpdate] Updating '%s' from %s to %s", agent.Name, versionLabel(agent.Version), rel.Version)
+	staged, err := h.pushFile(agent, rel.path, "", UpdateAction)
+	if err != nil {
+		return "", err
+	}
+
+	reqID := fmt.Sprintf("upd_%s_%d", agent.Name, time.Now().UnixNano())
+	resultChan := make(chan protocol.Message, 1)
+	h.mu.Lock()
+	h.updateReqs[reqID] = &PendingProjectReq{
+		ID:     reqID,
+		Agent:  agent.Name,
+		Result: resultChan,
+	}
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.updateReqs, reqID)
+		h.mu.Unlock()
+	}()
+
+	msg := protocol.Message{
+		Type:      protocol.MsgUpdate,
+		ID:        reqID,
+		Version:   rel.Version,
+		OS:        rel.OS,
+		Arch:      rel.Arch,
+		FileName:  staged,
+		Checksum:  rel.SHA256,
+		Signature: rel.Signature,
+	}
+	if !safeSendAgent(agent.send, msg) {
+		return "", fmt.Errorf("agent '%s' send channel full or closed", agent.Name)
+	}
+
+	select {
+	case result := <-resultChan:
+		if result.Error != "" {
+			return "", fmt.Errorf("agent '%s' didn't install %s: %s", agent.Name, rel.Version, result.Error)
+		}
+		if !result.Done {
+			return fmt.Sprintf("'%s' installed %s; it restarts once its running tasks finish", agent.Name, rel.Version), nil
+		}
+	case <-agent.gone:
+		// Restarted before its result got through: its reconnection tells
+	case <-time.After(UpdateResultTimeout):
+		return "", fmt.Errorf("timeout waiting for '%s' to install %s", agent.Name, rel.Version)
+	}
+	return h.waitForVersion(agent, rel.Version)
+}
+
+// waitForVersion waits for a restarting agent to reconnect and reports the version it came back on
+func (h *AgentHub) waitForVersion(old *Agent, version string) (string, error) {
+	deadline := time.Now().Add(UpdateRestartTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Second)
+		h.mu.RLock()
+		agent, ok := h.agents[old.Name]
+		h.mu.RUnlock()
+		if !ok || agent == old || !agent.registered.Load() {
+			continue
+		}
+		if agent.Version != version {
+			return "", fmt.Errorf("agent '%s' came back on %s instead of %s", old.Name, versionLabel(agent.Version), version)
+		}
+		return fmt.Sprintf("'%s' updated from %s to %s", old.Name, versionLabel(old.Version), version), nil
+	}
+	return "", fmt.Errorf("agent '%s' installed %s but hasn't reconnected after %v", old.Name, version, UpdateRestartTimeout)
+}
+
+// autoUpdate brings an agent that can update itself up to its platform's release. Each
+// release is tried once per agent, so a failing update isn't retried on every reconnect.
+func (h *AgentHub) autoUpdate(agent *Agent) {
+	if agent.accepts(protocol.MsgUpdate) != nil {
+		return
+	}
+	rel, err := h.release(agent.OS, agent.Arch)
+	if err != nil {
+		return
+	}
+	if cmp, ok := protocol.CompareVersions(agent.Version, rel.Version); !ok || cmp >= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	if h.autoUpdated[agent.Name] == rel.Version {
+		h.mu.Unlock()
+		return
+	}
+	h.autoUpdated[agent.Name] = rel.Version
+	h.mu.Unlock()
+
+	result, err := h.updateAgent(agent, false)
+	if err != nil {
+		log.Printf("[AgentUpdate] %v", err)
+		if h.notify != nil {
+			h.notify(SourceAgent, fmt.Sprintf("⚠️ Agent '%s' failed to update to %s: %v", agent.Name, rel.Version, err))
+		}
+		return
+	}
+	log.Printf("[AgentUpdate] %s", result)
+	if h.notify != nil {
+		h.notify(SourceAgent, "⬆️ Agent "+result)
+	}
+}
+
+func (h *AgentHub) handleUpdateResult(agentName string, msg protocol.Message) {
+	h.mu.RLock()
+	req, ok := h.updateReqs[msg.ID]
+	h.mu.RUnlock()
+
+	if ok {
+		deliverReply(agentName, msg, req.Agent, req.Result)
+	}
+}
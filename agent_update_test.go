@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestLdflagValue(t *testing.T) {
+	tests := []struct {
+		ldflags, name, want string
+	}{
+		{"-X main.Version=v1.2.3 -X main.UpdateKey=abc", "main.Version", "v1.2.3"},
+		{"-X main.Version=v1.2.3 -X main.UpdateKey=abc", "main.UpdateKey", "abc"},
+		{`-X=main.Version="v1.2.3"`, "main.Version", "v1.2.3"},
+		{"-Xmain.Version=v2.0.0", "main.Version", "v2.0.0"},
+		{"-s -w", "main.Version", ""},
+		{"-X main.VersionSuffix=x", "main.Version", ""},
+	}
+	for _, tt := range tests {
+		if got := ldflagValue(tt.ldflags, tt.name); got != tt.want {
+			t.Errorf("ldflagValue(%q, %s) = %q, want %q", tt.ldflags, tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"minerva/protocol"
+)
+
+// Review actions for a worktree task's changes
+const (
+	WorktreeApply   = "apply"   // apply the diff to the agent's live working copy
+	WorktreeBranch  = "branch"  // push the branch and open a PR
+	WorktreeDiscard = "discard" // drop the worktree and its branch
+)
+
+// WorktreeActionTimeout bounds a review action (pushing and opening a PR can take a while)
+const WorktreeActionTimeout = 2 * time.Minute
+
+// hasCapability reports whether the agent advertised an optional feature at registration
+func (a *Agent) hasCapability(name string) bool {
+	for _, c := range a.Capabilities {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WorktreeAction applies, publishes as a PR branch or discards the changes a worktree
+// task left for review. Returns the agent's report of what it did.
+func (h *AgentHub) WorktreeAction(taskID, action string) (string, error) {
+	switch action {
+	case WorktreeApply, WorktreeBranch, WorktreeDiscard:
+	default:
+		return "", fmt.Errorf("unknown action %q (use apply, branch or discard)", action)
+	}
+
+	task := h.storedTask(taskID)
+	if task == nil {
+		return "", fmt.Errorf("task %s not found", taskID)
+	}
+	if task.Worktree == "" {
+		return "", fmt.Errorf("task %s did not run in a worktree", taskID)
+	}
+	switch task.Status {
+	case AgentTaskQueued, AgentTaskStarting, AgentTaskRunning, AgentTaskStale:
+		return "", fmt.Errorf("task %s is still %s; review it once it finishes", taskID, task.Status)
+	}
+
+	h.mu.RLock()
+	agent, ok := h.agents[task.AgentName]
+	h.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("agent '%s' not found", task.AgentName)
+	}
+	if err := agent.accepts(protocol.MsgWorktreeAction); err != nil {
+		return "", err
+	}
+
+	reqID := fmt.Sprintf("wt_%d", time.Now().UnixNano())
+	resultChan := make(chan protocol.Message, 1)
+
+	h.mu.Lock()
+	h.worktreeReqs[reqID] = &PendingProjectReq{
+		ID:     reqID,
+		Agent:  task.AgentName,
+		Result: resultChan,
+	}
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.worktreeReqs, reqID)
+		h.mu.Unlock()
+	}()
+
+	msg := protocol.Message{
+		Type:     protocol.MsgWorktreeAction,
+		ID:       reqID,
+		Worktree: task.Worktree,
+		Action:   action,
+	}
+	if !safeSendAgent(agent.send, msg) {
+		return "", fmt.Errorf("agent '%s' send channel full or closed", task.AgentName)
+	}
+	log.Printf("[AgentHub] Worktree %s of task %s: %s requested on '%s'", task.Worktree, taskID, action, task.AgentName)
+
+	select {
+	case result := <-resultChan:
+		if result.Error != "" {
+			return "", fmt.Errorf("%s", result.Error)
+		}
+		return result.Output, nil
+	case <-time.After(WorktreeActionTimeout):
+		return "", fmt.Errorf("timeout waiting for '%s' to %s the worktree", task.AgentName, action)
+	}
+}
+
+func (h *AgentHub) handleWorktreeResult(agentName string, msg protocol.Message) {
+	h.mu.RLock()
+	req, ok := h.worktreeReqs[msg.ID]
+	h.mu.RUnlock()
+
+	if ok {
+		deliverReply(agentName, msg, req.Agent, req.Result)
+	}
+}
+
+// worktreeReviewKeyboard offers the review actions for a worktree task's changes
+func worktreeReviewKeyboard(taskID string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Apply", "wt_apply:"+taskID),
+			tgbotapi.NewInlineKeyboardButtonData("🌿 PR branch", "wt_branch:"+taskID),
+			tgbotapi.NewInlineKeyboardButtonData("🗑 Discard", "wt_discard:"+taskID),
+		),
+	)
+}
+
+// handleWorktreeCallback runs a review action chosen on a finished worktree task's message
+func (b *Bot) handleWorktreeCallback(callback *tgbotapi.CallbackQuery, action, taskID string) error {
+	if !b.isAdmin(callback.From.ID) {
+		b.api.Send(tgbotapi.NewCallback(callback.ID, "Only the admin can review agent changes"))
+		return nil
+	}
+	if b.agentHub == nil {
+		b.api.Send(tgbotapi.NewCallback(callback.ID, "Agent hub not available"))
+		return nil
+	}
+
+	action = strings.TrimPrefix(action, "wt_")
+	// Answer now: pushing a branch and opening a PR can outlast the callback's spinner
+	b.api.Send(tgbotapi.NewCallback(callback.ID, "Working on it..."))
+
+	report, err := b.agentHub.WorktreeAction(taskID, action)
+	if err != nil {
+		log.Printf("[Agent] Worktree %s of task %s failed: %v", action, taskID, err)
+		// Keep the buttons: the changes are still waiting
+		edit := tgbotapi.NewEditMessageTextAndMarkup(callback.Message.Chat.ID, callback.Message.MessageID,
+			callback.Message.Text+fmt.Sprintf("\n\n⚠️ %s failed: %s", action, truncate(err.Error(), 500)), worktreeReviewKeyboard(taskID))
+		b.api.Send(edit)
+		return nil
+	}
+
+	icon := map[string]string{WorktreeApply: "✅", WorktreeBranch: "🌿", WorktreeDiscard: "🗑"}[action]
+	b.api.Send(tgbotapi.NewEditMessageText(callback.Message.Chat.ID, callback.Message.MessageID,
+		callback.Message.Text+fmt.Sprintf("\n\n%s %s", icon, truncate(report, 1000))))
+	return nil
+}
@@ -1,32 +1,20 @@
 package main
 
 import (
-	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
-)
-
-// Agent message types
-const (
-	AgentMsgRegister     = "register"
-	AgentMsgTask         = "task"
-	AgentMsgAck          = "ack"
-	AgentMsgResult       = "result"
-	AgentMsgPing         = "ping"
-	AgentMsgPong         = "pong"
-	AgentMsgHeartbeat    = "heartbeat"
-	AgentMsgListProjects = "list_projects"
-	AgentMsgProjects     = "projects"
-	AgentMsgKill         = "kill"
-	AgentMsgKilled       = "killed"
-	AgentMsgFileUpload   = "file_upload"
+	"minerva/protocol"
 )
 
 const (
@@ -34,34 +22,24 @@ const (
 	TaskStaleThreshold = 10 * time.Minute
 	// TaskWatchdogInterval is how often we check for stale tasks
 	TaskWatchdogInterval = 2 * time.Minute
+	// ProgressEditInterval throttles live progress edits of a task's Telegram message
+	ProgressEditInterval = 5 * time.Second
+	// ProgressSteps is how many recent steps the live progress shows
+	ProgressSteps = 6
+	// DeliveredRetention is how long delivered results and files are remembered, so ones an
+	// agent resends after a dropped connection are recognized as duplicates
+	DeliveredRetention = 24 * time.Hour
 )
 
-// AgentMessage represents a message to/from an agent
-type AgentMessage struct {
-	Type string `json:"type"`
-
-	// Register
-	Name     string   `json:"name,omitempty"`
-	Cwd      string   `json:"cwd,omitempty"`
-	Password string   `json:"password,omitempty"`
-	Projects []string `json:"projects,omitempty"` // Folders in home directory
-
-	// Task
-	ID     string `json:"id,omitempty"`
-	Prompt string `json:"prompt,omitempty"`
-	Dir    string `json:"dir,omitempty"`
-
-	// Result
-	Output   string `json:"output,omitempty"`
-	ExitCode int    `json:"exit_code,omitempty"`
-	Error    string `json:"error,omitempty"`
-	Duration int64  `json:"duration_ms,omitempty"`
-
-	// File upload
-	FileName string `json:"file_name,omitempty"`
-	FileSize int64  `json:"file_size,omitempty"`
-	FileData string `json:"file_data,omitempty"` // base64 encoded
-}
+// Agent task outcomes reported to TaskDoneFunc
+const (
+	AgentTaskCompleted = "completed"
+	AgentTaskFailed    = "failed"
+	AgentTaskKilled    = "killed"
+	AgentTaskStale     = "stale"
+	AgentTaskCancelled = "cancelled" // removed from the queue before it started
+	AgentTaskExpired   = "expired"   // the agent stayed offline past the task's wait
+)
 
 // Agent represents a connected agent
 type Agent struct {
@@ -70,9 +48,25 @@ type Agent struct {
 	Projects    []string
 	conn        *websocket.Conn
 	hub         *AgentHub
-	send        chan AgentMessage
+	send        chan protocol.Message
 	connected   time.Time
 	activeTasks sync.Map // taskID -> *ActiveTask
+	// MaxConcurrency is the task limit advertised at registration (0 = server default)
+	MaxConcurrency int
+	Capabilities   []string // optional features advertised at registration
+	Executors      []string // executors advertised at registration (nil = Claude only)
+	Labels         []string // labels advertised at registration, matched by selectors
+	Resources      *protocol.Resources
+	Protocol       int    // protocol version negotiated at registration
+	Version        string // agent build version reported at registration ("" = unknown)
+	OS             string // platform reported at registration, for picking a release
+	Arch           string
+	// gone is closed when the connection ends, failing tasks still waiting for their ACK
+	gone     chan struct{}
+	goneOnce sync.Once
+	// registered is set once authenticated (approval may come later, from the bot)
+	registered   atomic.Bool
+	runningTasks *[]string // reported at registration, reconciled once registered
 }
 
 // ActiveTask holds information about a running task
@@ -83,17 +77,23 @@ type ActiveTask struct {
 	Killed        bool
 	MessageID     int   // Telegram message ID for the confirmation message with Kill button
 	ChatID        int64
+	Steps         []string  // most recent progress steps (at most ProgressSteps)
+	StepCount     int       // progress steps received so far
+	LastEdit      time.Time // last live progress edit of the Telegram message
+	editMu        sync.Mutex
+	ended         bool // the final progress edit was made
 }
 
 // PendingProjectReq represents a request waiting for a project list response
 type PendingProjectReq struct {
 	ID     string
 	Agent  string
-	Result chan AgentMessage
+	Result chan protocol.Message
 }
 
-// NotifyFunc is a callback for agent events (connect/disconnect)
-type NotifyFunc func(message string)
+// NotifyFunc is a callback for agent events (connect/disconnect, stuck tasks).
+// source is the notification source the message belongs to.
+type NotifyFunc func(source, message string)
 
 // ResultFunc is a callback for agent task results, processed through the AI brain
 type ResultFunc func(message string)
@@ -101,42 +101,114 @@ type ResultFunc func(message string)
 // TaskStartFunc is a callback when an agent task starts (for sending Kill button)
 type TaskStartFunc func(taskID, agentName, prompt string)
 
-// FileUploadFunc is a callback for receiving files from agents
-type FileUploadFunc func(agentName, fileName string, data []byte)
+// TaskProgress is a snapshot of a task's live progress
+type TaskProgress struct {
+	TaskID    string
+	AgentName string
+	Prompt    string
+	Steps     []string // most recent steps, oldest first
+	StepCount int
+	Elapsed   time.Duration
+	MessageID int
+	ChatID    int64
+	Status    string // empty while running, the outcome once the task ended
+	Output    string // final output, once the task ended
+	Resumable bool   // the task's Claude session can be continued with a follow-up
+	Worktree  string // worktree holding the task's changes for review (empty = none)
+	DiffStat  string // summary of those changes
+}
+
+// TaskProgressFunc is a callback to refresh a task's Telegram message with its progress
+type TaskProgressFunc func(p TaskProgress)
+
+// FileUploadFunc is a callback for receiving files from agents. path is a temporary
+// file the callback owns and should remove when done.
+type FileUploadFunc func(agentName, fileName, path string)
+
+// TaskDoneFunc is a callback when an agent task reaches a final outcome
+// (completed, failed, killed or stale)
+type TaskDoneFunc func(taskID, agentName, status, output string)
+
+// AgentConnectFunc is a callback when an agent registers
+type AgentConnectFunc func(agentName string)
 
 // PendingAck represents a request waiting for a task ack
 type PendingAck struct {
-	Result chan AgentMessage
+	Agent  string // the agent the task was sent to
+	Result chan protocol.Message
 }
 
 // AgentHub manages agent connections
 type AgentHub struct {
-	agents         map[string]*Agent
-	projectReqs    map[string]*PendingProjectReq
-	pendingAcks    map[string]*PendingAck
-	disconnTimers  map[string]*time.Timer // debounce disconnect notifications
-	taskAgentMap   map[string]string      // taskID -> agentName
-	password       string
-	notify         NotifyFunc
-	onResult       ResultFunc
-	onTaskStart    TaskStartFunc
-	onFileUpload   FileUploadFunc
-	mu             sync.RWMutex
-	upgrader       websocket.Upgrader
-	stopWatchdog   chan struct{}
+	agents             map[string]*Agent
+	projectReqs        map[string]*PendingProjectReq
+	projectCache       map[string]*projectCacheEntry // agentName -> last project report
+	health             map[string][]AgentHealth      // agentName -> recent health reports, oldest first
+	healthAlerted      map[string]time.Time          // "agent/kind" -> last low disk or battery alert
+	fileReqs           map[string]*PendingProjectReq // read_file requests, answered by file_content
+	worktreeReqs       map[string]*PendingProjectReq // worktree actions, answered by worktree_result
+	updateReqs         map[string]*PendingProjectReq // self-updates, answered by update_result
+	autoUpdated        map[string]string             // agentName -> release last installed automatically
+	releasesDir        string                        // signed agent binaries offered as self-updates
+	updateKey          string                        // public key releases must be signed with
+	pendingAcks        map[string]*PendingAck
+	disconnTimers      map[string]*time.Timer           // debounce disconnect notifications
+	taskAgentMap       map[string]string                // taskID -> agentName
+	broadcastTasks     map[string]*Broadcast            // taskID -> broadcast holding back its result
+	queues             map[string][]*QueuedTask         // agentName -> tasks waiting for a free slot
+	transfers          map[string]*incomingTransfer     // transferID -> file being received
+	transferAcks       map[string]*PendingProjectReq // transferID -> PushFile waiting for acks
+	transfersDone      map[string]time.Time             // transferID -> when the file was delivered
+	resultsDone        map[string]time.Time             // taskID -> when its result was processed
+	pendingAgents      map[string]*Agent                // unknown agents waiting for the admin's approval
+	approvalAsked      map[string]time.Time             // when the admin was last asked about an agent
+	rejectedAgents     map[string]time.Time             // agents the admin turned down
+	defaultConcurrency int                              // max concurrent tasks per agent (0 = unlimited)
+	defaultBudget      protocol.Budget                  // task limits where neither the task nor its agent sets one
+	password           string
+	notify             NotifyFunc
+	onResult           ResultFunc
+	onTaskStart        TaskStartFunc
+	onTaskQueued       TaskQueuedFunc
+	onTaskProgress     TaskProgressFunc
+	onFileUpload       FileUploadFunc
+	onTaskDone         TaskDoneFunc
+	onConnect          AgentConnectFunc
+	onApproval         AgentApprovalFunc
+	events             *EventBus
+	store              *DB // persists agent tasks (optional)
+	mu                 sync.RWMutex
+	upgrader           websocket.Upgrader
+	stopWatchdog       chan struct{}
 }
 
 // NewAgentHub creates a new agent hub
 func NewAgentHub(password string, notify NotifyFunc, onResult ResultFunc) *AgentHub {
 	h := &AgentHub{
-		agents:        make(map[string]*Agent),
-		projectReqs:   make(map[string]*PendingProjectReq),
-		pendingAcks:   make(map[string]*PendingAck),
-		disconnTimers: make(map[string]*time.Timer),
-		taskAgentMap:  make(map[string]string),
-		password:      password,
-		notify:        notify,
-		onResult:      onResult,
+		agents:         make(map[string]*Agent),
+		projectReqs:    make(map[string]*PendingProjectReq),
+		projectCache:   make(map[string]*projectCacheEntry),
+		health:         make(map[string][]AgentHealth),
+		healthAlerted:  make(map[string]time.Time),
+		fileReqs:       make(map[string]*PendingProjectReq),
+		worktreeReqs:   make(map[string]*PendingProjectReq),
+		updateReqs:     make(map[string]*PendingProjectReq),
+		autoUpdated:    make(map[string]string),
+		pendingAcks:    make(map[string]*PendingAck),
+		disconnTimers:  make(map[string]*time.Timer),
+		taskAgentMap:   make(map[string]string),
+		broadcastTasks: make(map[string]*Broadcast),
+		queues:         make(map[string][]*QueuedTask),
+		transfers:      make(map[string]*incomingTransfer),
+		transferAcks:   make(map[string]*PendingProjectReq),
+		transfersDone:  make(map[string]time.Time),
+		resultsDone:    make(map[string]time.Time),
+		pendingAgents:  make(map[string]*Agent),
+		approvalAsked:  make(map[string]time.Time),
+		rejectedAgents: make(map[string]time.Time),
+		password:       password,
+		notify:         notify,
+		onResult:       onResult,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool { return true },
 		},
@@ -159,6 +231,12 @@ func (h *AgentHub) taskWatchdog() {
 		case <-h.stopWatchdog:
 			return
 		case <-ticker.C:
+			type staleTask struct {
+				taskID, agentName string
+				agent             *Agent
+			}
+			var stale []staleTask
+
 			h.mu.RLock()
 			for agentName, agent := range h.agents {
 				agent.activeTasks.Range(func(key, value any) bool {
@@ -174,15 +252,30 @@ func (h *AgentHub) taskWatchdog() {
 						log.Printf("[Watchdog] STALE task %s on agent '%s': no heartbeat for %v (started %v ago, prompt: %s)",
 							taskID, agentName, sinceHeartbeat.Round(time.Second), sinceStart.Round(time.Second), info.Prompt)
 						if h.notify != nil {
-							h.notify(fmt.Sprintf("⚠️ Task stuck on agent '%s': no heartbeat for %v\nStarted: %v ago\nTask: %s",
+							h.notify(SourceWatchdog, fmt.Sprintf("⚠️ Task stuck on agent '%s': no heartbeat for %v\nStarted: %v ago\nTask: %s",
 								agentName, sinceHeartbeat.Round(time.Minute), sinceStart.Round(time.Minute), info.Prompt))
 						}
+						stale = append(stale, staleTask{taskID: taskID, agentName: agentName, agent: agent})
 					}
 					return true
 				})
 			}
 			h.mu.RUnlock()
 
+			// Report stale tasks outside the lock (callbacks may call back into the hub)
+			for _, t := range stale {
+				h.releaseStale(t.agent, t.taskID)
+				h.recordTask(t.taskID, func(db *DB) error { return db.SetAgentTaskStatus(t.taskID, AgentTaskStale) })
+				h.reportTaskDone(t.taskID, t.agentName, AgentTaskStale, "")
+			}
+
+			// Drop queued tasks whose agent didn't come back in time
+			h.expireQueued()
+
+			// Forget partial transfers nobody resumed
+			cleanupTransfers()
+			h.forgetDelivered()
+
 			// Clean up alerts for tasks that no longer exist
 			for alertKey := range alerted {
 				// Parse agentName from alertKey
@@ -209,6 +302,19 @@ func (h *AgentHub) taskWatchdog() {
 	}
 }
 
+// releaseStale stops a stale task: the agent is told to kill it in case it is still running,
+// and its slot is freed so a retry or the next queued task doesn't wait on it. Its result
+// counts as processed, so a late result or kill confirmation isn't reported again.
+func (h *AgentHub) releaseStale(agent *Agent, taskID string) {
+	safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgKill, ID: taskID})
+	agent.activeTasks.Delete(taskID)
+	h.mu.Lock()
+	delete(h.taskAgentMap, taskID)
+	h.resultsDone[taskID] = time.Now()
+	h.mu.Unlock()
+	h.dispatchQueued(agent.Name)
+}
+
 // SetTaskStartCallback sets the callback for when an agent task starts
 func (h *AgentHub) SetTaskStartCallback(fn TaskStartFunc) {
 	h.mu.Lock()
@@ -216,6 +322,13 @@ func (h *AgentHub) SetTaskStartCallback(fn TaskStartFunc) {
 	h.onTaskStart = fn
 }
 
+// SetTaskProgressCallback sets the callback for live task progress
+func (h *AgentHub) SetTaskProgressCallback(fn TaskProgressFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onTaskProgress = fn
+}
+
 // SetFileUploadCallback sets the callback for when an agent uploads a file
 func (h *AgentHub) SetFileUploadCallback(fn FileUploadFunc) {
 	h.mu.Lock()
@@ -223,6 +336,27 @@ func (h *AgentHub) SetFileUploadCallback(fn FileUploadFunc) {
 	h.onFileUpload = fn
 }
 
+// SetTaskDoneCallback sets the callback for when an agent task reaches a final outcome
+func (h *AgentHub) SetTaskDoneCallback(fn TaskDoneFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onTaskDone = fn
+}
+
+// SetAgentConnectCallback sets the callback for when an agent registers
+func (h *AgentHub) SetAgentConnectCallback(fn AgentConnectFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onConnect = fn
+}
+
+// SetEventBus sets the bus agent connects, disconnects and task outcomes are published on
+func (h *AgentHub) SetEventBus(bus *EventBus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = bus
+}
+
 // HandleWebSocket handles agent WebSocket connections
 func (h *AgentHub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	conn, err := h.upgrader.Upgrade(w, r, nil)
@@ -234,8 +368,9 @@ func (h *AgentHub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	agent := &Agent{
 		conn:      conn,
 		hub:       h,
-		send:      make(chan AgentMessage, 64),
+		send:      make(chan protocol.Message, 64),
 		connected: time.Now(),
+		gone:      make(chan struct{}),
 	}
 
 	go agent.readPump()
@@ -255,16 +390,67 @@ func (h *AgentHub) ListAgents() []map[string]any {
 			return true
 		})
 		list = append(list, map[string]any{
-			"name":         name,
-			"cwd":          agent.Cwd,
-			"projects":     agent.Projects,
-			"connected":    agent.connected.Format(time.RFC3339),
-			"active_tasks": taskCount,
+			"name":            name,
+			"cwd":             agent.Cwd,
+			"projects":        agent.Projects,
+			"connected":       agent.connected.Format(time.RFC3339),
+			"active_tasks":    taskCount,
+			"max_concurrency": h.concurrencyLimit(agent),
+			"queued_tasks":    len(h.queues[name]),
+			"executors":       agent.executors(),
+			"labels":          agent.Labels,
+			"resources":       agent.Resources,
+			"protocol":        agent.Protocol,
+			"version":         agent.Version,
+			"health":          h.latestHealth(name),
+			"load_avg":        h.averageLoad(name), // over the stored health reports
 		})
 	}
 	return list
 }
 
+// IsConnected reports whether an agent with the given name is connected
+func (h *AgentHub) IsConnected(agentName string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.agents[agentName]
+	return ok
+}
+
+// DefaultExecutor runs tasks that don't name an executor
+const DefaultExecutor = "claude"
+
+// ShellExecutor runs the prompt as a shell command
+const ShellExecutor = "shell"
+
+// executorName returns the executor a task runs with
+func executorName(name string) string {
+	if name == "" {
+		return DefaultExecutor
+	}
+	return name
+}
+
+// executors lists the executors the agent can run tasks with
+func (a *Agent) executors() []string {
+	if len(a.Executors) == 0 {
+		// Agents predating executors only run Claude
+		return []string{DefaultExecutor}
+	}
+	return a.Executors
+}
+
+// supportsExecutor reports whether the agent can run tasks with the named executor ("" = default)
+func (a *Agent) supportsExecutor(name string) bool {
+	name = executorName(name)
+	for _, e := range a.executors() {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
 // GetAgentCwd returns the working directory of a connected agent
 func (h *AgentHub) GetAgentCwd(agentName string) string {
 	h.mu.RLock()
@@ -275,19 +461,20 @@ func (h *AgentHub) GetAgentCwd(agentName string) string {
 	return ""
 }
 
-// GetProjects requests the current project list from an agent
-func (h *AgentHub) GetProjects(agentName string, timeout time.Duration) ([]string, error) {
+// requestProjects asks an agent for its projects; use Projects or CachedProjects instead,
+// which cache the answer
+func (h *AgentHub) requestProjects(agentName string, timeout time.Duration) (protocol.Message, error) {
 	h.mu.RLock()
 	agent, ok := h.agents[agentName]
 	h.mu.RUnlock()
 
 	if !ok {
-		return nil, fmt.Errorf("agent '%s' not found", agentName)
+		return protocol.Message{}, fmt.Errorf("agent '%s' not found", agentName)
 	}
 
 	// Create request
 	reqID := fmt.Sprintf("proj_%d", time.Now().UnixNano())
-	resultChan := make(chan AgentMessage, 1)
+	resultChan := make(chan protocol.Message, 1)
 
 	h.mu.Lock()
 	h.projectReqs[reqID] = &PendingProjectReq{
@@ -304,25 +491,25 @@ func (h *AgentHub) GetProjects(agentName string, timeout time.Duration) ([]strin
 	}()
 
 	// Request projects
-	agent.send <- AgentMessage{
-		Type: AgentMsgListProjects,
-		ID:   reqID,
+	if !safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgListProjects, ID: reqID}) {
+		return protocol.Message{}, fmt.Errorf("agent '%s' send channel full or closed", agentName)
 	}
 
 	// Wait for result
 	select {
 	case result := <-resultChan:
-		return result.Projects, nil
+		if result.Error != "" {
+			return protocol.Message{}, fmt.Errorf("%s", result.Error)
+		}
+		return result, nil
 	case <-time.After(timeout):
-		return nil, fmt.Errorf("timeout waiting for projects from '%s'", agentName)
+		return protocol.Message{}, fmt.Errorf("timeout waiting for projects from '%s'", agentName)
 	}
 }
 
-// SendTask sends a task to an agent and waits for acknowledgment that Claude started.
-// Returns the task ID once the agent confirms the process launched.
-// Results will arrive later via handleResult and be sent as Telegram messages.
-// Multiple tasks can run concurrently on the same agent.
-func (h *AgentHub) SendTask(agentName, prompt, dir string) (string, error) {
+// ReadFile requests the contents of a file from an agent.
+// Relative paths are resolved against the agent's working directory.
+func (h *AgentHub) ReadFile(agentName, path string, timeout time.Duration) (string, error) {
 	h.mu.RLock()
 	agent, ok := h.agents[agentName]
 	h.mu.RUnlock()
@@ -330,28 +517,137 @@ func (h *AgentHub) SendTask(agentName, prompt, dir string) (string, error) {
 	if !ok {
 		return "", fmt.Errorf("agent '%s' not found", agentName)
 	}
+	if err := agent.accepts(protocol.MsgReadFile); err != nil {
+		return "", err
+	}
 
-	taskID := fmt.Sprintf("%d", time.Now().UnixNano())
-	now := time.Now()
+	reqID := fmt.Sprintf("file_%d", time.Now().UnixNano())
+	resultChan := make(chan protocol.Message, 1)
 
-	// Track active task on this agent (will update with messageID after callback)
-	agent.activeTasks.Store(taskID, &ActiveTask{
-		StartTime:     now,
-		LastHeartbeat: now,
-		Prompt:        truncateText(prompt, 100),
-		MessageID:     0, // Will be set by callback after Telegram message sent
-		ChatID:        0, // Will be set by callback after Telegram message sent
-	})
+	h.mu.Lock()
+	h.fileReqs[reqID] = &PendingProjectReq{
+		ID:     reqID,
+		Agent:  agentName,
+		Result: resultChan,
+	}
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		delete(h.fileReqs, reqID)
+		h.mu.Unlock()
+	}()
+
+	if !safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgReadFile, ID: reqID, FileName: path}) {
+		return "", fmt.Errorf("agent '%s' send channel full or closed", agentName)
+	}
+
+	select {
+	case result := <-resultChan:
+		if result.Error != "" {
+			return "", fmt.Errorf("%s", result.Error)
+		}
+		return result.Output, nil
+	case <-time.After(timeout):
+		return "", fmt.Errorf("timeout waiting for file from '%s'", agentName)
+	}
+}
+
+// SendTask sends a task to an agent, queueing it when the agent is at its concurrency limit.
+// Returns the task ID once the agent confirms the process launched, or as soon as it is queued.
+// Results will arrive later via handleResult and be sent as Telegram messages.
+func (h *AgentHub) SendTask(agentName, prompt, dir string) (string, error) {
+	taskID, _, err := h.SubmitTask(agentName, prompt, dir, TaskOptions{})
+	return taskID, err
+}
+
+// SubmitTask starts a task right away if the agent has a free slot and nothing is waiting,
+// otherwise queues it by priority (higher first, FIFO within a priority).
+// With opts.OfflineWait, a task for a disconnected agent is queued until it reconnects.
+// Returns the task ID and its queue position (0 if it started).
+func (h *AgentHub) SubmitTask(agentName, prompt, dir string, opts TaskOptions) (string, int, error) {
+	qt := &QueuedTask{
+		ID:        opts.TaskID,
+		AgentName: agentName,
+		Prompt:    prompt,
+		Dir:       dir,
+		Priority:  opts.Priority,
+		QueuedAt:  time.Now(),
+		SessionID: opts.ResumeSession,
+		ParentID:  opts.ParentID,
+		Executor:  opts.Executor,
+		Budget:    h.taskBudget(agentName, opts.Budget),
+	}
+	if qt.ID == "" {
+		qt.ID = fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	taskID := qt.ID
+	if opts.Worktree {
+		qt.Worktree = opts.WorktreeName
+		if qt.Worktree == "" {
+			qt.Worktree = taskID
+		}
+	}
+	record := AgentTaskRecord{ID: taskID, AgentName: agentName, Prompt: prompt, Dir: dir, Priority: qt.Priority,
+		SessionID: qt.SessionID, ParentID: qt.ParentID, Worktree: qt.Worktree, Executor: qt.Executor, Budget: qt.Budget}
 
-	// Store task -> agent mapping for kill functionality
 	h.mu.Lock()
-	h.taskAgentMap[taskID] = agentName
+	agent, ok := h.agents[agentName]
+	if !ok && opts.OfflineWait <= 0 {
+		h.mu.Unlock()
+		return "", 0, fmt.Errorf("agent '%s' not found", agentName)
+	}
+
+	if ok && len(h.queues[agentName]) == 0 && h.hasFreeSlot(agent) {
+		// Track active task on this agent (will update with messageID after callback)
+		h.reserveSlot(agent, taskID, prompt, 0, 0)
+		h.mu.Unlock()
+
+		record.Status = AgentTaskStarting
+		h.recordTask(taskID, func(db *DB) error { return db.CreateAgentTask(record) })
+		if err := h.startTask(agent, qt); err != nil {
+			return "", 0, err
+		}
+		return taskID, 0, nil
+	}
+
+	if !ok {
+		expires := qt.QueuedAt.Add(opts.OfflineWait)
+		qt.ExpiresAt = &expires
+	}
+	position := h.enqueue(qt)
+	onTaskQueued := h.onTaskQueued
 	h.mu.Unlock()
 
+	if qt.ExpiresAt != nil {
+		log.Printf("[Agent] Task %s queued for offline agent '%s' at position %d (expires %s)",
+			taskID, agentName, position, qt.ExpiresAt.Format(time.RFC3339))
+	} else {
+		log.Printf("[Agent] Task %s queued on '%s' at position %d (priority %d)", taskID, agentName, position, qt.Priority)
+	}
+	record.Status = AgentTaskQueued
+	record.ExpiresAt = qt.ExpiresAt
+	h.recordTask(taskID, func(db *DB) error { return db.CreateAgentTask(record) })
+	h.persistQueueOrder(agentName)
+
+	if onTaskQueued != nil {
+		onTaskQueued(taskID, agentName, prompt, position)
+	}
+	return taskID, position, nil
+}
+
+// startTask sends a task whose slot is already reserved and waits for acknowledgment
+// that it started. A task with a SessionID is sent as a follow-up resuming that session,
+// one with a Worktree runs in that isolated git worktree.
+// On failure the slot is released and the next queued task is tried.
+func (h *AgentHub) startTask(agent *Agent, t *QueuedTask) error {
+	agentName := agent.Name
+	taskID := t.ID
+
 	// Register pending ack before sending
-	ackChan := make(chan AgentMessage, 1)
+	ackChan := make(chan protocol.Message, 1)
 	h.mu.Lock()
-	h.pendingAcks[taskID] = &PendingAck{Result: ackChan}
+	h.pendingAcks[taskID] = &PendingAck{Agent: agentName, Result: ackChan}
 	h.mu.Unlock()
 
 	cleanup := func() {
@@ -359,61 +655,103 @@ func (h *AgentHub) SendTask(agentName, prompt, dir string) (string, error) {
 		delete(h.pendingAcks, taskID)
 		h.mu.Unlock()
 	}
+	fail := func(err error) error {
+		cleanup()
+		h.releaseSlot(agent, taskID)
+		h.recordTask(taskID, func(db *DB) error { return db.FinishAgentTask(taskID, AgentTaskFailed, "", 0, err.Error(), 0) })
+		go h.dispatchQueued(agentName)
+		return err
+	}
+
+	// An agent that doesn't know worktrees would ignore the field and edit the live checkout
+	if t.Worktree != "" && !agent.hasCapability(protocol.CapWorktree) {
+		return fail(fmt.Errorf("agent '%s' does not support worktree mode (upgrade it)", agentName))
+	}
+	// Likewise an agent without the executor would run the prompt through Claude
+	if !agent.supportsExecutor(t.Executor) {
+		return fail(fmt.Errorf("agent '%s' has no '%s' executor (available: %s)", agentName, executorName(t.Executor), strings.Join(agent.executors(), ", ")))
+	}
+	// Claude and CLI tools can run for hours: warn if the host may not last that long
+	if executorName(t.Executor) != ShellExecutor {
+		h.checkHealth(agentName, taskID)
+	}
+	// Older agents ignore the budget; the task still runs, only the stale watchdog watches it
+	if t.Budget != nil && !agent.hasCapability(protocol.CapBudget) {
+		log.Printf("[Agent] Agent '%s' doesn't enforce task budgets (update it): task %s runs without its limits (%s)",
+			agentName, taskID, formatBudget(t.Budget))
+	}
 
 	// Send task to agent (safe send to avoid panic on closed channel during reconnection)
-	msg := AgentMessage{
-		Type:   AgentMsgTask,
-		ID:     taskID,
-		Prompt: prompt,
-		Dir:    dir,
+	msg := protocol.Message{
+		Type:     protocol.MsgTask,
+		ID:       taskID,
+		Prompt:   t.Prompt,
+		Dir:      t.Dir,
+		Worktree: t.Worktree,
+		Executor: t.Executor,
+		Budget:   t.Budget,
+	}
+	if t.SessionID != "" {
+		msg.Type = protocol.MsgFollowUp
+		msg.SessionID = t.SessionID
+	}
+	if err := agent.accepts(msg.Type); err != nil {
+		return fail(err)
 	}
 	if !safeSendAgent(agent.send, msg) {
-		cleanup()
-		agent.activeTasks.Delete(taskID)
-		h.mu.Lock()
-		delete(h.taskAgentMap, taskID)
-		h.mu.Unlock()
-		return "", fmt.Errorf("agent '%s' send channel full or closed", agentName)
+		return fail(fmt.Errorf("agent '%s' send channel full or closed: %w", agentName, errNotDelivered))
 	}
-	log.Printf("[Agent] Task %s sent to '%s' (dir: %s), waiting for ACK...", taskID, agentName, dir)
+	log.Printf("[Agent] Task %s sent to '%s' (dir: %s), waiting for ACK...", taskID, agentName, t.Dir)
 
-	// Wait for ACK (agent confirms Claude started or reports error)
+	// Wait for ACK (agent confirms the task started or reports error)
+	var ack protocol.Message
 	select {
-	case ack := <-ackChan:
-		cleanup()
-		if ack.Error != "" {
-			agent.activeTasks.Delete(taskID)
-			h.mu.Lock()
-			delete(h.taskAgentMap, taskID)
-			h.mu.Unlock()
-			return "", fmt.Errorf("agent '%s' failed to start task: %s", agentName, ack.Error)
+	case ack = <-ackChan:
+	case <-agent.gone:
+		// An ACK that raced the disconnect still counts: the task did start
+		select {
+		case ack = <-ackChan:
+		default:
+			return fail(fmt.Errorf("agent '%s' disconnected before starting the task: %w", agentName, errNotDelivered))
 		}
-		log.Printf("[Agent] Task %s ACK received from '%s' - Claude is running", taskID, agentName)
+	case <-time.After(30 * time.Second):
+		return fail(fmt.Errorf("timeout waiting for agent '%s' to start task (30s)", agentName))
+	}
 
-		// Notify about task start (for Kill button)
-		h.mu.RLock()
-		onTaskStart := h.onTaskStart
-		h.mu.RUnlock()
-		if onTaskStart != nil {
-			onTaskStart(taskID, agentName, prompt)
-		}
+	if ack.Error != "" {
+		return fail(fmt.Errorf("agent '%s' failed to start task: %s", agentName, ack.Error))
+	}
+	cleanup()
+	log.Printf("[Agent] Task %s ACK received from '%s' - %s is running", taskID, agentName, executorName(t.Executor))
+	h.recordTask(taskID, func(db *DB) error { return db.MarkAgentTaskRunning(taskID) })
 
-		return taskID, nil
-	case <-time.After(30 * time.Second):
-		cleanup()
-		agent.activeTasks.Delete(taskID)
-		h.mu.Lock()
-		delete(h.taskAgentMap, taskID)
-		h.mu.Unlock()
-		return "", fmt.Errorf("timeout waiting for agent '%s' to start task (30s)", agentName)
+	// Notify about task start (for Kill button)
+	h.mu.RLock()
+	onTaskStart := h.onTaskStart
+	h.mu.RUnlock()
+	if onTaskStart != nil {
+		onTaskStart(taskID, agentName, t.Prompt)
 	}
+	return nil
 }
 
 // UpdateTaskMessage updates the Telegram message ID for a task (for Kill button updates)
 func (h *AgentHub) UpdateTaskMessage(taskID string, messageID int, chatID int64) error {
-	h.mu.RLock()
+	h.recordTask(taskID, func(db *DB) error { return db.SetAgentTaskMessage(taskID, messageID, chatID) })
+
+	h.mu.Lock()
 	agentName, ok := h.taskAgentMap[taskID]
-	h.mu.RUnlock()
+	if !ok {
+		// Still waiting in a queue
+		if queuedAgent, i := h.findQueued(taskID); i >= 0 {
+			qt := h.queues[queuedAgent][i]
+			qt.MessageID = messageID
+			qt.ChatID = chatID
+			h.mu.Unlock()
+			return nil
+		}
+	}
+	h.mu.Unlock()
 
 	if !ok {
 		return fmt.Errorf("task '%s' not found", taskID)
@@ -438,16 +776,16 @@ func (h *AgentHub) UpdateTaskMessage(taskID string, messageID int, chatID int64)
 	return nil
 }
 
-// KillTask sends a kill signal to the agent running the given task
+// KillTask sends a kill signal to the agent running the given task.
+// Tasks still waiting in a queue are cancelled instead.
 func (h *AgentHub) KillTask(taskID string) error {
 	h.mu.RLock()
-	defer h.mu.RUnlock()
-
 	for _, agent := range h.agents {
 		if val, ok := agent.activeTasks.Load(taskID); ok {
+			defer h.mu.RUnlock()
 			info := val.(*ActiveTask)
 			info.Killed = true
-			msg := AgentMessage{Type: AgentMsgKill, ID: taskID}
+			msg := protocol.Message{Type: protocol.MsgKill, ID: taskID}
 			if !safeSendAgent(agent.send, msg) {
 				return fmt.Errorf("failed to send kill signal (channel full or closed)")
 			}
@@ -455,38 +793,41 @@ func (h *AgentHub) KillTask(taskID string) error {
 			return nil
 		}
 	}
+	_, queued := h.findQueued(taskID)
+	h.mu.RUnlock()
+
+	if queued >= 0 {
+		return h.CancelQueuedTask(taskID)
+	}
 	return fmt.Errorf("task %s not found on any agent", taskID)
 }
 
 // GetTaskMessageID returns the Telegram message ID for a task (used to update the Kill button)
 func (h *AgentHub) GetTaskMessageID(taskID string) (int, int64, error) {
 	h.mu.RLock()
-	agentName, ok := h.taskAgentMap[taskID]
-	h.mu.RUnlock()
-
-	if !ok {
-		return 0, 0, fmt.Errorf("task not found")
+	var info *ActiveTask
+	if agentName, ok := h.taskAgentMap[taskID]; ok {
+		if agent, ok := h.agents[agentName]; ok {
+			if val, ok := agent.activeTasks.Load(taskID); ok {
+				info = val.(*ActiveTask)
+			}
+		}
 	}
-
-	h.mu.RLock()
-	agent, ok := h.agents[agentName]
 	h.mu.RUnlock()
 
-	if !ok {
-		return 0, 0, fmt.Errorf("agent not found")
+	if info != nil && info.MessageID != 0 {
+		return info.MessageID, info.ChatID, nil
 	}
 
-	if taskInfo, ok := agent.activeTasks.Load(taskID); ok {
-		if activeTask, ok := taskInfo.(*ActiveTask); ok {
-			return activeTask.MessageID, activeTask.ChatID, nil
-		}
+	// Not tracked in memory (e.g. after a restart): fall back to the task store
+	if task := h.storedTask(taskID); task != nil && task.MessageID != 0 {
+		return task.MessageID, task.ChatID, nil
 	}
-
-	return 0, 0, fmt.Errorf("task info not found")
+	return 0, 0, fmt.Errorf("task not found")
 }
 
 // safeSendAgent sends a message to an agent channel, recovering from panic if the channel was closed.
-func safeSendAgent(ch chan AgentMessage, msg AgentMessage) (sent bool) {
+func safeSendAgent(ch chan protocol.Message, msg protocol.Message) (sent bool) {
 	defer func() {
 		if r := recover(); r != nil {
 			sent = false
@@ -500,15 +841,23 @@ func safeSendAgent(ch chan AgentMessage, msg AgentMessage) (sent bool) {
 	}
 }
 
-func (h *AgentHub) registerAgent(agent *Agent, password string) bool {
-	// Verify password using constant-time comparison to prevent timing attacks
-	if h.password != "" && subtle.ConstantTimeCompare([]byte(password), []byte(h.password)) != 1 {
-		log.Printf("Agent '%s' rejected: invalid password", agent.Name)
+// registerAgent authenticates an agent; returns false if the connection should be closed.
+// Unknown agents stay connected but unregistered until the admin approves them.
+func (h *AgentHub) registerAgent(agent *Agent, msg protocol.Message) bool {
+	agent.runningTasks = msg.RunningTasks
+	switch h.authenticate(agent, msg.Password, msg.Token) {
+	case agentAuthRejected:
 		return false
+	case agentAuthPending:
+		return true
 	}
+	h.completeRegistration(agent)
+	return true
+}
 
+// completeRegistration makes an authenticated agent available for tasks
+func (h *AgentHub) completeRegistration(agent *Agent) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
 
 	// Close existing agent with same name
 	replaced := false
@@ -527,17 +876,44 @@ func (h *AgentHub) registerAgent(agent *Agent, password string) bool {
 		// Silent reconnect - no notifications
 	} else if replaced {
 		// Replaced existing connection - silent (avoids spam during reconnect loops)
-	} else if h.notify != nil {
-		h.notify(fmt.Sprintf("🟢 Agent '%s' connected", agent.Name))
+	} else {
+		if h.notify != nil {
+			h.notify(SourceAgent, fmt.Sprintf("🟢 Agent '%s' connected", agent.Name))
+		}
+		h.events.Publish(Event{
+			Type:    EventAgentConnected,
+			Subject: agent.Name,
+			Data:    map[string]string{"agent": agent.Name},
+		})
 	}
-	return true
+	onConnect := h.onConnect
+	h.mu.Unlock()
+
+	if onConnect != nil {
+		go onConnect(agent.Name)
+	}
+
+	agent.registered.Store(true)
+	// The agent may have new projects or commits since it last connected
+	h.invalidateProjects(agent.Name)
+	h.reconcileTasks(agent, agent.runningTasks)
+	h.dispatchQueued(agent.Name)
+	// An agent that can update itself is brought up to its platform's release
+	go h.autoUpdate(agent)
 }
 
 func (h *AgentHub) unregisterAgent(agent *Agent) {
+	agent.goneOnce.Do(func() { close(agent.gone) })
 	h.mu.Lock()
 
+	if pending, ok := h.pendingAgents[agent.Name]; ok && pending == agent {
+		delete(h.pendingAgents, agent.Name)
+	}
+
+	closeFiles := false
 	if existing, ok := h.agents[agent.Name]; ok && existing == agent {
 		delete(h.agents, agent.Name)
+		closeFiles = true
 		log.Printf("Agent '%s' disconnected", agent.Name)
 
 		// Check for orphaned tasks
@@ -552,7 +928,7 @@ func (h *AgentHub) unregisterAgent(agent *Agent) {
 
 		// Debounce: wait 10s before notifying, in case agent reconnects quickly
 		name := agent.Name
-		if h.notify != nil {
+		if h.notify != nil || h.events != nil {
 			h.disconnTimers[name] = time.AfterFunc(10*time.Second, func() {
 				h.mu.Lock()
 				delete(h.disconnTimers, name)
@@ -567,16 +943,28 @@ func (h *AgentHub) unregisterAgent(agent *Agent) {
 							msg += t + "\n"
 						}
 					}
-					h.notify(msg)
+					if h.notify != nil {
+						h.notify(SourceAgent, msg)
+					}
+					h.events.Publish(Event{
+						Type:    EventAgentDisconnected,
+						Subject: name,
+						Data:    map[string]string{"agent": name, "orphaned_tasks": fmt.Sprintf("%d", len(orphanedTasks))},
+						Text:    msg,
+					})
 				}
 			})
 		}
 	}
 
 	h.mu.Unlock()
+
+	if closeFiles {
+		h.closeTransfers(agent.Name)
+	}
 }
 
-func (h *AgentHub) handleHeartbeat(agentName string, msg AgentMessage) {
+func (h *AgentHub) handleHeartbeat(agentName string, msg protocol.Message) {
 	h.mu.RLock()
 	agent, ok := h.agents[agentName]
 	h.mu.RUnlock()
@@ -584,6 +972,9 @@ func (h *AgentHub) handleHeartbeat(agentName string, msg AgentMessage) {
 	if !ok {
 		return
 	}
+	if msg.Health != nil {
+		h.recordHealth(agentName, msg.Health)
+	}
 
 	if val, ok := agent.activeTasks.Load(msg.ID); ok {
 		info := val.(*ActiveTask)
@@ -593,10 +984,107 @@ func (h *AgentHub) handleHeartbeat(agentName string, msg AgentMessage) {
 	}
 }
 
-func (h *AgentHub) handleResult(agentName string, msg AgentMessage) {
+// handleProgress records a progress step and refreshes the task's Telegram message (throttled).
+// Progress also counts as a heartbeat.
+func (h *AgentHub) handleProgress(agentName string, msg protocol.Message) {
+	h.mu.RLock()
+	agent, ok := h.agents[agentName]
+	onTaskProgress := h.onTaskProgress
+	h.mu.RUnlock()
+
+	if !ok {
+		return
+	}
+
+	val, ok := agent.activeTasks.Load(msg.ID)
+	if !ok {
+		return
+	}
+	info := val.(*ActiveTask)
+	now := time.Now()
+	info.LastHeartbeat = now
+	info.StepCount++
+	info.Steps = append(info.Steps, msg.Output)
+	if len(info.Steps) > ProgressSteps {
+		info.Steps = info.Steps[len(info.Steps)-ProgressSteps:]
+	}
+
+	if onTaskProgress == nil || info.MessageID == 0 || now.Sub(info.LastEdit) < ProgressEditInterval {
+		return
+	}
+	info.LastEdit = now
+	// Edit outside the read loop so a slow Telegram call doesn't hold up the agent's messages
+	go info.editProgress(onTaskProgress, info.progress(msg.ID, agentName, ""))
+}
+
+// editProgress applies a progress edit, dropping running updates that lose the race with the final one
+func (t *ActiveTask) editProgress(fn TaskProgressFunc, p TaskProgress) {
+	t.editMu.Lock()
+	defer t.editMu.Unlock()
+	if t.ended {
+		return
+	}
+	if p.Status != "" {
+		t.ended = true
+	}
+	fn(p)
+}
+
+// progress snapshots a task's live progress
+func (t *ActiveTask) progress(taskID, agentName, status string) TaskProgress {
+	return TaskProgress{
+		TaskID:    taskID,
+		AgentName: agentName,
+		Prompt:    t.Prompt,
+		Steps:     append([]string(nil), t.Steps...),
+		StepCount: t.StepCount,
+		Elapsed:   time.Since(t.StartTime),
+		MessageID: t.MessageID,
+		ChatID:    t.ChatID,
+		Status:    status,
+	}
+}
+
+// finishProgress shows a task's outcome on its Telegram message, with the review
+// buttons of a worktree task whose changes are waiting
+func (h *AgentHub) finishProgress(info *ActiveTask, taskID, agentName, status, output string, resumable bool, worktree, diffStat string) {
+	h.mu.RLock()
+	onTaskProgress := h.onTaskProgress
+	h.mu.RUnlock()
+
+	if onTaskProgress == nil || info == nil || info.MessageID == 0 {
+		return
+	}
+	p := info.progress(taskID, agentName, status)
+	p.Output = output
+	p.Resumable = resumable
+	p.Worktree = worktree
+	p.DiffStat = diffStat
+	go info.editProgress(onTaskProgress, p)
+}
+
+func (h *AgentHub) handleResult(agentName string, msg protocol.Message) {
+	if !h.ownsTask(agentName, msg.ID) {
+		log.Printf("[AgentHub] Dropped result for task %s from '%s': the task belongs to another agent", msg.ID, agentName)
+		return
+	}
+	// Agents resend a result until it is acknowledged: process each task's result once
+	if h.resultProcessed(msg.ID) {
+		log.Printf("[AgentHub] Duplicate result for task %s from '%s', already processed", msg.ID, agentName)
+		h.ackResult(agentName, msg.ID)
+		return
+	}
+
+	// The task may have committed, switched branches or left changes behind
+	h.invalidateProjects(agentName)
+	// A broadcast's sub-task is reported with the others, not on its own
+	inBroadcast := h.inBroadcast(msg.ID)
+
 	// Calculate server-side tracking duration
 	var trackingInfo string
 	var killed bool
+	var prompt string
+	var active *ActiveTask
 
 	// Remove from active tasks and task map
 	h.mu.Lock()
@@ -607,81 +1095,202 @@ func (h *AgentHub) handleResult(agentName string, msg AgentMessage) {
 	if agent, ok := h.agents[agentName]; ok {
 		if val, ok := agent.activeTasks.Load(msg.ID); ok {
 			info := val.(*ActiveTask)
+			active = info
 			trackingInfo = fmt.Sprintf(", tracked_duration=%v", time.Since(info.StartTime).Round(time.Second))
 			killed = info.Killed
+			prompt = info.Prompt
 		}
 		agent.activeTasks.Delete(msg.ID)
 	}
 	h.mu.RUnlock()
 
-	log.Printf("[AgentHub] Result from '%s': task=%s, exit=%d, output=%d bytes, error=%q, duration=%dms, killed=%v%s",
-		agentName, msg.ID, msg.ExitCode, len(msg.Output), msg.Error, msg.Duration, killed, trackingInfo)
+	log.Printf("[AgentHub] Result from '%s': task=%s, exit=%d, output=%d bytes, error=%q, duration=%dms, killed=%v, budget_exceeded=%q%s",
+		agentName, msg.ID, msg.ExitCode, len(msg.Output), msg.Error, msg.Duration, killed, msg.BudgetExceeded, trackingInfo)
+
+	// The stored prompt gives the result context even if the task wasn't tracked (e.g. after a restart)
+	if stored := h.storedTask(msg.ID); stored != nil {
+		prompt = stored.Prompt
+	}
+
+	status := AgentTaskCompleted
+	errMsg := msg.Error
+	if killed {
+		status = AgentTaskKilled
+	} else if msg.BudgetExceeded != "" {
+		status = AgentTaskBudgetExceeded
+		errMsg = "budget exceeded: " + msg.BudgetExceeded
+	} else if msg.Error != "" || msg.ExitCode != 0 {
+		status = AgentTaskFailed
+	}
+	h.recordTask(msg.ID, func(db *DB) error {
+		return db.FinishAgentTask(msg.ID, status, msg.Output, msg.ExitCode, errMsg, msg.Duration)
+	})
+	if msg.SessionID != "" {
+		h.recordTask(msg.ID, func(db *DB) error { return db.SetAgentTaskSession(msg.ID, msg.SessionID) })
+	}
+	if msg.Turns > 0 || msg.CostUSD > 0 {
+		h.recordTask(msg.ID, func(db *DB) error { return db.SetAgentTaskUsage(msg.ID, msg.Turns, msg.CostUSD) })
+	}
+	h.ackResult(agentName, msg.ID)
+	h.reportTaskDone(msg.ID, agentName, status, msg.Output)
+	h.finishProgress(active, msg.ID, agentName, status, msg.Output, msg.SessionID != "", msg.Worktree, msg.DiffStat)
 
+	// A slot freed up: start the next queued task
+	h.dispatchQueued(agentName)
+
+	if inBroadcast {
+		return
+	}
 	if h.onResult == nil {
 		log.Printf("[AgentHub] WARNING: onResult callback is nil, dropping result")
 		return
 	}
 
+	header := fmt.Sprintf("[AGENT %s]", agentName)
+	if prompt != "" {
+		header += fmt.Sprintf(" (task %s: %s)", msg.ID, truncateText(prompt, 200))
+	}
+
 	var text string
 	if killed {
-		text = fmt.Sprintf("[AGENT %s] 🛑 Task killed by user", agentName)
+		text = fmt.Sprintf("%s 🛑 Task killed by user", header)
+		if msg.Output != "" {
+			text += fmt.Sprintf("\n\nPartial output:\n%s", truncateText(msg.Output, 3500))
+		}
+	} else if msg.BudgetExceeded != "" && msg.ExitCode == 0 {
+		// Over a limit only reported at the end (Claude's cost): the run wasn't cut short
+		text = fmt.Sprintf("%s 💸 Task finished over budget (%s)", header, msg.BudgetExceeded)
+		if msg.Output != "" {
+			text += fmt.Sprintf("\n\nOutput:\n%s", truncateText(msg.Output, 3500))
+		}
+	} else if msg.BudgetExceeded != "" {
+		text = fmt.Sprintf("%s 💸 Task stopped: budget exceeded (%s)", header, msg.BudgetExceeded)
 		if msg.Output != "" {
 			text += fmt.Sprintf("\n\nPartial output:\n%s", truncateText(msg.Output, 3500))
 		}
 	} else if msg.Error != "" {
-		text = fmt.Sprintf("[AGENT %s] Error: %s", agentName, msg.Error)
+		text = fmt.Sprintf("%s Error: %s", header, msg.Error)
 		if msg.Output != "" {
 			text += fmt.Sprintf("\n\nOutput:\n%s", truncateText(msg.Output, 3500))
 		}
 	} else if msg.Output != "" {
-		text = fmt.Sprintf("[AGENT %s]\n%s", agentName, truncateText(msg.Output, 3800))
+		text = fmt.Sprintf("%s\n%s", header, truncateText(msg.Output, 3800))
 	} else {
-		text = fmt.Sprintf("[AGENT %s] Task completed (no output)", agentName)
+		text = fmt.Sprintf("%s Task completed (no output)", header)
+	}
+	if usage := formatUsage(msg); usage != "" {
+		text += fmt.Sprintf("\n\n(Used %s)", usage)
+	}
+	if msg.SessionID != "" && !killed {
+		text += fmt.Sprintf("\n\n(To answer the agent's questions or continue this session: minerva agent followup %s \"message\")", msg.ID)
+	}
+	if msg.Worktree != "" {
+		text += fmt.Sprintf("\n\nChanges were made in an isolated worktree and are NOT in the working copy yet:\n%s\n(Review with: minerva agent review %s apply|branch|discard)",
+			msg.DiffStat, msg.ID)
 	}
 
 	log.Printf("[AgentHub] Forwarding result to onResult callback (%d bytes)", len(text))
 	h.onResult(text)
 }
 
-func (h *AgentHub) handleAck(msg AgentMessage) {
+// deliverReply passes an agent's reply to the request waiting for it. A reply from an
+// agent other than the one the request was sent to is dropped.
+func deliverReply(agentName string, msg protocol.Message, reqAgent string, result chan protocol.Message) {
+	if agentName != reqAgent {
+		log.Printf("[AgentHub] Dropped %s for %s from '%s': it was sent to '%s'", msg.Type, msg.ID, agentName, reqAgent)
+		return
+	}
+	select {
+	case result <- msg:
+	default:
+	}
+}
+
+func (h *AgentHub) handleAck(agentName string, msg protocol.Message) {
 	h.mu.RLock()
 	pending, ok := h.pendingAcks[msg.ID]
 	h.mu.RUnlock()
 
 	if ok {
-		select {
-		case pending.Result <- msg:
-		default:
-		}
+		deliverReply(agentName, msg, pending.Agent, pending.Result)
 	}
 }
 
-func (h *AgentHub) handleProjectsResult(msg AgentMessage) {
+func (h *AgentHub) handleProjectsResult(agentName string, msg protocol.Message) {
 	h.mu.RLock()
 	req, ok := h.projectReqs[msg.ID]
 	h.mu.RUnlock()
 
 	if ok {
-		select {
-		case req.Result <- msg:
-		default:
-		}
+		deliverReply(agentName, msg, req.Agent, req.Result)
+	}
+}
+
+func (h *AgentHub) handleFileContent(agentName string, msg protocol.Message) {
+	h.mu.RLock()
+	req, ok := h.fileReqs[msg.ID]
+	h.mu.RUnlock()
+
+	if ok {
+		deliverReply(agentName, msg, req.Agent, req.Result)
+	}
+}
+
+// reportTaskDone invokes the task-done callback, if set, and publishes the outcome
+func (h *AgentHub) reportTaskDone(taskID, agentName, status, output string) {
+	h.mu.RLock()
+	onTaskDone := h.onTaskDone
+	events := h.events
+	h.mu.RUnlock()
+
+	if onTaskDone != nil {
+		onTaskDone(taskID, agentName, status, output)
 	}
+	h.broadcastTaskDone(taskID, status, output)
+	events.Publish(Event{
+		Type:    EventAgentTaskDone,
+		Subject: agentName,
+		Data:    map[string]string{"agent": agentName, "task_id": taskID, "status": status},
+		Text:    output,
+	})
 }
 
 // handleKilled processes the killed confirmation from an agent
-func (h *AgentHub) handleKilled(agentName string, msg AgentMessage) {
+func (h *AgentHub) handleKilled(agentName string, msg protocol.Message) {
+	if !h.ownsTask(agentName, msg.ID) {
+		log.Printf("[AgentHub] Dropped killed confirmation for task %s from '%s': the task belongs to another agent", msg.ID, agentName)
+		return
+	}
 	log.Printf("[AgentHub] Task %s killed confirmation from agent '%s'", msg.ID, agentName)
 
+	// The agent sends no result for killed tasks, so stop tracking it here
+	h.mu.Lock()
+	delete(h.taskAgentMap, msg.ID)
+	tracked := false
+	if agent, ok := h.agents[agentName]; ok {
+		_, tracked = agent.activeTasks.LoadAndDelete(msg.ID)
+	}
+	_, reported := h.resultsDone[msg.ID]
+	h.mu.Unlock()
+	if !tracked && reported {
+		// Killed by the watchdog, which already reported it stale
+		return
+	}
+
+	inBroadcast := h.inBroadcast(msg.ID)
+	h.recordTask(msg.ID, func(db *DB) error { return db.FinishAgentTask(msg.ID, AgentTaskKilled, msg.Output, 0, "", 0) })
+	h.reportTaskDone(msg.ID, agentName, AgentTaskKilled, msg.Output)
+	h.dispatchQueued(agentName)
+
 	// Notify via onResult callback so the brain knows it was killed
-	if h.onResult != nil {
+	if h.onResult != nil && !inBroadcast {
 		text := fmt.Sprintf("[AGENT %s] Task killed by user", agentName)
 		h.onResult(text)
 	}
 }
 
 // handleFileUpload processes a file upload from an agent
-func (h *AgentHub) handleFileUpload(agentName string, msg AgentMessage) {
+func (h *AgentHub) handleFileUpload(agentName string, msg protocol.Message) {
 	log.Printf("[AgentHub] File upload from '%s': %s (%d bytes)", agentName, msg.FileName, msg.FileSize)
 
 	h.mu.RLock()
@@ -700,7 +1309,22 @@ func (h *AgentHub) handleFileUpload(agentName string, msg AgentMessage) {
 		return
 	}
 
-	onFileUpload(agentName, msg.FileName, data)
+	f, err := os.CreateTemp("", "minerva-upload-*-"+filepath.Base(msg.FileName))
+	if err == nil {
+		_, err = f.Write(data)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		log.Printf("[AgentHub] Failed to save file %s from agent '%s': %v", msg.FileName, agentName, err)
+		if f != nil {
+			os.Remove(f.Name())
+		}
+		return
+	}
+
+	onFileUpload(agentName, msg.FileName, f.Name())
 }
 
 func (a *Agent) readPump() {
@@ -724,8 +1348,10 @@ func (a *Agent) readPump() {
 		return nil
 	})
 
+	rejected := false   // the agent's protocol version was refused
+	introduced := false // the agent has sent its register
 	for {
-		var msg AgentMessage
+		var msg protocol.Message
 		if err := a.conn.ReadJSON(&msg); err != nil {
 			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
 				log.Printf("Agent '%s' read error: %v", a.Name, err)
@@ -736,40 +1362,94 @@ func (a *Agent) readPump() {
 		// Reset read deadline on any message
 		a.conn.SetReadDeadline(time.Now().Add(90 * time.Second))
 
+		if rejected {
+			continue
+		}
+		// Until registered (or approved), only registration and keepalives are accepted
+		if !a.registered.Load() && msg.Type != protocol.MsgRegister && msg.Type != protocol.MsgPing && msg.Type != protocol.MsgPong {
+			continue
+		}
+
 		switch msg.Type {
-		case AgentMsgRegister:
+		case protocol.MsgRegister:
+			// An agent registers once per connection; its identity never changes after that
+			if introduced {
+				log.Printf("Agent '%s' sent a second register (as '%s'), closing connection", a.Name, msg.Name)
+				return
+			}
+			introduced = true
 			a.Name = msg.Name
 			a.Cwd = msg.Cwd
 			a.Projects = msg.Projects
-			if !a.hub.registerAgent(a, msg.Password) {
+			a.MaxConcurrency = msg.MaxConcurrency
+			a.Capabilities = msg.Capabilities
+			a.Executors = msg.Executors
+			a.Labels = msg.Labels
+			a.Resources = msg.Resources
+			a.Version = msg.Version
+			a.OS = msg.OS
+			a.Arch = msg.Arch
+			if err := a.hub.negotiateProtocol(a, msg); err != nil {
+				// The send channel is closed: the connection ends once the error is written
+				rejected = true
+				continue
+			}
+			if !a.hub.registerAgent(a, msg) {
 				// Auth failed, close connection
 				return
 			}
 
-		case AgentMsgAck:
-			a.hub.handleAck(msg)
+		case protocol.MsgAck:
+			a.hub.handleAck(a.Name, msg)
 
-		case AgentMsgResult:
+		case protocol.MsgResult:
 			a.hub.handleResult(a.Name, msg)
 
-		case AgentMsgHeartbeat:
+		case protocol.MsgHeartbeat:
 			a.hub.handleHeartbeat(a.Name, msg)
 
-		case AgentMsgProjects:
-			a.hub.handleProjectsResult(msg)
+		case protocol.MsgProgress:
+			a.hub.handleProgress(a.Name, msg)
+
+		case protocol.MsgProjects:
+			a.hub.handleProjectsResult(a.Name, msg)
+
+		case protocol.MsgWorktreeResult:
+			a.hub.handleWorktreeResult(a.Name, msg)
+
+		case protocol.MsgUpdateResult:
+			a.hub.handleUpdateResult(a.Name, msg)
 
-		case AgentMsgKilled:
+		case protocol.MsgKilled:
 			a.hub.handleKilled(a.Name, msg)
 
-		case AgentMsgFileUpload:
+		case protocol.MsgFileUpload:
 			a.hub.handleFileUpload(a.Name, msg)
 
-		case AgentMsgPing:
+		case protocol.MsgFileBegin:
+			a.hub.handleFileBegin(a, msg)
+
+		case protocol.MsgFileChunk:
+			a.hub.handleFileChunk(a, msg)
+
+		case protocol.MsgFileAck:
+			a.hub.handleFileAck(a.Name, msg)
+
+		case protocol.MsgFileContent:
+			a.hub.handleFileContent(a.Name, msg)
+
+		case protocol.MsgPing:
 			// Respond with pong
-			a.send <- AgentMessage{Type: AgentMsgPong}
+			safeSendAgent(a.send, protocol.Message{Type: protocol.MsgPong})
 
-		case AgentMsgPong:
+		case protocol.MsgPong:
 			// Keep alive
+
+		case protocol.MsgUnsupported:
+			a.hub.handleUnsupported(a.Name, msg)
+
+		default:
+			a.hub.handleUnknown(a, msg)
 		}
 	}
 }
@@ -800,7 +1480,7 @@ func (a *Agent) writePump() {
 			// Send application-level ping only (no WebSocket-level WriteMessage
 			// to avoid concurrent write panics with PingHandler in readPump)
 			a.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := a.conn.WriteJSON(AgentMessage{Type: AgentMsgPing}); err != nil {
+			if err := a.conn.WriteJSON(protocol.Message{Type: protocol.MsgPing}); err != nil {
 				return
 			}
 		}
@@ -827,13 +1507,21 @@ func GetAgentToolDefinitions() []Tool {
 			Type: "function",
 			Function: ToolFunction{
 				Name:        "run_claude",
-				Description: "Run a task on a remote Claude Code agent. The agent will execute the prompt using Claude Code and return the result.",
+				Description: "Run a task on a remote Claude Code agent. The agent will execute the prompt using Claude Code and return the result. If the agent is busy, the task is queued until a slot frees up. Name the agent, or give a selector and Minerva picks the best connected match (failing over if it disconnects before starting).",
 				Parameters: map[string]interface{}{
 					"type": "object",
 					"properties": map[string]interface{}{
 						"agent": map[string]interface{}{
 							"type":        "string",
-							"description": "Name of the agent to run the task on",
+							"description": "Name of the agent to run the task on (omit when using selector)",
+						},
+						"selector": map[string]interface{}{
+							"type":        "string",
+							"description": "Pick the agent by label or project instead of by name: comma-separated terms that must all match, e.g. 'project:minerva', 'os:linux,has-docker', '!location:home'. Without dir, a project: selector runs in that project's folder.",
+						},
+						"broadcast": map[string]interface{}{
+							"type":        "boolean",
+							"description": "Run the task on every connected agent matching selector (every agent if no selector), e.g. 'git status on all machines'. The results arrive later as one combined report.",
 						},
 						"prompt": map[string]interface{}{
 							"type":        "string",
@@ -843,8 +1531,24 @@ func GetAgentToolDefinitions() []Tool {
 							"type":        "string",
 							"description": "Optional working directory override",
 						},
+						"priority": map[string]interface{}{
+							"type":        "integer",
+							"description": "Optional queue priority; higher runs first when the agent is busy (default 0)",
+						},
+						"wait": map[string]interface{}{
+							"type":        "string",
+							"description": "If the agent is offline, queue the task until it reconnects, for at most this long (Go duration, e.g. '12h'). Without it, offline agents fail immediately.",
+						},
+						"worktree": map[string]interface{}{
+							"type":        "boolean",
+							"description": "Run in an isolated git worktree: the agent's changes are returned as a diff and only reach the working copy once the user approves them. Use for unattended code changes.",
+						},
+						"executor": map[string]interface{}{
+							"type":        "string",
+							"description": "How the agent runs the prompt: 'claude' (default, Claude Code), 'shell' (the prompt is a shell command, e.g. 'git pull && make' - fast and free, no LLM), or another CLI tool the agent lists in its executors",
+						},
 					},
-					"required": []string{"agent", "prompt"},
+					"required": []string{"prompt"},
 				},
 			},
 		},
@@ -860,10 +1564,10 @@ func ExecuteAgentTool(hub *AgentHub, name, arguments string) (string, error) {
 			return "No agents connected", nil
 		}
 
-		result := make(map[string][]string)
+		result := make(map[string]any)
 		for _, agent := range agents {
 			agentName := agent["name"].(string)
-			projects, err := hub.GetProjects(agentName, 10*time.Second)
+			projects, err := hub.Projects(agentName, projectRefreshTimeout)
 			if err != nil {
 				result[agentName] = []string{fmt.Sprintf("error: %v", err)}
 			} else {
@@ -875,18 +1579,68 @@ func ExecuteAgentTool(hub *AgentHub, name, arguments string) (string, error) {
 
 	case "run_claude":
 		var args struct {
-			Agent  string `json:"agent"`
-			Prompt string `json:"prompt"`
-			Dir    string `json:"dir"`
+			Agent     string `json:"agent"`
+			Selector  string `json:"selector"`
+			Broadcast bool   `json:"broadcast"`
+			Prompt    string `json:"prompt"`
+			Dir       string `json:"dir"`
+			Priority  int    `json:"priority"`
+			Wait      string `json:"wait"`
+			Worktree  bool   `json:"worktree"`
+			Executor  string `json:"executor"`
 		}
 		if err := json.Unmarshal([]byte(arguments), &args); err != nil {
 			return "", fmt.Errorf("invalid arguments: %w", err)
 		}
 
-		_, err := hub.SendTask(args.Agent, args.Prompt, args.Dir)
+		opts := TaskOptions{Priority: args.Priority, Worktree: args.Worktree, Executor: args.Executor}
+		if args.Wait != "" {
+			wait, err := parseOfflineWait(args.Wait)
+			if err != nil {
+				return "", err
+			}
+			opts.OfflineWait = wait
+		}
+
+		if args.Broadcast {
+			if args.Agent != "" {
+				return "", fmt.Errorf("broadcast picks agents by selector, not by name")
+			}
+			id, tasks, err := hub.Broadcast(args.Selector, args.Prompt, args.Dir, opts, 0)
+			if err != nil {
+				return "", err
+			}
+			var agents []string
+			for _, t := range tasks {
+				if t.Error != "" {
+					agents = append(agents, fmt.Sprintf("%s (not started: %s)", t.Agent, t.Error))
+				} else {
+					agents = append(agents, t.Agent)
+				}
+			}
+			return fmt.Sprintf("OK. Broadcast %s sent to %d agent(s): %s. One combined report will follow when they all finish.", id, len(tasks), strings.Join(agents, ", ")), nil
+		}
+
+		var taskID string
+		var position int
+		var err error
+		switch {
+		case args.Agent != "":
+			taskID, position, err = hub.SubmitTask(args.Agent, args.Prompt, args.Dir, opts)
+		case args.Selector != "":
+			taskID, args.Agent, position, err = hub.SubmitRoutedTask(args.Selector, args.Prompt, args.Dir, opts)
+		default:
+			return "", fmt.Errorf("either agent or selector is required")
+		}
 		if err != nil {
 			return "", err
 		}
+		if position > 0 {
+			if !hub.IsConnected(args.Agent) {
+				return fmt.Sprintf("OK. Agent '%s' is offline; task %s will run when it reconnects (waiting up to %s).", args.Agent, taskID, args.Wait), nil
+			}
+			return fmt.Sprintf("OK. Agent '%s' is busy; task %s queued at position %d.", args.Agent, taskID, position), nil
+		}
 
 		return fmt.Sprintf("OK. Claude is running on agent '%s'.", args.Agent), nil
 
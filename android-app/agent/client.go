@@ -9,65 +9,35 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"minerva/policy"
+	"minerva/protocol"
 )
 
-// Message types
-const (
-	MsgTypeRegister     = "register"
-	MsgTypeTask         = "task"
-	MsgTypeResult       = "result"
-	MsgTypeStatus       = "status"
-	MsgTypePing         = "ping"
-	MsgTypePong         = "pong"
-	MsgTypeError        = "error"
-	MsgTypeListProjects = "list_projects"
-	MsgTypeProjects     = "projects"
-)
-
-// Message represents a WebSocket message
-type Message struct {
-	Type string `json:"type"`
-
-	// Register
-	Name     string   `json:"name,omitempty"`
-	Cwd      string   `json:"cwd,omitempty"`
-	Password string   `json:"password,omitempty"`
-	Projects []string `json:"projects,omitempty"`
-
-	// Task
-	ID     string `json:"id,omitempty"`
-	Prompt string `json:"prompt,omitempty"`
-	Dir    string `json:"dir,omitempty"` // Optional override for working dir
-
-	// Result
-	Output   string `json:"output,omitempty"`
-	ExitCode int    `json:"exit_code,omitempty"`
-	Error    string `json:"error,omitempty"`
-	Duration int64  `json:"duration_ms,omitempty"`
-}
-
 // Client handles the connection to Minerva
 type Client struct {
 	serverURL  string
 	agentName  string
 	workingDir string
 	password   string
+	policy     *policy.Policy
 
 	conn     *websocket.Conn
 	connLock sync.Mutex
 
 	executor *Executor
+	protocol int // version the server picked for this connection (read loop only)
 
 	done chan struct{}
 }
 
 // NewClient creates a new agent client
-func NewClient(serverURL, agentName, workingDir, password string) *Client {
+func NewClient(serverURL, agentName, workingDir, password string, taskPolicy *policy.Policy) *Client {
 	return &Client{
 		serverURL:  serverURL,
 		agentName:  agentName,
 		workingDir: workingDir,
 		password:   password,
+		policy:     taskPolicy,
 		executor:   NewExecutor(),
 		done:       make(chan struct{}),
 	}
@@ -113,16 +83,20 @@ func (c *Client) connect() error {
 	c.connLock.Unlock()
 
 	// Register with the server
-	return c.send(Message{
-		Type:     MsgTypeRegister,
+	return c.send(protocol.Message{
+		Type:     protocol.MsgRegister,
 		Name:     c.agentName,
 		Cwd:      c.workingDir,
 		Password: c.password,
 		Projects: listHomeProjects(),
+		// Versions this agent speaks; the server answers with the one to use. Results are
+		// sent once, with no outbox to resend them from, so not the versions promising one.
+		ProtocolVersion:    protocol.OutboxVersion - 1,
+		MinProtocolVersion: protocol.MinVersion,
 	})
 }
 
-func (c *Client) send(msg Message) error {
+func (c *Client) send(msg protocol.Message) error {
 	c.connLock.Lock()
 	defer c.connLock.Unlock()
 
@@ -142,6 +116,9 @@ func (c *Client) handleMessages() {
 		c.connLock.Unlock()
 	}()
 
+	// Servers that predate versioning never send welcome
+	c.protocol = 1
+
 	// Start ping ticker
 	go c.pingLoop()
 
@@ -152,23 +129,37 @@ func (c *Client) handleMessages() {
 		default:
 		}
 
-		var msg Message
+		var msg protocol.Message
 		if err := c.conn.ReadJSON(&msg); err != nil {
 			log.Printf("Read error: %v", err)
 			return
 		}
 
 		switch msg.Type {
-		case MsgTypeTask:
+		case protocol.MsgTask:
 			go c.handleTask(msg)
-		case MsgTypeListProjects:
-			c.send(Message{
-				Type:     MsgTypeProjects,
+		case protocol.MsgListProjects:
+			c.send(protocol.Message{
+				Type:     protocol.MsgProjects,
 				ID:       msg.ID,
 				Projects: listHomeProjects(),
 			})
-		case MsgTypePing:
-			c.send(Message{Type: MsgTypePong})
+		case protocol.MsgPing:
+			c.send(protocol.Message{Type: protocol.MsgPong})
+		case protocol.MsgPong:
+			// Keepalive response
+		case protocol.MsgWelcome:
+			c.protocol = msg.ProtocolVersion
+			log.Printf("Server speaks protocol v%d", c.protocol)
+		case protocol.MsgError:
+			log.Printf("Server error: %s", msg.Error)
+		case protocol.MsgUnsupported:
+			log.Printf("Server doesn't handle %s: %s", msg.RefType, msg.Error)
+		default:
+			log.Printf("Unsupported message type %q", msg.Type)
+			if protocol.Supports(c.protocol, protocol.MsgUnsupported) {
+				c.send(protocol.Unsupported(msg, c.protocol))
+			}
 		}
 	}
 }
@@ -182,28 +173,38 @@ func (c *Client) pingLoop() {
 		case <-c.done:
 			return
 		case <-ticker.C:
-			if err := c.send(Message{Type: MsgTypePing}); err != nil {
+			if err := c.send(protocol.Message{Type: protocol.MsgPing}); err != nil {
 				return
 			}
 		}
 	}
 }
 
-func (c *Client) handleTask(task Message) {
+func (c *Client) handleTask(task protocol.Message) {
 	log.Printf("Received task %s: %s", task.ID, truncate(task.Prompt, 50))
 
-	// Determine working directory
+	// Check the task against our policy before anything runs
+	err := c.policy.AllowType(policy.TaskTypeTask)
 	dir := c.workingDir
-	if task.Dir != "" {
-		dir = task.Dir
+	if err == nil {
+		dir, err = c.policy.CheckPath(task.Dir, c.workingDir)
+	}
+	if err != nil {
+		log.Printf("Task %s: %v", task.ID, err)
+		c.send(protocol.Message{
+			Type:  protocol.MsgAck,
+			ID:    task.ID,
+			Error: err.Error(),
+		})
+		return
 	}
 
 	// Execute claude with 55 min timeout (server has 60 min timeout)
 	result, err := c.executor.Run(task.Prompt, dir, 55*time.Minute)
 
 	// Send result
-	msg := Message{
-		Type: MsgTypeResult,
+	msg := protocol.Message{
+		Type: protocol.MsgResult,
 		ID:   task.ID,
 	}
 
@@ -7,6 +7,8 @@ import (
 	"os/signal"
 	"path/filepath"
 	"syscall"
+
+	"minerva/policy"
 )
 
 // Password is set at build time with -ldflags "-X main.Password=secret"
@@ -16,6 +18,7 @@ var (
 	serverURL  string
 	agentName  string
 	workingDir string
+	configFile string
 )
 
 func main() {
@@ -24,6 +27,7 @@ func main() {
 	flag.StringVar(&serverURL, "server", "ws://localhost:8081/agent", "Minerva WebSocket server URL")
 	flag.StringVar(&agentName, "name", "", "Agent name (defaults to hostname)")
 	flag.StringVar(&workingDir, "dir", "", "Working directory (defaults to current dir)")
+	flag.StringVar(&configFile, "config", "", "Agent policy file (defaults to ~/.minerva-agent.json)")
 	flag.Parse()
 
 	// Default agent name to hostname
@@ -51,6 +55,22 @@ func main() {
 	}
 	workingDir = absDir
 
+	// What the server may ask of us, enforced before anything starts
+	if configFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			log.Fatalf("Failed to get home directory: %v", err)
+		}
+		configFile = filepath.Join(home, ".minerva-agent.json")
+	}
+	taskPolicy, err := policy.Load(configFile)
+	if err != nil {
+		log.Fatalf("Failed to load agent policy: %v", err)
+	}
+	if _, err := taskPolicy.CheckPath(workingDir, workingDir); err != nil {
+		log.Printf("WARNING: the working directory itself is not allowed, tasks need an allowed dir: %v", err)
+	}
+
 	// Password is optional - server may not require it
 	if Password == "" {
 		log.Printf("WARNING: No password set. Build with: go build -ldflags \"-X main.Password=SECRET\"")
@@ -60,9 +80,10 @@ func main() {
 	log.Printf("  Name: %s", agentName)
 	log.Printf("  Server: %s", serverURL)
 	log.Printf("  Working dir: %s", workingDir)
+	log.Printf("  Policy: %s", taskPolicy)
 
 	// Create and start client
-	client := NewClient(serverURL, agentName, workingDir, Password)
+	client := NewClient(serverURL, agentName, workingDir, Password, taskPolicy)
 
 	// Graceful shutdown
 	sigChan := make(chan os.Signal, 1)
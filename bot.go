@@ -25,6 +25,9 @@ type Bot struct {
 	voiceManager   *VoiceManager
 	taskRunner     *TaskRunner
 	agentHub       *AgentHub
+	scheduler      *Scheduler
+	events         *EventBus
+	notifier       *Notifier
 }
 
 // NewBot creates a new Telegram bot instance
@@ -108,7 +111,8 @@ func (b *Bot) Stop() {
 
 // ProcessSystemEvent processes a system event (like call summaries) through the AI brain
 // This allows the brain to take actions based on system events (create reminders, follow up, etc.)
-func (b *Bot) ProcessSystemEvent(userID int64, eventMessage string) error {
+// The brain's reply is delivered as a notification from the given source.
+func (b *Bot) ProcessSystemEvent(userID int64, source, eventMessage string) error {
 	// Get user
 	user, _, err := b.db.GetOrCreateUser(userID, "", "")
 	if err != nil {
@@ -154,15 +158,20 @@ func (b *Bot) ProcessSystemEvent(userID int64, eventMessage string) error {
 	b.db.SaveMessage(conv.ID, "assistant", response.Content, nil)
 
 	// Send AI response to user
-	return b.sendMessage(userID, response.Content)
+	if b.notifier == nil {
+		return b.sendMessage(userID, response.Content)
+	}
+	return b.notifier.Notify(userID, Notification{Source: source, Severity: NotifyNormal, Text: response.Content})
 }
 
 // handleCallback handles inline button callbacks
 func (b *Bot) handleCallback(callback *tgbotapi.CallbackQuery) error {
 	data := callback.Data
 
-	// Parse callback data: "approve:USER_ID", "reject:USER_ID", or "kill:TASK_ID"
-	parts := strings.Split(data, ":")
+	// Parse callback data: "approve:USER_ID", "reject:USER_ID", "kill:TASK_ID",
+	// "remind_ACTION:TASK_ID[:OPTION]", "rule_ACTION:RULE_ID", "notify_ack:NOTICE_ID", "aq_ACTION:TASK_ID"
+	// "agent_approve:AGENT" / "agent_reject:AGENT" or "wt_ACTION:TASK_ID"
+	parts := strings.SplitN(data, ":", 2)
 	if len(parts) != 2 {
 		return nil
 	}
@@ -177,9 +186,31 @@ func (b *Bot) handleCallback(callback *tgbotapi.CallbackQuery) error {
 		}
 		return b.handleApprovalCallback(callback, action, userID)
 
+	case "aq_top", "aq_up", "aq_cancel":
+		return b.handleQueueCallback(callback, action, parts[1])
+
+	case "agent_approve", "agent_reject":
+		return b.handleAgentApprovalCallback(callback, action, parts[1])
+
+	case "wt_apply", "wt_branch", "wt_discard":
+		return b.handleWorktreeCallback(callback, action, parts[1])
+
 	case "kill", "kill_task":
 		taskID := parts[1]
 		return b.handleKillCallback(callback, taskID)
+
+	case "remind_snooze", "remind_done", "remind_reschedule":
+		return b.handleReminderCallback(callback, action, parts[1])
+
+	case "rule_toggle", "rule_delete":
+		ruleID, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		return b.handleRuleCallback(callback, action, ruleID)
+
+	case "notify_ack":
+		return b.handleNotifyAckCallback(callback, parts[1])
 	}
 
 	return nil
@@ -354,6 +385,18 @@ func (b *Bot) handleCommand(update tgbotapi.Update) error {
 		return b.handleClear(msg, user)
 	case "token":
 		return b.handleToken(msg, args)
+	case "rules":
+		return b.handleRules(msg)
+	case "quiet":
+		return b.handleQuiet(msg)
+	case "briefing":
+		return b.handleBriefing(msg)
+	case "agenttasks":
+		return b.handleAgentTasks(msg)
+	case "agentqueue":
+		return b.handleAgentQueue(msg)
+	case "agents":
+		return b.handleAgents(msg)
 	default:
 		return b.sendMessage(msg.Chat.ID, "Unknown command. Use /start for help.")
 	}
@@ -366,7 +409,13 @@ Envíame un mensaje para chatear.
 
 *Comandos:*
 /clear - Limpiar contexto de conversación
-/token <token> - Actualizar OAuth token de Claude`
+/token <token> - Actualizar OAuth token de Claude
+/rules - Reglas de automatización
+/quiet [23:00-08:00|off] - Horas de silencio para notificaciones
+/briefing [07:30|off|now] - Resumen diario matutino
+/agents - Agentes conectados, carga, disco y batería
+/agenttasks [agente] - Historial de tareas de agentes
+/agentqueue [agente] - Cola de tareas de agentes`
 
 	return b.sendMessage(msg.Chat.ID, welcome)
 }
@@ -417,6 +466,16 @@ func (b *Bot) handleMessage(update tgbotapi.Update) error {
 		userMessage = "[Image]"
 	}
 
+	// Replies to an agent task's message continue that task's Claude session
+	if handled, err := b.handleAgentFollowUpReply(msg); handled {
+		return err
+	}
+
+	// Replies to a reminder's reschedule prompt carry the new time for the brain to apply
+	if req := b.rescheduleRequest(msg); req != "" {
+		userMessage = req
+	}
+
 	log.Printf("Message from %d: %s", msg.From.ID, truncate(userMessage, 50))
 
 	// Check approval
@@ -470,11 +529,7 @@ func (b *Bot) handleMessage(update tgbotapi.Update) error {
 	}
 
 	if msg.Document != nil {
-		ext := filepath.Ext(msg.Document.FileName)
-		if ext == "" {
-			ext = ".bin"
-		}
-		filePath, err := b.downloadFileToTemp(msg.Document.FileID, ext)
+		filePath, err := b.downloadDocumentToTemp(msg.Document)
 		if err != nil {
 			log.Printf("Failed to download document: %v", err)
 		} else {
@@ -482,6 +537,9 @@ func (b *Bot) handleMessage(update tgbotapi.Update) error {
 				userMessage = fmt.Sprintf("Analyze this file (%s):", msg.Document.FileName)
 			}
 			userMessage = fmt.Sprintf("%s %s", userMessage, filePath)
+			if b.agentHub != nil {
+				userMessage += fmt.Sprintf("\n(To hand it to an agent: minerva agent run <agent> \"prompt\" --file %s, or minerva agent push <agent> %s)", filePath, filePath)
+			}
 		}
 	}
 
@@ -539,6 +597,30 @@ func (b *Bot) downloadFileToTemp(fileID, ext string) (string, error) {
 	return destPath, nil
 }
 
+// downloadDocumentToTemp saves a Telegram document under its original file name,
+// so it keeps that name when pushed to an agent
+func (b *Bot) downloadDocumentToTemp(doc *tgbotapi.Document) (string, error) {
+	ext := filepath.Ext(doc.FileName)
+	if ext == "" {
+		ext = ".bin"
+	}
+	tmpPath, err := b.downloadFileToTemp(doc.FileID, ext)
+	if err != nil || doc.FileName == "" {
+		return tmpPath, err
+	}
+
+	dir := strings.TrimSuffix(tmpPath, ext)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return tmpPath, nil
+	}
+	destPath := filepath.Join(dir, filepath.Base(doc.FileName))
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		os.Remove(dir)
+		return tmpPath, nil
+	}
+	return destPath, nil
+}
+
 // AIResponse contains the AI response and model used
 type AIResponse struct {
 	Content string
@@ -641,6 +723,18 @@ func (b *Bot) sendDocument(chatID int64, filename string, data []byte) error {
 	return err
 }
 
+// sendDocumentFile uploads a file from disk without loading it into memory
+func (b *Bot) sendDocumentFile(chatID int64, filename, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileReader{Name: filename, Reader: f})
+	_, err = b.api.Send(doc)
+	return err
+}
+
 func (b *Bot) sendTypingAction(chatID int64) {
 	action := tgbotapi.NewChatAction(chatID, tgbotapi.ChatTyping)
 	b.api.Send(action)
@@ -661,15 +755,29 @@ func (b *Bot) sendAgentTaskStartedMessage(taskID, agentName, prompt string) {
 	text := fmt.Sprintf("🚀 *Agent task started*\n\nAgent: `%s`\nTask ID: `%s`\nPrompt: %s",
 		agentName, taskID, displayPrompt)
 
+	// Show how busy the agent is: slots in use and tasks still waiting behind this one
+	running, limit, queued := b.agentHub.QueueStatus(agentName)
+	if limit > 0 {
+		text += fmt.Sprintf("\nSlots: %d/%d running", running, limit)
+		if queued > 0 {
+			text += fmt.Sprintf(" · %d queued", queued)
+		}
+	}
+
+	keyboard := killTaskKeyboard(taskID)
+
+	// A task that waited in the queue already has a message: turn it into the started one
+	if messageID, chatID, err := b.agentHub.GetTaskMessageID(taskID); err == nil {
+		edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text, keyboard)
+		edit.ParseMode = "Markdown"
+		if _, err := b.api.Send(edit); err == nil {
+			return
+		}
+		log.Printf("Failed to edit queued task message, sending a new one")
+	}
+
 	msg := tgbotapi.NewMessage(b.config.AdminID, text)
 	msg.ParseMode = "Markdown"
-
-	// Add Kill button (red/dangerous style via emoji)
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("⛔ Kill", fmt.Sprintf("kill_task:%s", taskID)),
-		),
-	)
 	msg.ReplyMarkup = keyboard
 
 	sentMsg, err := b.api.Send(msg)
@@ -684,6 +792,67 @@ func (b *Bot) sendAgentTaskStartedMessage(taskID, agentName, prompt string) {
 	}
 }
 
+// killTaskKeyboard is the Kill button of a running agent task (red/dangerous style via emoji)
+func killTaskKeyboard(taskID string) tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⛔ Kill", fmt.Sprintf("kill_task:%s", taskID)),
+		),
+	)
+}
+
+// updateAgentTaskProgress edits a task's started message with its latest steps,
+// and with its outcome once it ends (dropping the Kill button)
+func (b *Bot) updateAgentTaskProgress(p TaskProgress) {
+	header := "🔄 Agent task running"
+	switch p.Status {
+	case "":
+	case AgentTaskCompleted:
+		header = "✅ Agent task completed"
+	case AgentTaskKilled:
+		header = "🛑 Agent task killed"
+	case AgentTaskBudgetExceeded:
+		header = "💸 Agent task over budget"
+	default:
+		header = "❌ Agent task " + p.Status
+	}
+
+	// Plain text: steps contain file names and commands that would break Markdown
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s · %v\n\nAgent: %s\nTask ID: %s\nPrompt: %s",
+		header, p.Elapsed.Round(time.Second), p.AgentName, p.TaskID, p.Prompt))
+	if len(p.Steps) > 0 {
+		sb.WriteString(fmt.Sprintf("\n\nProgress (%d steps):", p.StepCount))
+		if p.StepCount > len(p.Steps) {
+			sb.WriteString("\n…")
+		}
+		for _, step := range p.Steps {
+			sb.WriteString("\n" + step)
+		}
+	}
+
+	if p.Status != "" {
+		if p.Output != "" {
+			sb.WriteString("\n\nOutput:\n" + truncate(strings.TrimSpace(p.Output), 600))
+		}
+		if p.Resumable {
+			sb.WriteString("\n\n↩️ Reply to this message to continue this session")
+		}
+		if p.Worktree != "" {
+			// The changes wait in their worktree until reviewed
+			sb.WriteString("\n\n🌿 Changes (not applied yet):\n" + truncate(p.DiffStat, 1500))
+			b.api.Send(tgbotapi.NewEditMessageTextAndMarkup(p.ChatID, p.MessageID, sb.String(), worktreeReviewKeyboard(p.TaskID)))
+			return
+		}
+		b.api.Send(tgbotapi.NewEditMessageText(p.ChatID, p.MessageID, sb.String()))
+		return
+	}
+	edit := tgbotapi.NewEditMessageTextAndMarkup(p.ChatID, p.MessageID, sb.String(), killTaskKeyboard(p.TaskID))
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("[Agent] Failed to update progress of task %s: %v", p.TaskID, err)
+	}
+}
+
 func truncate(s string, n int) string {
 	if len(s) <= n {
 		return s
@@ -704,26 +873,28 @@ func (b *Bot) getAgentProjectsContext() string {
 
 	var sb strings.Builder
 	sb.WriteString("[CONNECTED AGENTS AND PROJECTS]\n")
-	sb.WriteString("You can use the run_claude tool to execute tasks on these agents.\n\n")
+	sb.WriteString("You can use the run_claude tool to execute tasks on these agents, by name or with a selector\n")
+	sb.WriteString("over their labels (e.g. selector \"project:minerva\" or \"os:linux,has-docker\") to let Minerva pick one.\n\n")
 
 	for _, agent := range agents {
 		agentName, _ := agent["name"].(string)
-		workDir, _ := agent["workDir"].(string)
+		workDir, _ := agent["cwd"].(string)
 		if agentName == "" {
 			continue
 		}
 		sb.WriteString(fmt.Sprintf("Agent: %s (working dir: %s)\n", agentName, workDir))
+		if labels, _ := agent["labels"].([]string); len(labels) > 0 {
+			sb.WriteString(fmt.Sprintf("  Labels: %s\n", strings.Join(labels, ", ")))
+		}
 
-		// Get projects with a short timeout
-		projects, err := b.agentHub.GetProjects(agentName, 5*time.Second)
-		if err != nil {
-			sb.WriteString("  Projects: (failed to retrieve)\n")
-		} else if len(projects) == 0 {
+		// Cached details, refreshed in the background: never wait on agents per message
+		projects := b.agentHub.CachedProjects(agentName)
+		if len(projects) == 0 {
 			sb.WriteString("  Projects: (none found)\n")
 		} else {
 			sb.WriteString("  Projects:\n")
 			for _, proj := range projects {
-				sb.WriteString(fmt.Sprintf("    - %s\n", proj))
+				sb.WriteString(fmt.Sprintf("    - %s (%s)\n", projectSummary(proj), proj.Path))
 			}
 		}
 		sb.WriteString("\n")
@@ -10,22 +10,36 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"minerva/policy"
+	"minerva/protocol"
 )
 
 // AgentConfig is loaded from ~/.minerva-agent.json
 type AgentConfig struct {
 	HomeDir string `json:"home_dir"`
+	// MaxConcurrency caps how many tasks run at once; extra tasks wait in Minerva's queue (0 = server default)
+	MaxConcurrency int `json:"max_concurrency"`
+	// Token is this agent's own credential, saved here once the admin approves it
+	Token string `json:"token,omitempty"`
+	// Labels Minerva routes tasks by, on top of os:<goos> and arch:<goarch> (e.g. location:office)
+	Labels []string `json:"labels,omitempty"`
+}
+
+func configPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".minerva-agent.json")
 }
 
 func loadConfig() AgentConfig {
 	home, _ := os.UserHomeDir()
-	configPath := filepath.Join(home, ".minerva-agent.json")
+	configPath := configPath()
 
 	data, err := os.ReadFile(configPath)
 	if err != nil {
@@ -42,61 +56,49 @@ func loadConfig() AgentConfig {
 		cfg.HomeDir = home
 	}
 
-	log.Printf("Loaded config from %s (homeDir=%s)", configPath, cfg.HomeDir)
+	log.Printf("Loaded config from %s (homeDir=%s, maxConcurrency=%d)", configPath, cfg.HomeDir, cfg.MaxConcurrency)
 	return cfg
 }
 
-// Message types
-const (
-	MsgRegister     = "register"
-	MsgTask         = "task"
-	MsgAck          = "ack"
-	MsgResult       = "result"
-	MsgPing         = "ping"
-	MsgPong         = "pong"
-	MsgListProjects = "list_projects"
-	MsgProjects     = "projects"
-	MsgKill         = "kill"
-	MsgKilled       = "killed"
-)
-
-// AgentMessage represents a message to/from Minerva
-type AgentMessage struct {
-	Type string `json:"type"`
+// saveToken stores the agent's token in ~/.minerva-agent.json, keeping the other settings
+func saveToken(token string) error {
+	settings := map[string]any{}
+	if data, err := os.ReadFile(configPath()); err == nil {
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", configPath(), err)
+		}
+	}
+	settings["token"] = token
 
-	// Register
-	Name     string   `json:"name,omitempty"`
-	Cwd      string   `json:"cwd,omitempty"`
-	Password string   `json:"password,omitempty"`
-	Projects []string `json:"projects,omitempty"`
-
-	// Task
-	ID     string `json:"id,omitempty"`
-	Prompt string `json:"prompt,omitempty"`
-	Dir    string `json:"dir,omitempty"`
-
-	// Result
-	Output   string `json:"output,omitempty"`
-	ExitCode int    `json:"exit_code,omitempty"`
-	Error    string `json:"error,omitempty"`
-	Duration int64  `json:"duration_ms,omitempty"`
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(configPath(), append(data, '\n'), 0600)
 }
 
 type Agent struct {
 	name         string
 	relayURL     string
 	password     string
+	token        string // per-agent token, guarded by mu
+	policy       *policy.Policy
 	homeDir      string
+	maxTasks     int
+	labels       []string
 	conn         *websocket.Conn
 	mu           sync.Mutex
 	stopCh       chan struct{}
 	activeTasks  sync.Map // taskID -> *exec.Cmd
+	protocol     int      // protocol version Minerva picked for this connection (read loop only)
 }
 
 func main() {
 	name := flag.String("name", "", "Agent name (required)")
 	relayURL := flag.String("relay", os.Getenv("MINERVA_RELAY_URL"), "Relay WebSocket URL")
 	password := flag.String("password", os.Getenv("MINERVA_PASSWORD"), "Agent password")
+	maxTasks := flag.Int("max-tasks", 0, "Max tasks to run at once (overrides max_concurrency in ~/.minerva-agent.json)")
+	token := flag.String("token", os.Getenv("MINERVA_AGENT_TOKEN"), "Agent token from `minerva agent enroll` (overrides token in ~/.minerva-agent.json)")
 	flag.Parse()
 
 	if *name == "" {
@@ -109,12 +111,29 @@ func main() {
 	}
 
 	cfg := loadConfig()
+	if *maxTasks > 0 {
+		cfg.MaxConcurrency = *maxTasks
+	}
+	if *token != "" {
+		cfg.Token = *token
+	}
+
+	// Allowed roots, forbidden paths and task types, enforced before anything starts
+	taskPolicy, err := policy.Load(configPath())
+	if err != nil {
+		log.Fatalf("Failed to load agent policy: %v", err)
+	}
+	log.Printf("Policy: %s", taskPolicy)
 
 	agent := &Agent{
 		name:     *name,
 		relayURL: *relayURL,
 		password: *password,
+		token:    cfg.Token,
+		policy:   taskPolicy,
 		homeDir:  cfg.HomeDir,
+		maxTasks: cfg.MaxConcurrency,
+		labels:   append([]string{"os:" + runtime.GOOS, "arch:" + runtime.GOARCH}, cfg.Labels...),
 		stopCh:   make(chan struct{}),
 	}
 
@@ -159,18 +178,35 @@ func (a *Agent) connect() error {
 
 	a.mu.Lock()
 	a.conn = conn
+	token := a.token
 	a.mu.Unlock()
 
 	// Get projects (directories in home)
 	projects := a.listProjects()
 
+	// Tasks still running from a previous connection
+	running := []string{}
+	a.activeTasks.Range(func(key, _ any) bool {
+		running = append(running, key.(string))
+		return true
+	})
+
 	// Register
-	reg := AgentMessage{
-		Type:     MsgRegister,
-		Name:     a.name,
-		Cwd:      a.homeDir,
-		Password: a.password,
-		Projects: projects,
+	reg := protocol.Message{
+		Type:           protocol.MsgRegister,
+		Name:           a.name,
+		Cwd:            a.homeDir,
+		Password:       a.password,
+		Token:          token,
+		Projects:       projects,
+		RunningTasks:   &running,
+		MaxConcurrency: a.maxTasks,
+		Labels:         a.labels,
+		Capabilities:   []string{protocol.CapReadFile},
+		// Versions this agent speaks; Minerva answers with the one to use. Results are
+		// sent once, with no outbox to resend them from, so not the versions promising one.
+		ProtocolVersion:    protocol.OutboxVersion - 1,
+		MinProtocolVersion: protocol.MinVersion,
 	}
 
 	if err := conn.WriteJSON(reg); err != nil {
@@ -212,17 +248,20 @@ func isProject(path string) bool {
 }
 
 func (a *Agent) handleMessages() {
+	// Servers that predate versioning never send welcome
+	a.protocol = 1
+
 	// Ping ticker
 	ticker := time.NewTicker(25 * time.Second)
 	defer ticker.Stop()
 
 	// Read messages in goroutine
-	msgCh := make(chan AgentMessage)
+	msgCh := make(chan protocol.Message)
 	errCh := make(chan error)
 
 	go func() {
 		for {
-			var msg AgentMessage
+			var msg protocol.Message
 			if err := a.conn.ReadJSON(&msg); err != nil {
 				errCh <- err
 				return
@@ -238,7 +277,7 @@ func (a *Agent) handleMessages() {
 			return
 
 		case <-ticker.C:
-			a.send(AgentMessage{Type: MsgPing})
+			a.send(protocol.Message{Type: protocol.MsgPing})
 
 		case err := <-errCh:
 			log.Printf("Read error: %v", err)
@@ -250,43 +289,112 @@ func (a *Agent) handleMessages() {
 	}
 }
 
-func (a *Agent) handleMessage(msg AgentMessage) {
+func (a *Agent) handleMessage(msg protocol.Message) {
 	switch msg.Type {
-	case MsgPing:
-		a.send(AgentMessage{Type: MsgPong})
+	case protocol.MsgPing:
+		a.send(protocol.Message{Type: protocol.MsgPong})
 
-	case MsgPong:
+	case protocol.MsgPong:
 		// Keepalive response, ignore
 
-	case MsgListProjects:
+	case protocol.MsgListProjects:
 		projects := a.listProjects()
-		a.send(AgentMessage{
-			Type:     MsgProjects,
+		a.send(protocol.Message{
+			Type:     protocol.MsgProjects,
 			ID:       msg.ID,
 			Projects: projects,
 		})
 
-	case MsgTask:
+	case protocol.MsgTask:
 		go a.executeTask(msg)
 
-	case MsgKill:
+	case protocol.MsgKill:
 		a.killTask(msg.ID)
+
+	case protocol.MsgReadFile:
+		go a.readFile(msg)
+
+	case protocol.MsgEnrolled:
+		a.mu.Lock()
+		a.token = msg.Token
+		a.mu.Unlock()
+		if err := saveToken(msg.Token); err != nil {
+			log.Printf("Enrolled, but failed to save the token: %v (issue a new one with: minerva agent enroll %s)", err, a.name)
+		} else {
+			log.Printf("Enrolled by Minerva, token saved to %s", configPath())
+		}
+
+	case protocol.MsgWelcome:
+		a.protocol = msg.ProtocolVersion
+		log.Printf("Minerva speaks protocol v%d", a.protocol)
+
+	case protocol.MsgError:
+		log.Printf("Minerva error: %s", msg.Error)
+
+	case protocol.MsgUnsupported:
+		log.Printf("Minerva doesn't handle %s: %s", msg.RefType, msg.Error)
+
+	default:
+		// Tell Minerva instead of leaving it waiting (follow-ups, worktrees and file pushes aren't supported here)
+		log.Printf("Unsupported message type %q", msg.Type)
+		if protocol.Supports(a.protocol, protocol.MsgUnsupported) {
+			a.send(protocol.Unsupported(msg, a.protocol))
+		}
 	}
 }
 
-func (a *Agent) executeTask(task AgentMessage) {
+// readFile returns the contents of a small text file (used by server-side watchers)
+func (a *Agent) readFile(msg protocol.Message) {
+	reply := protocol.Message{Type: protocol.MsgFileContent, ID: msg.ID}
+
+	err := a.policy.AllowType(policy.TaskTypeReadFile)
+	path := msg.FileName
+	if err == nil {
+		path, err = a.policy.CheckPath(msg.FileName, a.homeDir)
+	}
+
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(path)
+	}
+	switch {
+	case err != nil:
+		reply.Error = err.Error()
+	case info.IsDir():
+		reply.Error = fmt.Sprintf("%s is a directory", path)
+	case info.Size() > 1024*1024:
+		reply.Error = fmt.Sprintf("%s is too large (%d bytes)", path, info.Size())
+	default:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			reply.Error = err.Error()
+		} else {
+			reply.Output = string(data)
+		}
+	}
+
+	a.send(reply)
+}
+
+func (a *Agent) executeTask(task protocol.Message) {
 	log.Printf("Executing task %s: %s", task.ID, truncate(task.Prompt, 50))
 
 	start := time.Now()
 
-	// Determine working directory
+	// Check the task against our policy before anything runs
+	err := a.policy.AllowType(policy.TaskTypeTask)
 	workDir := a.homeDir
-	if task.Dir != "" {
-		if filepath.IsAbs(task.Dir) {
-			workDir = task.Dir
-		} else {
-			workDir = filepath.Join(a.homeDir, task.Dir)
-		}
+	if err == nil {
+		workDir, err = a.policy.CheckPath(task.Dir, a.homeDir)
+	}
+	if err != nil {
+		log.Printf("Task %s: %v", task.ID, err)
+		a.send(protocol.Message{
+			Type:  protocol.MsgAck,
+			ID:    task.ID,
+			Error: err.Error(),
+		})
+		return
 	}
 
 	// Execute claude CLI
@@ -303,11 +411,11 @@ func (a *Agent) executeTask(task AgentMessage) {
 	cmd.Stderr = &stderr
 
 	// Start the process
-	err := cmd.Start()
+	err = cmd.Start()
 	if err != nil {
 		// Send ACK with error
-		a.send(AgentMessage{
-			Type:  MsgAck,
+		a.send(protocol.Message{
+			Type:  protocol.MsgAck,
 			ID:    task.ID,
 			Error: fmt.Sprintf("Failed to start claude: %v", err),
 		})
@@ -318,8 +426,8 @@ func (a *Agent) executeTask(task AgentMessage) {
 	a.activeTasks.Store(task.ID, cmd)
 
 	// Send ACK - process started successfully
-	a.send(AgentMessage{
-		Type: MsgAck,
+	a.send(protocol.Message{
+		Type: protocol.MsgAck,
 		ID:   task.ID,
 	})
 
@@ -330,8 +438,8 @@ func (a *Agent) executeTask(task AgentMessage) {
 	// Remove from active tasks
 	a.activeTasks.Delete(task.ID)
 
-	result := AgentMessage{
-		Type:     MsgResult,
+	result := protocol.Message{
+		Type:     protocol.MsgResult,
 		ID:       task.ID,
 		Duration: duration,
 	}
@@ -361,8 +469,8 @@ func (a *Agent) killTask(taskID string) {
 	if !ok {
 		log.Printf("Task %s not found (may have already completed)", taskID)
 		// Send killed confirmation anyway
-		a.send(AgentMessage{
-			Type:   MsgKilled,
+		a.send(protocol.Message{
+			Type:   protocol.MsgKilled,
 			ID:     taskID,
 			Output: "Task not found or already completed",
 		})
@@ -373,8 +481,8 @@ func (a *Agent) killTask(taskID string) {
 	if !ok || cmd.Process == nil {
 		log.Printf("Task %s has no running process", taskID)
 		a.activeTasks.Delete(taskID)
-		a.send(AgentMessage{
-			Type:   MsgKilled,
+		a.send(protocol.Message{
+			Type:   protocol.MsgKilled,
 			ID:     taskID,
 			Output: "Task has no running process",
 		})
@@ -386,8 +494,8 @@ func (a *Agent) killTask(taskID string) {
 	// Kill the process group to also kill any child processes
 	if err := cmd.Process.Kill(); err != nil {
 		log.Printf("Failed to kill task %s: %v", taskID, err)
-		a.send(AgentMessage{
-			Type:   MsgKilled,
+		a.send(protocol.Message{
+			Type:   protocol.MsgKilled,
 			ID:     taskID,
 			Error:  fmt.Sprintf("Failed to kill: %v", err),
 			Output: "Kill signal failed",
@@ -399,8 +507,8 @@ func (a *Agent) killTask(taskID string) {
 	a.activeTasks.Delete(taskID)
 
 	// Send confirmation
-	a.send(AgentMessage{
-		Type:   MsgKilled,
+	a.send(protocol.Message{
+		Type:   protocol.MsgKilled,
 		ID:     taskID,
 		Output: "Task killed successfully",
 	})
@@ -408,7 +516,7 @@ func (a *Agent) killTask(taskID string) {
 	log.Printf("Task %s killed successfully", taskID)
 }
 
-func (a *Agent) send(msg AgentMessage) error {
+func (a *Agent) send(msg protocol.Message) error {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
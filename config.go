@@ -6,6 +6,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -20,28 +21,41 @@ func (c *Config) CLIBaseURL() string {
 
 // Config holds all configuration for the Minerva bot
 type Config struct {
-	TelegramBotToken   string
-	DatabasePath       string
-	MaxContextMessages int
-	AdminID            int64  // Telegram user ID of the admin
-	ResendAPIKey         string // Resend API key for email
-	ResendWebhookSecret  string // Resend webhook signing secret
-	WebhookPort          int    // Port for incoming webhooks
-	TelnyxAPIKey         string // Telnyx API key (v2)
-	TelnyxAppID          string // Telnyx Call Control App connection_id
-	TelnyxPhone          string // Telnyx phone number (E.164)
-	TelnyxPublicKey      string // Telnyx webhook public key for Ed25519 verification
-	AgentPassword        string // Password for agent authentication
-	GoogleAPIKey         string // Google API Key for Gemini Live voice
-	BaseURL              string // Public URL for webhooks (e.g., https://example.com)
-	FromEmail            string // Email sender address (e.g., Minerva <minerva@example.com>)
-	OwnerName            string // Name of the assistant owner (used in voice prompts)
-	DefaultCountryCode   string // Default country code for phone numbers (e.g., +34)
-	VerifiedEmailDomains []string // Verified email domains for sending (e.g., example.com)
-	TasksDir             string // Directory for background tasks
-	GeminiModel          string // Gemini model for voice calls
-	GeminiVoice          string // Gemini voice name for voice calls
-	VoiceLanguage        string // Default language for voice calls (e.g., "Spanish", "English")
+	TelegramBotToken       string
+	DatabasePath           string
+	MaxContextMessages     int
+	AdminID                int64         // Telegram user ID of the admin
+	ResendAPIKey           string        // Resend API key for email
+	ResendWebhookSecret    string        // Resend webhook signing secret
+	WebhookPort            int           // Port for incoming webhooks
+	TelnyxAPIKey           string        // Telnyx API key (v2)
+	TelnyxAppID            string        // Telnyx Call Control App connection_id
+	TelnyxPhone            string        // Telnyx phone number (E.164)
+	TelnyxPublicKey        string        // Telnyx webhook public key for Ed25519 verification
+	AgentPassword          string        // Password for agent authentication
+	AgentMaxConcurrency    int           // Default max concurrent tasks per agent (0 = unlimited)
+	AgentReleasesDir       string        // Directory of signed agent binaries offered as self-updates
+	AgentUpdateKey         string        // Public key agent releases must be signed with (minerva agent keygen)
+	AgentTaskMaxDuration   time.Duration // Default wall-time limit of agent tasks (0 = unlimited)
+	AgentTaskMaxTurns      int           // Default max turns of agent tasks (0 = unlimited)
+	AgentTaskMaxCost       float64       // Default max cost in USD of agent tasks (0 = unlimited)
+	GoogleAPIKey           string        // Google API Key for Gemini Live voice
+	BaseURL                string        // Public URL for webhooks (e.g., https://example.com)
+	FromEmail              string        // Email sender address (e.g., Minerva <minerva@example.com>)
+	OwnerName              string        // Name of the assistant owner (used in voice prompts)
+	DefaultCountryCode     string        // Default country code for phone numbers (e.g., +34)
+	VerifiedEmailDomains   []string      // Verified email domains for sending (e.g., example.com)
+	TasksDir               string        // Directory for background tasks
+	GeminiModel            string        // Gemini model for voice calls
+	GeminiVoice            string        // Gemini voice name for voice calls
+	VoiceLanguage          string        // Default language for voice calls (e.g., "Spanish", "English")
+	ScheduleMaxAttempts    int           // Default max attempts for scheduled agent tasks (1 = no retries)
+	ScheduleRetryBackoff   time.Duration // Base backoff between scheduled task retries (doubles each attempt)
+	ReminderRepingInterval time.Duration // How often unacknowledged reminders are sent again
+	QuietHours             string        // Default quiet hours for notifications (e.g., 23:00-08:00)
+	UrgentRenotifyInterval time.Duration // How often unacknowledged urgent notifications are sent again
+	UrgentRenotifyMax      int           // Max re-sends of an unacknowledged urgent notification
+	BriefingTime           string        // Default daily briefing time for the admin (e.g., 07:30)
 }
 
 // LoadConfig loads configuration from environment variables
@@ -64,27 +78,40 @@ func LoadConfigForCLI() (*Config, error) {
 
 func loadConfigCommon() *Config {
 	config := &Config{
-		TelegramBotToken:   os.Getenv("TELEGRAM_BOT_TOKEN"),
-		DatabasePath:       getEnvOrDefault("DATABASE_PATH", "./minerva.db"),
-		MaxContextMessages: getEnvAsIntOrDefault("MAX_CONTEXT_MESSAGES", 20),
-		AdminID:            int64(getEnvAsIntOrDefault("ADMIN_ID", 0)),
-		ResendAPIKey:       os.Getenv("RESEND_API_KEY"),
-		ResendWebhookSecret: os.Getenv("RESEND_WEBHOOK_SECRET"),
-		WebhookPort:        getEnvAsIntOrDefault("WEBHOOK_PORT", 8080),
-		TelnyxAPIKey:       os.Getenv("TELNYX_API_KEY"),
-		TelnyxAppID:        os.Getenv("TELNYX_APP_ID"),
-		TelnyxPhone:        os.Getenv("TELNYX_PHONE_NUMBER"),
-		TelnyxPublicKey:    os.Getenv("TELNYX_PUBLIC_KEY"),
-		AgentPassword:      os.Getenv("AGENT_PASSWORD"),
-		GoogleAPIKey:       os.Getenv("GOOGLE_API_KEY"),
-		BaseURL:            os.Getenv("BASE_URL"),
-		FromEmail:          getEnvOrDefault("FROM_EMAIL", ""),
-		OwnerName:          getEnvOrDefault("OWNER_NAME", "the owner"),
-		DefaultCountryCode: getEnvOrDefault("DEFAULT_COUNTRY_CODE", "+1"),
-		TasksDir:           getEnvOrDefault("TASKS_DIR", "./minerva-tasks"),
-		GeminiModel:        getEnvOrDefault("GEMINI_MODEL", "models/gemini-2.5-flash-native-audio-latest"),
-		GeminiVoice:        getEnvOrDefault("GEMINI_VOICE", "Zephyr"),
-		VoiceLanguage:      getEnvOrDefault("VOICE_LANGUAGE", "Spanish"),
+		TelegramBotToken:       os.Getenv("TELEGRAM_BOT_TOKEN"),
+		DatabasePath:           getEnvOrDefault("DATABASE_PATH", "./minerva.db"),
+		MaxContextMessages:     getEnvAsIntOrDefault("MAX_CONTEXT_MESSAGES", 20),
+		AdminID:                int64(getEnvAsIntOrDefault("ADMIN_ID", 0)),
+		ResendAPIKey:           os.Getenv("RESEND_API_KEY"),
+		ResendWebhookSecret:    os.Getenv("RESEND_WEBHOOK_SECRET"),
+		WebhookPort:            getEnvAsIntOrDefault("WEBHOOK_PORT", 8080),
+		TelnyxAPIKey:           os.Getenv("TELNYX_API_KEY"),
+		TelnyxAppID:            os.Getenv("TELNYX_APP_ID"),
+		TelnyxPhone:            os.Getenv("TELNYX_PHONE_NUMBER"),
+		TelnyxPublicKey:        os.Getenv("TELNYX_PUBLIC_KEY"),
+		AgentPassword:          os.Getenv("AGENT_PASSWORD"),
+		AgentMaxConcurrency:    getEnvAsIntOrDefault("AGENT_MAX_CONCURRENCY", 2),
+		AgentReleasesDir:       getEnvOrDefault("AGENT_RELEASES_DIR", "./minerva-agent-releases"),
+		AgentUpdateKey:         os.Getenv("AGENT_UPDATE_KEY"),
+		AgentTaskMaxDuration:   getEnvAsDurationOrDefault("AGENT_TASK_MAX_DURATION", 55*time.Minute),
+		AgentTaskMaxTurns:      getEnvAsIntOrDefault("AGENT_TASK_MAX_TURNS", 0),
+		AgentTaskMaxCost:       getEnvAsFloatOrDefault("AGENT_TASK_MAX_COST", 0),
+		GoogleAPIKey:           os.Getenv("GOOGLE_API_KEY"),
+		BaseURL:                os.Getenv("BASE_URL"),
+		FromEmail:              getEnvOrDefault("FROM_EMAIL", ""),
+		OwnerName:              getEnvOrDefault("OWNER_NAME", "the owner"),
+		DefaultCountryCode:     getEnvOrDefault("DEFAULT_COUNTRY_CODE", "+1"),
+		TasksDir:               getEnvOrDefault("TASKS_DIR", "./minerva-tasks"),
+		GeminiModel:            getEnvOrDefault("GEMINI_MODEL", "models/gemini-2.5-flash-native-audio-latest"),
+		GeminiVoice:            getEnvOrDefault("GEMINI_VOICE", "Zephyr"),
+		VoiceLanguage:          getEnvOrDefault("VOICE_LANGUAGE", "Spanish"),
+		ScheduleMaxAttempts:    getEnvAsIntOrDefault("SCHEDULE_MAX_ATTEMPTS", 1),
+		ScheduleRetryBackoff:   getEnvAsDurationOrDefault("SCHEDULE_RETRY_BACKOFF", 5*time.Minute),
+		ReminderRepingInterval: getEnvAsDurationOrDefault("REMINDER_REPING_INTERVAL", 15*time.Minute),
+		QuietHours:             getEnvOrDefault("QUIET_HOURS", ""),
+		UrgentRenotifyInterval: getEnvAsDurationOrDefault("URGENT_RENOTIFY_INTERVAL", 5*time.Minute),
+		UrgentRenotifyMax:      getEnvAsIntOrDefault("URGENT_RENOTIFY_MAX", 6),
+		BriefingTime:           getEnvOrDefault("BRIEFING_TIME", ""),
 	}
 
 	// Parse verified email domains
@@ -160,3 +187,31 @@ func getEnvAsIntOrDefault(key string, defaultValue int) int {
 
 	return value
 }
+
+func getEnvAsFloatOrDefault(key string, defaultValue float64) float64 {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil {
+		return defaultValue
+	}
+
+	return value
+}
+
+func getEnvAsDurationOrDefault(key string, defaultValue time.Duration) time.Duration {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+
+	value, err := time.ParseDuration(valueStr)
+	if err != nil {
+		return defaultValue
+	}
+
+	return value
+}
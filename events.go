@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Event types published on the event bus
+const (
+	EventAgentConnected    = "agent.connected"    // subject: agent name
+	EventAgentDisconnected = "agent.disconnected" // subject: agent name; data: orphaned_tasks
+	EventAgentTaskDone     = "agent.task_done"    // subject: agent name; data: task_id, status
+	EventEmailReceived     = "email.received"     // subject: sender; data: from, to, subject
+	EventCallEnded         = "call.ended"         // subject: caller; data: from, duration, via, missed
+	EventScheduleDone      = "schedule.done"      // subject: task ID; data: description, status, agent
+	EventWatcherFired      = "watcher.fired"      // subject: watcher name; data: watcher_id
+	EventTaskDone          = "task.done"          // subject: background task ID; data: status
+)
+
+// EventTypes lists the known event types and the data keys they carry, for help output
+var EventTypes = map[string][]string{
+	EventAgentConnected:    {"agent"},
+	EventAgentDisconnected: {"agent", "orphaned_tasks"},
+	EventAgentTaskDone:     {"agent", "task_id", "status"},
+	EventEmailReceived:     {"from", "to", "subject"},
+	EventCallEnded:         {"from", "duration", "via", "missed"},
+	EventScheduleDone:      {"id", "description", "status", "agent"},
+	EventWatcherFired:      {"watcher", "watcher_id"},
+	EventTaskDone:          {"task_id", "status"},
+}
+
+// Event is something that happened inside Minerva
+type Event struct {
+	Type    string
+	Subject string            // what the event is about (agent name, task ID...), used to pair events
+	Data    map[string]string // filterable fields
+	Text    string            // human-readable details (output, summary...)
+	Time    time.Time
+}
+
+// EventHandler receives published events
+type EventHandler func(evt Event)
+
+// EventBus fans internal events out to subscribers
+type EventBus struct {
+	mu       sync.RWMutex
+	handlers []EventHandler
+}
+
+// NewEventBus creates a new event bus
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe registers a handler for all events
+func (b *EventBus) Subscribe(fn EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, fn)
+}
+
+// Publish delivers an event to every subscriber asynchronously.
+// Safe to call on a nil bus, so publishers don't need to check.
+func (b *EventBus) Publish(evt Event) {
+	if b == nil {
+		return
+	}
+	if evt.Time.IsZero() {
+		evt.Time = time.Now()
+	}
+	if evt.Data == nil {
+		evt.Data = map[string]string{}
+	}
+
+	log.Printf("[Events] %s (%s)", evt.Type, evt.Subject)
+
+	b.mu.RLock()
+	handlers := append([]EventHandler(nil), b.handlers...)
+	b.mu.RUnlock()
+
+	for _, fn := range handlers {
+		go fn(evt)
+	}
+}
+
+// eventLogRetention is how long published events are kept in the event log
+const eventLogRetention = 7 * 24 * time.Hour
+
+// InitEventLogTable creates the event_log table
+func (db *DB) InitEventLogTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS event_log (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL,
+			subject TEXT NOT NULL DEFAULT '',
+			data TEXT NOT NULL DEFAULT '{}',
+			text TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_event_log_type_time ON event_log(type, created_at);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create event_log table: %w", err)
+	}
+	return nil
+}
+
+// RecordEvent stores an event in the event log and drops entries past the retention period
+func (db *DB) RecordEvent(evt Event) error {
+	data, _ := json.Marshal(evt.Data)
+	if _, err := db.Exec(`
+		INSERT INTO event_log (type, subject, data, text, created_at) VALUES (?, ?, ?, ?, ?)
+	`, evt.Type, evt.Subject, string(data), truncate(evt.Text, 4000), evt.Time.UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM event_log WHERE created_at < ?`, time.Now().Add(-eventLogRetention).UTC().Format(time.RFC3339))
+	return err
+}
+
+// GetLoggedEvents returns the logged events of a type since the given time, oldest first
+func (db *DB) GetLoggedEvents(eventType string, since time.Time) ([]Event, error) {
+	rows, err := db.Query(`
+		SELECT type, subject, data, text, created_at FROM event_log
+		WHERE type = ? AND created_at >= ?
+		ORDER BY id
+	`, eventType, since.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var evt Event
+		var data, createdAt string
+		if err := rows.Scan(&evt.Type, &evt.Subject, &data, &evt.Text, &createdAt); err != nil {
+			return nil, err
+		}
+		json.Unmarshal([]byte(data), &evt.Data)
+		evt.Time, _ = time.Parse(time.RFC3339, createdAt)
+		events = append(events, evt)
+	}
+	return events, rows.Err()
+}
+
+// logEvent is an event bus subscriber that persists every event
+func (db *DB) logEvent(evt Event) {
+	if err := db.RecordEvent(evt); err != nil {
+		log.Printf("[Events] Failed to log %s: %v", evt.Type, err)
+	}
+}
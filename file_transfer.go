@@ -0,0 +1,390 @@
+package main
+
+// Chunked file transfers between the server and agents, in both directions.
+//
+// The sender announces a file with file_begin (name, size, SHA-256) and then sends
+// file_chunk messages. The receiver answers every message with one file_ack holding
+// how many bytes it has, so a sender that reconnects and announces the same transfer
+// ID resumes where it left off. The last ack is marked Done once the checksum matched.
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"minerva/protocol"
+)
+
+const (
+	// FileChunkSize is how much file data goes in one file_chunk message
+	FileChunkSize = 256 * 1024
+	// FileAckTimeout is how long a sender waits for each file_ack
+	FileAckTimeout = 30 * time.Second
+	// StaleTransferAge is how long an interrupted transfer's partial data is kept for resuming
+	StaleTransferAge = 24 * time.Hour
+)
+
+var transferIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// incomingTransfer is a file being received from an agent
+type incomingTransfer struct {
+	agentName string
+	taskID    string
+	fileName  string
+	checksum  string
+	size      int64
+	received  int64
+	path      string // partial data, renamed once complete
+	file      *os.File
+}
+
+// transferDir holds partial and completed files received from agents
+func transferDir() string {
+	return filepath.Join(os.TempDir(), "minerva-transfers")
+}
+
+// fileChecksum returns the hex SHA-256 of a file
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// handleFileBegin opens (or resumes) a transfer announced by an agent
+func (h *AgentHub) handleFileBegin(agent *Agent, msg protocol.Message) {
+	// Resent after its final ack was lost: the file was already delivered
+	h.mu.RLock()
+	_, done := h.transfersDone[agent.Name+"/"+msg.ID]
+	h.mu.RUnlock()
+	if done {
+		log.Printf("[Transfer] %s from '%s' was already delivered", msg.FileName, agent.Name)
+		safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgFileAck, ID: msg.ID, Offset: msg.FileSize, Done: true})
+		return
+	}
+
+	t, err := h.openTransfer(agent.Name, msg)
+	if err != nil {
+		log.Printf("[Transfer] Rejected %s from '%s': %v", msg.FileName, agent.Name, err)
+		safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgFileAck, ID: msg.ID, Error: err.Error()})
+		return
+	}
+	if t.received > 0 {
+		log.Printf("[Transfer] Resuming %s from '%s' at %d/%d bytes", t.fileName, agent.Name, t.received, t.size)
+	} else {
+		log.Printf("[Transfer] Receiving %s from '%s' (%d bytes)", t.fileName, agent.Name, t.size)
+	}
+	h.ackTransfer(agent, msg.ID, t)
+}
+
+func (h *AgentHub) openTransfer(agentName string, msg protocol.Message) (*incomingTransfer, error) {
+	if !transferIDPattern.MatchString(msg.ID) {
+		return nil, fmt.Errorf("invalid transfer id")
+	}
+	if msg.FileSize < 0 || len(msg.Checksum) != sha256.Size*2 {
+		return nil, fmt.Errorf("file size and SHA-256 checksum are required")
+	}
+	name := filepath.Base(msg.FileName)
+	if name == "." || name == string(filepath.Separator) {
+		return nil, fmt.Errorf("invalid file name")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Same transfer announced again on this connection (e.g. the sender timed out)
+	if t, ok := h.transfers[msg.ID]; ok {
+		if t.agentName != agentName || t.checksum != msg.Checksum {
+			return nil, fmt.Errorf("transfer id already in use")
+		}
+		return t, nil
+	}
+
+	if err := os.MkdirAll(transferDir(), 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(transferDir(), msg.ID+".part")
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	received := info.Size()
+	if received > msg.FileSize {
+		// Leftover from a different file: start over
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return nil, err
+		}
+		received = 0
+	}
+
+	t := &incomingTransfer{
+		agentName: agentName,
+		taskID:    msg.TaskID,
+		fileName:  name,
+		checksum:  msg.Checksum,
+		size:      msg.FileSize,
+		received:  received,
+		path:      path,
+		file:      f,
+	}
+	h.transfers[msg.ID] = t
+	return t, nil
+}
+
+// handleFileChunk stores a chunk of an incoming transfer
+func (h *AgentHub) handleFileChunk(agent *Agent, msg protocol.Message) {
+	h.mu.RLock()
+	t, ok := h.transfers[msg.ID]
+	h.mu.RUnlock()
+
+	if !ok || t.agentName != agent.Name {
+		safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgFileAck, ID: msg.ID, Error: "unknown transfer, send file_begin again"})
+		return
+	}
+
+	// Out of order (e.g. a retried chunk): tell the sender where we are
+	if msg.Offset == t.received {
+		data, err := base64.StdEncoding.DecodeString(msg.FileData)
+		if err == nil && t.received+int64(len(data)) > t.size {
+			err = fmt.Errorf("chunk exceeds announced size")
+		}
+		if err == nil {
+			_, err = t.file.WriteAt(data, t.received)
+		}
+		if err != nil {
+			h.abortTransfer(msg.ID, t)
+			safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgFileAck, ID: msg.ID, Error: err.Error()})
+			return
+		}
+		t.received += int64(len(data))
+	}
+	h.ackTransfer(agent, msg.ID, t)
+}
+
+// ackTransfer reports progress, or verifies and delivers the file once all of it arrived
+func (h *AgentHub) ackTransfer(agent *Agent, id string, t *incomingTransfer) {
+	if t.received < t.size {
+		safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgFileAck, ID: id, Offset: t.received})
+		return
+	}
+
+	t.file.Close()
+	h.mu.Lock()
+	delete(h.transfers, id)
+	onFileUpload := h.onFileUpload
+	h.mu.Unlock()
+
+	sum, err := fileChecksum(t.path)
+	if err == nil && sum != t.checksum {
+		err = fmt.Errorf("checksum mismatch")
+	}
+	if err != nil {
+		log.Printf("[Transfer] %s from '%s' failed verification: %v", t.fileName, agent.Name, err)
+		os.Remove(t.path)
+		safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgFileAck, ID: id, Error: err.Error()})
+		return
+	}
+
+	final := filepath.Join(transferDir(), id+"-"+t.fileName)
+	if err := os.Rename(t.path, final); err != nil {
+		os.Remove(t.path)
+		safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgFileAck, ID: id, Error: err.Error()})
+		return
+	}
+	h.mu.Lock()
+	h.transfersDone[agent.Name+"/"+id] = time.Now()
+	h.mu.Unlock()
+	safeSendAgent(agent.send, protocol.Message{Type: protocol.MsgFileAck, ID: id, Offset: t.received, Done: true})
+	log.Printf("[Transfer] Received %s from '%s' (%d bytes, task %s)", t.fileName, agent.Name, t.size, t.taskID)
+
+	if onFileUpload == nil {
+		log.Printf("[AgentHub] WARNING: onFileUpload callback is nil, dropping file %s", t.fileName)
+		os.Remove(final)
+		return
+	}
+	// Deliver outside the read loop: uploading to Telegram can take a while
+	go onFileUpload(agent.Name, t.fileName, final)
+}
+
+// abortTransfer drops an incoming transfer and its partial data
+func (h *AgentHub) abortTransfer(id string, t *incomingTransfer) {
+	t.file.Close()
+	os.Remove(t.path)
+	h.mu.Lock()
+	delete(h.transfers, id)
+	h.mu.Unlock()
+}
+
+// closeTransfers releases the open files of an agent's transfers, keeping their data for a resume
+func (h *AgentHub) closeTransfers(agentName string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, t := range h.transfers {
+		if t.agentName == agentName {
+			t.file.Close()
+			delete(h.transfers, id)
+		}
+	}
+}
+
+// cleanupTransfers removes partial data of transfers nobody resumed
+func cleanupTransfers() {
+	entries, err := os.ReadDir(transferDir())
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < StaleTransferAge {
+			continue
+		}
+		os.Remove(filepath.Join(transferDir(), e.Name()))
+	}
+}
+
+// handleFileAck routes an agent's ack to the PushFile waiting for it
+func (h *AgentHub) handleFileAck(agentName string, msg protocol.Message) {
+	h.mu.RLock()
+	req, ok := h.transferAcks[msg.ID]
+	h.mu.RUnlock()
+
+	if ok {
+		deliverReply(agentName, msg, req.Agent, req.Result)
+	}
+}
+
+// PushFile sends a local file to an agent, into dir (relative to the agent's working directory
+// unless absolute). Pushing the same file to the same place again resumes an interrupted transfer.
+// Returns the file's path on the agent.
+func (h *AgentHub) PushFile(agentName, localPath, dir string) (string, error) {
+	h.mu.RLock()
+	agent, ok := h.agents[agentName]
+	h.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("agent '%s' not found", agentName)
+	}
+	return h.pushFile(agent, localPath, dir, "")
+}
+
+// pushFile pushes a file to a connected agent. A non-empty action tells the agent what the
+// file is for ("update": a release to stage next to its binary, ignoring dir).
+func (h *AgentHub) pushFile(agent *Agent, localPath, dir, action string) (string, error) {
+	agentName := agent.Name
+	if err := agent.accepts(protocol.MsgFileBegin); err != nil {
+		return "", err
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", localPath)
+	}
+	checksum, err := fileChecksum(localPath)
+	if err != nil {
+		return "", err
+	}
+
+	name := filepath.Base(localPath)
+	idKey := agentName + "\x00" + dir + "\x00" + name + "\x00" + checksum
+	if action != "" {
+		idKey += "\x00" + action
+	}
+	idSum := sha256.Sum256([]byte(idKey))
+	id := "push-" + hex.EncodeToString(idSum[:12])
+
+	acks := make(chan protocol.Message, 1)
+	h.mu.Lock()
+	if _, busy := h.transferAcks[id]; busy {
+		h.mu.Unlock()
+		return "", fmt.Errorf("%s is already being pushed to '%s'", name, agentName)
+	}
+	h.transferAcks[id] = &PendingProjectReq{ID: id, Agent: agentName, Result: acks}
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		delete(h.transferAcks, id)
+		h.mu.Unlock()
+	}()
+
+	send := func(msg protocol.Message) (protocol.Message, error) {
+		if !safeSendAgent(agent.send, msg) {
+			return protocol.Message{}, fmt.Errorf("agent '%s' send channel full or closed", agentName)
+		}
+		select {
+		case ack := <-acks:
+			if ack.Error != "" {
+				return ack, fmt.Errorf("agent '%s' rejected %s: %s", agentName, name, ack.Error)
+			}
+			return ack, nil
+		case <-time.After(FileAckTimeout):
+			return protocol.Message{}, fmt.Errorf("timeout waiting for agent '%s' to confirm %s", agentName, name)
+		}
+	}
+
+	log.Printf("[Transfer] Pushing %s to '%s' (%d bytes, dir %q)", name, agentName, info.Size(), dir)
+	ack, err := send(protocol.Message{
+		Type:     protocol.MsgFileBegin,
+		ID:       id,
+		FileName: name,
+		FileSize: info.Size(),
+		Checksum: checksum,
+		Dir:      dir,
+		Action:   action,
+	})
+	if err != nil {
+		return "", err
+	}
+	if ack.Offset > 0 && !ack.Done {
+		log.Printf("[Transfer] Resuming push of %s at %d/%d bytes", name, ack.Offset, info.Size())
+	}
+
+	buf := make([]byte, FileChunkSize)
+	for !ack.Done {
+		if ack.Offset < 0 || ack.Offset >= info.Size() {
+			return "", fmt.Errorf("agent '%s' reported an invalid offset for %s", agentName, name)
+		}
+		n, err := f.ReadAt(buf, ack.Offset)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		ack, err = send(protocol.Message{
+			Type:     protocol.MsgFileChunk,
+			ID:       id,
+			Offset:   ack.Offset,
+			FileData: base64.StdEncoding.EncodeToString(buf[:n]),
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	log.Printf("[Transfer] Pushed %s to '%s': %s", name, agentName, ack.Output)
+	return ack.Output, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"minerva/protocol"
+)
+
+// transferStep is one message an agent sends during a transfer, and the ack it should get
+type transferStep struct {
+	msgType    string
+	agent      string // sender, "laptop" if empty
+	offset     int64  // file_chunk offset
+	data       string // file_chunk data
+	disconnect bool   // the sender disconnects before this step
+	wantOffset int64
+	wantDone   bool
+	wantErr    bool
+}
+
+func TestIncomingTransfer(t *testing.T) {
+	const content = "hello, chunked world"
+	sum := sha256.Sum256([]byte(content))
+	checksum := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name     string
+		id       string
+		checksum string
+		steps    []transferStep
+	}{
+		{"in chunks", "t1", checksum, []transferStep{
+			{msgType: protocol.MsgFileBegin, wantOffset: 0},
+			{msgType: protocol.MsgFileChunk, offset: 0, data: content[:5], wantOffset: 5},
+			{msgType: protocol.MsgFileChunk, offset: 5, data: content[5:], wantOffset: int64(len(content)), wantDone: true},
+		}},
+		{"retried chunk", "t2", checksum, []transferStep{
+			{msgType: protocol.MsgFileBegin, wantOffset: 0},
+			{msgType: protocol.MsgFileChunk, offset: 0, data: content[:5], wantOffset: 5},
+			{msgType: protocol.MsgFileChunk, offset: 0, data: content[:5], wantOffset: 5},
+			{msgType: protocol.MsgFileChunk, offset: 5, data: content[5:], wantOffset: int64(len(content)), wantDone: true},
+		}},
+		{"resumed after reconnecting", "t3", checksum, []transferStep{
+			{msgType: protocol.MsgFileBegin, wantOffset: 0},
+			{msgType: protocol.MsgFileChunk, offset: 0, data: content[:8], wantOffset: 8},
+			{msgType: protocol.MsgFileBegin, disconnect: true, wantOffset: 8},
+			{msgType: protocol.MsgFileChunk, offset: 8, data: content[8:], wantOffset: int64(len(content)), wantDone: true},
+		}},
+		{"checksum mismatch", "t4", checksum, []transferStep{
+			{msgType: protocol.MsgFileBegin, wantOffset: 0},
+			{msgType: protocol.MsgFileChunk, offset: 0, data: "hello, chunked WORLD", wantErr: true},
+		}},
+		{"chunk past the announced size", "t5", checksum, []transferStep{
+			{msgType: protocol.MsgFileBegin, wantOffset: 0},
+			{msgType: protocol.MsgFileChunk, offset: 0, data: content + "!", wantErr: true},
+		}},
+		{"chunk from another agent", "t6", checksum, []transferStep{
+			{msgType: protocol.MsgFileBegin, wantOffset: 0},
+			{msgType: protocol.MsgFileChunk, agent: "desktop", offset: 0, data: content, wantErr: true},
+		}},
+		{"chunk before begin", "t7", checksum, []transferStep{
+			{msgType: protocol.MsgFileChunk, offset: 0, data: content, wantErr: true},
+		}},
+		{"invalid id", "../t8", checksum, []transferStep{
+			{msgType: protocol.MsgFileBegin, wantErr: true},
+		}},
+		{"no checksum", "t9", "", []transferStep{
+			{msgType: protocol.MsgFileBegin, wantErr: true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TMPDIR", t.TempDir())
+			h, _ := newAgentTestHub(t)
+			delivered := make(chan string, 1)
+			h.SetFileUploadCallback(func(agentName, fileName, path string) {
+				data, _ := os.ReadFile(path)
+				delivered <- agentName + ":" + fileName + ":" + string(data)
+			})
+			agents := map[string]*Agent{
+				"laptop":  {Name: "laptop", hub: h, send: make(chan protocol.Message, 1)},
+				"desktop": {Name: "desktop", hub: h, send: make(chan protocol.Message, 1)},
+			}
+
+			done := false
+			for i, step := range tt.steps {
+				if step.agent == "" {
+					step.agent = "laptop"
+				}
+				agent := agents[step.agent]
+				if step.disconnect {
+					h.closeTransfers(agent.Name)
+				}
+				msg := protocol.Message{Type: step.msgType, ID: tt.id, TaskID: "task1"}
+				if step.msgType == protocol.MsgFileBegin {
+					msg.FileName = "notes.txt"
+					msg.FileSize = int64(len(content))
+					msg.Checksum = tt.checksum
+					h.handleFileBegin(agent, msg)
+				} else {
+					msg.Offset = step.offset
+					msg.FileData = base64.StdEncoding.EncodeToString([]byte(step.data))
+					h.handleFileChunk(agent, msg)
+				}
+
+				ack := <-agent.send
+				if ack.Type != protocol.MsgFileAck || ack.ID != tt.id {
+					t.Fatalf("step %d: got %s for %s, want file_ack for %s", i, ack.Type, ack.ID, tt.id)
+				}
+				if (ack.Error != "") != step.wantErr {
+					t.Fatalf("step %d: ack error %q, want error %v", i, ack.Error, step.wantErr)
+				}
+				if !step.wantErr && (ack.Offset != step.wantOffset || ack.Done != step.wantDone) {
+					t.Errorf("step %d: ack at %d (done %v), want %d (done %v)", i, ack.Offset, ack.Done, step.wantOffset, step.wantDone)
+				}
+				done = ack.Done
+			}
+
+			if done {
+				if got, want := <-delivered, "laptop:notes.txt:"+content; got != want {
+					t.Errorf("delivered %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
@@ -7,7 +7,10 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -97,6 +100,12 @@ func runCLI() {
 		return
 	}
 
+	// Release signing runs where the key is kept, without the server's config or database
+	if cmd == "agent" && len(args) > 0 && (args[0] == "keygen" || args[0] == "sign") {
+		handleAgentSigningCLI(args[0], args[1:])
+		return
+	}
+
 	// Load config for database path and admin ID (without requiring bot tokens)
 	config, err := LoadConfigForCLI()
 	if err != nil {
@@ -126,7 +135,7 @@ func runCLI() {
 	case "context":
 		handleContextCLI(db, userID, config.MaxContextMessages)
 	case "agent":
-		handleAgentCLI(config, args)
+		handleAgentCLI(config, db, args)
 	case "email":
 		handleEmailCLI(config, args)
 	case "call":
@@ -136,7 +145,15 @@ func runCLI() {
 	case "file":
 		handleFileCLI(config, args)
 	case "schedule":
-		handleScheduleCLI(db, args)
+		handleScheduleCLI(config, db, args)
+	case "watch":
+		handleWatchCLI(db, args)
+	case "rule":
+		handleRuleCLI(db, args)
+	case "notify":
+		handleNotifyCLI(config, db, userID, args)
+	case "briefing":
+		handleBriefingCLI(config, db, userID, args)
 	default:
 		fmt.Fprintf(os.Stderr, "error: unknown command: %s\n", cmd)
 		printUsage()
@@ -154,16 +171,59 @@ Usage:
   minerva send "message"               Send a message to admin via Telegram
   minerva context                      Get recent conversation context
   minerva agent list                   List connected agents and their projects
-  minerva agent run <name> "prompt" [--dir /path]  Run a task on an agent
+  minerva agent run <name> "prompt" [--dir /path] [--priority N] [--wait 12h] [--file path]... [--worktree] [--executor shell]  Run a task on an agent (queued if busy; --wait queues it while offline; --file pushes files into its directory first; --worktree isolates its changes for review; --executor picks claude, shell or a configured CLI tool)
+  minerva agent run <name> "prompt" --after <id> [--when on_success|on_failure|always]  Run after a scheduled step
+  minerva agent run <name> "prompt" [--max-time 30m] [--max-turns N] [--max-cost USD]  Override the agent's task budget (the agent stops the task and returns its partial output when it runs over)
+  minerva agent run @<selector> "prompt" [...]  Let Minerva pick the agent by label or project (e.g. @project:minerva, @os:linux,has-docker)
+  minerva agent run --all "prompt" [--dir /path] [--timeout 30m] [--executor shell] [--worktree]  Run on every connected agent, reported together once all finish
+  minerva agent run --label <selector> "prompt" [...]  Run on every agent matching the selector (e.g. --label repo:minerva)
+  minerva agent tasks [--agent name] [--status running] [--limit 20]  Agent task history
+  minerva agent followup <task_id> "message"  Continue a finished task's Claude session (e.g. answer its questions)
+  minerva agent review <task_id> apply|branch|discard  Apply a worktree task's changes to the working copy, push them as a PR branch, or drop them
+  minerva agent push <name> <file> [--dir /path]  Send a file to an agent's working directory (resumes if interrupted)
+  minerva agent update <name>|--all [--force]  Install the latest published release on agents and wait for them to restart on it
+  minerva agent keygen <key-file>      Create a release signing key and print the update key for AGENT_UPDATE_KEY (keep the file off the server)
+  minerva agent sign --key <key-file> <binary>  Sign an agent build for publishing (run where the key is kept)
+  minerva agent publish <binary>       Offer a signed agent build to agents of its OS/arch (outdated ones update right away)
+  minerva agent releases               List published agent releases and the update key agents must be built with
+  minerva agent enroll <name>          Issue a token for an agent (shown once; replaces its previous token)
+  minerva agent revoke <name>          Revoke an agent's token and disconnect it
+  minerva agent credentials            List enrolled agents
+  minerva agent budget                 Show the default task budget and the agents with their own
+  minerva agent budget <name> [--max-time 2h] [--max-turns N] [--max-cost USD] | --reset  Set an agent's default task budget (unset limits use the server's)
+  minerva agent queue [name]           List tasks waiting for a free agent slot
+  minerva agent queue move <task_id> <position>  Reorder a queued task
+  minerva agent queue cancel <task_id>  Cancel a queued task before it starts
   minerva email send <to> --subject "subject" --body "body" [--from "sender"]  Send email via Resend
   minerva call <number> "purpose"      Make a phone call (via Telnyx)
   minerva phone list                   List connected Android phones
   minerva phone call <number> "purpose"  Make a call via Android phone
   minerva file send <path> ["caption"]  Send a file to admin via Telegram
   minerva schedule create "task" --at "time" [--agent name] [--dir /path] [--recurring daily|weekly|monthly]
-  minerva schedule list                List active scheduled tasks
+                          [--max-attempts N] [--backoff 5m] [--wait-for-agent] [--worktree] [--executor shell] [--require-ack]
+                          [--after <id> [--when on_success|on_failure|always]]
+  minerva schedule list                List active scheduled tasks and workflows
+  minerva schedule reschedule <id> --at "time"  Move a pending or fired reminder to a new time
   minerva schedule delete <id>         Delete a scheduled task
+  minerva schedule cancel <id>         Cancel a task and the rest of its workflow
   minerva schedule run <id>            Manually trigger a scheduled task
+  minerva watch create "name" (--url URL | --file PATH --from agent | --sql "SELECT ...") --if "predicate"
+                          [--every 5m] [--then "action"] [--agent name] [--dir /path] [--once]
+  minerva watch list                   List watchers and their last check
+  minerva watch pause|resume <id>      Pause or resume a watcher
+  minerva watch delete <id>            Delete a watcher
+  minerva rule create "name" --on <event> [--where key=pattern ...] [--for 10m [--unless <event>]] [--cooldown 1h]
+                          [--notify "msg"] [--brain "instruction"] [--run <agent> --prompt "p" [--dir /path]]
+                          [--email <to> [--subject s] [--body b]] [--remind "text" [--in 30m]] [--call <number> --purpose "p"]
+  minerva rule list                    List automation rules
+  minerva rule enable|disable <id>     Enable or disable a rule
+  minerva rule delete <id>             Delete a rule
+  minerva rule events                  List event types and their fields
+  minerva notify status                Show quiet hours, notification routes and queued digest items
+  minerva notify quiet <23:00-08:00|off|default>  Set quiet hours for notifications
+  minerva notify route <source> <low|normal|urgent|default>  Override the severity of a notification source
+  minerva briefing show                Show the data behind today's briefing
+  minerva briefing set <07:30|off|default>  Set the daily briefing time
   minerva help                         Show this help message`)
 }
 
@@ -319,9 +379,55 @@ func handleContextCLI(db *DB, userID int64, maxMessages int) {
 	fmt.Println(string(result))
 }
 
-func handleAgentCLI(config *Config, args []string) {
+// handleAgentSigningCLI creates release signing keys and signs agent builds. It runs where
+// the key is kept, never on the server.
+func handleAgentSigningCLI(subcmd string, subargs []string) {
+	switch subcmd {
+	case "keygen":
+		if len(subargs) < 1 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva agent keygen <key-file>\n")
+			os.Exit(1)
+		}
+
+		publicKey, err := GenerateUpdateKey(subargs[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Created release signing key %s. Keep it off the server.\n", subargs[0])
+		fmt.Printf("Set on the server: AGENT_UPDATE_KEY=%s\n", publicKey)
+		fmt.Printf("Build agents with: %s\n", agentBuildCommand(publicKey))
+
+	case "sign":
+		var keyPath, binary string
+		for i := 0; i < len(subargs); i++ {
+			switch subargs[i] {
+			case "--key":
+				if i+1 < len(subargs) {
+					keyPath = subargs[i+1]
+					i++
+				}
+			default:
+				binary = subargs[i]
+			}
+		}
+		if keyPath == "" || binary == "" {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva agent sign --key <key-file> <binary>\n")
+			os.Exit(1)
+		}
+
+		rel, err := SignAgentRelease(binary, keyPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Signed %s %s for %s/%s: %s\n", binary, rel.Version, rel.OS, rel.Arch, signaturePath(binary))
+	}
+}
+
+func handleAgentCLI(config *Config, db *DB, args []string) {
 	if len(args) < 1 {
-		fmt.Fprintf(os.Stderr, "error: agent subcommand required (list, run)\n")
+		fmt.Fprintf(os.Stderr, "error: agent subcommand required (list, run, followup, push, update, keygen, sign, publish, releases, enroll, revoke, credentials, budget, tasks, queue)\n")
 		os.Exit(1)
 	}
 
@@ -343,27 +449,129 @@ func handleAgentCLI(config *Config, args []string) {
 		fmt.Println(string(body))
 
 	case "run":
+		// --all / --label fan the task out to several agents
+		if len(subargs) > 0 && (subargs[0] == "--all" || subargs[0] == "--label") {
+			handleAgentBroadcastCLI(baseURL, subargs)
+			return
+		}
 		if len(subargs) < 2 {
-			fmt.Fprintf(os.Stderr, "error: usage: minerva agent run <agent-name> \"prompt\" [--dir /path]\n")
+			fmt.Fprintf(os.Stderr, "error: usage: minerva agent run <agent-name|@selector> \"prompt\" [--dir /path] [--priority N] [--wait 12h] [--worktree] [--executor name] [--max-time 30m] [--max-turns N] [--max-cost USD]\n")
 			os.Exit(1)
 		}
 
 		agentName := subargs[0]
 		prompt := subargs[1]
-		dir := ""
-
-		// Parse optional --dir flag
+		// @<selector> lets the server pick the agent by label or project
+		var selector string
+		if strings.HasPrefix(agentName, "@") {
+			selector, agentName = agentName[1:], ""
+		}
+		routed := selector != ""
+		var dir, after, when, wait, executor, maxTime string
+		var priority, maxTurns int
+		var maxCost float64
+		var files []string
+		var worktree bool
+
+		// Parse optional flags
 		for i, arg := range subargs {
-			if arg == "--dir" && i+1 < len(subargs) {
-				dir = subargs[i+1]
+			if arg == "--worktree" {
+				worktree = true
+				continue
+			}
+			if i+1 >= len(subargs) {
 				break
 			}
+			switch arg {
+			case "--dir":
+				dir = subargs[i+1]
+			case "--after":
+				after = subargs[i+1]
+			case "--when":
+				when = subargs[i+1]
+			case "--executor":
+				executor = subargs[i+1]
+			case "--priority":
+				n, err := strconv.Atoi(subargs[i+1])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error: invalid --priority: %s\n", subargs[i+1])
+					os.Exit(1)
+				}
+				priority = n
+			case "--wait":
+				if _, err := parseOfflineWait(subargs[i+1]); err != nil {
+					fmt.Fprintf(os.Stderr, "error: %v\n", err)
+					os.Exit(1)
+				}
+				wait = subargs[i+1]
+			case "--max-time", "--max-turns", "--max-cost":
+				parseBudgetFlag(arg, subargs[i+1], &maxTime, &maxTurns, &maxCost)
+			case "--file":
+				path, err := filepath.Abs(subargs[i+1])
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error: %v\n", err)
+					os.Exit(1)
+				}
+				files = append(files, path)
+			}
 		}
 
-		reqBody, _ := json.Marshal(map[string]string{
-			"agent":  agentName,
-			"prompt": prompt,
-			"dir":    dir,
+		// With --after, the task becomes a workflow step that runs once the parent finishes
+		if after != "" && routed {
+			fmt.Fprintf(os.Stderr, "error: --after needs an agent name, not a selector\n")
+			os.Exit(1)
+		}
+		if _, err := parseBudget(maxTime, maxTurns, maxCost); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		if after != "" && (maxTime != "" || maxTurns > 0 || maxCost > 0) {
+			fmt.Fprintf(os.Stderr, "error: --max-time, --max-turns and --max-cost are not supported with --after (the agent's defaults apply)\n")
+			os.Exit(1)
+		}
+		if after != "" {
+			parentID, err := strconv.ParseInt(after, 10, 64)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: invalid --after task ID: %v\n", err)
+				os.Exit(1)
+			}
+			id, err := db.CreateScheduledStep(prompt, time.Now(), agentName, dir, parentID, when)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			if err := db.SetScheduledTaskExecution(id, worktree, executor); err != nil {
+				db.DeleteScheduledTask(id)
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			if err := db.ResolveNewStep(id); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			result, _ := json.Marshal(map[string]any{
+				"status":     "queued",
+				"id":         id,
+				"depends_on": parentID,
+				"message":    fmt.Sprintf("Task will run on agent '%s' after scheduled task %d finishes.", agentName, parentID),
+			})
+			fmt.Println(string(result))
+			return
+		}
+
+		reqBody, _ := json.Marshal(map[string]any{
+			"agent":     agentName,
+			"selector":  selector,
+			"prompt":    prompt,
+			"dir":       dir,
+			"priority":  priority,
+			"wait":      wait,
+			"files":     files,
+			"worktree":  worktree,
+			"executor":  executor,
+			"max_time":  maxTime,
+			"max_turns": maxTurns,
+			"max_cost":  maxCost,
 		})
 
 		resp, err := http.Post(baseURL+"/agent/run", "application/json", bytes.NewReader(reqBody))
@@ -376,6 +584,314 @@ func handleAgentCLI(config *Config, args []string) {
 		body, _ := io.ReadAll(resp.Body)
 		fmt.Println(string(body))
 
+	case "followup":
+		if len(subargs) < 2 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva agent followup <task_id> \"message\"\n")
+			os.Exit(1)
+		}
+
+		reqBody, _ := json.Marshal(map[string]string{
+			"task_id": subargs[0],
+			"prompt":  subargs[1],
+		})
+		resp, err := http.Post(baseURL+"/agent/followup", "application/json", bytes.NewReader(reqBody))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to connect to Minerva: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Println(string(body))
+
+	case "review":
+		if len(subargs) < 2 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva agent review <task_id> apply|branch|discard\n")
+			os.Exit(1)
+		}
+
+		reqBody, _ := json.Marshal(map[string]string{
+			"task_id": subargs[0],
+			"action":  subargs[1],
+		})
+		resp, err := http.Post(baseURL+"/agent/review", "application/json", bytes.NewReader(reqBody))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to connect to Minerva: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Println(string(body))
+
+	case "push":
+		if len(subargs) < 2 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva agent push <agent-name> <file> [--dir /path]\n")
+			os.Exit(1)
+		}
+
+		// The server reads the file, so it needs a path that doesn't depend on our cwd
+		path, err := filepath.Abs(subargs[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		var dir string
+		for i := 2; i+1 < len(subargs); i++ {
+			if subargs[i] == "--dir" {
+				dir = subargs[i+1]
+				i++
+			}
+		}
+
+		reqBody, _ := json.Marshal(map[string]string{
+			"agent": subargs[0],
+			"path":  path,
+			"dir":   dir,
+		})
+		resp, err := http.Post(baseURL+"/agent/push", "application/json", bytes.NewReader(reqBody))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to connect to Minerva: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Println(string(body))
+
+	case "update":
+		if len(subargs) < 1 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva agent update <agent-name>|--all [--force]\n")
+			os.Exit(1)
+		}
+		req := map[string]interface{}{}
+		for _, arg := range subargs {
+			switch arg {
+			case "--all":
+				req["all"] = true
+			case "--force":
+				req["force"] = true
+			default:
+				req["agent"] = arg
+			}
+		}
+
+		reqBody, _ := json.Marshal(req)
+		resp, err := http.Post(baseURL+"/agent/update", "application/json", bytes.NewReader(reqBody))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to connect to Minerva: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Println(string(body))
+
+	case "publish":
+		if len(subargs) < 1 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva agent publish <binary>\n")
+			os.Exit(1)
+		}
+
+		// The server reads the binary, so it needs a path that doesn't depend on our cwd
+		path, err := filepath.Abs(subargs[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+
+		reqBody, _ := json.Marshal(map[string]string{"path": path})
+		resp, err := http.Post(baseURL+"/agent/publish", "application/json", bytes.NewReader(reqBody))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to connect to Minerva: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Println(string(body))
+
+	case "releases":
+		resp, err := http.Get(baseURL + "/agent/releases")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to connect to Minerva: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Println(string(body))
+
+	case "enroll", "revoke":
+		if len(subargs) < 1 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva agent %s <agent-name>\n", subcmd)
+			os.Exit(1)
+		}
+
+		reqBody, _ := json.Marshal(map[string]string{"agent": subargs[0]})
+		resp, err := http.Post(baseURL+"/agent/"+subcmd, "application/json", bytes.NewReader(reqBody))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to connect to Minerva: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Println(string(body))
+
+	case "budget":
+		if len(subargs) < 1 {
+			resp, err := http.Get(baseURL + "/agent/budgets")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: failed to connect to Minerva: %v\n", err)
+				os.Exit(1)
+			}
+			defer resp.Body.Close()
+
+			body, _ := io.ReadAll(resp.Body)
+			fmt.Println(string(body))
+			return
+		}
+		if len(subargs) < 2 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva agent budget [<agent-name> [--max-time 2h] [--max-turns N] [--max-cost USD] | <agent-name> --reset]\n")
+			os.Exit(1)
+		}
+
+		var maxTime string
+		var maxTurns int
+		var maxCost float64
+		var reset bool
+		for i, arg := range subargs {
+			if arg == "--reset" {
+				reset = true
+				continue
+			}
+			if i+1 >= len(subargs) {
+				break
+			}
+			switch arg {
+			case "--max-time", "--max-turns", "--max-cost":
+				parseBudgetFlag(arg, subargs[i+1], &maxTime, &maxTurns, &maxCost)
+			}
+		}
+		if maxTime == "" && maxTurns == 0 && maxCost == 0 && !reset {
+			fmt.Fprintf(os.Stderr, "error: give --max-time, --max-turns or --max-cost, or --reset to use the server defaults\n")
+			os.Exit(1)
+		}
+
+		reqBody, _ := json.Marshal(map[string]any{
+			"agent":     subargs[0],
+			"max_time":  maxTime,
+			"max_turns": maxTurns,
+			"max_cost":  maxCost,
+		})
+		resp, err := http.Post(baseURL+"/agent/budget", "application/json", bytes.NewReader(reqBody))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to connect to Minerva: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Println(string(body))
+
+	case "credentials":
+		resp, err := http.Get(baseURL + "/agent/credentials")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to connect to Minerva: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Println(string(body))
+
+	case "tasks":
+		var agentName, status string
+		limit := 20
+		for i := 0; i < len(subargs); i++ {
+			if i+1 >= len(subargs) {
+				break
+			}
+			switch subargs[i] {
+			case "--agent":
+				agentName = subargs[i+1]
+				i++
+			case "--status":
+				status = subargs[i+1]
+				i++
+			case "--limit":
+				n, err := strconv.Atoi(subargs[i+1])
+				if err != nil || n <= 0 {
+					fmt.Fprintf(os.Stderr, "error: invalid --limit: %s\n", subargs[i+1])
+					os.Exit(1)
+				}
+				limit = n
+				i++
+			}
+		}
+
+		if err := db.InitAgentTaskTable(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		tasks, err := db.ListAgentTasks(agentName, status, limit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		for i := range tasks {
+			tasks[i].Output = truncateText(tasks[i].Output, 500)
+		}
+		if tasks == nil {
+			tasks = []AgentTaskRecord{}
+		}
+		result, _ := json.Marshal(map[string]any{
+			"success": true,
+			"tasks":   tasks,
+			"count":   len(tasks),
+		})
+		fmt.Println(string(result))
+
+	case "queue":
+		var resp *http.Response
+		var err error
+		switch {
+		case len(subargs) >= 1 && subargs[0] == "move":
+			if len(subargs) < 3 {
+				fmt.Fprintf(os.Stderr, "error: usage: minerva agent queue move <task_id> <position>\n")
+				os.Exit(1)
+			}
+			position, perr := strconv.Atoi(subargs[2])
+			if perr != nil || position < 1 {
+				fmt.Fprintf(os.Stderr, "error: invalid position: %s\n", subargs[2])
+				os.Exit(1)
+			}
+			reqBody, _ := json.Marshal(map[string]any{"task_id": subargs[1], "position": position})
+			resp, err = http.Post(baseURL+"/agent/queue/move", "application/json", bytes.NewReader(reqBody))
+		case len(subargs) >= 1 && subargs[0] == "cancel":
+			if len(subargs) < 2 {
+				fmt.Fprintf(os.Stderr, "error: usage: minerva agent queue cancel <task_id>\n")
+				os.Exit(1)
+			}
+			reqBody, _ := json.Marshal(map[string]string{"task_id": subargs[1]})
+			resp, err = http.Post(baseURL+"/agent/queue/cancel", "application/json", bytes.NewReader(reqBody))
+		default:
+			queueURL := baseURL + "/agent/queue"
+			if len(subargs) >= 1 {
+				queueURL += "?agent=" + url.QueryEscape(subargs[0])
+			}
+			resp, err = http.Get(queueURL)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: failed to connect to Minerva: %v\n", err)
+			os.Exit(1)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		fmt.Println(string(body))
+
 	default:
 		fmt.Fprintf(os.Stderr, "error: unknown agent subcommand: %s\n", subcmd)
 		os.Exit(1)
@@ -608,9 +1124,9 @@ func handleFileCLI(config *Config, args []string) {
 	fmt.Println(string(result))
 }
 
-func handleScheduleCLI(db *DB, args []string) {
+func handleScheduleCLI(config *Config, db *DB, args []string) {
 	if len(args) < 1 {
-		fmt.Fprintf(os.Stderr, "error: schedule subcommand required (create, list, delete, run)\n")
+		fmt.Fprintf(os.Stderr, "error: schedule subcommand required (create, list, delete, cancel, run)\n")
 		os.Exit(1)
 	}
 
@@ -620,11 +1136,12 @@ func handleScheduleCLI(db *DB, args []string) {
 	switch subcmd {
 	case "create":
 		if len(subargs) < 1 {
-			fmt.Fprintf(os.Stderr, "error: usage: minerva schedule create \"task description\" --at \"2026-02-10T16:00:00+01:00\" [--agent name] [--dir /path] [--recurring daily|weekly|monthly]\n")
+			fmt.Fprintf(os.Stderr, "error: usage: minerva schedule create \"task description\" --at \"2026-02-10T16:00:00+01:00\" [--agent name] [--dir /path] [--recurring daily|weekly|monthly] [--max-attempts N] [--backoff 5m] [--wait-for-agent] [--worktree] [--executor name] [--require-ack] [--after <id> [--when on_success|on_failure|always]]\n")
 			os.Exit(1)
 		}
 		description := subargs[0]
-		var scheduledAt, agentName, workingDir, recurring string
+		var scheduledAt, agentName, workingDir, recurring, maxAttemptsStr, backoffStr, after, when, executor string
+		var waitForAgent, requireAck, worktree bool
 		for i, arg := range subargs {
 			switch arg {
 			case "--at":
@@ -643,46 +1160,172 @@ func handleScheduleCLI(db *DB, args []string) {
 				if i+1 < len(subargs) {
 					recurring = subargs[i+1]
 				}
+			case "--max-attempts":
+				if i+1 < len(subargs) {
+					maxAttemptsStr = subargs[i+1]
+				}
+			case "--backoff":
+				if i+1 < len(subargs) {
+					backoffStr = subargs[i+1]
+				}
+			case "--wait-for-agent":
+				waitForAgent = true
+			case "--require-ack":
+				requireAck = true
+			case "--worktree":
+				worktree = true
+			case "--executor":
+				if i+1 < len(subargs) {
+					executor = subargs[i+1]
+				}
+			case "--after":
+				if i+1 < len(subargs) {
+					after = subargs[i+1]
+				}
+			case "--when":
+				if i+1 < len(subargs) {
+					when = subargs[i+1]
+				}
 			}
 		}
-		if scheduledAt == "" {
-			fmt.Fprintf(os.Stderr, "error: --at flag is required\n")
+
+		var parentID int64
+		if after != "" {
+			var err error
+			parentID, err = strconv.ParseInt(after, 10, 64)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: invalid --after task ID: %v\n", err)
+				os.Exit(1)
+			}
+			if recurring != "" && recurring != "none" {
+				fmt.Fprintf(os.Stderr, "error: workflow steps cannot be recurring (make the first step recurring instead)\n")
+				os.Exit(1)
+			}
+		} else if when != "" {
+			fmt.Fprintf(os.Stderr, "error: --when requires --after\n")
 			os.Exit(1)
 		}
 
-		t, err := time.Parse(time.RFC3339, scheduledAt)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "error: invalid time format, use ISO8601 (e.g., 2026-02-10T16:00:00+01:00): %v\n", err)
+		// Workflow steps run when their dependency finishes; --at is then an optional "not before" time
+		t := time.Now()
+		if scheduledAt == "" && parentID == 0 {
+			fmt.Fprintf(os.Stderr, "error: --at flag is required\n")
 			os.Exit(1)
 		}
-		if t.Before(time.Now()) {
-			fmt.Fprintf(os.Stderr, "error: scheduled time must be in the future\n")
-			os.Exit(1)
+		if scheduledAt != "" {
+			var err error
+			t, err = time.Parse(time.RFC3339, scheduledAt)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: invalid time format, use ISO8601 (e.g., 2026-02-10T16:00:00+01:00): %v\n", err)
+				os.Exit(1)
+			}
+			if t.Before(time.Now()) {
+				fmt.Fprintf(os.Stderr, "error: scheduled time must be in the future\n")
+				os.Exit(1)
+			}
 		}
 		if recurring != "" && recurring != "none" && recurring != "daily" && recurring != "weekly" && recurring != "monthly" {
 			fmt.Fprintf(os.Stderr, "error: recurring must be one of: none, daily, weekly, monthly\n")
 			os.Exit(1)
 		}
 
-		id, err := db.CreateScheduledTask(description, t, agentName, workingDir, recurring)
+		var policy ScheduleRetryPolicy
+		if maxAttemptsStr != "" {
+			n, err := strconv.Atoi(maxAttemptsStr)
+			if err != nil || n < 1 {
+				fmt.Fprintf(os.Stderr, "error: --max-attempts must be a positive integer\n")
+				os.Exit(1)
+			}
+			policy.MaxAttempts = n
+		}
+		if backoffStr != "" {
+			d, err := time.ParseDuration(backoffStr)
+			if err != nil || d <= 0 {
+				fmt.Fprintf(os.Stderr, "error: --backoff must be a positive duration (e.g., 30s, 5m, 1h)\n")
+				os.Exit(1)
+			}
+			policy.RetryBackoff = d
+		}
+		policy.WaitForAgent = waitForAgent
+		if agentName == "" && (policy.MaxAttempts > 0 || policy.RetryBackoff > 0 || policy.WaitForAgent) {
+			fmt.Fprintf(os.Stderr, "error: --max-attempts, --backoff and --wait-for-agent require --agent\n")
+			os.Exit(1)
+		}
+		if (worktree || executor != "") && agentName == "" {
+			fmt.Fprintf(os.Stderr, "error: --worktree and --executor require --agent\n")
+			os.Exit(1)
+		}
+		if requireAck && agentName != "" {
+			fmt.Fprintf(os.Stderr, "error: --require-ack only applies to reminders (no --agent)\n")
+			os.Exit(1)
+		}
+
+		var id int64
+		var err error
+		if parentID != 0 {
+			id, err = db.CreateScheduledStep(description, t, agentName, workingDir, parentID, when)
+		} else {
+			id, err = db.CreateScheduledTask(description, t, agentName, workingDir, recurring)
+		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
+		if err := db.SetScheduledTaskRetryPolicy(id, policy); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := db.SetScheduledTaskRequireAck(id, requireAck); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := db.SetScheduledTaskExecution(id, worktree, executor); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
 
 		target := agentName
 		if target == "" {
 			target = "brain"
 		}
+
+		if parentID != 0 {
+			if err := db.ResolveNewStep(id); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			if when == "" {
+				when = RunOnSuccess
+			}
+			result, _ := json.Marshal(map[string]any{
+				"success":     true,
+				"id":          id,
+				"description": description,
+				"agent":       target,
+				"dir":         workingDir,
+				"depends_on":  parentID,
+				"when":        when,
+				"message":     fmt.Sprintf("Step scheduled to run after task %d (%s, target: %s)", parentID, when, target),
+			})
+			fmt.Println(string(result))
+			return
+		}
+
 		result, _ := json.Marshal(map[string]any{
-			"success":      true,
-			"id":           id,
-			"description":  description,
-			"scheduled_at": t.Format(time.RFC3339),
-			"agent":        target,
-			"dir":          workingDir,
-			"recurring":    recurring,
-			"message":      fmt.Sprintf("Task scheduled for %s (target: %s)", t.Format("Jan 2, 2006 at 15:04"), target),
+			"success":        true,
+			"id":             id,
+			"description":    description,
+			"scheduled_at":   t.Format(time.RFC3339),
+			"agent":          target,
+			"dir":            workingDir,
+			"recurring":      recurring,
+			"max_attempts":   policy.MaxAttempts,
+			"backoff":        policy.RetryBackoff.String(),
+			"wait_for_agent": policy.WaitForAgent,
+			"worktree":       worktree,
+			"executor":       executor,
+			"require_ack":    requireAck,
+			"message":        fmt.Sprintf("Task scheduled for %s (target: %s)", t.Format("Jan 2, 2006 at 15:04"), target),
 		})
 		fmt.Println(string(result))
 
@@ -701,15 +1344,26 @@ func handleScheduleCLI(db *DB, args []string) {
 			Dir         string `json:"dir,omitempty"`
 			Status      string `json:"status"`
 			Recurring   string `json:"recurring"`
+			Attempts    int    `json:"attempts,omitempty"`
+			MaxAttempts int    `json:"max_attempts,omitempty"`
+			Wait        bool   `json:"wait_for_agent,omitempty"`
+			RequireAck  bool   `json:"require_ack,omitempty"`
+			AgentTaskID string `json:"agent_task_id,omitempty"`
+			DependsOn   int64  `json:"depends_on,omitempty"`
+			When        string `json:"when,omitempty"`
+			Result      string `json:"result,omitempty"`
+		}
+		type workflowResult struct {
+			ID    int64        `json:"workflow_id"`
+			Steps []taskResult `json:"steps"`
 		}
 
-		var results []taskResult
-		for _, t := range tasks {
+		toResult := func(t ScheduledTask) taskResult {
 			agent := t.AgentName
 			if agent == "" {
 				agent = "brain"
 			}
-			results = append(results, taskResult{
+			r := taskResult{
 				ID:          t.ID,
 				Description: t.Description,
 				ScheduledAt: t.ScheduledAt.Format(time.RFC3339),
@@ -717,16 +1371,134 @@ func handleScheduleCLI(db *DB, args []string) {
 				Dir:         t.WorkingDir,
 				Status:      t.Status,
 				Recurring:   t.Recurring,
-			})
+				Attempts:    t.Attempts,
+				MaxAttempts: t.MaxAttempts,
+				Wait:        t.WaitForAgent,
+				RequireAck:  t.RequireAck,
+				AgentTaskID: t.AgentTaskID,
+				DependsOn:   t.DependsOn,
+				When:        t.RunCondition,
+			}
+			// Show how finished steps ended so the workflow reads as a whole
+			if isFinalScheduleStatus(t.Status) {
+				r.Result = truncateText(t.Result, 200)
+			}
+			return r
+		}
+
+		var results []taskResult
+		var workflows []workflowResult
+		seenWorkflows := make(map[int64]bool)
+		for _, t := range tasks {
+			if t.WorkflowID == 0 {
+				results = append(results, toResult(t))
+				continue
+			}
+			if seenWorkflows[t.WorkflowID] {
+				continue
+			}
+			seenWorkflows[t.WorkflowID] = true
+
+			steps, err := db.GetWorkflowSteps(t.WorkflowID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			wf := workflowResult{ID: t.WorkflowID}
+			for _, step := range steps {
+				wf.Steps = append(wf.Steps, toResult(step))
+			}
+			workflows = append(workflows, wf)
 		}
 
 		response, _ := json.Marshal(map[string]any{
-			"success": true,
-			"tasks":   results,
-			"count":   len(results),
+			"success":   true,
+			"tasks":     results,
+			"workflows": workflows,
+			"count":     len(results) + len(workflows),
 		})
 		fmt.Println(string(response))
 
+	case "cancel":
+		if len(subargs) < 1 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva schedule cancel <id>\n")
+			os.Exit(1)
+		}
+		id, err := strconv.ParseInt(subargs[0], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid task ID: %v\n", err)
+			os.Exit(1)
+		}
+		runningTasks, err := db.CancelWorkflow(id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+
+		// Stop steps that are already running on agents
+		var killErrors []string
+		for _, agentTaskID := range runningTasks {
+			reqBody, _ := json.Marshal(map[string]string{"task_id": agentTaskID})
+			resp, err := http.Post(config.CLIBaseURL()+"/agent/kill", "application/json", bytes.NewReader(reqBody))
+			if err != nil {
+				killErrors = append(killErrors, fmt.Sprintf("%s: %v", agentTaskID, err))
+				continue
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				killErrors = append(killErrors, fmt.Sprintf("%s: status %d", agentTaskID, resp.StatusCode))
+			}
+		}
+
+		result, _ := json.Marshal(map[string]any{
+			"success":      true,
+			"killed_tasks": runningTasks,
+			"kill_errors":  killErrors,
+			"message":      "Workflow cancelled",
+		})
+		fmt.Println(string(result))
+
+	case "reschedule":
+		if len(subargs) < 1 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva schedule reschedule <id> --at \"2026-02-10T16:00:00+01:00\"\n")
+			os.Exit(1)
+		}
+		id, err := strconv.ParseInt(subargs[0], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid task ID: %v\n", err)
+			os.Exit(1)
+		}
+		var scheduledAt string
+		for i, arg := range subargs {
+			if arg == "--at" && i+1 < len(subargs) {
+				scheduledAt = subargs[i+1]
+			}
+		}
+		if scheduledAt == "" {
+			fmt.Fprintf(os.Stderr, "error: --at flag is required\n")
+			os.Exit(1)
+		}
+		t, err := time.Parse(time.RFC3339, scheduledAt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid time format, use ISO8601 (e.g., 2026-02-10T16:00:00+01:00): %v\n", err)
+			os.Exit(1)
+		}
+		if t.Before(time.Now()) {
+			fmt.Fprintf(os.Stderr, "error: scheduled time must be in the future\n")
+			os.Exit(1)
+		}
+		if err := db.SnoozeScheduledTask(id, t); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		result, _ := json.Marshal(map[string]any{
+			"success":      true,
+			"id":           id,
+			"scheduled_at": t.Format(time.RFC3339),
+			"message":      fmt.Sprintf("Task rescheduled for %s", t.Format("Jan 2, 2006 at 15:04")),
+		})
+		fmt.Println(string(result))
+
 	case "delete":
 		if len(subargs) < 1 {
 			fmt.Fprintf(os.Stderr, "error: usage: minerva schedule delete <id>\n")
@@ -786,6 +1558,748 @@ func handleScheduleCLI(db *DB, args []string) {
 	}
 }
 
+// handleAgentBroadcastCLI sends a task to all agents (--all) or those matching a selector
+// (--label <selector>); Minerva reports the results together once all of them finish
+// parseBudgetFlag reads a --max-time, --max-turns or --max-cost value, exiting if it is invalid
+func parseBudgetFlag(flag, value string, maxTime *string, maxTurns *int, maxCost *float64) {
+	var err error
+	switch flag {
+	case "--max-time":
+		*maxTime = value
+		_, err = parseBudget(value, 0, 0)
+	case "--max-turns":
+		*maxTurns, err = strconv.Atoi(value)
+		if err == nil {
+			_, err = parseBudget("", *maxTurns, 0)
+		}
+	case "--max-cost":
+		*maxCost, err = strconv.ParseFloat(strings.TrimPrefix(value, "$"), 64)
+		if err == nil {
+			_, err = parseBudget("", 0, *maxCost)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: invalid %s: %s\n", flag, value)
+		os.Exit(1)
+	}
+}
+
+func handleAgentBroadcastCLI(baseURL string, args []string) {
+	var selector, prompt string
+	rest := args[1:]
+	if args[0] == "--label" {
+		if len(rest) < 1 {
+			fmt.Fprintf(os.Stderr, "error: --label needs a selector (e.g. --label repo:minerva or --label os:linux,has-docker)\n")
+			os.Exit(1)
+		}
+		selector, rest = rest[0], rest[1:]
+	}
+	if len(rest) < 1 {
+		fmt.Fprintf(os.Stderr, "error: usage: minerva agent run --all|--label <selector> \"prompt\" [--dir /path] [--timeout 30m] [--priority N] [--executor name] [--worktree] [--max-time 30m] [--max-turns N] [--max-cost USD]\n")
+		os.Exit(1)
+	}
+	prompt = rest[0]
+
+	var dir, timeout, executor, maxTime string
+	var priority, maxTurns int
+	var maxCost float64
+	var worktree bool
+	for i, arg := range rest {
+		if arg == "--worktree" {
+			worktree = true
+			continue
+		}
+		if i+1 >= len(rest) {
+			break
+		}
+		switch arg {
+		case "--dir":
+			dir = rest[i+1]
+		case "--timeout":
+			if d, err := time.ParseDuration(rest[i+1]); err != nil || d <= 0 {
+				fmt.Fprintf(os.Stderr, "error: invalid --timeout: %s\n", rest[i+1])
+				os.Exit(1)
+			}
+			timeout = rest[i+1]
+		case "--executor":
+			executor = rest[i+1]
+		case "--priority":
+			n, err := strconv.Atoi(rest[i+1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: invalid --priority: %s\n", rest[i+1])
+				os.Exit(1)
+			}
+			priority = n
+		case "--max-time", "--max-turns", "--max-cost":
+			parseBudgetFlag(arg, rest[i+1], &maxTime, &maxTurns, &maxCost)
+		case "--wait", "--file", "--after":
+			fmt.Fprintf(os.Stderr, "error: %s is not supported with --all or --label\n", arg)
+			os.Exit(1)
+		}
+	}
+
+	reqBody, _ := json.Marshal(map[string]any{
+		"selector":  selector,
+		"prompt":    prompt,
+		"dir":       dir,
+		"priority":  priority,
+		"worktree":  worktree,
+		"executor":  executor,
+		"timeout":   timeout,
+		"max_time":  maxTime,
+		"max_turns": maxTurns,
+		"max_cost":  maxCost,
+	})
+	resp, err := http.Post(baseURL+"/agent/broadcast", "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: failed to connect to Minerva: %v\n", err)
+		os.Exit(1)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Println(string(body))
+}
+
+// handleWatchCLI handles watcher subcommands
+func handleWatchCLI(db *DB, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "error: usage: minerva watch <create|list|pause|resume|delete>\n")
+		os.Exit(1)
+	}
+
+	subcmd := args[0]
+	subargs := args[1:]
+
+	switch subcmd {
+	case "create":
+		if len(subargs) < 1 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva watch create \"name\" (--url URL | --file PATH --from agent | --sql \"SELECT ...\") --if \"data.price < 100\" [--every 5m] [--then \"action\"] [--agent name] [--dir /path] [--once]\n")
+			os.Exit(1)
+		}
+		w := Watcher{Name: subargs[0], Interval: 5 * time.Minute}
+		var every string
+		sources := 0
+		for i, arg := range subargs {
+			next := ""
+			if i+1 < len(subargs) {
+				next = subargs[i+1]
+			}
+			switch arg {
+			case "--url":
+				w.SourceType, w.Source = WatchSourceHTTP, next
+				sources++
+			case "--file":
+				w.SourceType, w.Source = WatchSourceFile, next
+				sources++
+			case "--sql":
+				w.SourceType, w.Source = WatchSourceSQL, next
+				sources++
+			case "--from":
+				w.SourceAgent = next
+			case "--if":
+				w.Predicate = next
+			case "--every":
+				every = next
+			case "--then":
+				w.Action = next
+			case "--agent":
+				w.AgentName = next
+			case "--dir":
+				w.WorkingDir = next
+			case "--once":
+				w.Once = true
+			}
+		}
+
+		if sources != 1 || w.Source == "" {
+			fmt.Fprintf(os.Stderr, "error: exactly one of --url, --file or --sql is required\n")
+			os.Exit(1)
+		}
+		if w.SourceType == WatchSourceFile && w.SourceAgent == "" {
+			fmt.Fprintf(os.Stderr, "error: --file requires --from <agent>\n")
+			os.Exit(1)
+		}
+		if w.SourceType == WatchSourceHTTP && !strings.HasPrefix(w.Source, "http://") && !strings.HasPrefix(w.Source, "https://") {
+			fmt.Fprintf(os.Stderr, "error: --url must be an http(s) URL\n")
+			os.Exit(1)
+		}
+		if w.Predicate == "" {
+			fmt.Fprintf(os.Stderr, "error: --if predicate is required (JavaScript over `data`, e.g. \"data.price < 100\")\n")
+			os.Exit(1)
+		}
+		if err := validateWatcherPredicate(w.Predicate); err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid predicate: %v\n", err)
+			os.Exit(1)
+		}
+		if w.AgentName != "" && w.Action == "" {
+			fmt.Fprintf(os.Stderr, "error: --agent requires --then with the task to run\n")
+			os.Exit(1)
+		}
+		if every != "" {
+			d, err := time.ParseDuration(every)
+			if err != nil || d < time.Minute {
+				fmt.Fprintf(os.Stderr, "error: --every must be a duration of at least 1m (e.g., 5m, 1h)\n")
+				os.Exit(1)
+			}
+			w.Interval = d
+		}
+
+		id, err := db.CreateWatcher(w)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+
+		target := w.AgentName
+		if target == "" {
+			target = "brain"
+		}
+		result, _ := json.Marshal(map[string]any{
+			"success": true,
+			"id":      id,
+			"name":    w.Name,
+			"source":  w.SourceType,
+			"every":   w.Interval.String(),
+			"target":  target,
+			"once":    w.Once,
+			"message": fmt.Sprintf("Watching %s every %s (target: %s)", w.SourceType, w.Interval, target),
+		})
+		fmt.Println(string(result))
+
+	case "list":
+		watchers, err := db.GetWatchers()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+
+		type watcherResult struct {
+			ID          int64  `json:"id"`
+			Name        string `json:"name"`
+			Source      string `json:"source"`
+			From        string `json:"from,omitempty"`
+			Predicate   string `json:"if"`
+			Action      string `json:"then,omitempty"`
+			Agent       string `json:"agent"`
+			Every       string `json:"every"`
+			Once        bool   `json:"once,omitempty"`
+			Status      string `json:"status"`
+			LastValue   bool   `json:"last_value"`
+			LastError   string `json:"last_error,omitempty"`
+			LastChecked string `json:"last_checked_at,omitempty"`
+			LastFired   string `json:"last_fired_at,omitempty"`
+		}
+
+		var results []watcherResult
+		for _, w := range watchers {
+			agent := w.AgentName
+			if agent == "" {
+				agent = "brain"
+			}
+			r := watcherResult{
+				ID:        w.ID,
+				Name:      w.Name,
+				Source:    w.SourceType + " " + w.Source,
+				From:      w.SourceAgent,
+				Predicate: w.Predicate,
+				Action:    w.Action,
+				Agent:     agent,
+				Every:     w.Interval.String(),
+				Once:      w.Once,
+				Status:    w.Status,
+				LastValue: w.LastValue,
+				LastError: w.LastError,
+			}
+			if w.LastChecked != nil {
+				r.LastChecked = w.LastChecked.Format(time.RFC3339)
+			}
+			if w.LastFired != nil {
+				r.LastFired = w.LastFired.Format(time.RFC3339)
+			}
+			results = append(results, r)
+		}
+
+		response, _ := json.Marshal(map[string]any{
+			"success":  true,
+			"watchers": results,
+			"count":    len(results),
+		})
+		fmt.Println(string(response))
+
+	case "pause", "resume", "delete":
+		if len(subargs) < 1 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva watch %s <id>\n", subcmd)
+			os.Exit(1)
+		}
+		id, err := strconv.ParseInt(subargs[0], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid watcher ID: %v\n", err)
+			os.Exit(1)
+		}
+
+		var message string
+		switch subcmd {
+		case "pause":
+			err, message = db.SetWatcherStatus(id, "paused"), "Watcher paused"
+		case "resume":
+			err, message = db.SetWatcherStatus(id, "active"), "Watcher resumed"
+		case "delete":
+			err, message = db.DeleteWatcher(id), "Watcher deleted"
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		result, _ := json.Marshal(map[string]any{
+			"success": true,
+			"message": message,
+		})
+		fmt.Println(string(result))
+
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown watch subcommand: %s\n", subcmd)
+		os.Exit(1)
+	}
+}
+
+// handleRuleCLI handles automation rule subcommands
+func handleRuleCLI(db *DB, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "error: usage: minerva rule <create|list|enable|disable|delete|events>\n")
+		os.Exit(1)
+	}
+
+	subcmd := args[0]
+	subargs := args[1:]
+
+	switch subcmd {
+	case "create":
+		if len(subargs) < 1 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva rule create \"name\" --on agent.disconnected [--where agent=vps] [--for 10m] --email me@example.com\n")
+			os.Exit(1)
+		}
+		r := Rule{Name: subargs[0], Filter: map[string]string{}}
+		var forStr, cooldownStr string
+		for i, arg := range subargs {
+			next := ""
+			if i+1 < len(subargs) {
+				next = subargs[i+1]
+			}
+			switch arg {
+			case "--on":
+				r.Trigger = next
+			case "--where":
+				key, pattern, ok := strings.Cut(next, "=")
+				if !ok || key == "" {
+					fmt.Fprintf(os.Stderr, "error: --where expects key=pattern (e.g. agent=vps, status=!completed)\n")
+					os.Exit(1)
+				}
+				r.Filter[key] = pattern
+			case "--for":
+				forStr = next
+			case "--unless":
+				r.Unless = next
+			case "--cooldown":
+				cooldownStr = next
+			case "--notify":
+				r.Actions = append(r.Actions, RuleAction{Type: RuleActionNotify, Message: next})
+			case "--brain":
+				r.Actions = append(r.Actions, RuleAction{Type: RuleActionBrain, Message: next})
+			case "--run":
+				r.Actions = append(r.Actions, RuleAction{Type: RuleActionAgent, Target: next})
+			case "--email":
+				r.Actions = append(r.Actions, RuleAction{Type: RuleActionEmail, Target: next})
+			case "--remind":
+				r.Actions = append(r.Actions, RuleAction{Type: RuleActionRemind, Message: next})
+			case "--call":
+				r.Actions = append(r.Actions, RuleAction{Type: RuleActionCall, Target: next})
+			}
+		}
+
+		// Second pass: options that belong to an action
+		var run, email, call *RuleAction
+		for i := range r.Actions {
+			switch r.Actions[i].Type {
+			case RuleActionAgent:
+				run = &r.Actions[i]
+			case RuleActionEmail:
+				email = &r.Actions[i]
+			case RuleActionCall:
+				call = &r.Actions[i]
+			}
+		}
+		for i, arg := range subargs {
+			next := ""
+			if i+1 < len(subargs) {
+				next = subargs[i+1]
+			}
+			switch {
+			case arg == "--prompt" && run != nil:
+				run.Message = next
+			case arg == "--dir" && run != nil:
+				run.Dir = next
+			case arg == "--subject" && email != nil:
+				email.Subject = next
+			case arg == "--body" && email != nil:
+				email.Message = next
+			case arg == "--purpose" && call != nil:
+				call.Message = next
+			case arg == "--in":
+				for j := range r.Actions {
+					if r.Actions[j].Type == RuleActionRemind {
+						r.Actions[j].Delay = next
+					}
+				}
+			}
+		}
+
+		if r.Trigger == "" {
+			fmt.Fprintf(os.Stderr, "error: --on <event> is required (see: minerva rule events)\n")
+			os.Exit(1)
+		}
+		if _, err := path.Match(r.Trigger, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid --on pattern: %v\n", err)
+			os.Exit(1)
+		}
+		if len(r.Actions) == 0 {
+			fmt.Fprintf(os.Stderr, "error: at least one action is required (--notify, --brain, --run, --email, --remind, --call)\n")
+			os.Exit(1)
+		}
+		for _, a := range r.Actions {
+			switch {
+			case a.Type == RuleActionAgent && (a.Target == "" || a.Message == ""):
+				fmt.Fprintf(os.Stderr, "error: --run requires an agent name and --prompt\n")
+				os.Exit(1)
+			case a.Type == RuleActionEmail && a.Target == "":
+				fmt.Fprintf(os.Stderr, "error: --email requires a recipient\n")
+				os.Exit(1)
+			case a.Type == RuleActionCall && (a.Target == "" || a.Message == ""):
+				fmt.Fprintf(os.Stderr, "error: --call requires a number and --purpose\n")
+				os.Exit(1)
+			case (a.Type == RuleActionBrain || a.Type == RuleActionRemind) && a.Message == "":
+				fmt.Fprintf(os.Stderr, "error: --%s requires a text\n", a.Type)
+				os.Exit(1)
+			case a.Type == RuleActionRemind && a.Delay != "":
+				if _, err := time.ParseDuration(a.Delay); err != nil {
+					fmt.Fprintf(os.Stderr, "error: --in must be a duration (e.g., 30m, 2h)\n")
+					os.Exit(1)
+				}
+			}
+		}
+		if forStr != "" {
+			d, err := time.ParseDuration(forStr)
+			if err != nil || d <= 0 {
+				fmt.Fprintf(os.Stderr, "error: --for must be a positive duration (e.g., 10m)\n")
+				os.Exit(1)
+			}
+			r.For = d
+			if r.Unless == "" {
+				r.Unless = defaultUnlessEvent(r.Trigger)
+			}
+		} else if r.Unless != "" {
+			fmt.Fprintf(os.Stderr, "error: --unless requires --for\n")
+			os.Exit(1)
+		}
+		if cooldownStr != "" {
+			d, err := time.ParseDuration(cooldownStr)
+			if err != nil || d <= 0 {
+				fmt.Fprintf(os.Stderr, "error: --cooldown must be a positive duration (e.g., 1h)\n")
+				os.Exit(1)
+			}
+			r.Cooldown = d
+		}
+
+		id, err := db.CreateRule(r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		r.ID = id
+
+		result, _ := json.Marshal(map[string]any{
+			"success": true,
+			"id":      id,
+			"name":    r.Name,
+			"rule":    r.Describe(),
+			"message": fmt.Sprintf("Rule created: %s", r.Describe()),
+		})
+		fmt.Println(string(result))
+
+	case "list":
+		rules, err := db.GetRules()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+
+		type ruleResult struct {
+			ID        int64             `json:"id"`
+			Name      string            `json:"name"`
+			On        string            `json:"on"`
+			Where     map[string]string `json:"where,omitempty"`
+			For       string            `json:"for,omitempty"`
+			Unless    string            `json:"unless,omitempty"`
+			Cooldown  string            `json:"cooldown,omitempty"`
+			Actions   []RuleAction      `json:"actions"`
+			Enabled   bool              `json:"enabled"`
+			FireCount int               `json:"fire_count"`
+			LastFired string            `json:"last_fired_at,omitempty"`
+		}
+
+		var results []ruleResult
+		for _, r := range rules {
+			res := ruleResult{
+				ID:        r.ID,
+				Name:      r.Name,
+				On:        r.Trigger,
+				Where:     r.Filter,
+				Unless:    r.Unless,
+				Actions:   r.Actions,
+				Enabled:   r.Enabled,
+				FireCount: r.FireCount,
+			}
+			if r.For > 0 {
+				res.For = r.For.String()
+			}
+			if r.Cooldown > 0 {
+				res.Cooldown = r.Cooldown.String()
+			}
+			if r.LastFired != nil {
+				res.LastFired = r.LastFired.Format(time.RFC3339)
+			}
+			results = append(results, res)
+		}
+
+		response, _ := json.Marshal(map[string]any{
+			"success": true,
+			"rules":   results,
+			"count":   len(results),
+		})
+		fmt.Println(string(response))
+
+	case "events":
+		response, _ := json.Marshal(map[string]any{
+			"success": true,
+			"events":  EventTypes,
+			"message": "Filter with --where <field>=<glob> (or subject=<glob>); prefix the pattern with ! to negate. Use {{field}}, {{subject}} and {{text}} in action texts.",
+		})
+		fmt.Println(string(response))
+
+	case "enable", "disable", "delete":
+		if len(subargs) < 1 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva rule %s <id>\n", subcmd)
+			os.Exit(1)
+		}
+		id, err := strconv.ParseInt(subargs[0], 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: invalid rule ID: %v\n", err)
+			os.Exit(1)
+		}
+
+		var message string
+		switch subcmd {
+		case "enable":
+			err, message = db.SetRuleEnabled(id, true), "Rule enabled"
+		case "disable":
+			err, message = db.SetRuleEnabled(id, false), "Rule disabled"
+		case "delete":
+			err, message = db.DeleteRule(id), "Rule deleted"
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		result, _ := json.Marshal(map[string]any{
+			"success": true,
+			"message": message,
+		})
+		fmt.Println(string(result))
+
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown rule subcommand: %s\n", subcmd)
+		os.Exit(1)
+	}
+}
+
+func handleNotifyCLI(config *Config, db *DB, userID int64, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "error: usage: minerva notify <status|quiet|route>\n")
+		os.Exit(1)
+	}
+
+	if err := db.InitNotificationTables(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+
+	subcmd := args[0]
+	subargs := args[1:]
+
+	switch subcmd {
+	case "status":
+		spec, ok, err := db.GetQuietHours(userID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		if !ok {
+			spec = config.QuietHours
+		}
+		if spec == "" {
+			spec = "off"
+		}
+		routes, err := db.GetNotificationRoutes()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		queued, err := db.CountDigestItems(userID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		response, _ := json.Marshal(map[string]any{
+			"success":     true,
+			"quiet_hours": spec,
+			"quiet_now":   inQuietHours(spec, time.Now()),
+			"routes":      routes,
+			"sources":     NotificationSources,
+			"digest":      queued,
+		})
+		fmt.Println(string(response))
+
+	case "quiet":
+		if len(subargs) < 1 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva notify quiet <23:00-08:00|off|default>\n")
+			os.Exit(1)
+		}
+		spec := subargs[0]
+		switch spec {
+		case "default":
+			spec = ""
+		case "off":
+		default:
+			if _, _, err := parseQuietHours(spec); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := db.SetQuietHours(userID, spec); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		result, _ := json.Marshal(map[string]any{
+			"success": true,
+			"message": "Quiet hours updated",
+		})
+		fmt.Println(string(result))
+
+	case "route":
+		if len(subargs) < 2 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva notify route <source> <low|normal|urgent|default>\n")
+			os.Exit(1)
+		}
+		source, severity := subargs[0], subargs[1]
+		if _, ok := NotificationSources[source]; !ok {
+			fmt.Fprintf(os.Stderr, "error: unknown notification source %q (see: minerva notify status)\n", source)
+			os.Exit(1)
+		}
+		if severity == "default" {
+			severity = ""
+		} else if !validSeverity(severity) {
+			fmt.Fprintf(os.Stderr, "error: severity must be low, normal, urgent or default\n")
+			os.Exit(1)
+		}
+		if err := db.SetNotificationRoute(source, severity); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		result, _ := json.Marshal(map[string]any{
+			"success": true,
+			"message": "Notification route updated",
+		})
+		fmt.Println(string(result))
+
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown notify subcommand: %s\n", subcmd)
+		os.Exit(1)
+	}
+}
+
+func handleBriefingCLI(config *Config, db *DB, userID int64, args []string) {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "error: usage: minerva briefing <show|set>\n")
+		os.Exit(1)
+	}
+
+	for _, init := range []func() error{db.InitScheduleTable, db.InitEventLogTable, db.InitBriefingTable} {
+		if err := init(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	subcmd := args[0]
+	subargs := args[1:]
+
+	switch subcmd {
+	case "show":
+		data, err := db.CompileBriefing(userID, true, time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		spec, ok, err := db.GetBriefingTime(userID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		if !ok {
+			spec = config.BriefingTime
+		}
+		if spec == "" {
+			spec = "off"
+		}
+		response, _ := json.Marshal(map[string]any{
+			"success":  true,
+			"time":     spec,
+			"briefing": data,
+		})
+		fmt.Println(string(response))
+
+	case "set":
+		if len(subargs) < 1 {
+			fmt.Fprintf(os.Stderr, "error: usage: minerva briefing set <07:30|off|default>\n")
+			os.Exit(1)
+		}
+		spec := subargs[0]
+		switch spec {
+		case "default":
+			spec = ""
+		case "off":
+		default:
+			if _, err := parseClock(spec); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if err := db.SetBriefingTime(userID, spec); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		result, _ := json.Marshal(map[string]any{
+			"success": true,
+			"message": "Briefing time updated",
+		})
+		fmt.Println(string(result))
+
+	default:
+		fmt.Fprintf(os.Stderr, "error: unknown briefing subcommand: %s\n", subcmd)
+		os.Exit(1)
+	}
+}
+
 // runBot runs the main Telegram bot
 func runBot() {
 	// Check for existing instance
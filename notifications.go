@@ -0,0 +1,613 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Notification severities
+const (
+	NotifyLow    = "low"    // silent message; batched into the digest during quiet hours
+	NotifyNormal = "normal" // regular message; silent during quiet hours
+	NotifyUrgent = "urgent" // always rings, re-sent until acknowledged
+)
+
+// Notification sources, used for per-source routing
+const (
+	SourceAgent       = "agent"        // agent connect/disconnect
+	SourceAgentHealth = "agent_health" // low disk or battery on an agent starting a task
+	SourceWatchdog    = "watchdog"     // stuck agent tasks
+	SourceAgentResult = "agent_result" // agent task results
+	SourceScheduler   = "scheduler"    // scheduled task start/retry/failure
+	SourceReminder    = "reminder"     // reminders
+	SourceWatcher     = "watcher"      // watcher triggers and errors
+	SourceRules       = "rules"        // automation rule notifications
+	SourceEmail       = "email"        // incoming emails
+	SourceCall        = "call"         // voice call notices and summaries
+	SourcePhone       = "phone"        // Android phone bridge
+	SourceBriefing    = "briefing"     // daily briefing
+)
+
+// NotificationSources lists the known sources and what they cover, for help output
+var NotificationSources = map[string]string{
+	SourceAgent:       "agent connected/disconnected",
+	SourceAgentHealth: "agents low on disk or battery when a task starts",
+	SourceWatchdog:    "agent tasks with no heartbeat",
+	SourceAgentResult: "agent task results",
+	SourceScheduler:   "scheduled task start, retries and failures",
+	SourceReminder:    "reminders",
+	SourceWatcher:     "watcher triggers and check errors",
+	SourceRules:       "automation rule notifications",
+	SourceEmail:       "incoming emails and their summaries",
+	SourceCall:        "call notices and summaries",
+	SourcePhone:       "Android phone connect/disconnect and call events",
+	SourceBriefing:    "daily briefing",
+}
+
+// Notification is a message for a user that goes through the notification policy
+type Notification struct {
+	Source   string
+	Severity string
+	Text     string
+	Keyboard *tgbotapi.InlineKeyboardMarkup // optional buttons (never batched into the digest)
+}
+
+// DigestItem is a low-priority notification held back during quiet hours
+type DigestItem struct {
+	ID        int64
+	UserID    int64
+	Source    string
+	Text      string
+	CreatedAt time.Time
+}
+
+// urgentNotice tracks an urgent notification that has not been acknowledged yet
+type urgentNotice struct {
+	userID int64
+	note   Notification
+	sent   int
+	timer  *time.Timer
+}
+
+// Notifier routes notifications by source and severity, honoring quiet hours
+type Notifier struct {
+	bot    *Bot
+	db     *DB
+	mu     sync.Mutex
+	urgent map[string]*urgentNotice
+	stop   chan struct{}
+}
+
+// NewNotifier creates a new notifier
+func NewNotifier(bot *Bot, db *DB) *Notifier {
+	return &Notifier{
+		bot:    bot,
+		db:     db,
+		urgent: make(map[string]*urgentNotice),
+		stop:   make(chan struct{}),
+	}
+}
+
+// InitNotificationTables creates the notification settings, routes and digest tables
+func (db *DB) InitNotificationTables() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS notification_settings (
+			user_id INTEGER PRIMARY KEY,
+			quiet_hours TEXT NOT NULL DEFAULT ''
+		);
+		CREATE TABLE IF NOT EXISTS notification_routes (
+			source TEXT PRIMARY KEY,
+			severity TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS notification_digest (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			source TEXT NOT NULL,
+			text TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+		CREATE INDEX IF NOT EXISTS idx_notification_digest_user ON notification_digest(user_id);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create notification tables: %w", err)
+	}
+	return nil
+}
+
+// GetQuietHours returns the user's quiet hours ("HH:MM-HH:MM" or "off"); ok is false if unset
+func (db *DB) GetQuietHours(userID int64) (string, bool, error) {
+	var spec string
+	err := db.QueryRow(`SELECT quiet_hours FROM notification_settings WHERE user_id = ?`, userID).Scan(&spec)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return spec, true, nil
+}
+
+// SetQuietHours stores the user's quiet hours; "" removes the override
+func (db *DB) SetQuietHours(userID int64, spec string) error {
+	if spec == "" {
+		_, err := db.Exec(`DELETE FROM notification_settings WHERE user_id = ?`, userID)
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT INTO notification_settings (user_id, quiet_hours) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET quiet_hours = excluded.quiet_hours
+	`, userID, spec)
+	return err
+}
+
+// GetNotificationRoutes returns the severity overrides by source
+func (db *DB) GetNotificationRoutes() (map[string]string, error) {
+	rows, err := db.Query(`SELECT source, severity FROM notification_routes ORDER BY source`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := make(map[string]string)
+	for rows.Next() {
+		var source, severity string
+		if err := rows.Scan(&source, &severity); err != nil {
+			return nil, err
+		}
+		routes[source] = severity
+	}
+	return routes, rows.Err()
+}
+
+// GetNotificationRoute returns the severity override for a source, or "" if none
+func (db *DB) GetNotificationRoute(source string) (string, error) {
+	var severity string
+	err := db.QueryRow(`SELECT severity FROM notification_routes WHERE source = ?`, source).Scan(&severity)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return severity, err
+}
+
+// SetNotificationRoute overrides the severity of a source; "" restores the default
+func (db *DB) SetNotificationRoute(source, severity string) error {
+	if severity == "" {
+		_, err := db.Exec(`DELETE FROM notification_routes WHERE source = ?`, source)
+		return err
+	}
+	_, err := db.Exec(`
+		INSERT INTO notification_routes (source, severity) VALUES (?, ?)
+		ON CONFLICT(source) DO UPDATE SET severity = excluded.severity
+	`, source, severity)
+	return err
+}
+
+// AddDigestItem queues a notification for the user's next digest
+func (db *DB) AddDigestItem(userID int64, source, text string) error {
+	_, err := db.Exec(`
+		INSERT INTO notification_digest (user_id, source, text, created_at) VALUES (?, ?, ?, ?)
+	`, userID, source, text, time.Now().Format(time.RFC3339))
+	return err
+}
+
+// GetDigestUsers returns the users with queued digest items
+func (db *DB) GetDigestUsers() ([]int64, error) {
+	rows, err := db.Query(`SELECT DISTINCT user_id FROM notification_digest`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		users = append(users, id)
+	}
+	return users, rows.Err()
+}
+
+// CountDigestItems returns how many notifications are queued for the user
+func (db *DB) CountDigestItems(userID int64) (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM notification_digest WHERE user_id = ?`, userID).Scan(&n)
+	return n, err
+}
+
+// TakeDigestItems removes and returns the queued notifications of a user, oldest first
+func (db *DB) TakeDigestItems(userID int64) ([]DigestItem, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT id, user_id, source, text, created_at FROM notification_digest
+		WHERE user_id = ? ORDER BY id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []DigestItem
+	for rows.Next() {
+		var item DigestItem
+		var createdAt string
+		if err := rows.Scan(&item.ID, &item.UserID, &item.Source, &item.Text, &createdAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		item.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		items = append(items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	if _, err := tx.Exec(`DELETE FROM notification_digest WHERE user_id = ? AND id <= ?`, userID, items[len(items)-1].ID); err != nil {
+		return nil, err
+	}
+	return items, tx.Commit()
+}
+
+// validSeverity reports whether s is a known severity
+func validSeverity(s string) bool {
+	return s == NotifyLow || s == NotifyNormal || s == NotifyUrgent
+}
+
+// parseQuietHours parses "HH:MM-HH:MM" into minutes since midnight
+func parseQuietHours(spec string) (start, end int, err error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("quiet hours must look like 23:00-08:00, got %q", spec)
+	}
+	if start, err = parseClock(from); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parseClock(to); err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("quiet hours start and end must differ")
+	}
+	return start, end, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (use HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// inQuietHours reports whether now falls inside the quiet hours spec.
+// Ranges that cross midnight (23:00-08:00) are supported; "" and "off" are never quiet.
+func inQuietHours(spec string, now time.Time) bool {
+	if spec == "" || spec == "off" {
+		return false
+	}
+	start, end, err := parseQuietHours(spec)
+	if err != nil {
+		return false
+	}
+	minute := now.Hour()*60 + now.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// quietHours returns the effective quiet hours of a user: their own setting or QUIET_HOURS
+func (n *Notifier) quietHours(userID int64) string {
+	spec, ok, err := n.db.GetQuietHours(userID)
+	if err != nil {
+		log.Printf("[Notify] Failed to load quiet hours for %d: %v", userID, err)
+	}
+	if ok {
+		return spec
+	}
+	return n.bot.config.QuietHours
+}
+
+// Start starts the loop that delivers digests when quiet hours end
+func (n *Notifier) Start() {
+	go func() {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
+
+		n.flushDigests()
+		for {
+			select {
+			case <-n.stop:
+				return
+			case <-ticker.C:
+				n.flushDigests()
+			}
+		}
+	}()
+	log.Println("[Notify] Notifier started")
+}
+
+// Stop stops the digest loop and pending urgent re-notifications
+func (n *Notifier) Stop() {
+	close(n.stop)
+	n.mu.Lock()
+	for id, u := range n.urgent {
+		u.timer.Stop()
+		delete(n.urgent, id)
+	}
+	n.mu.Unlock()
+}
+
+// Notify delivers a notification to a user according to its source route, severity and the user's quiet hours
+func (n *Notifier) Notify(userID int64, note Notification) error {
+	if strings.TrimSpace(note.Text) == "" {
+		return nil
+	}
+	if route, err := n.db.GetNotificationRoute(note.Source); err != nil {
+		log.Printf("[Notify] Failed to load route for %s: %v", note.Source, err)
+	} else if route != "" {
+		note.Severity = route
+	}
+	if !validSeverity(note.Severity) {
+		note.Severity = NotifyNormal
+	}
+
+	quiet := inQuietHours(n.quietHours(userID), time.Now())
+
+	switch note.Severity {
+	case NotifyUrgent:
+		return n.sendUrgent(userID, note)
+	case NotifyLow:
+		if quiet && note.Keyboard == nil {
+			log.Printf("[Notify] Quiet hours: %s notification for %d queued for the digest", note.Source, userID)
+			return n.db.AddDigestItem(userID, note.Source, note.Text)
+		}
+		_, err := n.deliver(userID, note.Text, true, note.Keyboard)
+		return err
+	default:
+		_, err := n.deliver(userID, note.Text, quiet, note.Keyboard)
+		return err
+	}
+}
+
+// deliver sends text to a chat, splitting long messages; the keyboard goes on the last chunk
+func (n *Notifier) deliver(chatID int64, text string, silent bool, keyboard *tgbotapi.InlineKeyboardMarkup) (tgbotapi.Message, error) {
+	var sent tgbotapi.Message
+	chunks := splitMessage(text, 4096)
+	for i, chunk := range chunks {
+		msg := tgbotapi.NewMessage(chatID, chunk)
+		msg.ParseMode = "Markdown"
+		msg.DisableNotification = silent
+		if keyboard != nil && i == len(chunks)-1 {
+			msg.ReplyMarkup = *keyboard
+		}
+		var err error
+		sent, err = n.bot.api.Send(msg)
+		if err != nil && strings.Contains(err.Error(), "can't parse entities") {
+			msg.ParseMode = ""
+			sent, err = n.bot.api.Send(msg)
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// sendUrgent sends an urgent notification with an acknowledge button and re-sends it until acknowledged
+func (n *Notifier) sendUrgent(userID int64, note Notification) error {
+	id := fmt.Sprintf("%d", time.Now().UnixNano())
+	u := &urgentNotice{userID: userID, note: note}
+
+	n.mu.Lock()
+	n.urgent[id] = u
+	n.mu.Unlock()
+
+	return n.sendUrgentAttempt(id, u)
+}
+
+// sendUrgentAttempt sends one copy of an urgent notification and arms the next re-notification
+func (n *Notifier) sendUrgentAttempt(id string, u *urgentNotice) error {
+	text := u.note.Text
+	if u.sent > 0 {
+		text = fmt.Sprintf("🔁 Still waiting for acknowledgement (%d):\n\n%s", u.sent, text)
+	}
+
+	if _, err := n.deliver(u.userID, text, false, urgentKeyboard(id, u.note.Keyboard)); err != nil {
+		n.mu.Lock()
+		delete(n.urgent, id)
+		n.mu.Unlock()
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.urgent[id]; !ok {
+		return nil // acknowledged while sending
+	}
+	u.sent++
+	if u.sent > n.bot.config.UrgentRenotifyMax {
+		log.Printf("[Notify] Urgent %s notification %s never acknowledged, giving up", u.note.Source, id)
+		delete(n.urgent, id)
+		return nil
+	}
+	u.timer = time.AfterFunc(n.bot.config.UrgentRenotifyInterval, func() {
+		n.mu.Lock()
+		_, pending := n.urgent[id]
+		n.mu.Unlock()
+		if !pending {
+			return
+		}
+		if err := n.sendUrgentAttempt(id, u); err != nil {
+			log.Printf("[Notify] Failed to re-send urgent notification %s: %v", id, err)
+		}
+	})
+	return nil
+}
+
+// urgentKeyboard adds the acknowledge button to a notification's own buttons
+func urgentKeyboard(id string, keyboard *tgbotapi.InlineKeyboardMarkup) *tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if keyboard != nil {
+		rows = append(rows, keyboard.InlineKeyboard...)
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("👌 Got it", "notify_ack:"+id),
+	))
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return &markup
+}
+
+// Acknowledge stops the re-notifications of an urgent notification
+func (n *Notifier) Acknowledge(id string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	u, ok := n.urgent[id]
+	if !ok {
+		return false
+	}
+	if u.timer != nil {
+		u.timer.Stop()
+	}
+	delete(n.urgent, id)
+	return true
+}
+
+// flushDigests delivers the queued notifications of every user whose quiet hours are over
+func (n *Notifier) flushDigests() {
+	users, err := n.db.GetDigestUsers()
+	if err != nil {
+		log.Printf("[Notify] Failed to load digest users: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, userID := range users {
+		if inQuietHours(n.quietHours(userID), now) {
+			continue
+		}
+		if err := n.SendDigest(userID); err != nil {
+			log.Printf("[Notify] Failed to send digest to %d: %v", userID, err)
+		}
+	}
+}
+
+// SendDigest delivers the queued notifications of a user as a single message
+func (n *Notifier) SendDigest(userID int64) error {
+	items, err := n.db.TakeDigestItems(userID)
+	if err != nil || len(items) == 0 {
+		return err
+	}
+
+	log.Printf("[Notify] Sending digest of %d notification(s) to %d", len(items), userID)
+	_, err = n.deliver(userID, formatDigest(items), false, nil)
+	return err
+}
+
+// formatDigest renders queued notifications as one message
+func formatDigest(items []DigestItem) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("🌅 *While you were away* (%d)\n", len(items)))
+	for _, item := range items {
+		sb.WriteString(fmt.Sprintf("\n`%s` %s · %s", item.CreatedAt.Local().Format("15:04"), item.Source, truncate(item.Text, 300)))
+	}
+	return sb.String()
+}
+
+// notify sends an admin notification through the notification policy
+func (b *Bot) notify(source, severity, text string) error {
+	return b.notifyWithKeyboard(source, severity, text, nil)
+}
+
+// notifyWithKeyboard sends an admin notification with buttons through the notification policy
+func (b *Bot) notifyWithKeyboard(source, severity, text string, keyboard *tgbotapi.InlineKeyboardMarkup) error {
+	if b.config.AdminID == 0 {
+		return nil
+	}
+	if b.notifier == nil {
+		if keyboard != nil {
+			return b.sendMessageWithKeyboard(b.config.AdminID, text, *keyboard)
+		}
+		return b.sendMessage(b.config.AdminID, text)
+	}
+	return b.notifier.Notify(b.config.AdminID, Notification{Source: source, Severity: severity, Text: text, Keyboard: keyboard})
+}
+
+// handleQuiet shows or changes the user's quiet hours: /quiet [HH:MM-HH:MM|off|default]
+func (b *Bot) handleQuiet(msg *tgbotapi.Message) error {
+	arg := strings.TrimSpace(msg.CommandArguments())
+	userID := msg.From.ID
+
+	switch arg {
+	case "":
+	case "default":
+		if err := b.db.SetQuietHours(userID, ""); err != nil {
+			return b.sendMessage(msg.Chat.ID, fmt.Sprintf("Error: %v", err))
+		}
+	case "off":
+		if err := b.db.SetQuietHours(userID, "off"); err != nil {
+			return b.sendMessage(msg.Chat.ID, fmt.Sprintf("Error: %v", err))
+		}
+	default:
+		if _, _, err := parseQuietHours(arg); err != nil {
+			return b.sendMessage(msg.Chat.ID, fmt.Sprintf("❌ %v\nUsage: /quiet 23:00-08:00 | off | default", err))
+		}
+		if err := b.db.SetQuietHours(userID, arg); err != nil {
+			return b.sendMessage(msg.Chat.ID, fmt.Sprintf("Error: %v", err))
+		}
+	}
+
+	spec, ok, err := b.db.GetQuietHours(userID)
+	if err != nil {
+		return b.sendMessage(msg.Chat.ID, fmt.Sprintf("Error: %v", err))
+	}
+	if !ok {
+		spec = b.config.QuietHours
+	}
+
+	var text string
+	if spec == "" || spec == "off" {
+		text = "🔔 Quiet hours: *off*"
+	} else {
+		text = fmt.Sprintf("🌙 Quiet hours: *%s*\nLow-priority notifications are saved for a digest, normal ones arrive silently, urgent ones still ring.", spec)
+		if inQuietHours(spec, time.Now()) {
+			text += "\n\n_Quiet hours are active now._"
+		}
+	}
+	if queued, err := b.db.CountDigestItems(userID); err == nil && queued > 0 {
+		text += fmt.Sprintf("\n📥 %d notification(s) waiting for the digest.", queued)
+	}
+	return b.sendMessage(msg.Chat.ID, text)
+}
+
+// handleNotifyAckCallback acknowledges an urgent notification
+func (b *Bot) handleNotifyAckCallback(callback *tgbotapi.CallbackQuery, id string) error {
+	if b.notifier != nil {
+		b.notifier.Acknowledge(id)
+	}
+	if callback.Message != nil {
+		editMsg := tgbotapi.NewEditMessageText(
+			callback.Message.Chat.ID,
+			callback.Message.MessageID,
+			callback.Message.Text+"\n\n👌 Acknowledged",
+		)
+		b.api.Send(editMsg)
+	}
+	b.api.Send(tgbotapi.NewCallback(callback.ID, "Acknowledged"))
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	tests := []struct {
+		spec       string
+		start, end int
+		wantErr    bool
+	}{
+		{"23:00-08:00", 23 * 60, 8 * 60, false},
+		{"12:30-13:15", 12*60 + 30, 13*60 + 15, false},
+		{" 22:00 - 06:30 ", 22 * 60, 6*60 + 30, false},
+		{"00:00-23:59", 0, 23*60 + 59, false},
+		{"22:00", 0, 0, true},
+		{"22:00-22:00", 0, 0, true},
+		{"25:00-08:00", 0, 0, true},
+		{"10pm-8am", 0, 0, true},
+		{"", 0, 0, true},
+	}
+	for _, tt := range tests {
+		start, end, err := parseQuietHours(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseQuietHours(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if start != tt.start || end != tt.end {
+			t.Errorf("parseQuietHours(%q) = %d, %d, want %d, %d", tt.spec, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2026, 3, 14, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		spec string
+		now  time.Time
+		want bool
+	}{
+		// Across midnight
+		{"23:00-08:00", at(23, 0), true},
+		{"23:00-08:00", at(2, 30), true},
+		{"23:00-08:00", at(7, 59), true},
+		{"23:00-08:00", at(8, 0), false},
+		{"23:00-08:00", at(12, 0), false},
+		{"23:00-08:00", at(22, 59), false},
+		// Within a day
+		{"12:00-13:30", at(12, 0), true},
+		{"12:00-13:30", at(13, 29), true},
+		{"12:00-13:30", at(13, 30), false},
+		{"12:00-13:30", at(11, 59), false},
+		{"12:00-13:30", at(0, 0), false},
+		// Never quiet
+		{"", at(3, 0), false},
+		{"off", at(3, 0), false},
+		{"not a range", at(3, 0), false},
+	}
+	for _, tt := range tests {
+		if got := inQuietHours(tt.spec, tt.now); got != tt.want {
+			t.Errorf("inQuietHours(%q, %s) = %v, want %v", tt.spec, tt.now.Format("15:04"), got, tt.want)
+		}
+	}
+}
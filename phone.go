@@ -250,7 +250,7 @@ func (d *PhoneDevice) handleCallStart(msg PhoneMessage) {
 	if msg.Direction == "outgoing" {
 		direction = "saliente"
 	}
-	d.bridge.bot.sendMessage(d.bridge.bot.config.AdminID,
+	d.bridge.bot.notify(SourcePhone, NotifyNormal,
 		fmt.Sprintf("📱 Llamada %s de %s (%s)", direction, msg.From, msg.CallerName))
 }
 
@@ -287,7 +287,7 @@ Key behaviors:
 
 	if err := d.connectGemini(session, prompt); err != nil {
 		log.Printf("[Phone] Failed to connect Gemini: %v", err)
-		d.bridge.bot.sendMessage(d.bridge.bot.config.AdminID,
+		d.bridge.bot.notify(SourcePhone, NotifyNormal,
 			fmt.Sprintf("❌ Error conectando con Gemini: %v", err))
 		return
 	}
@@ -492,9 +492,15 @@ func (d *PhoneDevice) generateSummary(session *phoneSession, duration time.Durat
 	session.mu.Unlock()
 
 	if len(transcript) == 0 {
-		d.bridge.bot.sendMessage(d.bridge.bot.config.AdminID, fmt.Sprintf(
+		d.bridge.bot.notify(SourceCall, NotifyNormal, fmt.Sprintf(
 			"📱 *Llamada finalizada*\nDe: %s\nDuración: %s\n\n_Sin transcripción disponible_",
 			session.from, duration))
+		// Nobody spoke: record it as a missed call
+		d.bridge.bot.events.Publish(Event{
+			Type:    EventCallEnded,
+			Subject: session.from,
+			Data:    map[string]string{"from": session.from, "duration": duration.String(), "via": "android", "missed": "true"},
+		})
 		return
 	}
 
@@ -533,21 +539,28 @@ func (d *PhoneDevice) generateSummary(session *phoneSession, duration time.Durat
 		}
 	}
 
-	d.bridge.bot.sendMessage(d.bridge.bot.config.AdminID, fmt.Sprintf(
+	d.bridge.bot.notify(SourceCall, NotifyNormal, fmt.Sprintf(
 		"📱 *Llamada finalizada*\nDe: %s\nDuración: %s\n\n*Resumen:*\n%s",
 		session.from, duration, summary))
 
 	// Pass to brain for follow-up actions
 	callContext := fmt.Sprintf("[LLAMADA TELEFÓNICA (Android) COMPLETADA]\nDe: %s\nDuración: %s\nResumen: %s\n\nSi hay acciones pendientes, créalas ahora.",
 		session.from, duration, summary)
-	go d.bridge.bot.ProcessSystemEvent(d.bridge.bot.config.AdminID, callContext)
+	go d.bridge.bot.ProcessSystemEvent(d.bridge.bot.config.AdminID, SourceCall, callContext)
+
+	d.bridge.bot.events.Publish(Event{
+		Type:    EventCallEnded,
+		Subject: session.from,
+		Data:    map[string]string{"from": session.from, "duration": duration.String(), "via": "android", "missed": "false"},
+		Text:    summary,
+	})
 }
 
 func (p *PhoneBridge) registerDevice(device *PhoneDevice) {
 	p.devices.Store(device.ID, device)
 	log.Printf("[Phone] Device '%s' (%s) connected", device.ID, device.DeviceType)
 
-	p.bot.sendMessage(p.bot.config.AdminID,
+	p.bot.notify(SourcePhone, NotifyLow,
 		fmt.Sprintf("📱 Teléfono '%s' conectado", device.ID))
 }
 
@@ -556,7 +569,7 @@ func (p *PhoneBridge) unregisterDevice(device *PhoneDevice) {
 		p.devices.Delete(device.ID)
 		log.Printf("[Phone] Device '%s' disconnected", device.ID)
 
-		p.bot.sendMessage(p.bot.config.AdminID,
+		p.bot.notify(SourcePhone, NotifyLow,
 			fmt.Sprintf("📱 Teléfono '%s' desconectado", device.ID))
 	}
 
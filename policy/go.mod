@@ -0,0 +1,3 @@
+module minerva/policy
+
+go 1.24.0
@@ -0,0 +1,177 @@
+// Package policy limits what the Minerva server may ask an agent to do: which directories
+// and files it may touch, and which kinds of task it accepts. minerva-agent, the relay
+// agent (cmd/agent) and the Android agent all load it from ~/.minerva-agent.json and check
+// every request against it before anything starts.
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Task types a policy can allow. Not every agent receives every type (relay and Android
+// agents only get task and read_file), but all of them are accepted so one config file
+// works for any agent.
+const (
+	TaskTypeTask     = "task"
+	TaskTypeFollowUp = "follow_up"
+	TaskTypeReadFile = "read_file"
+	TaskTypeFilePush = "file_push"
+	TaskTypeUpdate   = "update" // installing a signed release of the agent itself
+)
+
+// defaultForbiddenPaths are never used as a working directory or file path, whatever the config says
+var defaultForbiddenPaths = []string{
+	"~/.ssh",
+	"~/.gnupg",
+	"~/.aws",
+	"~/.kube",
+	"~/.docker",
+	"~/.config/gcloud",
+	"~/.minerva-agent.json",
+}
+
+// Policy limits what the server may ask this agent to do. It is enforced here, before
+// anything starts, so a compromised server or an injected prompt can't point Claude
+// (which runs with --dangerously-skip-permissions) at directories like ~/.ssh.
+type Policy struct {
+	// AllowedRoots: task directories and files must be inside one of these (empty = anywhere)
+	AllowedRoots []string `json:"allowed_roots"`
+	// ForbiddenPaths are refused on top of defaultForbiddenPaths
+	ForbiddenPaths []string `json:"forbidden_paths"`
+	// AllowedTaskTypes: task, follow_up, read_file, file_push, update (empty = all but update:
+	// a self-update replaces the binary that enforces this policy, so it must be listed)
+	AllowedTaskTypes []string `json:"allowed_task_types"`
+	// AllowedExecutors: claude, shell and configured executors this agent offers (empty = all;
+	// only minerva-agent has executors to choose from)
+	AllowedExecutors []string `json:"allowed_executors"`
+}
+
+// Load reads the policy from the agent config file. A missing file means the
+// default policy; a file that can't be parsed is an error rather than no policy.
+func Load(path string) (*Policy, error) {
+	p := &Policy{}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, p); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	for i, root := range p.AllowedRoots {
+		p.AllowedRoots[i] = ResolvePath(expandHome(root, home))
+	}
+	forbidden := append(append([]string{}, defaultForbiddenPaths...), p.ForbiddenPaths...)
+	p.ForbiddenPaths = p.ForbiddenPaths[:0]
+	for _, f := range forbidden {
+		p.ForbiddenPaths = append(p.ForbiddenPaths, ResolvePath(expandHome(f, home)))
+	}
+	for _, t := range p.AllowedTaskTypes {
+		switch t {
+		case TaskTypeTask, TaskTypeFollowUp, TaskTypeReadFile, TaskTypeFilePush, TaskTypeUpdate:
+		default:
+			return nil, fmt.Errorf("unknown task type %q in allowed_task_types", t)
+		}
+	}
+	return p, nil
+}
+
+// AllowType rejects task types the policy doesn't allow
+func (p *Policy) AllowType(taskType string) error {
+	if len(p.AllowedTaskTypes) == 0 && taskType != TaskTypeUpdate {
+		return nil
+	}
+	for _, t := range p.AllowedTaskTypes {
+		if t == taskType {
+			return nil
+		}
+	}
+	return fmt.Errorf("rejected by agent policy: %s tasks are not allowed on this agent", taskType)
+}
+
+// CheckPath resolves path (relative to base) and rejects it if it is forbidden or
+// outside the allowed roots. Returns the resolved path, which is what should be used.
+func (p *Policy) CheckPath(path, base string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(base, path)
+	}
+	resolved := ResolvePath(path)
+
+	for _, f := range p.ForbiddenPaths {
+		if IsWithin(resolved, f) {
+			return "", fmt.Errorf("rejected by agent policy: %s is inside forbidden path %s", resolved, f)
+		}
+	}
+	if len(p.AllowedRoots) == 0 {
+		return resolved, nil
+	}
+	for _, root := range p.AllowedRoots {
+		if IsWithin(resolved, root) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("rejected by agent policy: %s is outside the allowed roots (%s)", resolved, strings.Join(p.AllowedRoots, ", "))
+}
+
+// String summarizes the policy for the startup log
+func (p *Policy) String() string {
+	roots := "anywhere"
+	if len(p.AllowedRoots) > 0 {
+		roots = strings.Join(p.AllowedRoots, ", ")
+	}
+	types := "all but update"
+	if len(p.AllowedTaskTypes) > 0 {
+		types = strings.Join(p.AllowedTaskTypes, ", ")
+	}
+	executors := "all"
+	if len(p.AllowedExecutors) > 0 {
+		executors = strings.Join(p.AllowedExecutors, ", ")
+	}
+	return fmt.Sprintf("roots: %s; task types: %s; executors: %s; %d forbidden paths", roots, types, executors, len(p.ForbiddenPaths))
+}
+
+func expandHome(path, home string) string {
+	if path == "~" {
+		return home
+	}
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(home, path[2:])
+	}
+	return path
+}
+
+// ResolvePath makes path absolute and follows symlinks in the part of it that exists,
+// so a symlink can't lead out of an allowed root or into a forbidden path
+func ResolvePath(path string) string {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+
+	rest := ""
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(real, rest)
+		}
+		if dir == filepath.Dir(dir) {
+			return path
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+	}
+}
+
+// IsWithin reports whether path is root or inside it
+func IsWithin(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,145 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadConfig loads a policy from config with HOME set to home
+func loadConfig(t *testing.T, home, config string) (*Policy, error) {
+	t.Helper()
+	t.Setenv("HOME", home)
+	path := filepath.Join(t.TempDir(), "agent.json")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestCheckPath(t *testing.T) {
+	home := ResolvePath(t.TempDir())
+	projects := filepath.Join(home, "projects")
+	for _, dir := range []string{filepath.Join(projects, "app"), filepath.Join(home, "projects-old"), filepath.Join(home, ".ssh"), filepath.Join(home, "secrets")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A link inside the allowed root that leads out of it
+	if err := os.Symlink(filepath.Join(home, ".ssh"), filepath.Join(projects, "keys")); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := loadConfig(t, home, `{"allowed_roots": ["~/projects"], "forbidden_paths": ["~/projects/app/.env"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, path, base string
+		want             string // resolved path, "" if rejected
+	}{
+		{"root itself", projects, "/", projects},
+		{"inside root", filepath.Join(projects, "app"), "/", filepath.Join(projects, "app")},
+		{"not created yet", filepath.Join(projects, "new", "file.go"), "/", filepath.Join(projects, "new", "file.go")},
+		{"relative to base", "main.go", filepath.Join(projects, "app"), filepath.Join(projects, "app", "main.go")},
+		{"relative escape", "../../secrets", filepath.Join(projects, "app"), ""},
+		{"outside root", filepath.Join(home, "secrets"), "/", ""},
+		{"sibling with root as prefix", filepath.Join(home, "projects-old"), "/", ""},
+		{"dot-dot out of root", filepath.Join(projects, "..", "secrets"), "/", ""},
+		{"symlink out of root", filepath.Join(projects, "keys", "id_ed25519"), "/", ""},
+		{"configured forbidden path", filepath.Join(projects, "app", ".env"), "/", ""},
+		{"default forbidden path", filepath.Join(home, ".ssh"), "/", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := p.CheckPath(tt.path, tt.base)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("CheckPath(%q) = %q, want rejection", tt.path, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CheckPath(%q) = %v", tt.path, err)
+			}
+			if got != tt.want {
+				t.Errorf("CheckPath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPathNoRoots(t *testing.T) {
+	home := ResolvePath(t.TempDir())
+	p, err := loadConfig(t, home, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.CheckPath(filepath.Join(home, "anything"), "/"); err != nil {
+		t.Errorf("no allowed roots should allow any path: %v", err)
+	}
+	if _, err := p.CheckPath(filepath.Join(home, ".aws", "credentials"), "/"); err == nil {
+		t.Error("default forbidden paths should apply without allowed roots")
+	}
+}
+
+func TestAllowType(t *testing.T) {
+	tests := []struct {
+		name     string
+		allowed  []string
+		taskType string
+		want     bool
+	}{
+		{"default task", nil, TaskTypeTask, true},
+		{"default file push", nil, TaskTypeFilePush, true},
+		{"default update", nil, TaskTypeUpdate, false},
+		{"listed types", []string{TaskTypeTask, TaskTypeFollowUp}, TaskTypeFollowUp, true},
+		{"listed task", []string{TaskTypeReadFile}, TaskTypeReadFile, true},
+		{"unlisted task", []string{TaskTypeReadFile}, TaskTypeTask, false},
+		{"listed update", []string{TaskTypeTask, TaskTypeUpdate}, TaskTypeUpdate, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Policy{AllowedTaskTypes: tt.allowed}
+			if err := p.AllowType(tt.taskType); (err == nil) != tt.want {
+				t.Errorf("AllowType(%s) with %v = %v, want allowed %v", tt.taskType, tt.allowed, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	home := t.TempDir()
+	tests := []struct {
+		name, config, wantErr string
+	}{
+		{"empty", `{}`, ""},
+		{"all types", `{"allowed_task_types": ["task", "follow_up", "read_file", "file_push", "update"]}`, ""},
+		{"unknown type", `{"allowed_task_types": ["task", "shell"]}`, `unknown task type "shell"`},
+		{"invalid JSON", `{"allowed_roots": `, "failed to parse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadConfig(t, home, tt.config)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Load() = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Load() = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv("HOME", home)
+		p, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+		if err != nil {
+			t.Fatalf("Load() = %v", err)
+		}
+		if len(p.ForbiddenPaths) != len(defaultForbiddenPaths) {
+			t.Errorf("missing config has %d forbidden paths, want the %d defaults", len(p.ForbiddenPaths), len(defaultForbiddenPaths))
+		}
+	})
+}
@@ -0,0 +1,3 @@
+module minerva/protocol
+
+go 1.24.0
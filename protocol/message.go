@@ -0,0 +1,123 @@
+package protocol
+
+// Message is a message between Minerva and an agent. Type says which fields are set.
+type Message struct {
+	Type string `json:"type"`
+
+	// Register
+	Name     string   `json:"name,omitempty"`
+	Cwd      string   `json:"cwd,omitempty"`
+	Password string   `json:"password,omitempty"` // shared AGENT_PASSWORD, built into the agent with ldflags
+	Token    string   `json:"token,omitempty"`    // per-agent token (also in enrolled)
+	Projects []string `json:"projects,omitempty"` // folders in the agent's home directory
+	// Protocol versions the agent speaks (register), and the one the server picked (welcome)
+	ProtocolVersion    int `json:"protocol_version,omitempty"`
+	MinProtocolVersion int `json:"min_protocol_version,omitempty"`
+	// Version is the agent's build (register) or the release to install (update)
+	Version string `json:"version,omitempty"`
+	// Git state, languages and build systems of each project (projects replies)
+	ProjectInfo []ProjectInfo `json:"project_info,omitempty"`
+	// IDs of tasks the agent is still executing; nil for agents that don't report them
+	RunningTasks *[]string `json:"running_tasks,omitempty"`
+	// Max tasks the agent runs at once; 0 leaves it to the server default
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// Optional features the agent supports (Cap* constants)
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Executors the agent can run tasks with; nil for agents that only run Claude
+	Executors []string `json:"executors,omitempty"`
+	// Labels and hardware tasks are routed by (e.g. os:linux, has-docker, repo:minerva)
+	Labels    []string   `json:"labels,omitempty"`
+	Resources *Resources `json:"resources,omitempty"`
+	// Host load, memory, disk, battery and versions (heartbeats)
+	Health *Health `json:"health,omitempty"`
+
+	// Task
+	ID     string `json:"id,omitempty"`
+	Prompt string `json:"prompt,omitempty"`
+	Dir    string `json:"dir,omitempty"` // overrides the agent's working directory
+	// Claude session: resumed by follow_up, reported back with the result
+	SessionID string `json:"session_id,omitempty"`
+	// Worktree names the isolated git worktree the task runs in (empty = the live checkout);
+	// also set on a result whose changes await review, and on worktree actions
+	Worktree string `json:"worktree,omitempty"`
+	Action   string `json:"action,omitempty"` // worktree_action: apply, branch or discard; file_begin: update
+	// Executor runs the task: claude (the default), shell or a CLI tool configured on the agent
+	Executor string `json:"executor,omitempty"`
+	// Budget limits the task; agents with the budget capability stop it when it runs over
+	Budget *Budget `json:"budget,omitempty"`
+
+	// Result
+	Output   string `json:"output,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"duration_ms,omitempty"`
+	DiffStat string `json:"diff_stat,omitempty"` // git diff --stat of a worktree task's changes
+	// Turns and cost the executor reported (0 if it doesn't), and the limit that stopped the
+	// task if it ran over its budget (its output is then partial)
+	Turns          int     `json:"turns,omitempty"`
+	CostUSD        float64 `json:"cost_usd,omitempty"`
+	BudgetExceeded string  `json:"budget_exceeded,omitempty"`
+
+	// File upload / chunked transfer (the transfer ID goes in ID)
+	FileName string `json:"file_name,omitempty"`
+	FileSize int64  `json:"file_size,omitempty"`
+	FileData string `json:"file_data,omitempty"` // base64 encoded
+	TaskID   string `json:"task_id,omitempty"`   // task that produced the file
+	Checksum string `json:"checksum,omitempty"`  // hex SHA-256 of the whole file
+	Offset   int64  `json:"offset,omitempty"`    // chunk position, or bytes the receiver holds in an ack
+	Done     bool   `json:"done,omitempty"`      // ack: file complete and verified; update_result: restarting now
+
+	// The agent's platform (register) or the release's (update), and the release's base64
+	// ed25519 signature of UpdateSignedData
+	OS        string `json:"os,omitempty"`
+	Arch      string `json:"arch,omitempty"`
+	Signature string `json:"signature,omitempty"`
+
+	// RefType is the type of the message an unsupported reply refers to (its ID goes in ID)
+	RefType string `json:"ref_type,omitempty"`
+}
+
+// ProjectInfo describes a project folder on an agent. Agents that only report paths
+// (relay agents, older minerva-agents) fill in Path alone.
+type ProjectInfo struct {
+	Path         string   `json:"path"`
+	Git          bool     `json:"git,omitempty"`
+	Branch       string   `json:"branch,omitempty"`
+	Dirty        bool     `json:"dirty,omitempty"`
+	Ahead        int      `json:"ahead,omitempty"`  // commits not on the upstream branch
+	Behind       int      `json:"behind,omitempty"` // upstream commits not pulled
+	LastCommit   string   `json:"last_commit,omitempty"`
+	LastCommitAt string   `json:"last_commit_at,omitempty"` // RFC3339
+	Languages    []string `json:"languages,omitempty"`
+	BuildSystems []string `json:"build_systems,omitempty"`
+	ClaudeMD     bool     `json:"claude_md,omitempty"`
+}
+
+// Resources is the hardware an agent reports at registration
+type Resources struct {
+	CPUs     int   `json:"cpus"`
+	MemoryMB int64 `json:"memory_mb,omitempty"` // 0 if unknown
+}
+
+// Budget caps what a task may use. Zero fields are unlimited. Turns and cost only apply
+// to executors that report them (Claude).
+type Budget struct {
+	MaxDurationSec int64   `json:"max_duration_sec,omitempty"` // wall time
+	MaxTurns       int     `json:"max_turns,omitempty"`
+	MaxCostUSD     float64 `json:"max_cost_usd,omitempty"`
+}
+
+// Health is a snapshot of an agent's host, sent in heartbeats. Zero values mean unknown.
+type Health struct {
+	Load1          float64 `json:"load1,omitempty"` // 1-minute load average
+	CPUs           int     `json:"cpus,omitempty"`
+	MemTotalMB     int64   `json:"mem_total_mb,omitempty"`
+	MemAvailableMB int64   `json:"mem_available_mb,omitempty"`
+	DiskTotalMB    int64   `json:"disk_total_mb,omitempty"` // filesystem of the agent's working directory
+	DiskFreeMB     int64   `json:"disk_free_mb,omitempty"`
+	Battery        *int    `json:"battery,omitempty"` // percent; nil without a battery
+	Charging       bool    `json:"charging,omitempty"`
+	UptimeSec      int64   `json:"uptime_sec,omitempty"`
+	AgentVersion   string  `json:"agent_version,omitempty"`
+	ClaudeVersion  string  `json:"claude_version,omitempty"`
+}
@@ -0,0 +1,263 @@
+// Package protocol defines the JSON messages Minerva and its agents exchange over
+// WebSocket (directly, or through the encrypted relay). The server, minerva-agent,
+// the relay agent (cmd/agent) and the Android agent all build against it, so a new
+// message type or field only has to be added here.
+//
+// Versions:
+//
+//	1: everything before versioning. Agents that send no protocol_version speak it.
+//	2: register carries the agent's protocol_version and min_protocol_version; the server
+//	   answers with welcome and the version it picked, and either side answers a message
+//	   type it doesn't handle with unsupported instead of dropping it.
+//	3: register carries the agent's build version; update and update_result let the server
+//	   install a signed agent binary (capability self_update).
+//	4: the server confirms each result with result_ack, so agents keep undelivered results
+//	   (and a running task's ID) until then and resend them after reconnecting. Agents
+//	   without an outbox (relay, Android) stay on 3.
+//
+// Optional features are advertised as capabilities at registration. From version 2 on,
+// the server only sends a message type that needs a capability to agents advertising it.
+package protocol
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+const (
+	// Version is the newest protocol version this build speaks
+	Version = 4
+	// MinVersion is the oldest protocol version this build still accepts
+	MinVersion = 1
+	// OutboxVersion promises that the agent keeps each result until result_ack. Agents
+	// that send a result once and forget it speak at most OutboxVersion-1.
+	OutboxVersion = 4
+)
+
+// Message types
+const (
+	MsgRegister     = "register"
+	MsgWelcome      = "welcome" // the server accepted the agent's protocol (v2)
+	MsgTask         = "task"
+	MsgFollowUp     = "follow_up" // a task that resumes an earlier task's Claude session
+	MsgAck          = "ack"
+	MsgResult       = "result"
+	MsgResultAck    = "result_ack" // v4: the server stored the result, the agent can forget it
+	MsgPing         = "ping"
+	MsgPong         = "pong"
+	MsgHeartbeat    = "heartbeat"
+	MsgProgress     = "progress" // one-line summary of a step the agent's Claude took
+	MsgListProjects = "list_projects"
+	MsgProjects     = "projects"
+	MsgKill         = "kill"
+	MsgKilled       = "killed"
+	MsgFileUpload   = "file_upload" // whole file in one message, for peers without chunked transfers
+	MsgFileBegin    = "file_begin"
+	MsgFileChunk    = "file_chunk"
+	MsgFileAck      = "file_ack"
+	MsgReadFile     = "read_file"
+	MsgFileContent  = "file_content"
+	MsgEnrolled     = "enrolled" // the agent's own token, sent once the admin approved it
+	MsgError        = "error"
+	MsgUnsupported  = "unsupported" // reply to a message type the receiver doesn't handle (v2)
+	// Review of a worktree task's changes
+	MsgWorktreeAction = "worktree_action"
+	MsgWorktreeResult = "worktree_result"
+	// Self-update: install the signed binary pushed with file_begin (Action "update") and restart
+	MsgUpdate       = "update"
+	MsgUpdateResult = "update_result"
+)
+
+// since is the protocol version that introduced each message type
+var since = map[string]int{
+	MsgRegister:       1,
+	MsgWelcome:        2,
+	MsgTask:           1,
+	MsgFollowUp:       1,
+	MsgAck:            1,
+	MsgResult:         1,
+	MsgPing:           1,
+	MsgPong:           1,
+	MsgHeartbeat:      1,
+	MsgProgress:       1,
+	MsgListProjects:   1,
+	MsgProjects:       1,
+	MsgKill:           1,
+	MsgKilled:         1,
+	MsgFileUpload:     1,
+	MsgFileBegin:      1,
+	MsgFileChunk:      1,
+	MsgFileAck:        1,
+	MsgReadFile:       1,
+	MsgFileContent:    1,
+	MsgEnrolled:       1,
+	MsgError:          1,
+	MsgUnsupported:    2,
+	MsgWorktreeAction: 1,
+	MsgWorktreeResult: 1,
+	MsgUpdate:         3,
+	MsgUpdateResult:   3,
+	MsgResultAck:      4,
+}
+
+// Capabilities agents advertise at registration
+const (
+	CapWorktree     = "worktree"      // runs tasks in isolated git worktrees and handles worktree_action
+	CapFollowUp     = "follow_up"     // resumes a task's Claude session
+	CapReadFile     = "read_file"     // answers read_file
+	CapFileTransfer = "file_transfer" // receives files in chunks (file_begin, file_chunk)
+	CapSelfUpdate   = "self_update"   // installs signed agent binaries (built with an update key)
+	CapBudget       = "budget"        // stops tasks that exceed their budget and says so in the result
+)
+
+// requires maps server-to-agent message types to the capability an agent needs for them
+var requires = map[string]string{
+	MsgFollowUp:       CapFollowUp,
+	MsgReadFile:       CapReadFile,
+	MsgFileBegin:      CapFileTransfer,
+	MsgFileChunk:      CapFileTransfer,
+	MsgWorktreeAction: CapWorktree,
+	MsgUpdate:         CapSelfUpdate,
+}
+
+// Known reports whether msgType is a message type of any protocol version
+func Known(msgType string) bool {
+	_, ok := since[msgType]
+	return ok
+}
+
+// Supports reports whether a peer speaking version handles msgType
+func Supports(version int, msgType string) bool {
+	v, ok := since[msgType]
+	return ok && v <= version
+}
+
+// HasCapability reports whether capabilities include c
+func HasCapability(capabilities []string, c string) bool {
+	return slices.Contains(capabilities, c)
+}
+
+// Check reports why a peer speaking version with capabilities can't handle msgType, if
+// it can't. Peers older than version 2 don't advertise capabilities and aren't held to them.
+func Check(version int, capabilities []string, msgType string) error {
+	if !Supports(version, msgType) {
+		if !Known(msgType) {
+			return fmt.Errorf("unknown message type %q", msgType)
+		}
+		return fmt.Errorf("%s needs protocol v%d, the agent speaks v%d", msgType, since[msgType], version)
+	}
+	if c, ok := requires[msgType]; ok && version >= 2 && !HasCapability(capabilities, c) {
+		return fmt.Errorf("%s needs the %s capability, which the agent doesn't have", msgType, c)
+	}
+	return nil
+}
+
+// Negotiation failures, wrapped with the versions involved
+var (
+	ErrPeerTooOld = errors.New("peer protocol too old")
+	ErrPeerTooNew = errors.New("peer protocol too new")
+)
+
+// Negotiate picks the version to speak with a peer that supports minVersion..maxVersion
+// (both 0 for peers that predate versioning): the newest one both sides speak.
+func Negotiate(minVersion, maxVersion int) (int, error) {
+	if maxVersion == 0 {
+		maxVersion = 1
+	}
+	if minVersion == 0 || minVersion > maxVersion {
+		minVersion = maxVersion
+	}
+	if maxVersion < MinVersion {
+		return 0, fmt.Errorf("%w: it speaks v%d, at least v%d is needed", ErrPeerTooOld, maxVersion, MinVersion)
+	}
+	if minVersion > Version {
+		return 0, fmt.Errorf("%w: it needs at least v%d, this side speaks up to v%d", ErrPeerTooNew, minVersion, Version)
+	}
+	return min(maxVersion, Version), nil
+}
+
+// Unsupported is the reply to msg from a peer that doesn't handle its type
+func Unsupported(msg Message, version int) Message {
+	return Message{
+		Type:    MsgUnsupported,
+		ID:      msg.ID,
+		RefType: msg.Type,
+		Error:   fmt.Sprintf("unsupported message type %q (protocol v%d)", msg.Type, version),
+	}
+}
+
+// UpdateSignedData is what an agent release's ed25519 signature covers: the binary's
+// SHA-256 and what it is, so a signed binary can't be passed off as another version or platform
+func UpdateSignedData(version, goos, goarch, checksum string) []byte {
+	return []byte(fmt.Sprintf("minerva-agent\n%s\n%s/%s\n%s\n", version, goos, goarch, checksum))
+}
+
+// Update signature failures
+var (
+	ErrBadUpdateKey       = errors.New("invalid update key")
+	ErrBadUpdateSignature = errors.New("release not signed with the update key")
+)
+
+// VerifyUpdate checks a release's base64 signature of UpdateSignedData against a base64
+// ed25519 public key
+func VerifyUpdate(publicKey, signature, version, goos, goarch, checksum string) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return ErrBadUpdateKey
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), UpdateSignedData(version, goos, goarch, checksum), sig) {
+		return ErrBadUpdateSignature
+	}
+	return nil
+}
+
+var releaseVersionPattern = regexp.MustCompile(`^v?(\d+)\.(\d+)\.(\d+)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// ParseVersion parses a release version like v1.2.3 or 1.2.3-rc1
+func ParseVersion(v string) (parts [4]string, ok bool) {
+	m := releaseVersionPattern.FindStringSubmatch(v)
+	if m == nil {
+		return parts, false
+	}
+	copy(parts[:], m[1:])
+	return parts, true
+}
+
+// CompareVersions compares two release versions (-1, 0 or 1). ok is false when either
+// isn't a release version, e.g. a dev build.
+func CompareVersions(a, b string) (cmp int, ok bool) {
+	pa, okA := ParseVersion(a)
+	pb, okB := ParseVersion(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	for i := 0; i < 3; i++ {
+		na, _ := strconv.Atoi(pa[i])
+		nb, _ := strconv.Atoi(pb[i])
+		if na != nb {
+			if na < nb {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	// A pre-release comes before its release
+	switch {
+	case pa[3] == pb[3]:
+		return 0, true
+	case pa[3] == "":
+		return 1, true
+	case pb[3] == "":
+		return -1, true
+	case pa[3] < pb[3]:
+		return -1, true
+	default:
+		return 1, true
+	}
+}
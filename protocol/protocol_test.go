@@ -0,0 +1,134 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name     string
+		min, max int
+		want     int
+		wantErr  error
+	}{
+		{"predates versioning", 0, 0, 1, nil},
+		{"max only", 0, 2, 2, nil},
+		{"same range", MinVersion, Version, Version, nil},
+		{"older peer", 1, 3, 3, nil},
+		{"newer peer", 2, Version + 3, Version, nil},
+		{"min above max", 5, 1, 1, nil},
+		{"peer needs newer", Version + 1, Version + 2, 0, ErrPeerTooNew},
+		{"peer too old", -1, -1, 0, ErrPeerTooOld},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Negotiate(tt.min, tt.max)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Negotiate(%d, %d) error = %v, want %v", tt.min, tt.max, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Negotiate(%d, %d) = %d, want %d", tt.min, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name         string
+		version      int
+		capabilities []string
+		msgType      string
+		wantErr      bool
+	}{
+		{"v1 task", 1, nil, MsgTask, false},
+		{"v1 capability not required", 1, nil, MsgFollowUp, false},
+		{"v1 unsupported", 1, nil, MsgUnsupported, true},
+		{"v2 missing capability", 2, nil, MsgFollowUp, true},
+		{"v2 with capability", 2, []string{CapFollowUp}, MsgFollowUp, false},
+		{"v2 other capability", 2, []string{CapWorktree}, MsgFileBegin, true},
+		{"v2 unsupported", 2, nil, MsgUnsupported, false},
+		{"v2 update", 2, []string{CapSelfUpdate}, MsgUpdate, true},
+		{"v3 update", 3, []string{CapSelfUpdate}, MsgUpdate, false},
+		{"v3 update without capability", 3, []string{CapWorktree}, MsgUpdate, true},
+		{"v3 result ack", 3, nil, MsgResultAck, true},
+		{"v4 result ack", 4, nil, MsgResultAck, false},
+		{"unknown type", Version, nil, "bogus", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check(tt.version, tt.capabilities, tt.msgType)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check(%d, %v, %s) = %v, want error %v", tt.version, tt.capabilities, tt.msgType, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyUpdate(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base64.StdEncoding.EncodeToString(pub)
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, UpdateSignedData("v1.2.0", "linux", "amd64", "abc123")))
+
+	tests := []struct {
+		name                            string
+		key, sig                        string
+		version, goos, goarch, checksum string
+		wantErr                         error
+	}{
+		{"valid", key, sig, "v1.2.0", "linux", "amd64", "abc123", nil},
+		{"other version", key, sig, "v1.3.0", "linux", "amd64", "abc123", ErrBadUpdateSignature},
+		{"other platform", key, sig, "v1.2.0", "windows", "amd64", "abc123", ErrBadUpdateSignature},
+		{"other arch", key, sig, "v1.2.0", "linux", "arm64", "abc123", ErrBadUpdateSignature},
+		{"other binary", key, sig, "v1.2.0", "linux", "amd64", "def456", ErrBadUpdateSignature},
+		{"other key", base64.StdEncoding.EncodeToString(otherPub), sig, "v1.2.0", "linux", "amd64", "abc123", ErrBadUpdateSignature},
+		{"no signature", key, "", "v1.2.0", "linux", "amd64", "abc123", ErrBadUpdateSignature},
+		{"signature not base64", key, "!!!", "v1.2.0", "linux", "amd64", "abc123", ErrBadUpdateSignature},
+		{"no key", "", sig, "v1.2.0", "linux", "amd64", "abc123", ErrBadUpdateKey},
+		{"short key", base64.StdEncoding.EncodeToString(pub[:16]), sig, "v1.2.0", "linux", "amd64", "abc123", ErrBadUpdateKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyUpdate(tt.key, tt.sig, tt.version, tt.goos, tt.goarch, tt.checksum)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("VerifyUpdate() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+		ok   bool
+	}{
+		{"v1.2.3", "v1.2.3", 0, true},
+		{"1.2.3", "v1.2.3", 0, true},
+		{"v1.2.4", "v1.2.3", 1, true},
+		{"v1.10.0", "v1.9.0", 1, true},
+		{"v1.9.9", "v2.0.0", -1, true},
+		{"v1.2.3-rc1", "v1.2.3", -1, true},
+		{"v1.2.3", "v1.2.3-rc1", 1, true},
+		{"v1.2.3-rc1", "v1.2.3-rc2", -1, true},
+		{"v1.2.3+build5", "v1.2.3", 0, true},
+		{"dev", "v1.2.3", 0, false},
+		{"v1.2", "v1.2.3", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := CompareVersions(tt.a, tt.b)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("CompareVersions(%q, %q) = %d, %v, want %d, %v", tt.a, tt.b, got, ok, tt.want, tt.ok)
+		}
+	}
+}
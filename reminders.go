@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Snooze options offered on reminder notifications, keyed by their callback value
+var reminderSnoozeOptions = []struct {
+	key   string
+	label string
+}{
+	{"10m", "💤 10m"},
+	{"1h", "💤 1h"},
+	{"tomorrow", "💤 Tomorrow"},
+}
+
+// reschedulePromptPattern extracts the reminder ID from the reschedule prompt the user replies to
+var reschedulePromptPattern = regexp.MustCompile(`^🗓 When should reminder #(\d+) fire again\?`)
+
+// reminderKeyboard builds the Snooze / Done / Reschedule buttons of a reminder
+func reminderKeyboard(taskID int64) tgbotapi.InlineKeyboardMarkup {
+	var snoozeRow []tgbotapi.InlineKeyboardButton
+	for _, opt := range reminderSnoozeOptions {
+		snoozeRow = append(snoozeRow, tgbotapi.NewInlineKeyboardButtonData(opt.label, fmt.Sprintf("remind_snooze:%d:%s", taskID, opt.key)))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		snoozeRow,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Done", fmt.Sprintf("remind_done:%d", taskID)),
+			tgbotapi.NewInlineKeyboardButtonData("🗓 Reschedule…", fmt.Sprintf("remind_reschedule:%d", taskID)),
+		),
+	)
+}
+
+// sendReminder sends a reminder notification with its action buttons
+func (b *Bot) sendReminder(task ScheduledTask, title string) {
+	if b.config.AdminID == 0 {
+		return
+	}
+
+	text := fmt.Sprintf("%s:\n*%s*", title, task.Description)
+	if task.RequireAck {
+		text += "\n\n_I'll keep reminding you until you press Done._"
+	}
+
+	keyboard := reminderKeyboard(task.ID)
+	if err := b.notifyWithKeyboard(SourceReminder, NotifyNormal, text, &keyboard); err != nil {
+		log.Printf("[Reminder] Failed to send reminder %d: %v", task.ID, err)
+	}
+}
+
+// snoozeUntil returns the time a reminder snoozed with the given option fires again
+func snoozeUntil(option string, now time.Time) (time.Time, error) {
+	if option == "tomorrow" {
+		return now.AddDate(0, 0, 1), nil
+	}
+	d, err := time.ParseDuration(option)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("invalid snooze option %q", option)
+	}
+	return now.Add(d), nil
+}
+
+// handleReminderCallback handles the Snooze / Done / Reschedule buttons of a reminder.
+// payload is "ID" or, for snooze, "ID:OPTION".
+func (b *Bot) handleReminderCallback(callback *tgbotapi.CallbackQuery, action, payload string) error {
+	if !b.isAdmin(callback.From.ID) {
+		b.api.Send(tgbotapi.NewCallback(callback.ID, "Only the admin can manage reminders"))
+		return nil
+	}
+
+	idStr, option, _ := strings.Cut(payload, ":")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	task, err := b.db.GetScheduledTask(id)
+	if err != nil {
+		b.api.Send(tgbotapi.NewCallback(callback.ID, "Reminder not found"))
+		return nil
+	}
+
+	switch action {
+	case "remind_snooze":
+		at, err := snoozeUntil(option, time.Now())
+		if err != nil {
+			return err
+		}
+		if err := b.db.SnoozeScheduledTask(id, at); err != nil {
+			b.api.Send(tgbotapi.NewCallback(callback.ID, fmt.Sprintf("Error: %v", err)))
+			return nil
+		}
+		log.Printf("[Reminder] Reminder %d snoozed until %s", id, at.Format(time.RFC3339))
+		b.closeReminderMessage(callback, fmt.Sprintf("💤 Snoozed until %s", at.Format("Mon 02 Jan 15:04")))
+		b.api.Send(tgbotapi.NewCallback(callback.ID, "Snoozed"))
+
+	case "remind_done":
+		switch {
+		case task.Status == "unacknowledged" && b.scheduler != nil:
+			if _, err := b.scheduler.AcknowledgeReminder(id); err != nil {
+				return err
+			}
+			log.Printf("[Reminder] Reminder %d acknowledged", id)
+		case task.Status == "pending" && task.Recurring == "none":
+			// Done on an older copy of a snoozed reminder: drop the snooze
+			if err := b.db.UpdateScheduledTaskStatus(id, "completed", "done by user"); err != nil {
+				return err
+			}
+		}
+		b.closeReminderMessage(callback, "✅ Done")
+		b.api.Send(tgbotapi.NewCallback(callback.ID, "Marked as done"))
+
+	case "remind_reschedule":
+		prompt := tgbotapi.NewMessage(callback.Message.Chat.ID,
+			fmt.Sprintf("🗓 When should reminder #%d fire again?\n%s\n\nReply to this message with the new time (e.g. \"tomorrow at 9\", \"Friday 18:00\").", id, task.Description))
+		prompt.ReplyMarkup = tgbotapi.ForceReply{ForceReply: true, Selective: true}
+		if _, err := b.api.Send(prompt); err != nil {
+			return err
+		}
+		b.api.Send(tgbotapi.NewCallback(callback.ID, ""))
+	}
+
+	return nil
+}
+
+// closeReminderMessage removes the buttons of a reminder message and appends its outcome
+func (b *Bot) closeReminderMessage(callback *tgbotapi.CallbackQuery, outcome string) {
+	editMsg := tgbotapi.NewEditMessageText(
+		callback.Message.Chat.ID,
+		callback.Message.MessageID,
+		callback.Message.Text+"\n\n"+outcome,
+	)
+	b.api.Send(editMsg)
+}
+
+// rescheduleRequest turns a reply to a reschedule prompt into an instruction for the brain,
+// which parses the requested time and moves the reminder with the CLI.
+// Returns "" if the message is not such a reply.
+func (b *Bot) rescheduleRequest(msg *tgbotapi.Message) string {
+	reply := msg.ReplyToMessage
+	if reply == nil || reply.From == nil || reply.From.ID != b.api.Self.ID {
+		return ""
+	}
+	m := reschedulePromptPattern.FindStringSubmatch(reply.Text)
+	if m == nil {
+		return ""
+	}
+	return fmt.Sprintf("[RESCHEDULE REMINDER #%s] The user wants to move this reminder to: %s\n\nWork out the exact time and run `minerva schedule reschedule %s --at \"<RFC3339 time>\"`, then confirm the new time to the user.",
+		m[1], msg.Text, m[1])
+}
@@ -14,13 +14,55 @@ type ScheduledTask struct {
 	ScheduledAt time.Time
 	AgentName   string
 	WorkingDir  string
-	Status      string // pending, running, completed, failed
+	Status      string // pending, running, waiting, blocked, unacknowledged, completed, failed, skipped, cancelled
 	Result      string
 	CreatedAt   time.Time
 	Recurring   string // none, daily, weekly, monthly
 	LastRunAt   *time.Time
+
+	// Retry policy and tracking of the dispatched agent task
+	Attempts     int
+	MaxAttempts  int           // 0 = use SCHEDULE_MAX_ATTEMPTS
+	RetryBackoff time.Duration // 0 = use SCHEDULE_RETRY_BACKOFF
+	WaitForAgent bool          // queue until the agent reconnects instead of failing
+	AgentTaskID  string        // agent task ID of the current run
+
+	// Workflow (task chain) membership
+	WorkflowID   int64  // ID of the workflow's root task (0 = standalone task)
+	DependsOn    int64  // ID of the step this one runs after (0 = none)
+	RunCondition string // on_success, on_failure or always
+	InputContext string // output of the previous step, passed to this step
+
+	// How the agent runs the task: in an isolated git worktree whose changes wait for
+	// review, and with which executor ("" = claude)
+	Worktree bool
+	Executor string
+
+	// Reminders that keep pinging until the user presses Done
+	RequireAck bool
+}
+
+// Conditions for running a dependent step
+const (
+	RunOnSuccess = "on_success"
+	RunOnFailure = "on_failure"
+	RunAlways    = "always"
+)
+
+// ScheduleRetryPolicy controls how failed agent runs are retried
+type ScheduleRetryPolicy struct {
+	MaxAttempts  int
+	RetryBackoff time.Duration
+	WaitForAgent bool
 }
 
+// MaxRetryDelay caps the exponential backoff between attempts of a scheduled agent run
+const MaxRetryDelay = 24 * time.Hour
+
+const scheduledTaskColumns = `id, description, scheduled_at, agent_name, working_dir, status, result, created_at, recurring, last_run_at,
+	attempts, max_attempts, retry_backoff, wait_for_agent, agent_task_id,
+	workflow_id, depends_on, run_condition, input_context, require_ack, worktree, executor`
+
 // InitScheduleTable creates the scheduled_tasks table
 func (db *DB) InitScheduleTable() error {
 	_, err := db.Exec(`
@@ -41,7 +83,37 @@ func (db *DB) InitScheduleTable() error {
 		return fmt.Errorf("failed to create scheduled_tasks table: %w", err)
 	}
 
+	// Columns added after the initial schema
+	columns := []struct{ name, definition string }{
+		{"attempts", "INTEGER NOT NULL DEFAULT 0"},
+		{"max_attempts", "INTEGER NOT NULL DEFAULT 0"},
+		{"retry_backoff", "INTEGER NOT NULL DEFAULT 0"}, // seconds
+		{"wait_for_agent", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"agent_task_id", "TEXT NOT NULL DEFAULT ''"},
+		{"workflow_id", "INTEGER NOT NULL DEFAULT 0"},
+		{"depends_on", "INTEGER NOT NULL DEFAULT 0"},
+		{"run_condition", "TEXT NOT NULL DEFAULT ''"},
+		{"input_context", "TEXT NOT NULL DEFAULT ''"},
+		{"require_ack", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"next_ping_at", "DATETIME"},
+		{"worktree", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"executor", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, col := range columns {
+		if err := db.addColumnIfMissing("scheduled_tasks", col.name, col.definition); err != nil {
+			return err
+		}
+	}
+
 	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_scheduled_tasks_status ON scheduled_tasks(status, scheduled_at)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_scheduled_tasks_agent_task ON scheduled_tasks(agent_task_id)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_scheduled_tasks_depends_on ON scheduled_tasks(depends_on, status)`)
 	return err
 }
 
@@ -63,10 +135,18 @@ func (db *DB) CreateScheduledTask(description string, scheduledAt time.Time, age
 	return id, nil
 }
 
+// SetScheduledTaskRetryPolicy sets the retry policy of a scheduled task
+func (db *DB) SetScheduledTaskRetryPolicy(id int64, policy ScheduleRetryPolicy) error {
+	_, err := db.Exec(`
+		UPDATE scheduled_tasks SET max_attempts = ?, retry_backoff = ?, wait_for_agent = ? WHERE id = ?
+	`, policy.MaxAttempts, int64(policy.RetryBackoff/time.Second), policy.WaitForAgent, id)
+	return err
+}
+
 // GetPendingScheduledTasks retrieves pending tasks that are due
 func (db *DB) GetPendingScheduledTasks() ([]ScheduledTask, error) {
 	rows, err := db.Query(`
-		SELECT id, description, scheduled_at, agent_name, working_dir, status, result, created_at, recurring, last_run_at
+		SELECT `+scheduledTaskColumns+`
 		FROM scheduled_tasks
 		WHERE status = 'pending' AND scheduled_at <= ?
 	`, time.Now().Format(time.RFC3339))
@@ -78,12 +158,13 @@ func (db *DB) GetPendingScheduledTasks() ([]ScheduledTask, error) {
 	return scanScheduledTasks(rows)
 }
 
-// GetScheduledTasks retrieves all active scheduled tasks (pending + running)
+// GetScheduledTasks retrieves all active scheduled tasks (pending, running, waiting for an agent,
+// blocked on a dependency or waiting for the user to acknowledge a reminder)
 func (db *DB) GetScheduledTasks() ([]ScheduledTask, error) {
 	rows, err := db.Query(`
-		SELECT id, description, scheduled_at, agent_name, working_dir, status, result, created_at, recurring, last_run_at
+		SELECT ` + scheduledTaskColumns + `
 		FROM scheduled_tasks
-		WHERE status IN ('pending', 'running')
+		WHERE status IN ('pending', 'running', 'waiting', 'blocked', 'unacknowledged')
 		ORDER BY scheduled_at ASC
 	`)
 	if err != nil {
@@ -96,35 +177,33 @@ func (db *DB) GetScheduledTasks() ([]ScheduledTask, error) {
 
 // GetScheduledTask retrieves a single scheduled task by ID
 func (db *DB) GetScheduledTask(id int64) (*ScheduledTask, error) {
-	var t ScheduledTask
-	var scheduledAtStr, createdAtStr string
-	var result sql.NullString
-	var lastRunAt sql.NullString
+	row := db.QueryRow(`SELECT `+scheduledTaskColumns+` FROM scheduled_tasks WHERE id = ?`, id)
+	return scanScheduledTask(row)
+}
 
-	err := db.QueryRow(`
-		SELECT id, description, scheduled_at, agent_name, working_dir, status, result, created_at, recurring, last_run_at
-		FROM scheduled_tasks WHERE id = ?
-	`, id).Scan(&t.ID, &t.Description, &scheduledAtStr, &t.AgentName, &t.WorkingDir, &t.Status, &result, &createdAtStr, &t.Recurring, &lastRunAt)
-	if err != nil {
-		return nil, err
-	}
+// GetRunningScheduledTaskByAgentTask retrieves the running scheduled task that dispatched the given agent task
+func (db *DB) GetRunningScheduledTaskByAgentTask(agentTaskID string) (*ScheduledTask, error) {
+	row := db.QueryRow(`
+		SELECT `+scheduledTaskColumns+` FROM scheduled_tasks
+		WHERE agent_task_id = ? AND status = 'running'
+	`, agentTaskID)
+	return scanScheduledTask(row)
+}
 
-	t.ScheduledAt, _ = time.Parse(time.RFC3339, scheduledAtStr)
-	t.CreatedAt, _ = time.Parse(time.RFC3339, createdAtStr)
-	if result.Valid {
-		t.Result = result.String
-	}
-	if lastRunAt.Valid {
-		parsed, _ := time.Parse(time.RFC3339, lastRunAt.String)
-		t.LastRunAt = &parsed
+// ClaimScheduledTask atomically moves a pending task to running.
+// Returns false if another scheduler tick already claimed it.
+func (db *DB) ClaimScheduledTask(id int64) (bool, error) {
+	result, err := db.Exec(`UPDATE scheduled_tasks SET status = 'running' WHERE id = ? AND status = 'pending'`, id)
+	if err != nil {
+		return false, err
 	}
-
-	return &t, nil
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
 }
 
 // UpdateScheduledTaskStatus updates a task's status and optionally its result
 func (db *DB) UpdateScheduledTaskStatus(id int64, status, result string) error {
-	if status == "completed" || status == "failed" {
+	if status == "completed" || status == "failed" || status == "cancelled" || status == AgentTaskBudgetExceeded {
 		_, err := db.Exec(`
 			UPDATE scheduled_tasks SET status = ?, result = ?, last_run_at = ? WHERE id = ?
 		`, status, result, time.Now().Format(time.RFC3339), id)
@@ -134,6 +213,46 @@ func (db *DB) UpdateScheduledTaskStatus(id int64, status, result string) error {
 	return err
 }
 
+// MarkScheduledTaskDispatched records a new attempt and the agent task it was dispatched as
+func (db *DB) MarkScheduledTaskDispatched(id int64, agentTaskID string) error {
+	_, err := db.Exec(`
+		UPDATE scheduled_tasks SET status = 'running', agent_task_id = ?, result = ? WHERE id = ?
+	`, agentTaskID, fmt.Sprintf("dispatched as agent task %s", agentTaskID), id)
+	return err
+}
+
+// IncrementScheduledTaskAttempts increments and returns the attempt counter of a task
+func (db *DB) IncrementScheduledTaskAttempts(id int64) (int, error) {
+	if _, err := db.Exec(`UPDATE scheduled_tasks SET attempts = attempts + 1 WHERE id = ?`, id); err != nil {
+		return 0, err
+	}
+	var attempts int
+	err := db.QueryRow(`SELECT attempts FROM scheduled_tasks WHERE id = ?`, id).Scan(&attempts)
+	return attempts, err
+}
+
+// RetryScheduledTask puts a failed run back to pending for a later attempt
+func (db *DB) RetryScheduledTask(id int64, nextRun time.Time, lastError string) error {
+	_, err := db.Exec(`
+		UPDATE scheduled_tasks SET scheduled_at = ?, status = 'pending', agent_task_id = '', result = ?, last_run_at = ?
+		WHERE id = ?
+	`, nextRun.Format(time.RFC3339), lastError, time.Now().Format(time.RFC3339), id)
+	return err
+}
+
+// ReleaseWaitingScheduledTasks moves tasks waiting for an agent back to pending, due now.
+// Returns the number of released tasks.
+func (db *DB) ReleaseWaitingScheduledTasks(agentName string) (int64, error) {
+	result, err := db.Exec(`
+		UPDATE scheduled_tasks SET status = 'pending', scheduled_at = ?
+		WHERE status = 'waiting' AND agent_name = ?
+	`, time.Now().Format(time.RFC3339), agentName)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 // RescheduleTask reschedules a recurring task for its next run
 func (db *DB) RescheduleTask(id int64, nextRun time.Time) error {
 	_, err := db.Exec(`
@@ -142,7 +261,94 @@ func (db *DB) RescheduleTask(id int64, nextRun time.Time) error {
 	return err
 }
 
-// DeleteScheduledTask deletes a scheduled task
+// SetScheduledTaskRequireAck sets whether a reminder keeps pinging until acknowledged
+func (db *DB) SetScheduledTaskRequireAck(id int64, requireAck bool) error {
+	_, err := db.Exec(`UPDATE scheduled_tasks SET require_ack = ? WHERE id = ?`, requireAck, id)
+	return err
+}
+
+// SetScheduledTaskExecution sets whether the agent task runs in an isolated git worktree,
+// and with which executor ("" = claude)
+func (db *DB) SetScheduledTaskExecution(id int64, worktree bool, executor string) error {
+	_, err := db.Exec(`UPDATE scheduled_tasks SET worktree = ?, executor = ? WHERE id = ?`, worktree, executor, id)
+	return err
+}
+
+// SnoozeScheduledTask moves a reminder to a new time. A reminder that already fired
+// is put back to pending as a one-off, since recurring ones were already rescheduled.
+func (db *DB) SnoozeScheduledTask(id int64, at time.Time) error {
+	result, err := db.Exec(`
+		UPDATE scheduled_tasks SET
+			scheduled_at = ?,
+			recurring = CASE WHEN status = 'pending' THEN recurring ELSE 'none' END,
+			status = 'pending', attempts = 0, agent_task_id = '', next_ping_at = NULL
+		WHERE id = ? AND status IN ('pending', 'completed', 'unacknowledged')
+	`, at.Format(time.RFC3339), id)
+	if err != nil {
+		return fmt.Errorf("failed to reschedule task: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("scheduled task not found or cannot be rescheduled")
+	}
+	return nil
+}
+
+// MarkScheduledTaskUnacknowledged records that a reminder fired and waits for the user to acknowledge it
+func (db *DB) MarkScheduledTaskUnacknowledged(id int64, nextPing time.Time) error {
+	_, err := db.Exec(`
+		UPDATE scheduled_tasks SET status = 'unacknowledged', result = 'awaiting acknowledgement', last_run_at = ?, next_ping_at = ?
+		WHERE id = ?
+	`, time.Now().Format(time.RFC3339), nextPing.Format(time.RFC3339), id)
+	return err
+}
+
+// GetUnacknowledgedReminders retrieves fired reminders that are due for another ping
+func (db *DB) GetUnacknowledgedReminders() ([]ScheduledTask, error) {
+	rows, err := db.Query(`
+		SELECT `+scheduledTaskColumns+`
+		FROM scheduled_tasks
+		WHERE status = 'unacknowledged' AND next_ping_at <= ?
+	`, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query unacknowledged reminders: %w", err)
+	}
+	defer rows.Close()
+
+	return scanScheduledTasks(rows)
+}
+
+// ClaimReminderPing atomically moves the next ping of a due unacknowledged reminder.
+// Returns false if the reminder was acknowledged or already pinged meanwhile.
+func (db *DB) ClaimReminderPing(id int64, nextPing time.Time) (bool, error) {
+	now := time.Now()
+	result, err := db.Exec(`
+		UPDATE scheduled_tasks SET next_ping_at = ?
+		WHERE id = ? AND status = 'unacknowledged' AND next_ping_at <= ?
+	`, nextPing.Format(time.RFC3339), id, now.Format(time.RFC3339))
+	if err != nil {
+		return false, err
+	}
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+// AcknowledgeScheduledTask completes an unacknowledged reminder.
+// Returns false if the reminder was not waiting for acknowledgement.
+func (db *DB) AcknowledgeScheduledTask(id int64) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE scheduled_tasks SET status = 'completed', result = 'acknowledged', next_ping_at = NULL
+		WHERE id = ? AND status = 'unacknowledged'
+	`, id)
+	if err != nil {
+		return false, err
+	}
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+// DeleteScheduledTask deletes a scheduled task. Steps waiting on it can never run, so
+// they are cancelled like the rest of a cancelled chain.
 func (db *DB) DeleteScheduledTask(id int64) error {
 	result, err := db.Exec(`DELETE FROM scheduled_tasks WHERE id = ?`, id)
 	if err != nil {
@@ -152,9 +358,223 @@ func (db *DB) DeleteScheduledTask(id int64) error {
 	if rows == 0 {
 		return fmt.Errorf("scheduled task not found")
 	}
+	if _, err := db.ResolveDependents(id, "cancelled", ""); err != nil {
+		return fmt.Errorf("failed to cancel the steps after task %d: %w", id, err)
+	}
 	return nil
 }
 
+// CreateScheduledStep creates a task that runs after parentID finishes. It is inserted
+// blocked, together with its dependency, so the scheduler can never claim it before its
+// parent is done, and it joins the parent's workflow. Call ResolveNewStep once its other
+// settings are stored, in case the parent has already finished.
+func (db *DB) CreateScheduledStep(description string, scheduledAt time.Time, agentName, workingDir string, parentID int64, condition string) (int64, error) {
+	if condition == "" {
+		condition = RunOnSuccess
+	}
+	if condition != RunOnSuccess && condition != RunOnFailure && condition != RunAlways {
+		return 0, fmt.Errorf("condition must be one of: on_success, on_failure, always")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var parentWorkflow int64
+	if err := tx.QueryRow(`SELECT workflow_id FROM scheduled_tasks WHERE id = ?`, parentID).Scan(&parentWorkflow); err != nil {
+		return 0, fmt.Errorf("parent task %d not found: %w", parentID, err)
+	}
+
+	// The root of a chain identifies the workflow
+	workflowID := parentWorkflow
+	if workflowID == 0 {
+		workflowID = parentID
+		if _, err := tx.Exec(`UPDATE scheduled_tasks SET workflow_id = ? WHERE id = ?`, workflowID, parentID); err != nil {
+			return 0, fmt.Errorf("failed to start workflow: %w", err)
+		}
+	}
+
+	result, err := tx.Exec(`
+		INSERT INTO scheduled_tasks (description, scheduled_at, agent_name, working_dir, recurring, status,
+			workflow_id, depends_on, run_condition)
+		VALUES (?, ?, ?, ?, 'none', 'blocked', ?, ?, ?)
+	`, description, scheduledAt.Format(time.RFC3339), agentName, workingDir, workflowID, parentID, condition)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create workflow step: %w", err)
+	}
+	id, _ := result.LastInsertId()
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to create workflow step: %w", err)
+	}
+	return id, nil
+}
+
+// ResolveNewStep releases or skips a step created with CreateScheduledStep whose parent
+// had already finished by then
+func (db *DB) ResolveNewStep(id int64) error {
+	step, err := db.GetScheduledTask(id)
+	if err != nil {
+		return fmt.Errorf("scheduled task not found")
+	}
+	parent, err := db.GetScheduledTask(step.DependsOn)
+	if err != nil {
+		return fmt.Errorf("parent task %d not found: %w", step.DependsOn, err)
+	}
+	if isFinalScheduleStatus(parent.Status) {
+		_, err = db.ResolveDependents(parent.ID, parent.Status, parent.Result)
+	}
+	return err
+}
+
+// ResolveDependents releases or skips the blocked steps that depend on a finished task,
+// based on their run condition. Skips and cancellations cascade down the chain.
+// Returns the number of steps released to pending.
+func (db *DB) ResolveDependents(parentID int64, parentStatus, parentResult string) (int, error) {
+	rows, err := db.Query(`
+		SELECT `+scheduledTaskColumns+` FROM scheduled_tasks
+		WHERE depends_on = ? AND status = 'blocked'
+	`, parentID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query dependent tasks: %w", err)
+	}
+	steps, err := scanScheduledTasks(rows)
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, step := range steps {
+		if parentStatus == "cancelled" {
+			db.UpdateScheduledTaskStatus(step.ID, "cancelled", fmt.Sprintf("workflow step #%d cancelled", parentID))
+			n, _ := db.ResolveDependents(step.ID, "cancelled", "")
+			released += n
+			continue
+		}
+
+		if !dependencyMet(step.RunCondition, parentStatus) {
+			db.Exec(`UPDATE scheduled_tasks SET status = 'skipped', result = ? WHERE id = ?`,
+				fmt.Sprintf("skipped: step #%d %s (condition %s)", parentID, parentStatus, step.RunCondition), step.ID)
+			n, _ := db.ResolveDependents(step.ID, "skipped", "")
+			released += n
+			continue
+		}
+
+		runAt := step.ScheduledAt
+		if runAt.Before(time.Now()) {
+			runAt = time.Now()
+		}
+		inputContext := fmt.Sprintf("[PREVIOUS STEP #%d %s]\n%s", parentID, parentStatus, parentResult)
+		_, err := db.Exec(`
+			UPDATE scheduled_tasks SET status = 'pending', scheduled_at = ?, input_context = ? WHERE id = ?
+		`, runAt.Format(time.RFC3339), inputContext, step.ID)
+		if err != nil {
+			return released, fmt.Errorf("failed to release step %d: %w", step.ID, err)
+		}
+		released++
+	}
+	return released, nil
+}
+
+// GetWorkflowSteps retrieves all steps of a workflow (any status), ordered by creation
+func (db *DB) GetWorkflowSteps(workflowID int64) ([]ScheduledTask, error) {
+	rows, err := db.Query(`
+		SELECT `+scheduledTaskColumns+` FROM scheduled_tasks
+		WHERE workflow_id = ?
+		ORDER BY id ASC
+	`, workflowID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query workflow steps: %w", err)
+	}
+	defer rows.Close()
+
+	return scanScheduledTasks(rows)
+}
+
+// CancelWorkflow cancels every unfinished step of the workflow containing the given task
+// (or just the task if it is standalone). Returns the agent task IDs of steps that were running.
+func (db *DB) CancelWorkflow(id int64) ([]string, error) {
+	task, err := db.GetScheduledTask(id)
+	if err != nil {
+		return nil, fmt.Errorf("scheduled task not found")
+	}
+
+	var steps []ScheduledTask
+	if task.WorkflowID != 0 {
+		if steps, err = db.GetWorkflowSteps(task.WorkflowID); err != nil {
+			return nil, err
+		}
+	} else {
+		steps = []ScheduledTask{*task}
+	}
+
+	var running []string
+	for _, step := range steps {
+		if isFinalScheduleStatus(step.Status) {
+			continue
+		}
+		if step.Status == "running" && step.AgentTaskID != "" {
+			running = append(running, step.AgentTaskID)
+		}
+		if err := db.UpdateScheduledTaskStatus(step.ID, "cancelled", "cancelled by user"); err != nil {
+			return running, fmt.Errorf("failed to cancel step %d: %w", step.ID, err)
+		}
+	}
+	return running, nil
+}
+
+// CloneWorkflowSteps copies the steps that depend on oldParentID so they run after newParentID.
+// Used when the root of a recurring workflow is rescheduled.
+func (db *DB) CloneWorkflowSteps(oldParentID, newParentID, workflowID int64) error {
+	rows, err := db.Query(`SELECT `+scheduledTaskColumns+` FROM scheduled_tasks WHERE depends_on = ?`, oldParentID)
+	if err != nil {
+		return fmt.Errorf("failed to query workflow steps: %w", err)
+	}
+	steps, err := scanScheduledTasks(rows)
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		result, err := db.Exec(`
+			INSERT INTO scheduled_tasks (description, scheduled_at, agent_name, working_dir, recurring, status,
+				max_attempts, retry_backoff, wait_for_agent, workflow_id, depends_on, run_condition, require_ack, worktree, executor)
+			VALUES (?, ?, ?, ?, 'none', 'blocked', ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, step.Description, time.Now().Format(time.RFC3339), step.AgentName, step.WorkingDir,
+			step.MaxAttempts, int64(step.RetryBackoff/time.Second), step.WaitForAgent, workflowID, newParentID, step.RunCondition, step.RequireAck, step.Worktree, step.Executor)
+		if err != nil {
+			return fmt.Errorf("failed to clone step %d: %w", step.ID, err)
+		}
+		newID, _ := result.LastInsertId()
+		if err := db.CloneWorkflowSteps(step.ID, newID, workflowID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dependencyMet(condition, parentStatus string) bool {
+	switch condition {
+	case RunAlways:
+		return parentStatus == "completed" || parentStatus == "failed" || parentStatus == "skipped" || parentStatus == AgentTaskBudgetExceeded
+	case RunOnFailure:
+		return parentStatus == "failed"
+	default:
+		return parentStatus == "completed"
+	}
+}
+
+func isFinalScheduleStatus(status string) bool {
+	switch status {
+	case "completed", "failed", "skipped", "cancelled", AgentTaskBudgetExceeded:
+		return true
+	}
+	return false
+}
+
 // NextRecurringTime calculates the next run time for a recurring task
 func NextRecurringTime(current time.Time, recurring string) time.Time {
 	switch recurring {
@@ -198,6 +618,8 @@ func (s *Scheduler) Start() {
 			select {
 			case <-ticker.C:
 				s.checkTasks()
+				s.checkWatchers()
+				s.checkBriefings()
 			case <-s.stop:
 				return
 			}
@@ -219,13 +641,19 @@ func (s *Scheduler) checkTasks() {
 
 	for _, task := range tasks {
 		// Mark as running first to prevent double-processing
-		if err := s.db.UpdateScheduledTaskStatus(task.ID, "running", ""); err != nil {
+		claimed, err := s.db.ClaimScheduledTask(task.ID)
+		if err != nil {
 			log.Printf("[Scheduler] Failed to mark task %d as running: %v", task.ID, err)
 			continue
 		}
+		if !claimed {
+			continue
+		}
 
 		go s.executeTask(task)
 	}
+
+	s.repingReminders()
 }
 
 func (s *Scheduler) executeTask(task ScheduledTask) {
@@ -242,63 +670,278 @@ func (s *Scheduler) executeTask(task ScheduledTask) {
 }
 
 func (s *Scheduler) executeBrainTask(task ScheduledTask) {
-	s.bot.sendMessage(s.bot.config.AdminID, fmt.Sprintf("⏰ Scheduled reminder:\n*%s*", task.Description))
+	s.bot.sendReminder(task, "⏰ Scheduled reminder")
 
 	eventMsg := fmt.Sprintf("[SCHEDULED TASK FIRED] The following scheduled task has triggered:\n\n%s\n\nPlease handle this appropriately - send a message to the user, take action, or do whatever is needed.", task.Description)
-	err := s.bot.ProcessSystemEvent(s.bot.config.AdminID, eventMsg)
+	if task.InputContext != "" {
+		eventMsg += "\n\nThis is a workflow step. Output of the previous step:\n" + task.InputContext
+	}
+	err := s.bot.ProcessSystemEvent(s.bot.config.AdminID, SourceReminder, eventMsg)
 	if err != nil {
 		log.Printf("[Scheduler] Brain task %d failed: %v", task.ID, err)
-		s.db.UpdateScheduledTaskStatus(task.ID, "failed", err.Error())
+		s.finishRun(task, "failed", err.Error())
+	} else if task.RequireAck {
+		log.Printf("[Scheduler] Brain task %d fired, waiting for acknowledgement", task.ID)
+		s.awaitAcknowledgement(task)
 	} else {
-		s.db.UpdateScheduledTaskStatus(task.ID, "completed", "processed by brain")
 		log.Printf("[Scheduler] Brain task %d completed", task.ID)
+		s.finishRun(task, "completed", "processed by brain")
 	}
+}
 
+// awaitAcknowledgement keeps a fired reminder open until the user presses Done.
+// The next occurrence of a recurring reminder is scheduled right away; dependent
+// workflow steps wait for the acknowledgement.
+func (s *Scheduler) awaitAcknowledgement(task ScheduledTask) {
+	if err := s.db.MarkScheduledTaskUnacknowledged(task.ID, time.Now().Add(s.bot.config.ReminderRepingInterval)); err != nil {
+		log.Printf("[Scheduler] Failed to mark task %d as unacknowledged: %v", task.ID, err)
+	}
 	s.handleRecurring(task)
 }
 
-func (s *Scheduler) executeAgentTask(task ScheduledTask) {
-	s.bot.sendMessage(s.bot.config.AdminID, fmt.Sprintf("⏰ Scheduled task starting:\n*%s*\nAgent: %s", task.Description, task.AgentName))
+// repingReminders sends unacknowledged reminders again
+func (s *Scheduler) repingReminders() {
+	tasks, err := s.db.GetUnacknowledgedReminders()
+	if err != nil {
+		log.Printf("[Scheduler] Error getting unacknowledged reminders: %v", err)
+		return
+	}
+
+	for _, task := range tasks {
+		claimed, err := s.db.ClaimReminderPing(task.ID, time.Now().Add(s.bot.config.ReminderRepingInterval))
+		if err != nil {
+			log.Printf("[Scheduler] Failed to update next ping of task %d: %v", task.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		s.bot.sendReminder(task, "🔔 Reminder (not acknowledged yet)")
+	}
+}
+
+// AcknowledgeReminder completes a reminder waiting for acknowledgement and
+// releases the workflow steps that depend on it
+func (s *Scheduler) AcknowledgeReminder(id int64) (bool, error) {
+	acked, err := s.db.AcknowledgeScheduledTask(id)
+	if err != nil || !acked {
+		return acked, err
+	}
+	s.releaseDependents(id, "completed", "acknowledged")
+	return true, nil
+}
 
+func (s *Scheduler) executeAgentTask(task ScheduledTask) {
 	if s.agentHub == nil {
-		s.db.UpdateScheduledTaskStatus(task.ID, "failed", "agent hub not available")
-		s.bot.sendMessage(s.bot.config.AdminID, fmt.Sprintf("❌ Scheduled task failed: %s\nError: agent hub not available", task.Description))
-		s.handleRecurring(task)
+		s.bot.notify(SourceScheduler, NotifyNormal, fmt.Sprintf("❌ Scheduled task failed: %s\nError: agent hub not available", task.Description))
+		s.finishRun(task, "failed", "agent hub not available")
 		return
 	}
 
 	// Check if agent is connected
-	agents := s.agentHub.ListAgents()
-	agentFound := false
-	for _, a := range agents {
-		if a["name"] == task.AgentName {
-			agentFound = true
-			break
+	if !s.agentHub.IsConnected(task.AgentName) {
+		if task.WaitForAgent {
+			// Park the task until the agent registers again
+			s.db.UpdateScheduledTaskStatus(task.ID, "waiting", "")
+			s.bot.notify(SourceScheduler, NotifyNormal, fmt.Sprintf("⏸ Scheduled task waiting for agent '%s' to reconnect:\n*%s*", task.AgentName, task.Description))
+			log.Printf("[Scheduler] Task %d waiting for agent '%s'", task.ID, task.AgentName)
+			return
 		}
+		s.countAttempt(&task)
+		s.handleRunFailure(task, fmt.Sprintf("agent '%s' not connected", task.AgentName))
+		return
 	}
 
-	if !agentFound {
-		errMsg := fmt.Sprintf("agent '%s' not connected", task.AgentName)
-		s.db.UpdateScheduledTaskStatus(task.ID, "failed", errMsg)
-		s.bot.sendMessage(s.bot.config.AdminID, fmt.Sprintf("❌ Scheduled task failed: %s\nError: %s", task.Description, errMsg))
-		s.handleRecurring(task)
-		return
+	s.countAttempt(&task)
+
+	startMsg := fmt.Sprintf("⏰ Scheduled task starting:\n*%s*\nAgent: %s", task.Description, task.AgentName)
+	if maxAttempts := s.maxAttempts(task); maxAttempts > 1 {
+		startMsg += fmt.Sprintf("\nAttempt: %d/%d", task.Attempts, maxAttempts)
 	}
+	s.bot.notify(SourceScheduler, NotifyLow, startMsg)
 
 	// Send task to agent
-	taskID, err := s.agentHub.SendTask(task.AgentName, task.Description, task.WorkingDir)
+	taskID, _, err := s.agentHub.SubmitTask(task.AgentName, stepPrompt(task), task.WorkingDir, TaskOptions{Worktree: task.Worktree, Executor: task.Executor})
 	if err != nil {
-		s.db.UpdateScheduledTaskStatus(task.ID, "failed", err.Error())
-		s.bot.sendMessage(s.bot.config.AdminID, fmt.Sprintf("❌ Scheduled task failed: %s\nError: %v", task.Description, err))
-		s.handleRecurring(task)
+		s.handleRunFailure(task, err.Error())
 		return
 	}
 
-	// Task sent successfully - mark completed (result will come async via agent result handler)
-	s.db.UpdateScheduledTaskStatus(task.ID, "completed", fmt.Sprintf("dispatched as agent task %s", taskID))
+	// Task sent successfully - stay running until the agent reports the final result
+	if err := s.db.MarkScheduledTaskDispatched(task.ID, taskID); err != nil {
+		log.Printf("[Scheduler] Failed to record agent task %s for task %d: %v", taskID, task.ID, err)
+	}
 	log.Printf("[Scheduler] Task %d dispatched as agent task %s", task.ID, taskID)
+}
+
+// HandleAgentTaskDone resolves the scheduled run linked to a finished agent task.
+// Registered as the AgentHub task-done callback.
+func (s *Scheduler) HandleAgentTaskDone(agentTaskID, agentName, status, output string) {
+	task, err := s.db.GetRunningScheduledTaskByAgentTask(agentTaskID)
+	if err == sql.ErrNoRows {
+		return // ad-hoc task, not started by the scheduler
+	}
+	if err != nil {
+		log.Printf("[Scheduler] Failed to look up scheduled task for agent task %s: %v", agentTaskID, err)
+		return
+	}
+
+	switch status {
+	case AgentTaskCompleted:
+		log.Printf("[Scheduler] Task %d completed (agent task %s)", task.ID, agentTaskID)
+		s.finishRun(*task, "completed", truncateText(output, 2000))
+
+	case AgentTaskKilled:
+		// Killed by the user: never retry, and stop the rest of the workflow
+		log.Printf("[Scheduler] Task %d killed (agent task %s)", task.ID, agentTaskID)
+		s.finishRun(*task, "cancelled", "killed by user")
+
+	case AgentTaskCancelled:
+		log.Printf("[Scheduler] Task %d cancelled while queued (agent task %s)", task.ID, agentTaskID)
+		s.finishRun(*task, "cancelled", "cancelled while queued on the agent")
+
+	case AgentTaskStale:
+		s.handleRunFailure(*task, fmt.Sprintf("agent task %s stale: no heartbeat for %v", agentTaskID, TaskStaleThreshold))
+
+	case AgentTaskBudgetExceeded:
+		// Its own outcome: a retry would most likely run over again, and the run neither
+		// succeeded nor failed, so only the workflow's "always" steps follow it
+		limit := "budget exceeded"
+		if stored, err := s.db.GetAgentTask(agentTaskID); err == nil && stored.Error != "" {
+			limit = stored.Error
+		}
+		log.Printf("[Scheduler] Task %d stopped, not retrying (agent task %s): %s", task.ID, agentTaskID, limit)
+		s.bot.notify(SourceScheduler, NotifyNormal, fmt.Sprintf("💸 Scheduled task stopped: %s\nReason: %s\nIt isn't retried, since it would most likely run over again. Raise the limit with: minerva agent budget %s",
+			task.Description, limit, agentName))
+		result := "stopped: " + limit
+		if output != "" {
+			result += "\n\n" + truncateText(output, 2000)
+		}
+		s.finishRun(*task, AgentTaskBudgetExceeded, result)
+
+	default:
+		reason := fmt.Sprintf("agent task %s failed", agentTaskID)
+		if output != "" {
+			reason += ": " + truncateText(output, 500)
+		}
+		s.handleRunFailure(*task, reason)
+	}
+}
+
+// HandleAgentConnected releases tasks that were waiting for the agent to reconnect.
+// Registered as the AgentHub connect callback.
+func (s *Scheduler) HandleAgentConnected(agentName string) {
+	released, err := s.db.ReleaseWaitingScheduledTasks(agentName)
+	if err != nil {
+		log.Printf("[Scheduler] Failed to release waiting tasks for agent '%s': %v", agentName, err)
+		return
+	}
+	if released > 0 {
+		log.Printf("[Scheduler] Agent '%s' reconnected, released %d waiting task(s)", agentName, released)
+		go s.checkTasks()
+	}
+}
+
+// countAttempt records that a run of task starts. If the counter can't be stored, the
+// attempt is still counted for this run so retries stay bounded.
+func (s *Scheduler) countAttempt(task *ScheduledTask) {
+	attempts, err := s.db.IncrementScheduledTaskAttempts(task.ID)
+	if err != nil {
+		log.Printf("[Scheduler] Failed to increment attempts for task %d: %v", task.ID, err)
+		task.Attempts++
+		return
+	}
+	task.Attempts = attempts
+}
+
+// retryDelay is the backoff before the attempt after the given one: backoff, 2*backoff,
+// 4*backoff, ... up to MaxRetryDelay
+func retryDelay(backoff time.Duration, attempts int) time.Duration {
+	shift := min(max(attempts-1, 0), 16)
+	if backoff > MaxRetryDelay>>shift {
+		return MaxRetryDelay
+	}
+	return backoff << shift
+}
+
+// handleRunFailure retries a failed agent run with backoff or marks it failed once attempts are exhausted
+func (s *Scheduler) handleRunFailure(task ScheduledTask, reason string) {
+	maxAttempts := s.maxAttempts(task)
+	if task.Attempts < maxAttempts {
+		delay := retryDelay(s.retryBackoff(task), task.Attempts)
+		nextRun := time.Now().Add(delay)
+		if err := s.db.RetryScheduledTask(task.ID, nextRun, reason); err != nil {
+			log.Printf("[Scheduler] Failed to schedule retry for task %d: %v", task.ID, err)
+		} else {
+			log.Printf("[Scheduler] Task %d attempt %d/%d failed (%s), retrying at %s",
+				task.ID, task.Attempts, maxAttempts, reason, nextRun.Format(time.RFC3339))
+			s.bot.notify(SourceScheduler, NotifyNormal, fmt.Sprintf("🔁 Scheduled task failed (attempt %d/%d): %s\nError: %s\nRetrying in %v",
+				task.Attempts, maxAttempts, task.Description, reason, delay.Round(time.Second)))
+			return
+		}
+	}
 
+	log.Printf("[Scheduler] Task %d failed after %d attempt(s): %s", task.ID, task.Attempts, reason)
+	s.bot.notify(SourceScheduler, NotifyNormal, fmt.Sprintf("❌ Scheduled task failed: %s\nError: %s", task.Description, reason))
+	s.finishRun(task, "failed", reason)
+}
+
+// finishRun records the final outcome of a run, schedules the next occurrence
+// of recurring tasks and releases the workflow steps that depend on it
+func (s *Scheduler) finishRun(task ScheduledTask, status, result string) {
+	if err := s.db.UpdateScheduledTaskStatus(task.ID, status, result); err != nil {
+		log.Printf("[Scheduler] Failed to mark task %d as %s: %v", task.ID, status, err)
+	}
 	s.handleRecurring(task)
+	s.releaseDependents(task.ID, status, result)
+
+	s.bot.events.Publish(Event{
+		Type:    EventScheduleDone,
+		Subject: fmt.Sprintf("%d", task.ID),
+		Data: map[string]string{
+			"id":          fmt.Sprintf("%d", task.ID),
+			"description": task.Description,
+			"status":      status,
+			"agent":       task.AgentName,
+		},
+		Text: result,
+	})
+}
+
+func (s *Scheduler) releaseDependents(taskID int64, status, result string) {
+	released, err := s.db.ResolveDependents(taskID, status, result)
+	if err != nil {
+		log.Printf("[Scheduler] Failed to resolve steps depending on task %d: %v", taskID, err)
+	}
+	if released > 0 {
+		log.Printf("[Scheduler] Task %d %s, released %d dependent step(s)", taskID, status, released)
+		go s.checkTasks()
+	}
+}
+
+// stepPrompt builds the agent prompt for a task, including the previous step's output for workflow steps
+func stepPrompt(task ScheduledTask) string {
+	if task.InputContext == "" {
+		return task.Description
+	}
+	return fmt.Sprintf("%s\n\nThis task is a step of a workflow. Output of the previous step for context:\n%s", task.Description, task.InputContext)
+}
+
+func (s *Scheduler) maxAttempts(task ScheduledTask) int {
+	if task.MaxAttempts > 0 {
+		return task.MaxAttempts
+	}
+	if s.bot.config.ScheduleMaxAttempts > 0 {
+		return s.bot.config.ScheduleMaxAttempts
+	}
+	return 1
+}
+
+func (s *Scheduler) retryBackoff(task ScheduledTask) time.Duration {
+	if task.RetryBackoff > 0 {
+		return task.RetryBackoff
+	}
+	return s.bot.config.ScheduleRetryBackoff
 }
 
 func (s *Scheduler) handleRecurring(task ScheduledTask) {
@@ -321,33 +964,76 @@ func (s *Scheduler) handleRecurring(task ScheduledTask) {
 		log.Printf("[Scheduler] Failed to reschedule recurring task %d: %v", task.ID, err)
 		return
 	}
+	if err := s.db.SetScheduledTaskRetryPolicy(newID, ScheduleRetryPolicy{
+		MaxAttempts:  task.MaxAttempts,
+		RetryBackoff: task.RetryBackoff,
+		WaitForAgent: task.WaitForAgent,
+	}); err != nil {
+		log.Printf("[Scheduler] Failed to copy retry policy to task %d: %v", newID, err)
+	}
+	if task.RequireAck {
+		if err := s.db.SetScheduledTaskRequireAck(newID, true); err != nil {
+			log.Printf("[Scheduler] Failed to copy acknowledgement setting to task %d: %v", newID, err)
+		}
+	}
+	if task.Worktree || task.Executor != "" {
+		if err := s.db.SetScheduledTaskExecution(newID, task.Worktree, task.Executor); err != nil {
+			log.Printf("[Scheduler] Failed to copy worktree mode and executor to task %d: %v", newID, err)
+		}
+	}
+
+	// The root of a workflow brings its chain of steps along
+	if task.WorkflowID == task.ID {
+		if _, err := s.db.Exec(`UPDATE scheduled_tasks SET workflow_id = ? WHERE id = ?`, newID, newID); err != nil {
+			log.Printf("[Scheduler] Failed to start workflow %d: %v", newID, err)
+		} else if err := s.db.CloneWorkflowSteps(task.ID, newID, newID); err != nil {
+			log.Printf("[Scheduler] Failed to clone workflow steps of task %d: %v", task.ID, err)
+		}
+	}
 
 	log.Printf("[Scheduler] Recurring task %d rescheduled as %d for %s", task.ID, newID, nextRun.Format(time.RFC3339))
 }
 
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanScheduledTask(row rowScanner) (*ScheduledTask, error) {
+	var t ScheduledTask
+	var scheduledAtStr, createdAtStr string
+	var result sql.NullString
+	var lastRunAt sql.NullString
+	var retryBackoff int64
+
+	if err := row.Scan(&t.ID, &t.Description, &scheduledAtStr, &t.AgentName, &t.WorkingDir, &t.Status, &result, &createdAtStr, &t.Recurring, &lastRunAt,
+		&t.Attempts, &t.MaxAttempts, &retryBackoff, &t.WaitForAgent, &t.AgentTaskID,
+		&t.WorkflowID, &t.DependsOn, &t.RunCondition, &t.InputContext, &t.RequireAck, &t.Worktree, &t.Executor); err != nil {
+		return nil, err
+	}
+
+	t.ScheduledAt, _ = time.Parse(time.RFC3339, scheduledAtStr)
+	t.CreatedAt, _ = time.Parse(time.RFC3339, createdAtStr)
+	t.RetryBackoff = time.Duration(retryBackoff) * time.Second
+	if result.Valid {
+		t.Result = result.String
+	}
+	if lastRunAt.Valid {
+		parsed, _ := time.Parse(time.RFC3339, lastRunAt.String)
+		t.LastRunAt = &parsed
+	}
+
+	return &t, nil
+}
+
 func scanScheduledTasks(rows *sql.Rows) ([]ScheduledTask, error) {
 	var tasks []ScheduledTask
 	for rows.Next() {
-		var t ScheduledTask
-		var scheduledAtStr, createdAtStr string
-		var result sql.NullString
-		var lastRunAt sql.NullString
-
-		if err := rows.Scan(&t.ID, &t.Description, &scheduledAtStr, &t.AgentName, &t.WorkingDir, &t.Status, &result, &createdAtStr, &t.Recurring, &lastRunAt); err != nil {
+		t, err := scanScheduledTask(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan scheduled task: %w", err)
 		}
-
-		t.ScheduledAt, _ = time.Parse(time.RFC3339, scheduledAtStr)
-		t.CreatedAt, _ = time.Parse(time.RFC3339, createdAtStr)
-		if result.Valid {
-			t.Result = result.String
-		}
-		if lastRunAt.Valid {
-			parsed, _ := time.Parse(time.RFC3339, lastRunAt.String)
-			t.LastRunAt = &parsed
-		}
-
-		tasks = append(tasks, t)
+		tasks = append(tasks, *t)
 	}
 	return tasks, rows.Err()
 }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newScheduleTestDB opens a fresh database with the scheduled_tasks table
+func newScheduleTestDB(t *testing.T) *DB {
+	t.Helper()
+	db, err := InitDB(filepath.Join(t.TempDir(), "minerva.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.InitScheduleTable(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// addStep creates a task that runs after parentID when condition is met
+func addStep(t *testing.T, db *DB, description string, parentID int64, condition string) int64 {
+	t.Helper()
+	id, err := db.CreateScheduledStep(description, time.Now(), "laptop", "", parentID, condition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func scheduleStatus(t *testing.T, db *DB, id int64) string {
+	t.Helper()
+	task, err := db.GetScheduledTask(id)
+	if err != nil {
+		t.Fatalf("task %d: %v", id, err)
+	}
+	return task.Status
+}
+
+func TestDependencyMet(t *testing.T) {
+	tests := []struct {
+		condition, parentStatus string
+		want                    bool
+	}{
+		{RunOnSuccess, "completed", true},
+		{RunOnSuccess, "failed", false},
+		{RunOnSuccess, "skipped", false},
+		{"", "completed", true},
+		{RunOnFailure, "failed", true},
+		{RunOnFailure, "completed", false},
+		{RunOnFailure, "skipped", false},
+		{RunOnSuccess, AgentTaskBudgetExceeded, false},
+		{RunOnFailure, AgentTaskBudgetExceeded, false},
+		{RunAlways, AgentTaskBudgetExceeded, true},
+		{RunAlways, "completed", true},
+		{RunAlways, "failed", true},
+		{RunAlways, "skipped", true},
+	}
+	for _, tt := range tests {
+		if got := dependencyMet(tt.condition, tt.parentStatus); got != tt.want {
+			t.Errorf("dependencyMet(%q, %q) = %v, want %v", tt.condition, tt.parentStatus, got, tt.want)
+		}
+	}
+}
+
+func TestResolveDependents(t *testing.T) {
+	tests := []struct {
+		name         string
+		condition    string
+		parentStatus string
+		want         string // status of the step after root
+		wantNext     string // status of the step after that one
+	}{
+		{"success runs on success", RunOnSuccess, "completed", "pending", "blocked"},
+		{"failure skips on success", RunOnSuccess, "failed", "skipped", "pending"},
+		{"failure runs on failure", RunOnFailure, "failed", "pending", "blocked"},
+		{"success skips on failure", RunOnFailure, "completed", "skipped", "pending"},
+		{"always after success", RunAlways, "completed", "pending", "blocked"},
+		{"always after failure", RunAlways, "failed", "pending", "blocked"},
+		{"cancel cascades", RunAlways, "cancelled", "cancelled", "cancelled"},
+		{"over budget skips on success", RunOnSuccess, AgentTaskBudgetExceeded, "skipped", "pending"},
+		{"over budget skips on failure", RunOnFailure, AgentTaskBudgetExceeded, "skipped", "pending"},
+		{"always after over budget", RunAlways, AgentTaskBudgetExceeded, "pending", "blocked"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newScheduleTestDB(t)
+			root, err := db.CreateScheduledTask("root", time.Now(), "laptop", "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			step := addStep(t, db, "step", root, tt.condition)
+			next := addStep(t, db, "next", step, RunAlways)
+
+			if err := db.UpdateScheduledTaskStatus(root, tt.parentStatus, "root output"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.ResolveDependents(root, tt.parentStatus, "root output"); err != nil {
+				t.Fatal(err)
+			}
+			if got := scheduleStatus(t, db, step); got != tt.want {
+				t.Errorf("step is %s, want %s", got, tt.want)
+			}
+			if got := scheduleStatus(t, db, next); got != tt.wantNext {
+				t.Errorf("next step is %s, want %s", got, tt.wantNext)
+			}
+			if tt.want == "pending" {
+				task, _ := db.GetScheduledTask(step)
+				if task.InputContext == "" {
+					t.Error("released step didn't get the previous step's output")
+				}
+			}
+		})
+	}
+}
+
+func TestCreateScheduledStep(t *testing.T) {
+	db := newScheduleTestDB(t)
+	root, err := db.CreateScheduledTask("root", time.Now(), "laptop", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step := addStep(t, db, "step", root, "")
+	task, err := db.GetScheduledTask(step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != "blocked" || task.DependsOn != root || task.WorkflowID != root || task.RunCondition != RunOnSuccess {
+		t.Errorf("step = %s, depends on %d, workflow %d, %s; want blocked after %d in workflow %d, on_success",
+			task.Status, task.DependsOn, task.WorkflowID, task.RunCondition, root, root)
+	}
+	if parent, _ := db.GetScheduledTask(root); parent.WorkflowID != root {
+		t.Errorf("root is in workflow %d, want %d", parent.WorkflowID, root)
+	}
+
+	// A step after a step joins the root's workflow
+	next := addStep(t, db, "next", step, RunAlways)
+	if task, _ := db.GetScheduledTask(next); task.WorkflowID != root {
+		t.Errorf("second step is in workflow %d, want %d", task.WorkflowID, root)
+	}
+
+	// The parent hasn't run yet, so the step stays blocked
+	if err := db.ResolveNewStep(step); err != nil {
+		t.Fatal(err)
+	}
+	if got := scheduleStatus(t, db, step); got != "blocked" {
+		t.Errorf("step after a pending parent is %s, want blocked", got)
+	}
+
+	if _, err := db.CreateScheduledStep("orphan", time.Now(), "laptop", "", 9999, ""); err == nil {
+		t.Error("step after a missing parent was created")
+	}
+	if _, err := db.CreateScheduledStep("bad", time.Now(), "laptop", "", root, "sometimes"); err == nil {
+		t.Error("step with an unknown condition was created")
+	}
+}
+
+func TestResolveNewStep(t *testing.T) {
+	tests := []struct {
+		name         string
+		parentStatus string
+		condition    string
+		want         string
+	}{
+		{"parent pending", "pending", RunOnSuccess, "blocked"},
+		{"parent running", "running", RunOnSuccess, "blocked"},
+		{"parent completed", "completed", RunOnSuccess, "pending"},
+		{"parent completed, on failure", "completed", RunOnFailure, "skipped"},
+		{"parent failed, on failure", "failed", RunOnFailure, "pending"},
+		{"parent cancelled", "cancelled", RunAlways, "cancelled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newScheduleTestDB(t)
+			root, err := db.CreateScheduledTask("root", time.Now(), "laptop", "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.UpdateScheduledTaskStatus(root, tt.parentStatus, ""); err != nil {
+				t.Fatal(err)
+			}
+			step := addStep(t, db, "step", root, tt.condition)
+			if err := db.ResolveNewStep(step); err != nil {
+				t.Fatal(err)
+			}
+			if got := scheduleStatus(t, db, step); got != tt.want {
+				t.Errorf("step is %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteScheduledTaskCancelsDependents(t *testing.T) {
+	db := newScheduleTestDB(t)
+	root, err := db.CreateScheduledTask("root", time.Now(), "laptop", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	middle := addStep(t, db, "middle", root, RunOnSuccess)
+	last := addStep(t, db, "last", middle, RunAlways)
+
+	if err := db.DeleteScheduledTask(middle); err != nil {
+		t.Fatal(err)
+	}
+	if got := scheduleStatus(t, db, last); got != "cancelled" {
+		t.Errorf("step after the deleted one is %s, want cancelled", got)
+	}
+	if got := scheduleStatus(t, db, root); got != "pending" {
+		t.Errorf("root is %s, want pending", got)
+	}
+	if err := db.DeleteScheduledTask(middle); err == nil {
+		t.Error("deleting a missing task succeeded")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		backoff  time.Duration
+		attempts int
+		want     time.Duration
+	}{
+		{time.Minute, 1, time.Minute},
+		{time.Minute, 2, 2 * time.Minute},
+		{time.Minute, 4, 8 * time.Minute},
+		{time.Minute, 0, time.Minute},
+		{time.Minute, -3, time.Minute},
+		{time.Hour, 6, MaxRetryDelay},
+		{time.Minute, 100, MaxRetryDelay},
+		{0, 3, 0},
+		{10000 * time.Hour, 20, MaxRetryDelay},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.backoff, tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%v, %d) = %v, want %v", tt.backoff, tt.attempts, got, tt.want)
+		}
+	}
+}
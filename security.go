@@ -56,6 +56,12 @@ func extractBearerToken(r *http.Request) string {
 // wsAuthMiddleware validates authentication BEFORE upgrading to WebSocket.
 // It also checks the Origin header against allowed origins.
 func wsAuthMiddleware(password string, allowedOrigins []string, next http.HandlerFunc) http.HandlerFunc {
+	return wsTokenAuthMiddleware(password, nil, allowedOrigins, next)
+}
+
+// wsTokenAuthMiddleware is wsAuthMiddleware that also accepts bearer tokens validToken accepts
+// (e.g. per-agent tokens)
+func wsTokenAuthMiddleware(password string, validToken func(string) bool, allowedOrigins []string, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Check auth token
 		if password != "" {
@@ -64,7 +70,7 @@ func wsAuthMiddleware(password string, allowedOrigins []string, next http.Handle
 				http.Error(w, `{"error": "unauthorized"}`, http.StatusForbidden)
 				return
 			}
-			if subtle.ConstantTimeCompare([]byte(token), []byte(password)) != 1 {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(password)) != 1 && (validToken == nil || !validToken(token)) {
 				http.Error(w, `{"error": "unauthorized"}`, http.StatusForbidden)
 				return
 			}
@@ -52,6 +52,38 @@ func StartServer(config *Config) error {
 		db.Close()
 		return fmt.Errorf("failed to initialize schedule table: %w", err)
 	}
+	if err := db.InitWatcherTable(); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to initialize watchers table: %w", err)
+	}
+	if err := db.InitRulesTable(); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to initialize automation rules table: %w", err)
+	}
+	if err := db.InitNotificationTables(); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to initialize notification tables: %w", err)
+	}
+	if err := db.InitEventLogTable(); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to initialize event log table: %w", err)
+	}
+	if err := db.InitBriefingTable(); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to initialize briefing table: %w", err)
+	}
+	if err := db.InitAgentTaskTable(); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to initialize agent tasks table: %w", err)
+	}
+	if err := db.InitAgentCredentialTable(); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to initialize agent credentials table: %w", err)
+	}
+	if err := db.InitAgentBudgetTable(); err != nil {
+		db.Close()
+		return fmt.Errorf("failed to initialize agent budgets table: %w", err)
+	}
 
 	// Initialize email
 	if config.ResendAPIKey != "" {
@@ -80,34 +112,49 @@ func StartServer(config *Config) error {
 	}
 	log.Println("Bot created")
 
+	// Route notifications through quiet hours and per-source severities
+	bot.notifier = NewNotifier(bot, db)
+	bot.notifier.Start()
+
 	// Initialize task runner
 	bot.taskRunner = NewTaskRunner(bot, config.TasksDir)
 	log.Println("Task runner initialized")
 
 	// Initialize agent hub with notification callback
-	agentNotify := func(message string) {
-		if config.AdminID != 0 {
-			bot.sendMessage(config.AdminID, message)
-		}
+	agentNotify := func(source, message string) {
+		bot.notify(source, NotifyNormal, message)
 	}
 	agentResult := func(message string) {
 		if config.AdminID != 0 {
-			if err := bot.ProcessSystemEvent(config.AdminID, message); err != nil {
+			if err := bot.ProcessSystemEvent(config.AdminID, SourceAgentResult, message); err != nil {
 				log.Printf("[Agent] Failed to process result through AI brain: %v", err)
-				bot.sendMessage(config.AdminID, message)
+				bot.notify(SourceAgentResult, NotifyNormal, message)
 			}
 		}
 	}
 	bot.agentHub = NewAgentHub(config.AgentPassword, agentNotify, agentResult)
+	bot.agentHub.SetDefaultConcurrency(config.AgentMaxConcurrency)
+	bot.agentHub.SetDefaultBudget(config.AgentTaskMaxDuration, config.AgentTaskMaxTurns, config.AgentTaskMaxCost)
+	bot.agentHub.SetTaskStore(db)
+	bot.agentHub.SetReleasesDir(config.AgentReleasesDir)
+	bot.agentHub.SetUpdateKey(config.AgentUpdateKey)
+	bot.events = NewEventBus()
+	bot.events.Subscribe(db.logEvent)
+	bot.agentHub.SetEventBus(bot.events)
 	// Set callback for when agent tasks start (to send Kill button)
 	bot.agentHub.SetTaskStartCallback(bot.sendAgentTaskStartedMessage)
+	bot.agentHub.SetTaskQueuedCallback(bot.sendAgentTaskQueuedMessage)
+	// Unknown agents wait for the admin's approval instead of registering with the shared password
+	bot.agentHub.SetAgentApprovalCallback(bot.sendAgentApprovalRequest)
+	bot.agentHub.SetTaskProgressCallback(bot.updateAgentTaskProgress)
 	// Set callback for file uploads from agents
-	bot.agentHub.SetFileUploadCallback(func(agentName, fileName string, data []byte) {
+	bot.agentHub.SetFileUploadCallback(func(agentName, fileName, path string) {
+		defer os.Remove(path)
 		if config.AdminID != 0 {
-			log.Printf("[Agent] Received file '%s' from agent '%s' (%d bytes), sending to Telegram", fileName, agentName, len(data))
-			if err := bot.sendDocument(config.AdminID, fileName, data); err != nil {
+			log.Printf("[Agent] Received file '%s' from agent '%s', sending to Telegram", fileName, agentName)
+			if err := bot.sendDocumentFile(config.AdminID, fileName, path); err != nil {
 				log.Printf("[Agent] Failed to send file '%s' to Telegram: %v", fileName, err)
-				bot.sendMessage(config.AdminID, fmt.Sprintf("Failed to send file '%s' from agent '%s': %v", fileName, agentName, err))
+				bot.notify(SourceAgentResult, NotifyNormal, fmt.Sprintf("Failed to send file '%s' from agent '%s': %v", fileName, agentName, err))
 			}
 		}
 	})
@@ -127,8 +174,14 @@ func StartServer(config *Config) error {
 
 	// Start scheduler for scheduled tasks
 	state.scheduler = NewScheduler(db, bot, bot.agentHub)
+	bot.agentHub.SetTaskDoneCallback(state.scheduler.HandleAgentTaskDone)
+	bot.agentHub.SetAgentConnectCallback(state.scheduler.HandleAgentConnected)
+	bot.scheduler = state.scheduler
 	state.scheduler.Start()
 
+	// Automation rules react to events from the whole server
+	bot.events.Subscribe(NewRuleEngine(db, bot, state.scheduler).HandleEvent)
+
 	// Initialize Voice AI (Telnyx + Gemini Live)
 	if config.GoogleAPIKey != "" && config.TelnyxAPIKey != "" {
 		state.voiceManager = NewVoiceManager(bot, config.GoogleAPIKey,
@@ -190,6 +243,9 @@ func StopServer() {
 	if serverState.scheduler != nil {
 		serverState.scheduler.Stop()
 	}
+	if serverState.bot.notifier != nil {
+		serverState.bot.notifier.Stop()
+	}
 	serverState.bot.Stop()
 
 	if serverState.relayClient != nil {
@@ -193,18 +193,31 @@ func (tr *TaskRunner) monitorTask(cmd *exec.Cmd, taskID string, userID int64, ta
 		output, _ := os.ReadFile(outputFile)
 		outputStr := string(output)
 
+		status := "completed"
 		if err != nil {
+			status = "failed"
 			tr.bot.db.UpdateTaskStatus(taskID, "failed", outputStr)
 			tr.bot.sendMessage(userID, fmt.Sprintf("❌ *Tarea fallida*\nID: `%s`\n\n%s", taskID, truncateOutput(outputStr, 2000)))
 		} else {
 			tr.bot.db.UpdateTaskStatus(taskID, "completed", outputStr)
 			tr.bot.sendMessage(userID, fmt.Sprintf("✅ *Tarea completada*\nID: `%s`\n\n%s", taskID, truncateOutput(outputStr, 3000)))
 		}
+		tr.bot.events.Publish(Event{
+			Type:    EventTaskDone,
+			Subject: taskID,
+			Data:    map[string]string{"task_id": taskID, "status": status},
+			Text:    outputStr,
+		})
 
 	case <-time.After(TaskTimeout):
 		cmd.Process.Kill()
 		tr.bot.db.UpdateTaskStatus(taskID, "failed", "Task timed out after 24 hours")
 		tr.bot.sendMessage(userID, fmt.Sprintf("⏰ *Tarea cancelada por timeout*\nID: `%s`", taskID))
+		tr.bot.events.Publish(Event{
+			Type:    EventTaskDone,
+			Subject: taskID,
+			Data:    map[string]string{"task_id": taskID, "status": "timeout"},
+		})
 	}
 }
 
@@ -238,7 +238,7 @@ func GetToolDefinitions() []Tool {
 			Type: "function",
 			Function: ToolFunction{
 				Name:        "list_claude_projects",
-				Description: "List all projects (home directories) available on connected Claude Code agents. Use this to see what projects each agent can work on. Returns a map of agent name to list of project folders.",
+				Description: "List all projects (home directories) available on connected Claude Code agents. Use this to see what projects each agent can work on. Returns a map of agent name to its projects, each with its path, git branch, dirty/ahead/behind state, last commit, languages, build systems and whether it has a CLAUDE.md.",
 				Parameters: map[string]any{
 					"type":       "object",
 					"properties": map[string]any{},
@@ -55,17 +55,6 @@ func RunCode(arguments string) (string, error) {
 	})
 	vm.Set("console", console)
 
-	// Add some safe utilities
-	vm.Set("JSON", vm.NewObject())
-	vm.RunString(`
-		JSON.stringify = function(obj, replacer, space) {
-			return JSON.stringify(obj, replacer, space);
-		};
-		JSON.parse = function(str) {
-			return JSON.parse(str);
-		};
-	`)
-
 	// Run with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
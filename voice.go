@@ -239,7 +239,7 @@ func (v *VoiceManager) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 
 	case "streaming.failed":
 		log.Printf("[Voice] Streaming failed for call: %s", event.Data.Payload.CallControlID)
-		v.bot.sendMessage(v.bot.config.AdminID, "❌ Error: streaming de audio falló en la llamada")
+		v.bot.notify(SourceCall, NotifyNormal, "❌ Error: streaming de audio falló en la llamada")
 
 	case "call.hangup":
 		log.Printf("[Voice] Call hangup: cause=%s source=%s",
@@ -262,7 +262,7 @@ func (v *VoiceManager) handleIncomingCall(event telnyxWebhookEvent) {
 	log.Printf("[Voice] Incoming call from %s (ID: %s)", from, callControlID)
 
 	// Notify admin
-	v.bot.sendMessage(v.bot.config.AdminID, fmt.Sprintf("📞 *Llamada entrante*\nDe: %s\nMinerva (Gemini Live) contestando...", from))
+	v.bot.notify(SourceCall, NotifyNormal, fmt.Sprintf("📞 *Llamada entrante*\nDe: %s\nMinerva (Gemini Live) contestando...", from))
 
 	// Build WebSocket URL for media stream
 	wsURL := strings.Replace(v.baseURL, "https://", "wss://", 1)
@@ -284,7 +284,7 @@ func (v *VoiceManager) handleIncomingCall(event telnyxWebhookEvent) {
 
 	if err := v.telnyxCallAction(callControlID, "answer", answerReq); err != nil {
 		log.Printf("[Voice] Failed to answer call: %v", err)
-		v.bot.sendMessage(v.bot.config.AdminID, "❌ Error contestando llamada")
+		v.bot.notify(SourceCall, NotifyNormal, "❌ Error contestando llamada")
 	}
 }
 
@@ -369,7 +369,7 @@ func (v *VoiceManager) HandleMediaStream(w http.ResponseWriter, r *http.Request)
 			// Connect to Gemini Live API
 			if err := v.connectGemini(session); err != nil {
 				log.Printf("[Voice] Failed to connect Gemini: %v", err)
-				v.bot.sendMessage(v.bot.config.AdminID, "❌ Error conectando con Gemini Live para la llamada")
+				v.bot.notify(SourceCall, NotifyNormal, "❌ Error conectando con Gemini Live para la llamada")
 				return
 			}
 
@@ -797,9 +797,15 @@ func (v *VoiceManager) generateSummary(session *voiceSession, duration time.Dura
 	session.mu.Unlock()
 
 	if len(transcript) == 0 {
-		v.bot.sendMessage(v.bot.config.AdminID, fmt.Sprintf(
+		v.bot.notify(SourceCall, NotifyNormal, fmt.Sprintf(
 			"📞 *Llamada finalizada*\nDe: %s\nDuración: %s\n\n_Sin transcripción disponible_",
 			session.from, duration))
+		// Nobody spoke: record it as a missed call
+		v.bot.events.Publish(Event{
+			Type:    EventCallEnded,
+			Subject: session.from,
+			Data:    map[string]string{"from": session.from, "duration": duration.String(), "via": "telnyx", "missed": "true"},
+		})
 		return
 	}
 
@@ -828,7 +834,7 @@ func (v *VoiceManager) generateSummary(session *voiceSession, duration time.Dura
 	response, err := v.bot.ai.Chat(summaryPrompt, "", nil)
 	if err != nil {
 		log.Printf("[Voice] Failed to generate summary: %v", err)
-		v.bot.sendMessage(v.bot.config.AdminID, fmt.Sprintf(
+		v.bot.notify(SourceCall, NotifyNormal, fmt.Sprintf(
 			"📞 *Llamada finalizada*\nDe: %s\nDuración: %s\n\n*Transcripción:*\n%s",
 			session.from, duration, truncateForTelegram(sb.String(), 500)))
 		return
@@ -842,14 +848,21 @@ func (v *VoiceManager) generateSummary(session *voiceSession, duration time.Dura
 	}
 
 	// Send summary to Telegram
-	v.bot.sendMessage(v.bot.config.AdminID, fmt.Sprintf(
+	v.bot.notify(SourceCall, NotifyNormal, fmt.Sprintf(
 		"📞 *Llamada finalizada*\nDe: %s\nDuración: %s\n\n*Resumen:*\n%s",
 		session.from, duration, summary))
 
 	// Pass the summary to the brain so it has context about the call
 	callContext := fmt.Sprintf("[LLAMADA TELEFÓNICA COMPLETADA]\nDe: %s\nDuración: %s\nResumen: %s\n\nSi hay acciones pendientes (callbacks, recordatorios, tareas), créalas ahora. Responde brevemente confirmando qué acciones has tomado (si alguna).",
 		session.from, duration, summary)
-	go v.bot.ProcessSystemEvent(v.bot.config.AdminID, callContext)
+	go v.bot.ProcessSystemEvent(v.bot.config.AdminID, SourceCall, callContext)
+
+	v.bot.events.Publish(Event{
+		Type:    EventCallEnded,
+		Subject: session.from,
+		Data:    map[string]string{"from": session.from, "duration": duration.String(), "via": "telnyx", "missed": "false"},
+		Text:    summary,
+	})
 }
 
 func truncateForTelegram(s string, n int) string {
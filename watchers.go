@@ -0,0 +1,460 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+
+	"minerva/tools"
+)
+
+// Watcher data sources
+const (
+	WatchSourceHTTP = "http" // GET an HTTP endpoint (JSON or text)
+	WatchSourceFile = "file" // read a file on an agent
+	WatchSourceSQL  = "sql"  // read-only query against Minerva's database
+)
+
+// Watcher periodically evaluates a JavaScript predicate against a data source
+// and fires a brain event or agent task when the predicate flips to true
+type Watcher struct {
+	ID          int64
+	Name        string
+	SourceType  string // http, file, sql
+	Source      string // URL, file path or SQL query
+	SourceAgent string // agent holding the file (file sources)
+	Predicate   string // JS expression or function body over `data`
+	Action      string // what to do when it fires (empty = just notify)
+	AgentName   string // run Action on this agent instead of the brain
+	WorkingDir  string
+	Interval    time.Duration
+	Once        bool   // stop watching after the first trigger
+	Status      string // active, paused, fired
+	LastValue   bool
+	LastError   string
+	LastChecked *time.Time
+	LastFired   *time.Time
+	CreatedAt   time.Time
+}
+
+const (
+	// watcherMaxData caps how much source data is fed to the predicate
+	watcherMaxData = 1024 * 1024
+	// watcherFetchTimeout bounds HTTP requests and agent file reads
+	watcherFetchTimeout = 30 * time.Second
+)
+
+const watcherColumns = `id, name, source_type, source, source_agent, predicate, action, agent_name, working_dir,
+	interval_seconds, once, status, last_value, last_error, last_checked_at, last_fired_at, created_at`
+
+// InitWatcherTable creates the watchers table
+func (db *DB) InitWatcherTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS watchers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			source_type TEXT NOT NULL,
+			source TEXT NOT NULL,
+			source_agent TEXT NOT NULL DEFAULT '',
+			predicate TEXT NOT NULL,
+			action TEXT NOT NULL DEFAULT '',
+			agent_name TEXT NOT NULL DEFAULT '',
+			working_dir TEXT NOT NULL DEFAULT '',
+			interval_seconds INTEGER NOT NULL DEFAULT 300,
+			once BOOLEAN NOT NULL DEFAULT FALSE,
+			status TEXT NOT NULL DEFAULT 'active',
+			last_value BOOLEAN NOT NULL DEFAULT FALSE,
+			last_error TEXT NOT NULL DEFAULT '',
+			next_check_at DATETIME NOT NULL,
+			last_checked_at DATETIME,
+			last_fired_at DATETIME,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create watchers table: %w", err)
+	}
+
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_watchers_due ON watchers(status, next_check_at)`)
+	return err
+}
+
+// CreateWatcher creates a new watcher, checked for the first time on the next scheduler tick
+func (db *DB) CreateWatcher(w Watcher) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO watchers (name, source_type, source, source_agent, predicate, action, agent_name, working_dir,
+			interval_seconds, once, next_check_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, w.Name, w.SourceType, w.Source, w.SourceAgent, w.Predicate, w.Action, w.AgentName, w.WorkingDir,
+		int64(w.Interval/time.Second), w.Once, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create watcher: %w", err)
+	}
+
+	id, _ := result.LastInsertId()
+	return id, nil
+}
+
+// GetWatchers retrieves all watchers
+func (db *DB) GetWatchers() ([]Watcher, error) {
+	rows, err := db.Query(`SELECT ` + watcherColumns + ` FROM watchers ORDER BY id ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query watchers: %w", err)
+	}
+	defer rows.Close()
+
+	return scanWatchers(rows)
+}
+
+// GetDueWatchers retrieves active watchers whose next check is due
+func (db *DB) GetDueWatchers() ([]Watcher, error) {
+	rows, err := db.Query(`
+		SELECT `+watcherColumns+` FROM watchers
+		WHERE status = 'active' AND next_check_at <= ?
+	`, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query due watchers: %w", err)
+	}
+	defer rows.Close()
+
+	return scanWatchers(rows)
+}
+
+// ClaimWatcherCheck atomically moves the next check of a due watcher.
+// Returns false if another tick already claimed it.
+func (db *DB) ClaimWatcherCheck(id int64, nextCheck time.Time) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE watchers SET next_check_at = ?
+		WHERE id = ? AND status = 'active' AND next_check_at <= ?
+	`, nextCheck.Format(time.RFC3339), id, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return false, err
+	}
+	rows, _ := result.RowsAffected()
+	return rows == 1, nil
+}
+
+// RecordWatcherCheck stores the outcome of a check
+func (db *DB) RecordWatcherCheck(id int64, value bool, checkErr string) error {
+	_, err := db.Exec(`
+		UPDATE watchers SET last_value = ?, last_error = ?, last_checked_at = ? WHERE id = ?
+	`, value, checkErr, time.Now().Format(time.RFC3339), id)
+	return err
+}
+
+// MarkWatcherFired records a trigger; watchers created with once stop watching
+func (db *DB) MarkWatcherFired(id int64, once bool) error {
+	status := "active"
+	if once {
+		status = "fired"
+	}
+	_, err := db.Exec(`
+		UPDATE watchers SET last_fired_at = ?, status = ? WHERE id = ?
+	`, time.Now().Format(time.RFC3339), status, id)
+	return err
+}
+
+// SetWatcherStatus pauses or resumes a watcher. Resuming checks it on the next tick
+// and forgets the last value, so a condition that is already true fires again.
+func (db *DB) SetWatcherStatus(id int64, status string) error {
+	result, err := db.Exec(`
+		UPDATE watchers SET status = ?, next_check_at = ?,
+			last_value = CASE WHEN ? = 'active' THEN FALSE ELSE last_value END
+		WHERE id = ?
+	`, status, time.Now().Format(time.RFC3339), status, id)
+	if err != nil {
+		return fmt.Errorf("failed to update watcher: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("watcher not found")
+	}
+	return nil
+}
+
+// DeleteWatcher deletes a watcher
+func (db *DB) DeleteWatcher(id int64) error {
+	result, err := db.Exec(`DELETE FROM watchers WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete watcher: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return fmt.Errorf("watcher not found")
+	}
+	return nil
+}
+
+// QueryReadOnly runs a single SELECT statement and returns its rows as column -> value maps.
+// The query runs in a transaction that is always rolled back.
+func (db *DB) QueryReadOnly(query string) ([]map[string]any, error) {
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+	lower := strings.ToLower(query)
+	if !strings.HasPrefix(lower, "select") && !strings.HasPrefix(lower, "with") {
+		return nil, fmt.Errorf("only SELECT queries are allowed")
+	}
+	if strings.Contains(query, ";") {
+		return nil, fmt.Errorf("only a single statement is allowed")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []map[string]any
+	for rows.Next() && len(results) < 1000 {
+		values := make([]any, len(columns))
+		ptrs := make([]any, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]any, len(columns))
+		for i, col := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[col] = string(b)
+			} else {
+				row[col] = values[i]
+			}
+		}
+		results = append(results, row)
+	}
+	return results, rows.Err()
+}
+
+// checkWatchers evaluates the watchers that are due
+func (s *Scheduler) checkWatchers() {
+	watchers, err := s.db.GetDueWatchers()
+	if err != nil {
+		log.Printf("[Watcher] Error getting due watchers: %v", err)
+		return
+	}
+
+	for _, w := range watchers {
+		claimed, err := s.db.ClaimWatcherCheck(w.ID, time.Now().Add(w.Interval))
+		if err != nil {
+			log.Printf("[Watcher] Failed to claim watcher %d: %v", w.ID, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		go s.evaluateWatcher(w)
+	}
+}
+
+func (s *Scheduler) evaluateWatcher(w Watcher) {
+	data, err := s.fetchWatcherData(w)
+	value := false
+	if err == nil {
+		value, err = evaluateWatcherPredicate(w.Predicate, data)
+	}
+
+	checkErr := ""
+	if err != nil {
+		checkErr = err.Error()
+		log.Printf("[Watcher] Watcher %d (%s) check failed: %v", w.ID, w.Name, err)
+		// Only report the first failure of a streak
+		if w.LastError == "" {
+			s.bot.notify(SourceWatcher, NotifyNormal, fmt.Sprintf("⚠️ Watcher *%s* check failed: %s", w.Name, checkErr))
+		}
+		// Keep the last value so a transient error doesn't re-arm the trigger
+		value = w.LastValue
+	}
+
+	if err := s.db.RecordWatcherCheck(w.ID, value, checkErr); err != nil {
+		log.Printf("[Watcher] Failed to record check of watcher %d: %v", w.ID, err)
+	}
+
+	// Fire only when the condition flips from false to true
+	if !value || w.LastValue {
+		return
+	}
+
+	log.Printf("[Watcher] Watcher %d (%s) triggered", w.ID, w.Name)
+	if err := s.db.MarkWatcherFired(w.ID, w.Once); err != nil {
+		log.Printf("[Watcher] Failed to mark watcher %d as fired: %v", w.ID, err)
+	}
+	s.fireWatcher(w, data)
+}
+
+// fireWatcher notifies the user and runs the watcher's action on the brain or an agent
+func (s *Scheduler) fireWatcher(w Watcher, data json.RawMessage) {
+	s.bot.notify(SourceWatcher, NotifyNormal, fmt.Sprintf("👀 Watcher triggered: *%s*\nCondition: `%s`", w.Name, w.Predicate))
+	s.bot.events.Publish(Event{
+		Type:    EventWatcherFired,
+		Subject: w.Name,
+		Data:    map[string]string{"watcher": w.Name, "watcher_id": fmt.Sprintf("%d", w.ID)},
+		Text:    string(data),
+	})
+
+	snapshot := truncateText(string(data), 2000)
+
+	if w.AgentName != "" {
+		prompt := fmt.Sprintf("%s\n\nTriggered by watcher '%s' (condition: %s). Current data:\n%s", w.Action, w.Name, w.Predicate, snapshot)
+		if _, err := s.db.CreateScheduledTask(prompt, time.Now(), w.AgentName, w.WorkingDir, "none"); err != nil {
+			log.Printf("[Watcher] Failed to queue agent task for watcher %d: %v", w.ID, err)
+			return
+		}
+		go s.checkTasks()
+		return
+	}
+
+	action := w.Action
+	if action == "" {
+		action = "Tell the user the condition is now met, with the relevant values."
+	}
+	eventMsg := fmt.Sprintf("[WATCHER TRIGGERED] Watcher '%s' condition became true.\n\nCondition: %s\nSource: %s %s\n\nAction requested:\n%s\n\nCurrent data:\n%s",
+		w.Name, w.Predicate, w.SourceType, w.Source, action, snapshot)
+	if err := s.bot.ProcessSystemEvent(s.bot.config.AdminID, SourceWatcher, eventMsg); err != nil {
+		log.Printf("[Watcher] Brain event for watcher %d failed: %v", w.ID, err)
+	}
+}
+
+// fetchWatcherData reads a watcher's source and returns it as JSON.
+// Text that is not JSON is passed to the predicate as a string.
+func (s *Scheduler) fetchWatcherData(w Watcher) (json.RawMessage, error) {
+	var raw []byte
+	switch w.SourceType {
+	case WatchSourceHTTP:
+		client := &http.Client{Timeout: watcherFetchTimeout}
+		resp, err := client.Get(w.Source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return nil, fmt.Errorf("HTTP %d from %s", resp.StatusCode, w.Source)
+		}
+		raw, err = io.ReadAll(io.LimitReader(resp.Body, watcherMaxData))
+		if err != nil {
+			return nil, err
+		}
+
+	case WatchSourceFile:
+		if s.agentHub == nil {
+			return nil, fmt.Errorf("agent hub not available")
+		}
+		content, err := s.agentHub.ReadFile(w.SourceAgent, w.Source, watcherFetchTimeout)
+		if err != nil {
+			return nil, err
+		}
+		raw = []byte(content)
+
+	case WatchSourceSQL:
+		rows, err := s.db.QueryReadOnly(w.Source)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(rows)
+
+	default:
+		return nil, fmt.Errorf("unknown source type %q", w.SourceType)
+	}
+
+	if json.Valid(raw) {
+		return raw, nil
+	}
+	return json.Marshal(strings.TrimSpace(string(raw)))
+}
+
+// watcherCode wraps a predicate into a program for the Goja sandbox.
+// The predicate is either an expression over `data` or a function body with a return statement.
+func watcherCode(predicate string, data json.RawMessage) string {
+	return fmt.Sprintf("(function(data) {\n%s\n})(%s) ? 'true' : 'false'", predicateBody(predicate), data)
+}
+
+// predicateBody returns the function body for a predicate: the predicate returned as an
+// expression if it parses as one, or else the predicate itself as statements
+func predicateBody(predicate string) string {
+	expr := "return (" + predicate + "\n);"
+	if _, err := goja.Compile("predicate", "(function(data) {\n"+expr+"\n})", false); err == nil {
+		return expr
+	}
+	return predicate
+}
+
+// validateWatcherPredicate checks that a predicate compiles, without evaluating it
+func validateWatcherPredicate(predicate string) error {
+	args, _ := json.Marshal(tools.RunCodeArgs{Code: fmt.Sprintf("(function(data) {\n%s\n}); true", predicateBody(predicate))})
+	out, err := tools.RunCode(string(args))
+	if err != nil {
+		return err
+	}
+	var result tools.CodeResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		return err
+	}
+	if !result.Success {
+		return fmt.Errorf("%s", result.Error)
+	}
+	return nil
+}
+
+// evaluateWatcherPredicate runs a predicate through tools.RunCode and reports whether it is truthy
+func evaluateWatcherPredicate(predicate string, data json.RawMessage) (bool, error) {
+	args, _ := json.Marshal(tools.RunCodeArgs{Code: watcherCode(predicate, data)})
+	out, err := tools.RunCode(string(args))
+	if err != nil {
+		return false, err
+	}
+
+	var result tools.CodeResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		return false, fmt.Errorf("invalid predicate result: %w", err)
+	}
+	if !result.Success {
+		return false, fmt.Errorf("predicate error: %s", result.Error)
+	}
+	return result.Result == "true", nil
+}
+
+func scanWatchers(rows *sql.Rows) ([]Watcher, error) {
+	var watchers []Watcher
+	for rows.Next() {
+		var w Watcher
+		var intervalSeconds int64
+		var lastChecked, lastFired sql.NullString
+		var createdAtStr string
+		if err := rows.Scan(&w.ID, &w.Name, &w.SourceType, &w.Source, &w.SourceAgent, &w.Predicate, &w.Action, &w.AgentName, &w.WorkingDir,
+			&intervalSeconds, &w.Once, &w.Status, &w.LastValue, &w.LastError, &lastChecked, &lastFired, &createdAtStr); err != nil {
+			return nil, fmt.Errorf("failed to scan watcher: %w", err)
+		}
+
+		w.Interval = time.Duration(intervalSeconds) * time.Second
+		w.CreatedAt, _ = time.Parse(time.RFC3339, createdAtStr)
+		if lastChecked.Valid {
+			t, _ := time.Parse(time.RFC3339, lastChecked.String)
+			w.LastChecked = &t
+		}
+		if lastFired.Valid {
+			t, _ := time.Parse(time.RFC3339, lastFired.String)
+			w.LastFired = &t
+		}
+		watchers = append(watchers, w)
+	}
+	return watchers, rows.Err()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEvaluateWatcherPredicate(t *testing.T) {
+	data := json.RawMessage(`{"price": 120, "status": "open", "items": [1, 2, 3]}`)
+	tests := []struct {
+		name      string
+		predicate string
+		want      bool
+		wantErr   bool // fails when evaluated
+		invalid   bool // fails validation, before it is ever evaluated
+	}{
+		{"expression true", "data.price > 100", true, false, false},
+		{"expression false", "data.price < 100", false, false, false},
+		{"string comparison", `data.status === "open"`, true, false, false},
+		{"trailing line comment", "data.price > 100 // alert above 100", true, false, false},
+		{"multi-line expression", "data.price > 100 &&\n  data.items.length === 3", true, false, false},
+		{"object literal is truthy", "{}", true, false, false},
+		{"statement body", "const n = data.items.length;\nreturn n > 2;", true, false, false},
+		{"if statement", "if (data.status !== 'open') return false;\nreturn data.price > 200;", false, false, false},
+		{"body without return", "data.price > 100;", false, false, false},
+		{"syntax error", "data.price >", false, true, true},
+		{"runtime error", "data.missing.field", false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateWatcherPredicate(tt.predicate); (err != nil) != tt.invalid {
+				t.Errorf("validateWatcherPredicate(%q) = %v, want error %v", tt.predicate, err, tt.invalid)
+			}
+			got, err := evaluateWatcherPredicate(tt.predicate, data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("evaluateWatcherPredicate(%q) error = %v, want error %v", tt.predicate, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("evaluateWatcherPredicate(%q) = %v, want %v", tt.predicate, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPredicateBody(t *testing.T) {
+	tests := []struct {
+		predicate string
+		want      string
+	}{
+		{"data.x > 1", "return (data.x > 1\n);"},
+		{"data.x > 1 // comment", "return (data.x > 1 // comment\n);"},
+		{"return data.x > 1;", "return data.x > 1;"},
+		{"const x = data.x; return x > 1;", "const x = data.x; return x > 1;"},
+	}
+	for _, tt := range tests {
+		if got := predicateBody(tt.predicate); got != tt.want {
+			t.Errorf("predicateBody(%q) = %q, want %q", tt.predicate, got, tt.want)
+		}
+	}
+}
@@ -122,11 +122,30 @@ func (w *WebhookServer) Start() error {
 
 	// Agent WebSocket endpoint (auth required)
 	if w.agentHub != nil {
-		http.HandleFunc("/agent", chainMiddleware(w.agentHub.HandleWebSocket, rl, wsAuth))
+		agentAuth := func(next http.HandlerFunc) http.HandlerFunc {
+			return wsTokenAuthMiddleware(w.config.AgentPassword, w.agentHub.ValidToken, w.allowedOrigins, next)
+		}
+		http.HandleFunc("/agent", chainMiddleware(w.agentHub.HandleWebSocket, rl, agentAuth))
 		http.HandleFunc("/agent/list", chainMiddleware(w.handleAgentList, rl, localhostOnly))
 		http.HandleFunc("/agent/run", chainMiddleware(w.handleAgentRun, rl, body, localhostOnly))
+		http.HandleFunc("/agent/broadcast", chainMiddleware(w.handleAgentBroadcast, rl, body, localhostOnly))
+		http.HandleFunc("/agent/kill", chainMiddleware(w.handleAgentKill, rl, body, localhostOnly))
+		http.HandleFunc("/agent/queue", chainMiddleware(w.handleAgentQueue, rl, localhostOnly))
+		http.HandleFunc("/agent/queue/move", chainMiddleware(w.handleAgentQueueMove, rl, body, localhostOnly))
+		http.HandleFunc("/agent/queue/cancel", chainMiddleware(w.handleAgentQueueCancel, rl, body, localhostOnly))
+		http.HandleFunc("/agent/followup", chainMiddleware(w.handleAgentFollowUp, rl, body, localhostOnly))
+		http.HandleFunc("/agent/review", chainMiddleware(w.handleAgentReview, rl, body, localhostOnly))
+		http.HandleFunc("/agent/push", chainMiddleware(w.handleAgentPush, rl, body, localhostOnly))
+		http.HandleFunc("/agent/update", chainMiddleware(w.handleAgentUpdate, rl, body, localhostOnly))
+		http.HandleFunc("/agent/publish", chainMiddleware(w.handleAgentPublish, rl, body, localhostOnly))
+		http.HandleFunc("/agent/releases", chainMiddleware(w.handleAgentReleases, rl, localhostOnly))
+		http.HandleFunc("/agent/enroll", chainMiddleware(w.handleAgentEnroll, rl, body, localhostOnly))
+		http.HandleFunc("/agent/revoke", chainMiddleware(w.handleAgentRevoke, rl, body, localhostOnly))
+		http.HandleFunc("/agent/credentials", chainMiddleware(w.handleAgentCredentials, rl, localhostOnly))
+		http.HandleFunc("/agent/budget", chainMiddleware(w.handleAgentBudget, rl, body, localhostOnly))
+		http.HandleFunc("/agent/budgets", chainMiddleware(w.handleAgentBudgets, rl, localhostOnly))
 		log.Println("Agent WebSocket endpoint: /agent (auth required)")
-		log.Println("Agent API endpoints: /agent/list, /agent/run (auth required)")
+		log.Println("Agent API endpoints: /agent/list, /agent/run, /agent/broadcast, /agent/kill, /agent/queue, /agent/followup, /agent/push, /agent/update, /agent/publish, /agent/releases, /agent/enroll, /agent/revoke, /agent/credentials (auth required)")
 	}
 
 	addr := fmt.Sprintf(":%d", w.port)
@@ -172,7 +191,7 @@ func (w *WebhookServer) handleVoiceCall(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	w.bot.sendMessage(w.bot.config.AdminID, fmt.Sprintf("📞 Llamada saliente a %s iniciada", req.To))
+	w.bot.notify(SourceCall, NotifyLow, fmt.Sprintf("📞 Llamada saliente a %s iniciada", req.To))
 
 	rw.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(rw).Encode(map[string]any{
@@ -224,7 +243,7 @@ func (w *WebhookServer) handlePhoneCall(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	w.bot.sendMessage(w.bot.config.AdminID, fmt.Sprintf("📱 Llamada saliente a %s iniciada (Android)", req.To))
+	w.bot.notify(SourcePhone, NotifyLow, fmt.Sprintf("📱 Llamada saliente a %s iniciada (Android)", req.To))
 
 	rw.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(rw).Encode(map[string]any{
@@ -251,23 +270,27 @@ func (w *WebhookServer) handleAgentList(rw http.ResponseWriter, r *http.Request)
 			continue
 		}
 
-		// Get projects for this agent
-		projects, err := w.agentHub.GetProjects(name, 5*time.Second)
+		entry := map[string]interface{}{
+			"workDir":      workDir,
+			"active_tasks": activeTasks,
+			"labels":       agent["labels"],
+			"resources":    agent["resources"],
+			"executors":    agent["executors"],
+			"protocol":     agent["protocol"],
+			"version":      agent["version"],
+			"health":       agent["health"],
+			"load_avg":     agent["load_avg"],
+		}
+
+		// Project details are cached for ProjectCacheTTL
+		projects, err := w.agentHub.Projects(name, projectRefreshTimeout)
 		if err != nil {
 			log.Printf("[Agent] Failed to get projects for '%s': %v", name, err)
-			result[name] = map[string]interface{}{
-				"workDir":      workDir,
-				"projects":     []string{},
-				"active_tasks": activeTasks,
-				"error":        "failed to retrieve projects",
-			}
-		} else {
-			result[name] = map[string]interface{}{
-				"workDir":      workDir,
-				"projects":     projects,
-				"active_tasks": activeTasks,
-			}
+			projects = w.agentHub.CachedProjects(name)
+			entry["error"] = "failed to retrieve project details"
 		}
+		entry["projects"] = projects
+		result[name] = entry
 	}
 
 	rw.Header().Set("Content-Type", "application/json")
@@ -288,9 +311,19 @@ func (w *WebhookServer) handleAgentRun(rw http.ResponseWriter, r *http.Request)
 	}
 
 	var req struct {
-		Agent  string `json:"agent"`
-		Prompt string `json:"prompt"`
-		Dir    string `json:"dir,omitempty"`
+		Agent    string   `json:"agent"`
+		Selector string   `json:"selector,omitempty"` // pick the agent by label or project instead of agent
+		Prompt   string   `json:"prompt"`
+		Dir      string   `json:"dir,omitempty"`
+		Priority int      `json:"priority,omitempty"` // higher runs first when the agent is busy
+		Wait     string   `json:"wait,omitempty"`     // queue for an offline agent for at most this long (e.g. "12h")
+		Files    []string `json:"files,omitempty"`    // local files pushed into the task's directory first
+		Worktree bool     `json:"worktree,omitempty"` // run in an isolated git worktree, applied only on review
+		Executor string   `json:"executor,omitempty"` // agent backend: claude (default), shell or a configured CLI tool
+		// Limits overriding the agent's defaults: wall time (e.g. "30m"), turns, cost in USD
+		MaxTime  string  `json:"max_time,omitempty"`
+		MaxTurns int     `json:"max_turns,omitempty"`
+		MaxCost  float64 `json:"max_cost,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -298,8 +331,8 @@ func (w *WebhookServer) handleAgentRun(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if req.Agent == "" || req.Prompt == "" {
-		http.Error(rw, `{"error": "agent and prompt are required"}`, http.StatusBadRequest)
+	if (req.Agent == "" && req.Selector == "") || req.Prompt == "" {
+		http.Error(rw, `{"error": "agent (or selector) and prompt are required"}`, http.StatusBadRequest)
 		return
 	}
 
@@ -309,7 +342,69 @@ func (w *WebhookServer) handleAgentRun(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	taskID, err := w.agentHub.SendTask(req.Agent, req.Prompt, req.Dir)
+	budget, err := budgetOption(req.MaxTime, req.MaxTurns, req.MaxCost)
+	if err != nil {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	opts := TaskOptions{Priority: req.Priority, Worktree: req.Worktree, Executor: req.Executor, Budget: budget}
+	if req.Wait != "" {
+		wait, err := parseOfflineWait(req.Wait)
+		if err != nil {
+			http.Error(rw, `{"error": "invalid wait duration"}`, http.StatusBadRequest)
+			return
+		}
+		opts.OfflineWait = wait
+	}
+
+	// Files go to one agent, so a routed task picks it before pushing them
+	if req.Agent == "" && len(req.Files) > 0 {
+		agentName, dir, err := w.agentHub.RouteTask(req.Selector, req.Dir, opts)
+		if err != nil {
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(rw).Encode(map[string]interface{}{"error": err.Error()})
+			return
+		}
+		req.Agent, req.Dir = agentName, dir
+	}
+
+	// Hand over attachments before the task can start, so Claude finds them in place
+	if len(req.Files) > 0 {
+		var names []string
+		for _, path := range req.Files {
+			remote, err := w.agentHub.PushFile(req.Agent, path, req.Dir)
+			if err != nil {
+				log.Printf("[Agent] Failed to push %s to '%s': %v", path, req.Agent, err)
+				rw.Header().Set("Content-Type", "application/json")
+				rw.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(rw).Encode(map[string]interface{}{
+					"error": fmt.Sprintf("failed to push %s: %v", path, err),
+				})
+				return
+			}
+			names = append(names, remote)
+		}
+		req.Prompt += "\n\nAttached files: " + strings.Join(names, ", ")
+	}
+
+	var taskID string
+	var position int
+	if req.Agent != "" {
+		taskID, position, err = w.agentHub.SubmitTask(req.Agent, req.Prompt, req.Dir, opts)
+	} else {
+		taskID, req.Agent, position, err = w.agentHub.SubmitRoutedTask(req.Selector, req.Prompt, req.Dir, opts)
+		if err != nil {
+			log.Printf("[Agent] Failed to route task for %s: %v", req.Selector, err)
+			rw.Header().Set("Content-Type", "application/json")
+			rw.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(rw).Encode(map[string]interface{}{"error": err.Error()})
+			return
+		}
+	}
 	if err != nil {
 		log.Printf("[Agent] Failed to send task to '%s': %v", req.Agent, err)
 		rw.Header().Set("Content-Type", "application/json")
@@ -320,6 +415,23 @@ func (w *WebhookServer) handleAgentRun(rw http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if position > 0 {
+		// The queued message (with reorder / cancel buttons) was already sent by the hub
+		rw.Header().Set("Content-Type", "application/json")
+		message := fmt.Sprintf("Agent '%s' is busy; task queued at position %d.", req.Agent, position)
+		if !w.agentHub.IsConnected(req.Agent) {
+			message = fmt.Sprintf("Agent '%s' is offline; task will run when it reconnects (waiting up to %s).", req.Agent, req.Wait)
+		}
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"status":   "queued",
+			"task_id":  taskID,
+			"agent":    req.Agent,
+			"position": position,
+			"message":  message,
+		})
+		return
+	}
+
 	// Notify admin that agent started (with kill button)
 	if w.bot != nil && w.bot.config.AdminID != 0 {
 		dir := req.Dir
@@ -339,10 +451,611 @@ func (w *WebhookServer) handleAgentRun(rw http.ResponseWriter, r *http.Request)
 	json.NewEncoder(rw).Encode(map[string]interface{}{
 		"status":  "started",
 		"task_id": taskID,
+		"agent":   req.Agent,
 		"message": fmt.Sprintf("Claude is now running on agent '%s'.", req.Agent),
 	})
 }
 
+// handleAgentBroadcast sends a task to every connected agent, or those matching a selector.
+// One aggregated report reaches Telegram once all of them finish or the timeout passes.
+func (w *WebhookServer) handleAgentBroadcast(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(rw, `{"error": "method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Selector string `json:"selector,omitempty"` // "" = all agents
+		Prompt   string `json:"prompt"`
+		Dir      string `json:"dir,omitempty"`
+		Priority int    `json:"priority,omitempty"`
+		Worktree bool   `json:"worktree,omitempty"`
+		Executor string `json:"executor,omitempty"`
+		Timeout  string `json:"timeout,omitempty"` // how long to wait for all agents (e.g. "30m")
+		// Limits overriding each agent's defaults: wall time (e.g. "30m"), turns, cost in USD
+		MaxTime  string  `json:"max_time,omitempty"`
+		MaxTurns int     `json:"max_turns,omitempty"`
+		MaxCost  float64 `json:"max_cost,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(rw, `{"error": "invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if req.Prompt == "" {
+		http.Error(rw, `{"error": "prompt is required"}`, http.StatusBadRequest)
+		return
+	}
+	if err := validateDirField(req.Dir); err != nil {
+		http.Error(rw, `{"error": "invalid directory"}`, http.StatusBadRequest)
+		return
+	}
+	var timeout time.Duration
+	if req.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(req.Timeout); err != nil || timeout <= 0 {
+			http.Error(rw, `{"error": "invalid timeout duration"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	budget, err := budgetOption(req.MaxTime, req.MaxTurns, req.MaxCost)
+	if err != nil {
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	opts := TaskOptions{Priority: req.Priority, Worktree: req.Worktree, Executor: req.Executor, Budget: budget}
+	id, tasks, err := w.agentHub.Broadcast(req.Selector, req.Prompt, req.Dir, opts, timeout)
+	if err != nil {
+		log.Printf("[Agent] Broadcast failed: %v", err)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(map[string]interface{}{"error": err.Error()})
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"status":       "started",
+		"broadcast_id": id,
+		"tasks":        tasks,
+		"message":      fmt.Sprintf("Task sent to %d agent(s); one report will arrive once they all finish.", len(tasks)),
+	})
+}
+
+// handleAgentFollowUp continues the Claude session of a finished agent task
+func (w *WebhookServer) handleAgentFollowUp(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(rw, `{"error": "method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		TaskID string `json:"task_id"`
+		Prompt string `json:"prompt"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TaskID == "" || req.Prompt == "" {
+		http.Error(rw, `{"error": "task_id and prompt are required"}`, http.StatusBadRequest)
+		return
+	}
+
+	taskID, position, err := w.agentHub.FollowUp(req.TaskID, req.Prompt)
+	rw.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		log.Printf("[Agent] Failed to follow up on task %s: %v", req.TaskID, err)
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	status := "started"
+	if position > 0 {
+		status = "queued"
+	}
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"status":    status,
+		"task_id":   taskID,
+		"parent_id": req.TaskID,
+		"position":  position,
+	})
+}
+
+// handleAgentReview applies, publishes or discards the changes of a worktree task
+func (w *WebhookServer) handleAgentReview(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(rw, `{"error": "method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		TaskID string `json:"task_id"`
+		Action string `json:"action"` // apply, branch or discard
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TaskID == "" || req.Action == "" {
+		http.Error(rw, `{"error": "task_id and action are required"}`, http.StatusBadRequest)
+		return
+	}
+
+	report, err := w.agentHub.WorktreeAction(req.TaskID, req.Action)
+	rw.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		log.Printf("[Agent] Failed to %s worktree of task %s: %v", req.Action, req.TaskID, err)
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"status":  "ok",
+		"task_id": req.TaskID,
+		"action":  req.Action,
+		"message": report,
+	})
+}
+
+// handleAgentPush sends a local file to an agent's working directory (or dir)
+func (w *WebhookServer) handleAgentPush(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(rw, `{"error": "method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Agent string `json:"agent"`
+		Path  string `json:"path"`
+		Dir   string `json:"dir,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Agent == "" || req.Path == "" {
+		http.Error(rw, `{"error": "agent and path are required"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := validateDirField(req.Dir); err != nil {
+		http.Error(rw, `{"error": "invalid directory"}`, http.StatusBadRequest)
+		return
+	}
+
+	remote, err := w.agentHub.PushFile(req.Agent, req.Path, req.Dir)
+	rw.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		log.Printf("[Agent] Failed to push %s to '%s': %v", req.Path, req.Agent, err)
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"status": "pushed",
+		"agent":  req.Agent,
+		"path":   remote,
+	})
+}
+
+// handleAgentUpdate installs the latest release on one agent (or all of them) and waits
+// for it to reconnect on the new version
+func (w *WebhookServer) handleAgentUpdate(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(rw, `{"error": "method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Agent string `json:"agent,omitempty"`
+		All   bool   `json:"all,omitempty"`
+		Force bool   `json:"force,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Agent == "") == !req.All {
+		http.Error(rw, `{"error": "agent or all is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if req.All {
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"results": w.agentHub.UpdateAgents(req.Force),
+		})
+		return
+	}
+
+	result, err := w.agentHub.UpdateAgent(req.Agent, req.Force)
+	if err != nil {
+		log.Printf("[Agent] Failed to update '%s': %v", req.Agent, err)
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"status": "updated",
+		"agent":  req.Agent,
+		"result": result,
+	})
+}
+
+// handleAgentPublish offers a signed local agent binary to agents of its platform
+func (w *WebhookServer) handleAgentPublish(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(rw, `{"error": "method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		http.Error(rw, `{"error": "path is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	release, err := w.agentHub.PublishAgentRelease(req.Path)
+	rw.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		log.Printf("[Agent] Failed to publish %s: %v", req.Path, err)
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"status":  "published",
+		"release": release,
+	})
+}
+
+// handleAgentReleases lists the published agent releases and the key agents must be built with
+func (w *WebhookServer) handleAgentReleases(rw http.ResponseWriter, r *http.Request) {
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	publicKey, err := w.agentHub.UpdatePublicKey()
+	var releases []AgentRelease
+	if err == nil {
+		releases, err = w.agentHub.AgentReleases()
+	}
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"update_key": publicKey,
+		"build":      agentBuildCommand(publicKey),
+		"releases":   releases,
+	})
+}
+
+// handleAgentEnroll issues a per-agent token (replacing any previous one for the name)
+func (w *WebhookServer) handleAgentEnroll(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(rw, `{"error": "method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Agent string `json:"agent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Agent == "" {
+		http.Error(rw, `{"error": "agent is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	token, err := w.agentHub.EnrollAgent(req.Agent)
+	rw.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		log.Printf("[Agent] Failed to enroll '%s': %v", req.Agent, err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	log.Printf("[Agent] Enrolled agent '%s'", req.Agent)
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"status":  "enrolled",
+		"agent":   req.Agent,
+		"token":   token,
+		"message": fmt.Sprintf("Start the agent with -name %s -token <token> (or MINERVA_AGENT_TOKEN). The token is shown only once.", req.Agent),
+	})
+}
+
+// handleAgentRevoke invalidates an agent's token and disconnects it
+func (w *WebhookServer) handleAgentRevoke(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(rw, `{"error": "method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Agent string `json:"agent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Agent == "" {
+		http.Error(rw, `{"error": "agent is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := w.agentHub.RevokeAgent(req.Agent); err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"status": "revoked",
+		"agent":  req.Agent,
+	})
+}
+
+// handleAgentCredentials lists enrolled agents
+func (w *WebhookServer) handleAgentCredentials(rw http.ResponseWriter, r *http.Request) {
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	creds, err := w.agentHub.AgentCredentials()
+	rw.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	json.NewEncoder(rw).Encode(creds)
+}
+
+// handleAgentBudget sets an agent's default task limits
+func (w *WebhookServer) handleAgentBudget(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(rw, `{"error": "method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		Agent    string  `json:"agent"`
+		MaxTime  string  `json:"max_time,omitempty"` // e.g. "2h"; empty = server default
+		MaxTurns int     `json:"max_turns,omitempty"`
+		MaxCost  float64 `json:"max_cost,omitempty"` // USD
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Agent == "" {
+		http.Error(rw, `{"error": "agent is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	budget, err := parseBudget(req.MaxTime, req.MaxTurns, req.MaxCost)
+	var effective string
+	if err == nil {
+		effective, err = w.agentHub.SetAgentBudget(req.Agent, budget)
+	}
+	if err != nil {
+		rw.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"status":    "ok",
+		"agent":     req.Agent,
+		"budget":    budget,
+		"effective": effective,
+		"message":   fmt.Sprintf("New tasks on '%s' run with: %s", req.Agent, effective),
+	})
+}
+
+// handleAgentBudgets lists the default task limits and the agents with their own
+func (w *WebhookServer) handleAgentBudgets(rw http.ResponseWriter, r *http.Request) {
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	budgets, err := w.agentHub.AgentBudgets()
+	rw.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		rw.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+	json.NewEncoder(rw).Encode(budgets)
+}
+
+// handleAgentKill kills a running agent task
+func (w *WebhookServer) handleAgentKill(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(rw, `{"error": "method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		TaskID string `json:"task_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TaskID == "" {
+		http.Error(rw, `{"error": "task_id is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	if err := w.agentHub.KillTask(req.TaskID); err != nil {
+		log.Printf("[Agent] Failed to kill task %s: %v", req.TaskID, err)
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"status":  "killed",
+		"task_id": req.TaskID,
+	})
+}
+
+// handleAgentQueue lists queued agent tasks, optionally for one agent (?agent=name)
+func (w *WebhookServer) handleAgentQueue(rw http.ResponseWriter, r *http.Request) {
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	tasks := w.agentHub.QueuedTasks(r.URL.Query().Get("agent"))
+	if tasks == nil {
+		tasks = []QueuedTask{}
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(tasks)
+}
+
+// handleAgentQueueMove moves a queued agent task to another position
+func (w *WebhookServer) handleAgentQueueMove(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(rw, `{"error": "method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		TaskID   string `json:"task_id"`
+		Position int    `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TaskID == "" || req.Position < 1 {
+		http.Error(rw, `{"error": "task_id and a position >= 1 are required"}`, http.StatusBadRequest)
+		return
+	}
+
+	position, err := w.agentHub.MoveQueuedTask(req.TaskID, req.Position)
+	rw.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		rw.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"status":   "moved",
+		"task_id":  req.TaskID,
+		"position": position,
+	})
+}
+
+// handleAgentQueueCancel removes a queued agent task before it starts
+func (w *WebhookServer) handleAgentQueueCancel(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(rw, `{"error": "method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if w.agentHub == nil {
+		http.Error(rw, `{"error": "agent hub not available"}`, http.StatusServiceUnavailable)
+		return
+	}
+
+	var req struct {
+		TaskID string `json:"task_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TaskID == "" {
+		http.Error(rw, `{"error": "task_id is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	if err := w.agentHub.CancelQueuedTask(req.TaskID); err != nil {
+		rw.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(rw).Encode(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	json.NewEncoder(rw).Encode(map[string]interface{}{
+		"status":  "cancelled",
+		"task_id": req.TaskID,
+	})
+}
+
 // verifySignature verifies the Svix webhook signature
 func (w *WebhookServer) verifySignature(payload []byte, signature, msgID, timestamp string) bool {
 	if w.secret == "" {
@@ -424,7 +1137,7 @@ func (w *WebhookServer) handleEmailWebhook(rw http.ResponseWriter, r *http.Reque
 	notification := fmt.Sprintf("📧 *%s*\n_%s_", toAddrs, payload.Data.Subject)
 
 	if w.bot.config.AdminID != 0 {
-		if err := w.bot.sendMessage(w.bot.config.AdminID, notification); err != nil {
+		if err := w.bot.notify(SourceEmail, NotifyLow, notification); err != nil {
 			log.Printf("Failed to send email notification: %v", err)
 		}
 
@@ -437,6 +1150,12 @@ func (w *WebhookServer) handleEmailWebhook(rw http.ResponseWriter, r *http.Reque
 		go w.processEmailWithAI(payload, toAddrs)
 	}
 
+	w.bot.events.Publish(Event{
+		Type:    EventEmailReceived,
+		Subject: payload.Data.From,
+		Data:    map[string]string{"from": payload.Data.From, "to": toAddrs, "subject": payload.Data.Subject},
+	})
+
 	rw.WriteHeader(http.StatusOK)
 }
 
@@ -596,7 +1315,7 @@ func (w *WebhookServer) processEmailWithAI(payload ResendWebhookPayload, toAddrs
 	w.bot.db.SaveMessage(conv.ID, "assistant", response.Content, nil)
 
 	// Send AI response to admin
-	if err := w.bot.sendMessage(adminID, response.Content); err != nil {
+	if err := w.bot.notify(SourceEmail, NotifyNormal, response.Content); err != nil {
 		log.Printf("Failed to send AI email response: %v", err)
 	}
 }